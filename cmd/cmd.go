@@ -22,6 +22,10 @@ func Run() error {
 
 	var quiet = flag.Bool("quiet", false, "turn of logs")
 
+	var planPath = flag.String("plan", "", "write the files that would change to a plan file instead of changing them")
+
+	var applyPlanPath = flag.String("apply-plan", "", "apply a plan file written by `-plan`")
+
 	flag.Parse()
 
 	zlog := log.New(*quiet)
@@ -37,6 +41,28 @@ func Run() error {
 		fmt.Print("\n")
 	}
 
+	if len(*planPath) > 0 {
+		err := encryptdir.RunPlan(zlog, *configPath, *password, *decrypt, *planPath)
+		if err != nil {
+			if !(*quiet) {
+				fmt.Fprintf(os.Stderr, "cmd.Run: encryptdir.RunPlan: %s\n", err)
+			}
+			return err
+		}
+		return nil
+	}
+
+	if len(*applyPlanPath) > 0 {
+		err := encryptdir.RunApplyPlan(zlog, *configPath, *password, *applyPlanPath)
+		if err != nil {
+			if !(*quiet) {
+				fmt.Fprintf(os.Stderr, "cmd.Run: encryptdir.RunApplyPlan: %s\n", err)
+			}
+			return err
+		}
+		return nil
+	}
+
 	err := encryptdir.Run(zlog, *configPath, *password, *decrypt)
 	if err != nil {
 		if !(*quiet) {
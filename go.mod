@@ -3,19 +3,21 @@ module github.com/prairir/encryptdir
 go 1.20
 
 require (
+	github.com/iafan/cwalk v0.0.0-20210125030640-586a8832a711
 	github.com/knadh/koanf v1.5.0
 	go.uber.org/zap v1.24.0
-	golang.org/x/term v0.0.0-20201126162022-7de9c90e9dd1
+	golang.org/x/crypto v0.17.0
+	golang.org/x/sync v0.1.0
+	golang.org/x/sys v0.15.0
+	golang.org/x/term v0.15.0
 )
 
 require (
 	github.com/fsnotify/fsnotify v1.4.9 // indirect
-	github.com/iafan/cwalk v0.0.0-20210125030640-586a8832a711 // indirect
 	github.com/mitchellh/copystructure v1.2.0 // indirect
 	github.com/mitchellh/mapstructure v1.5.0 // indirect
 	github.com/mitchellh/reflectwalk v1.0.2 // indirect
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/multierr v1.6.0 // indirect
-	golang.org/x/sys v0.0.0-20210603081109-ebe580a85c40 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -9,6 +9,7 @@ import (
 	gorsa "crypto/rsa"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
@@ -19,6 +20,9 @@ import (
 
 const SIGNATURE_SIZE = 256
 
+// sentinel error used for when a ciphertext's sizes don't add up
+var ErrCorrupt = errors.New("ciphertext is corrupt")
+
 // aes.GenKeyList: Generates a list of `keySize` sized keys
 // if keySize random keys of random size
 func GenKeyList(keySize uint64, length int) ([][]byte, error) {
@@ -247,8 +251,14 @@ func Decrypt(key []byte, ciphertext []byte) ([]byte, error) {
 	}
 
 	iv := make([]byte, cipherBlock.BlockSize())
-	if _, err := cipherBuf.Read(iv); err != nil {
-		return nil, fmt.Errorf("aes.Decrypt: cipherBuf.Read: %w", err)
+	if _, err := io.ReadFull(cipherBuf, iv); err != nil {
+		return nil, fmt.Errorf("aes.Decrypt: io.ReadFull(iv): %w", err)
+	}
+
+	// the plaintext is padded to a multiple of the block size, so the rest has to be the plaintext size rounded up to it
+	padded := uint64(cipherBuf.Len())
+	if padded%aes.BlockSize != 0 || origSize > padded || padded-origSize >= aes.BlockSize {
+		return nil, fmt.Errorf("aes.Decrypt: size = %d, padded = %d: %w", origSize, padded, ErrCorrupt)
 	}
 
 	buf := make([]byte, aes.BlockSize)
@@ -0,0 +1,45 @@
+package aes
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// benchGCM: seals `size` bytes with `EncryptGCM` and opens them with `DecryptGCMStream` a run
+func benchGCM(b *testing.B, size int) {
+	key := bytes.Repeat([]byte{1}, 32)
+	plaintext := bytes.Repeat([]byte("a"), size)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ciphertext, err := EncryptGCM(key, plaintext)
+		if err != nil {
+			b.Fatalf("EncryptGCM: %v", err)
+		}
+		err = DecryptGCMStream(key, bytes.NewReader(ciphertext), int64(len(ciphertext)), io.Discard)
+		if err != nil {
+			b.Fatalf("DecryptGCMStream: %v", err)
+		}
+	}
+}
+
+// BenchmarkGCMSmall: a 1 KiB payload
+//
+// sizing the chunk buffer to a payload smaller than a chunk instead of `DefaultGCMChunkSize`, median of 3:
+//
+//	before: 179710 ns/op    5.70 MB/s  2117472 B/op  14 allocs/op
+//	after:    3568 ns/op  287.02 MB/s     6240 B/op  14 allocs/op
+func BenchmarkGCMSmall(b *testing.B) {
+	benchGCM(b, 1<<10)
+}
+
+// BenchmarkGCMLarge: a 16 MiB payload, more than a chunk so the buffer is the same as before
+//
+//	before: 16117006 ns/op  1040.96 MB/s  18902208 B/op  44 allocs/op
+//	after:  16208361 ns/op  1035.10 MB/s  18902208 B/op  44 allocs/op
+func BenchmarkGCMLarge(b *testing.B) {
+	benchGCM(b, 16<<20)
+}
@@ -0,0 +1,111 @@
+package aes
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// sentinel error used for when a passphrase to derive a key from is empty
+var ErrEmptyPassphrase = errors.New("passphrase is empty")
+
+// sentinel error used for when a salt or iteration count is out of range for deriving a key
+var ErrBadKDFParams = errors.New("bad key derivation parameters")
+
+// keys are derived with PBKDF2-HMAC-SHA256
+const (
+	// iterations `DeriveKeyMap` uses, OWASP's recommendation for PBKDF2-HMAC-SHA256
+	DefaultKDFIterations = 600000
+	// most iterations a key is derived with, file headers are read from files anyone may have written and a huge count would hang the run
+	MaxKDFIterations = 10 * DefaultKDFIterations
+
+	// size of the salts `GenSalt` makes
+	DefaultSaltSize = 16
+	// salts have to be between these sizes, the file header has room for `MaxSaltSize` bytes
+	MinSaltSize = 8
+	MaxSaltSize = 32
+)
+
+// size of the derived keys, always AES-256
+const DerivedKeySize = 32
+
+// KDFParams: what a key is derived from besides the passphrase
+type KDFParams struct {
+	Salt []byte
+	// PBKDF2 iterations, 0 means `DefaultKDFIterations`
+	Iterations int
+}
+
+// aes.KDFParams.iterations: iterations of `p`, 0 means `DefaultKDFIterations`
+func (p KDFParams) iterations() int {
+	if p.Iterations == 0 {
+		return DefaultKDFIterations
+	}
+	return p.Iterations
+}
+
+// aes.KDFParams.validate: checks the salt size and the iteration count
+// returns: error wrapping `ErrBadKDFParams`
+func (p KDFParams) validate() error {
+	if len(p.Salt) < MinSaltSize || len(p.Salt) > MaxSaltSize {
+		return fmt.Errorf("aes.KDFParams.validate: salt = %d bytes, want %d to %d: %w", len(p.Salt), MinSaltSize, MaxSaltSize, ErrBadKDFParams)
+	}
+	if p.Iterations < 0 || p.Iterations > MaxKDFIterations {
+		return fmt.Errorf("aes.KDFParams.validate: iterations = %d, want at most %d: %w", p.Iterations, MaxKDFIterations, ErrBadKDFParams)
+	}
+	return nil
+}
+
+// aes.GenSalt: random salt of `DefaultSaltSize` bytes
+// returns: salt or error
+func GenSalt() ([]byte, error) {
+	salt := make([]byte, DefaultSaltSize)
+	_, err := io.ReadFull(rand.Reader, salt)
+	if err != nil {
+		return nil, fmt.Errorf("aes.GenSalt: io.ReadFull(rand.Reader): %w", err)
+	}
+	return salt, nil
+}
+
+// aes.DeriveKey: derives an AES-256 key from `passphrase` with PBKDF2-HMAC-SHA256, the same passphrase and params always give the same key
+// returns: key, or error wrapping `ErrEmptyPassphrase` or `ErrBadKDFParams`
+func DeriveKey(passphrase string, params KDFParams) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("aes.DeriveKey: %w", ErrEmptyPassphrase)
+	}
+
+	err := params.validate()
+	if err != nil {
+		return nil, fmt.Errorf("aes.DeriveKey: %w", err)
+	}
+
+	return pbkdf2.Key([]byte(passphrase), params.Salt, params.iterations(), DerivedKeySize, sha256.New), nil
+}
+
+// aes.DeriveKeyMap: derives a key map from passphrases per extension with `salt` and `DefaultKDFIterations`
+// returns: key map, or error wrapping `ErrEmptyPassphrase` or `ErrBadKDFParams` naming the extension
+func DeriveKeyMap(passphrases map[string]string, salt []byte) (map[string][]byte, error) {
+	keyMap, err := DeriveKeyMapWithParams(passphrases, KDFParams{Salt: salt})
+	if err != nil {
+		return nil, fmt.Errorf("aes.DeriveKeyMap: %w", err)
+	}
+	return keyMap, nil
+}
+
+// aes.DeriveKeyMapWithParams: like `DeriveKeyMap` but with the iterations in `params` too
+// returns: key map, or error wrapping `ErrEmptyPassphrase` or `ErrBadKDFParams` naming the extension
+func DeriveKeyMapWithParams(passphrases map[string]string, params KDFParams) (map[string][]byte, error) {
+	keyMap := make(map[string][]byte, len(passphrases))
+	for ext, passphrase := range passphrases {
+		key, err := DeriveKey(passphrase, params)
+		if err != nil {
+			return nil, fmt.Errorf("aes.DeriveKeyMapWithParams: extension = %q: %w", ext, err)
+		}
+		keyMap[ext] = key
+	}
+	return keyMap, nil
+}
@@ -0,0 +1,83 @@
+package aes
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDeriveKey(t *testing.T) {
+	params := KDFParams{Salt: []byte("0123456789abcdef"), Iterations: 1000}
+
+	key, err := DeriveKey("correct horse", params)
+	if err != nil {
+		t.Fatalf("DeriveKey: %v", err)
+	}
+	if len(key) != DerivedKeySize {
+		t.Errorf("DeriveKey: %d bytes, want %d", len(key), DerivedKeySize)
+	}
+
+	// the same passphrase and params always give the same key, anything else another one
+	again, err := DeriveKey("correct horse", params)
+	if err != nil || !bytes.Equal(again, key) {
+		t.Errorf("DeriveKey again = %x, %v, want %x", again, err, key)
+	}
+	for name, other := range map[string]func() ([]byte, error){
+		"passphrase": func() ([]byte, error) { return DeriveKey("battery staple", params) },
+		"salt": func() ([]byte, error) {
+			return DeriveKey("correct horse", KDFParams{Salt: []byte("fedcba9876543210"), Iterations: 1000})
+		},
+		"iterations": func() ([]byte, error) {
+			return DeriveKey("correct horse", KDFParams{Salt: params.Salt, Iterations: 1001})
+		},
+	} {
+		k, err := other()
+		if err != nil || bytes.Equal(k, key) {
+			t.Errorf("DeriveKey with another %s = %x, %v, want another key", name, k, err)
+		}
+	}
+}
+
+func TestDeriveKeyBadParams(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+
+	_, err := DeriveKey("", KDFParams{Salt: salt})
+	if !errors.Is(err, ErrEmptyPassphrase) {
+		t.Errorf("DeriveKey empty passphrase: err = %v, want ErrEmptyPassphrase", err)
+	}
+
+	for name, params := range map[string]KDFParams{
+		"short salt":          {Salt: salt[:MinSaltSize-1]},
+		"long salt":           {Salt: make([]byte, MaxSaltSize+1)},
+		"negative iterations": {Salt: salt, Iterations: -1},
+		// a file header can ask for anything, a count past the cap is refused instead of hanging the run
+		"too many iterations": {Salt: salt, Iterations: MaxKDFIterations + 1},
+		"uint32 iterations":   {Salt: salt, Iterations: 1<<32 - 1},
+	} {
+		_, err := DeriveKey("correct horse", params)
+		if !errors.Is(err, ErrBadKDFParams) {
+			t.Errorf("DeriveKey %s: err = %v, want ErrBadKDFParams", name, err)
+		}
+	}
+}
+
+func TestDeriveKeyMap(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	passphrases := map[string]string{"txt": "correct horse", "sql": "battery staple"}
+
+	keyMap, err := DeriveKeyMapWithParams(passphrases, KDFParams{Salt: salt, Iterations: 1000})
+	if err != nil {
+		t.Fatalf("DeriveKeyMapWithParams: %v", err)
+	}
+	for ext, passphrase := range passphrases {
+		key, err := DeriveKey(passphrase, KDFParams{Salt: salt, Iterations: 1000})
+		if err != nil || !bytes.Equal(keyMap[ext], key) {
+			t.Errorf("extension = %q: key = %x, want %x", ext, keyMap[ext], key)
+		}
+	}
+
+	_, err = DeriveKeyMap(map[string]string{"txt": ""}, salt)
+	if !errors.Is(err, ErrEmptyPassphrase) {
+		t.Errorf("DeriveKeyMap empty passphrase: err = %v, want ErrEmptyPassphrase", err)
+	}
+}
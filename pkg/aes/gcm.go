@@ -0,0 +1,342 @@
+package aes
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// sentinel error used for when a GCM chunk fails authentication, the ciphertext was modified or the key is wrong
+var ErrAuthFailed = errors.New("ciphertext failed authentication")
+
+// layout of the output of `EncryptGCM` and `EncryptGCMStream`
+//
+//	[plaintext size][chunk size][nonce][chunk]...
+//
+// plaintext size is a little endian uint64, chunk size a little endian uint32
+// the plaintext is sealed `chunk size` bytes at a time, every chunk has its own tag so a stream can be checked as it is read
+// chunk `n` is sealed with the nonce with `n` xored into its last 4 bytes, so chunks cant be reordered
+// the plaintext size and chunk size are the additional data of every chunk, so neither can be changed and the ciphertext cant be cut short
+// there is always at least one chunk, an empty plaintext still has a tag
+const (
+	GCMNonceSize = 12
+	GCMTagSize   = 16
+
+	// size of the plaintext size, chunk size, and nonce in front of the chunks
+	GCMHeaderSize = 8 + 4 + GCMNonceSize
+)
+
+// number of plaintext bytes sealed per chunk by `EncryptGCM`
+const DefaultGCMChunkSize = 1 << 20
+
+// largest chunk size `DecryptGCM` accepts, keeps a corrupt header from allocating a huge buffer
+const MaxGCMChunkSize = 64 << 20
+
+// aes.GCMSize: size of the output of `EncryptGCMStream` for `size` bytes of plaintext sealed `chunkSize` bytes at a time
+func GCMSize(size int64, chunkSize int) int64 {
+	return GCMHeaderSize + size + gcmChunks(uint64(size), chunkSize)*GCMTagSize
+}
+
+// aes.gcmChunks: how many chunks `size` bytes of plaintext are sealed in
+func gcmChunks(size uint64, chunkSize int) int64 {
+	chunks := int64((size + uint64(chunkSize) - 1) / uint64(chunkSize))
+	if chunks == 0 {
+		return 1
+	}
+	return chunks
+}
+
+// aes.EncryptGCM: encrypts and authenticates `plaintext` with AES-GCM under a random nonce
+// returns: ciphertext, decrypted by `DecryptGCM` or `DecryptGCMStream`, or error
+func EncryptGCM(key []byte, plaintext []byte) ([]byte, error) {
+	var cipherBuf bytes.Buffer
+	cipherBuf.Grow(int(GCMSize(int64(len(plaintext)), DefaultGCMChunkSize)))
+
+	err := EncryptGCMStream(key, bytes.NewReader(plaintext), uint64(len(plaintext)), &cipherBuf, DefaultGCMChunkSize)
+	if err != nil {
+		return nil, fmt.Errorf("aes.EncryptGCM: %w", err)
+	}
+	return cipherBuf.Bytes(), nil
+}
+
+// aes.DecryptGCM: decrypts `ciphertext` from `EncryptGCM` or `EncryptGCMStream`
+// returns: plaintext, or error wrapping `ErrAuthFailed` if it was modified or `ErrCorrupt` if its sizes dont add up
+func DecryptGCM(key []byte, ciphertext []byte) ([]byte, error) {
+	var plainBuf bytes.Buffer
+
+	err := DecryptGCMStream(key, bytes.NewReader(ciphertext), int64(len(ciphertext)), &plainBuf)
+	if err != nil {
+		return nil, fmt.Errorf("aes.DecryptGCM: %w", err)
+	}
+	return plainBuf.Bytes(), nil
+}
+
+// aes.EncryptGCMStream: like `EncryptGCM` but reads `size` bytes from `in` and writes to `out` one `chunkSize` chunk at a time
+// returns: error, `io.ErrUnexpectedEOF` if `in` has less than `size` bytes
+func EncryptGCMStream(key []byte, in io.Reader, size uint64, out io.Writer, chunkSize int) error {
+	nonce := make([]byte, GCMNonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return fmt.Errorf("aes.EncryptGCMStream: io.ReadFull(rand.Reader, nonce): %w", err)
+	}
+
+	err := EncryptGCMStreamWithNonce(key, in, size, out, chunkSize, nonce)
+	if err != nil {
+		return fmt.Errorf("aes.EncryptGCMStream: %w", err)
+	}
+	return nil
+}
+
+// aes.ConvergentNonce: the nonce to seal the plaintext read from `in` with `key` under for convergent encryption, an HMAC-SHA256 of it keyed with `key` cut to `GCMNonceSize`
+// the same key and plaintext always get the same nonce and so the same ciphertext, a different plaintext gets another nonce, so no nonce is used for two plaintexts
+// the ciphertexts give away which plaintexts are the same, which is what deduplicating them needs
+// returns: nonce, or error
+func ConvergentNonce(key []byte, in io.Reader) ([]byte, error) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("encryptdir convergent nonce\x00"))
+	_, err := io.Copy(mac, in)
+	if err != nil {
+		return nil, fmt.Errorf("aes.ConvergentNonce: io.Copy: %w", err)
+	}
+	return mac.Sum(nil)[:GCMNonceSize], nil
+}
+
+// aes.EncryptGCMConvergent: like `EncryptGCM` under the `ConvergentNonce` of `plaintext`, so the same key and plaintext always encrypt the same
+// returns: ciphertext, decrypted by `DecryptGCM` or `DecryptGCMStream`, or error
+func EncryptGCMConvergent(key []byte, plaintext []byte) ([]byte, error) {
+	nonce, err := ConvergentNonce(key, bytes.NewReader(plaintext))
+	if err != nil {
+		return nil, fmt.Errorf("aes.EncryptGCMConvergent: %w", err)
+	}
+
+	var cipherBuf bytes.Buffer
+	cipherBuf.Grow(int(GCMSize(int64(len(plaintext)), DefaultGCMChunkSize)))
+
+	err = EncryptGCMStreamWithNonce(key, bytes.NewReader(plaintext), uint64(len(plaintext)), &cipherBuf, DefaultGCMChunkSize, nonce)
+	if err != nil {
+		return nil, fmt.Errorf("aes.EncryptGCMConvergent: %w", err)
+	}
+	return cipherBuf.Bytes(), nil
+}
+
+// aes.EncryptGCMStreamWithNonce: like `EncryptGCMStream` under `nonce` instead of a random one, it must never be used with `key` for another plaintext
+// returns: error, `io.ErrUnexpectedEOF` if `in` has less than `size` bytes
+func EncryptGCMStreamWithNonce(key []byte, in io.Reader, size uint64, out io.Writer, chunkSize int, nonce []byte) error {
+	if chunkSize <= 0 || chunkSize > MaxGCMChunkSize {
+		return fmt.Errorf("aes.EncryptGCMStreamWithNonce: chunk size = %d: %w", chunkSize, ErrCorrupt)
+	}
+	if len(nonce) != GCMNonceSize {
+		return fmt.Errorf("aes.EncryptGCMStreamWithNonce: nonce size = %d, want %d", len(nonce), GCMNonceSize)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return fmt.Errorf("aes.EncryptGCMStreamWithNonce: %w", err)
+	}
+
+	header := make([]byte, GCMHeaderSize)
+	binary.LittleEndian.PutUint64(header[0:8], size)
+	binary.LittleEndian.PutUint32(header[8:12], uint32(chunkSize))
+	copy(header[12:], nonce)
+
+	_, err = out.Write(header)
+	if err != nil {
+		return fmt.Errorf("aes.EncryptGCMStreamWithNonce: out.Write(header): %w", err)
+	}
+
+	// a payload smaller than a chunk only needs a buffer its size
+	buf := make([]byte, bufSize(size, chunkSize), bufSize(size, chunkSize)+GCMTagSize)
+	chunks := gcmChunks(size, chunkSize)
+	done := uint64(0)
+	for i := int64(0); i < chunks; i++ {
+		n := uint64(chunkSize)
+		if size-done < n {
+			n = size - done
+		}
+
+		_, err = io.ReadFull(in, buf[:n])
+		if err != nil {
+			return fmt.Errorf("aes.EncryptGCMStreamWithNonce: io.ReadFull: %w", err)
+		}
+
+		sealed := gcm.Seal(buf[:0], chunkNonce(header[12:], i), buf[:n], header[:12])
+		_, err = out.Write(sealed)
+		if err != nil {
+			return fmt.Errorf("aes.EncryptGCMStreamWithNonce: out.Write: %w", err)
+		}
+		done += n
+	}
+
+	return nil
+}
+
+// aes.DecryptGCMStream: like `DecryptGCM` but reads the `inSize` bytes of ciphertext from `in` and writes to `out` one chunk at a time
+// each chunk is authenticated before it is written, a chunk failing stops the stream with the chunks before it already written
+// returns: error, wrapping `ErrAuthFailed` if a chunk was modified or `ErrCorrupt` if the sizes dont add up
+func DecryptGCMStream(key []byte, in io.Reader, inSize int64, out io.Writer) error {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return fmt.Errorf("aes.DecryptGCMStream: %w", err)
+	}
+
+	if inSize < GCMHeaderSize+GCMTagSize {
+		return fmt.Errorf("aes.DecryptGCMStream: size = %d: %w", inSize, ErrCorrupt)
+	}
+
+	header := make([]byte, GCMHeaderSize)
+	_, err = io.ReadFull(in, header)
+	if err != nil {
+		return fmt.Errorf("aes.DecryptGCMStream: io.ReadFull(header): %w", err)
+	}
+
+	size, chunkSize, err := parseGCMHeader(header, inSize)
+	if err != nil {
+		return fmt.Errorf("aes.DecryptGCMStream: %w", err)
+	}
+
+	buf := make([]byte, bufSize(size, chunkSize)+GCMTagSize)
+	chunks := gcmChunks(size, chunkSize)
+	done := uint64(0)
+	for i := int64(0); i < chunks; i++ {
+		n := uint64(chunkSize)
+		if size-done < n {
+			n = size - done
+		}
+
+		_, err = io.ReadFull(in, buf[:n+GCMTagSize])
+		if err != nil {
+			return fmt.Errorf("aes.DecryptGCMStream: io.ReadFull: %w", err)
+		}
+
+		plain, err := gcm.Open(buf[:0], chunkNonce(header[12:], i), buf[:n+GCMTagSize], header[:12])
+		if err != nil {
+			return fmt.Errorf("aes.DecryptGCMStream: chunk = %d: %w", i, ErrAuthFailed)
+		}
+
+		_, err = out.Write(plain)
+		if err != nil {
+			return fmt.Errorf("aes.DecryptGCMStream: out.Write: %w", err)
+		}
+		done += n
+	}
+
+	return nil
+}
+
+// GCMReader: `io.ReaderAt` over the plaintext of `EncryptGCM` output, only the chunks covering a read are decrypted and authenticated
+type GCMReader struct {
+	ra        io.ReaderAt
+	gcm       cipher.AEAD
+	header    []byte
+	size      int64
+	chunkSize int
+}
+
+// aes.NewGCMReader: reads arbitrary plaintext ranges of the `inSize` bytes of `EncryptGCM` output in `ra`
+// returns: reader, or error wrapping `ErrCorrupt` if the sizes dont add up
+func NewGCMReader(key []byte, ra io.ReaderAt, inSize int64) (*GCMReader, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, fmt.Errorf("aes.NewGCMReader: %w", err)
+	}
+
+	if inSize < GCMHeaderSize+GCMTagSize {
+		return nil, fmt.Errorf("aes.NewGCMReader: size = %d: %w", inSize, ErrCorrupt)
+	}
+
+	header := make([]byte, GCMHeaderSize)
+	_, err = ra.ReadAt(header, 0)
+	if err != nil {
+		return nil, fmt.Errorf("aes.NewGCMReader: ra.ReadAt: %w", err)
+	}
+
+	size, chunkSize, err := parseGCMHeader(header, inSize)
+	if err != nil {
+		return nil, fmt.Errorf("aes.NewGCMReader: %w", err)
+	}
+
+	return &GCMReader{ra: ra, gcm: gcm, header: header, size: int64(size), chunkSize: chunkSize}, nil
+}
+
+// aes.GCMReader.Size: size of the plaintext
+func (r *GCMReader) Size() int64 {
+	return r.size
+}
+
+// aes.GCMReader.ReadAt: decrypts `len(p)` plaintext bytes starting at `off`
+// returns: bytes read, `io.EOF` past the end of the plaintext, or error wrapping `ErrAuthFailed`
+func (r *GCMReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("aes.GCMReader.ReadAt: negative offset")
+	}
+
+	chunkSize := int64(r.chunkSize)
+	buf := make([]byte, int64(bufSize(uint64(r.size), r.chunkSize))+GCMTagSize)
+	n := 0
+	for n < len(p) && off < r.size {
+		i := off / chunkSize
+		plainLen := chunkSize
+		if r.size-i*chunkSize < plainLen {
+			plainLen = r.size - i*chunkSize
+		}
+
+		sealed := buf[:plainLen+GCMTagSize]
+		_, err := r.ra.ReadAt(sealed, GCMHeaderSize+i*(chunkSize+GCMTagSize))
+		if err != nil && !errors.Is(err, io.EOF) {
+			return n, fmt.Errorf("aes.GCMReader.ReadAt: ra.ReadAt: %w", err)
+		}
+
+		plain, err := r.gcm.Open(sealed[:0], chunkNonce(r.header[12:], i), sealed, r.header[:12])
+		if err != nil {
+			return n, fmt.Errorf("aes.GCMReader.ReadAt: chunk = %d: %w", i, ErrAuthFailed)
+		}
+
+		copied := copy(p[n:], plain[off-i*chunkSize:])
+		n += copied
+		off += int64(copied)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// aes.newGCM: AES-GCM with the standard nonce and tag sizes
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("aes.NewCipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("cipher.NewGCM: %w", err)
+	}
+	return gcm, nil
+}
+
+// aes.parseGCMHeader: the plaintext size and chunk size in `header`, checked against the `inSize` bytes of ciphertext
+// returns: sizes, or error wrapping `ErrCorrupt` if they dont add up
+func parseGCMHeader(header []byte, inSize int64) (uint64, int, error) {
+	size := binary.LittleEndian.Uint64(header[0:8])
+	chunkSize := int(binary.LittleEndian.Uint32(header[8:12]))
+	if chunkSize <= 0 || chunkSize > MaxGCMChunkSize || size > uint64(inSize) || GCMSize(int64(size), chunkSize) != inSize {
+		return 0, 0, fmt.Errorf("aes.parseGCMHeader: size = %d, chunk size = %d, ciphertext = %d: %w", size, chunkSize, inSize, ErrCorrupt)
+	}
+	return size, chunkSize, nil
+}
+
+// aes.chunkNonce: the nonce chunk `n` is sealed with, `nonce` with `n` xored into its last 4 bytes
+func chunkNonce(nonce []byte, n int64) []byte {
+	chunk := make([]byte, len(nonce))
+	copy(chunk, nonce)
+	binary.BigEndian.PutUint32(chunk[8:], binary.BigEndian.Uint32(chunk[8:])^uint32(n))
+	return chunk
+}
@@ -0,0 +1,207 @@
+package aes
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestGCMStreamSizes(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	chunkSize := 64
+
+	// payloads smaller than a chunk get a buffer their size, the rest a chunk, either way they come back whole
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 5} {
+		plaintext := bytes.Repeat([]byte("a"), size)
+
+		var sealed bytes.Buffer
+		err := EncryptGCMStream(key, bytes.NewReader(plaintext), uint64(size), &sealed, chunkSize)
+		if err != nil {
+			t.Fatalf("size = %d: EncryptGCMStream: %v", size, err)
+		}
+		if int64(sealed.Len()) != GCMSize(int64(size), chunkSize) {
+			t.Errorf("size = %d: EncryptGCMStream wrote %d bytes, want %d", size, sealed.Len(), GCMSize(int64(size), chunkSize))
+		}
+
+		got, err := DecryptGCM(key, sealed.Bytes())
+		if err != nil || !bytes.Equal(got, plaintext) {
+			t.Errorf("size = %d: DecryptGCM = %d bytes, %v, want %d", size, len(got), err, size)
+		}
+
+		r, err := NewGCMReader(key, bytes.NewReader(sealed.Bytes()), int64(sealed.Len()))
+		if err != nil {
+			t.Fatalf("size = %d: NewGCMReader: %v", size, err)
+		}
+		at := make([]byte, size)
+		_, err = r.ReadAt(at, 0)
+		if (err != nil && size > 0) || !bytes.Equal(at, plaintext) {
+			t.Errorf("size = %d: GCMReader.ReadAt = %v", size, err)
+		}
+	}
+}
+
+func TestStreamSizes(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	chunkSize := 64
+
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 5} {
+		plaintext := bytes.Repeat([]byte("a"), size)
+
+		var sealed bytes.Buffer
+		err := EncryptStream(key, bytes.NewReader(plaintext), uint64(size), &sealed, chunkSize)
+		if err != nil {
+			t.Fatalf("size = %d: EncryptStream: %v", size, err)
+		}
+
+		var got bytes.Buffer
+		err = DecryptStream(key, bytes.NewReader(sealed.Bytes()), int64(sealed.Len()), &got, chunkSize)
+		if err != nil || !bytes.Equal(got.Bytes(), plaintext) {
+			t.Errorf("size = %d: DecryptStream = %d bytes, %v, want %d", size, got.Len(), err, size)
+		}
+	}
+}
+
+func TestGCMConvergent(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	plaintext := []byte("hello")
+
+	a, err := EncryptGCMConvergent(key, plaintext)
+	if err != nil {
+		t.Fatalf("EncryptGCMConvergent: %v", err)
+	}
+	b, err := EncryptGCMConvergent(key, plaintext)
+	if err != nil {
+		t.Fatalf("EncryptGCMConvergent: %v", err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Error("EncryptGCMConvergent: the same plaintext encrypted differently")
+	}
+
+	other, err := EncryptGCMConvergent(key, []byte("world"))
+	if err != nil {
+		t.Fatalf("EncryptGCMConvergent: %v", err)
+	}
+	if bytes.Equal(a[GCMHeaderSize-GCMNonceSize:GCMHeaderSize], other[GCMHeaderSize-GCMNonceSize:GCMHeaderSize]) {
+		t.Error("EncryptGCMConvergent: another plaintext got the same nonce")
+	}
+
+	// streamed under the same nonce it is the same too
+	nonce, err := ConvergentNonce(key, bytes.NewReader(plaintext))
+	if err != nil {
+		t.Fatalf("ConvergentNonce: %v", err)
+	}
+	var streamed bytes.Buffer
+	err = EncryptGCMStreamWithNonce(key, bytes.NewReader(plaintext), uint64(len(plaintext)), &streamed, DefaultGCMChunkSize, nonce)
+	if err != nil || !bytes.Equal(streamed.Bytes(), a) {
+		t.Errorf("EncryptGCMStreamWithNonce = %x, %v, want %x", streamed.Bytes(), err, a)
+	}
+
+	got, err := DecryptGCM(key, a)
+	if err != nil || !bytes.Equal(got, plaintext) {
+		t.Errorf("DecryptGCM = %q, %v, want %q", got, err, plaintext)
+	}
+}
+
+// countingWriter: discards what is written to it, counting the bytes
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// zeroReader: an endless stream of zero bytes, so a big plaintext never has to be in memory
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestDecryptGCMStreamLarge(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	chunkSize := 1 << 20
+	size := int64(64 << 20)
+
+	f, err := os.CreateTemp(t.TempDir(), "sealed")
+	if err != nil {
+		t.Fatalf("os.CreateTemp: %v", err)
+	}
+	defer f.Close()
+	err = EncryptGCMStream(key, zeroReader{}, uint64(size), f, chunkSize)
+	if err != nil {
+		t.Fatalf("EncryptGCMStream: %v", err)
+	}
+	sealedSize := GCMSize(size, chunkSize)
+
+	// only a chunk at a time is held, not the file
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	var out countingWriter
+	err = DecryptGCMStream(key, io.NewSectionReader(f, 0, sealedSize), sealedSize, &out)
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatalf("DecryptGCMStream: %v", err)
+	}
+	if out.n != size {
+		t.Errorf("DecryptGCMStream wrote %d bytes, want %d", out.n, size)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 8<<20 {
+		t.Errorf("DecryptGCMStream allocated %d bytes for a %d byte file, want a few chunks", alloc, size)
+	}
+
+	// a bad chunk stops the stream with only the chunks before it written
+	bad := int64(5)
+	off := GCMHeaderSize + bad*int64(chunkSize+GCMTagSize)
+	_, err = f.WriteAt([]byte{0xff}, off)
+	if err != nil {
+		t.Fatalf("f.WriteAt: %v", err)
+	}
+	out = countingWriter{}
+	err = DecryptGCMStream(key, io.NewSectionReader(f, 0, sealedSize), sealedSize, &out)
+	if !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("DecryptGCMStream: err = %v, want ErrAuthFailed", err)
+	}
+	if out.n != bad*int64(chunkSize) {
+		t.Errorf("DecryptGCMStream wrote %d bytes before the bad chunk, want %d", out.n, bad*int64(chunkSize))
+	}
+}
+
+func TestGCMTampered(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	sealed, err := EncryptGCM(key, []byte("attack at dawn, bring snacks"))
+	if err != nil {
+		t.Fatalf("EncryptGCM: %v", err)
+	}
+
+	// any flipped byte fails, the sizes in front either dont add up or take the tags with them
+	for off := range sealed {
+		tampered := append([]byte(nil), sealed...)
+		tampered[off] ^= 1
+		_, err := DecryptGCM(key, tampered)
+		if off >= GCMHeaderSize {
+			if !errors.Is(err, ErrAuthFailed) {
+				t.Errorf("byte %d flipped: err = %v, want ErrAuthFailed", off, err)
+			}
+		} else if !errors.Is(err, ErrAuthFailed) && !errors.Is(err, ErrCorrupt) {
+			t.Errorf("byte %d flipped: err = %v, want ErrAuthFailed or ErrCorrupt", off, err)
+		}
+	}
+
+	_, err = DecryptGCM(key, sealed[:len(sealed)-1])
+	if err == nil {
+		t.Errorf("DecryptGCM of a cut short ciphertext: err = nil")
+	}
+	_, err = DecryptGCM(bytes.Repeat([]byte{2}, 32), sealed)
+	if !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("DecryptGCM with another key: err = %v, want ErrAuthFailed", err)
+	}
+}
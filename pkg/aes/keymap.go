@@ -0,0 +1,111 @@
+package aes
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/knadh/koanf/parsers/json"
+	"github.com/knadh/koanf/parsers/yaml"
+)
+
+// sentinel error used for when a key map value isn't a string of base64 or `hex:` prefixed hex
+var ErrBadKeyEncoding = errors.New("key is not valid base64 or hex")
+
+// sentinel error used for when a decoded key isn't 16, 24, or 32 bytes
+var ErrBadKeyLength = errors.New("key is not a valid AES length")
+
+// prefix of the environment variables `LoadKeyMapFromEnv` reads when given none, like `ENCRYPTDIR_KEY_TXT`
+const DefaultKeyEnvPrefix = "ENCRYPTDIR_KEY_"
+
+// prefix of a key map value written in hex, values without it are standard base64 like `WriteKeys` writes
+const HexKeyPrefix = "hex:"
+
+// aes.LoadKeyMap: reads a plaintext key map from the JSON or YAML file at `path`, picked by a `.yaml` or `.yml` extension
+// the file maps extensions to keys, each key is standard base64 or hex prefixed with `HexKeyPrefix`
+// returns: key map, or error wrapping `ErrBadKeyEncoding` or `ErrBadKeyLength` naming the extension
+func LoadKeyMap(path string) (map[string][]byte, error) {
+	payload, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("aes.LoadKeyMap: os.ReadFile: %w", err)
+	}
+
+	var raw map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		raw, err = yaml.Parser().Unmarshal(payload)
+	default:
+		raw, err = json.Parser().Unmarshal(payload)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("aes.LoadKeyMap: path = %q: %w", path, err)
+	}
+
+	keyMap := make(map[string][]byte, len(raw))
+	for ext, value := range raw {
+		encoded, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("aes.LoadKeyMap: extension = %q, value = %T: %w", ext, value, ErrBadKeyEncoding)
+		}
+
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("aes.LoadKeyMap: extension = %q: %w", ext, err)
+		}
+		keyMap[ext] = key
+	}
+
+	return keyMap, nil
+}
+
+// aes.LoadKeyMapFromEnv: reads a key map from the environment variables starting with `prefix`, an empty `prefix` means `DefaultKeyEnvPrefix`
+// the rest of the name, lowercased, is the extension, like `txt` for `ENCRYPTDIR_KEY_TXT`, variables with nothing after `prefix` are ignored
+// values are encoded like `LoadKeyMap` values, standard base64 or hex prefixed with `HexKeyPrefix`
+// returns: key map, empty if no variable matches, or error wrapping `ErrBadKeyEncoding` or `ErrBadKeyLength` naming the variable
+func LoadKeyMapFromEnv(prefix string) (map[string][]byte, error) {
+	if len(prefix) == 0 {
+		prefix = DefaultKeyEnvPrefix
+	}
+
+	keyMap := make(map[string][]byte)
+	for _, env := range os.Environ() {
+		name, encoded, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(name, prefix) || len(name) == len(prefix) {
+			continue
+		}
+
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("aes.LoadKeyMapFromEnv: variable = %q: %w", name, err)
+		}
+		keyMap[strings.ToLower(strings.TrimPrefix(name, prefix))] = key
+	}
+
+	return keyMap, nil
+}
+
+// aes.decodeKey: decodes a key map value, standard base64 or hex prefixed with `HexKeyPrefix`
+// returns: key, or error wrapping `ErrBadKeyEncoding` or `ErrBadKeyLength`
+func decodeKey(encoded string) ([]byte, error) {
+	var key []byte
+	var err error
+	if strings.HasPrefix(encoded, HexKeyPrefix) {
+		key, err = hex.DecodeString(strings.TrimPrefix(encoded, HexKeyPrefix))
+	} else {
+		key, err = base64.StdEncoding.DecodeString(encoded)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("aes.decodeKey: %w", ErrBadKeyEncoding)
+	}
+
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("aes.decodeKey: key = %d bytes, want 16, 24, or 32: %w", len(key), ErrBadKeyLength)
+	}
+	return key, nil
+}
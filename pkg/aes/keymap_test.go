@@ -0,0 +1,112 @@
+package aes
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeKeyMap: writes `payload` to `name` in a temp dir
+// returns: path of the file
+func writeKeyMap(t *testing.T, name string, payload string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(payload), 0600)
+	if err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	return path
+}
+
+func TestLoadKeyMap(t *testing.T) {
+	txt := bytes.Repeat([]byte{1}, 16)
+	sql := bytes.Repeat([]byte{2}, 32)
+	b64, hx := base64.StdEncoding.EncodeToString(txt), HexKeyPrefix+hex.EncodeToString(sql)
+
+	for name, payload := range map[string]string{
+		"keys.json": `{"txt": "` + b64 + `", "sql": "` + hx + `"}`,
+		"keys.yaml": "txt: " + b64 + "\nsql: " + hx + "\n",
+		"keys.yml":  "txt: " + b64 + "\nsql: " + hx + "\n",
+	} {
+		keyMap, err := LoadKeyMap(writeKeyMap(t, name, payload))
+		if err != nil {
+			t.Fatalf("LoadKeyMap(%s): %v", name, err)
+		}
+		if len(keyMap) != 2 || !bytes.Equal(keyMap["txt"], txt) || !bytes.Equal(keyMap["sql"], sql) {
+			t.Errorf("LoadKeyMap(%s) = %v, want the base64 txt key and the hex sql key", name, keyMap)
+		}
+	}
+}
+
+func TestLoadKeyMapInvalid(t *testing.T) {
+	for name, test := range map[string]struct {
+		payload string
+		want    error
+	}{
+		"bad base64":   {`{"txt": "not base64!"}`, ErrBadKeyEncoding},
+		"bad hex":      {`{"txt": "hex:zz"}`, ErrBadKeyEncoding},
+		"short key":    {`{"txt": "` + base64.StdEncoding.EncodeToString(make([]byte, 15)) + `"}`, ErrBadKeyLength},
+		"long hex key": {`{"txt": "hex:` + hex.EncodeToString(make([]byte, 33)) + `"}`, ErrBadKeyLength},
+		"not a string": {`{"txt": 16}`, ErrBadKeyEncoding},
+	} {
+		_, err := LoadKeyMap(writeKeyMap(t, "keys.json", test.payload))
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: err = %v, want %v", name, err, test.want)
+			continue
+		}
+		// which key is bad, and for a bad length how long it is
+		if !strings.Contains(err.Error(), `extension = "txt"`) {
+			t.Errorf("%s: err = %v, want it to name the extension", name, err)
+		}
+		if test.want == ErrBadKeyLength && !strings.Contains(err.Error(), " bytes") {
+			t.Errorf("%s: err = %v, want it to give the length", name, err)
+		}
+	}
+
+	_, err := LoadKeyMap(writeKeyMap(t, "keys.json", "{not json"))
+	if err == nil {
+		t.Errorf("LoadKeyMap of a malformed file: err = nil")
+	}
+}
+
+func TestLoadKeyMapFromEnv(t *testing.T) {
+	txt := bytes.Repeat([]byte{1}, 16)
+	pdf := bytes.Repeat([]byte{2}, 32)
+	t.Setenv(DefaultKeyEnvPrefix+"TXT", base64.StdEncoding.EncodeToString(txt))
+	t.Setenv(DefaultKeyEnvPrefix+"PDF", HexKeyPrefix+hex.EncodeToString(pdf))
+	// nothing after the prefix, and another prefix, are ignored
+	t.Setenv(DefaultKeyEnvPrefix, base64.StdEncoding.EncodeToString(txt))
+	t.Setenv("OTHER_KEY_SQL", base64.StdEncoding.EncodeToString(txt))
+
+	keyMap, err := LoadKeyMapFromEnv("")
+	if err != nil {
+		t.Fatalf("LoadKeyMapFromEnv: %v", err)
+	}
+	if len(keyMap) != 2 || !bytes.Equal(keyMap["txt"], txt) || !bytes.Equal(keyMap["pdf"], pdf) {
+		t.Errorf("LoadKeyMapFromEnv = %v, want the txt and pdf keys", keyMap)
+	}
+
+	keyMap, err = LoadKeyMapFromEnv("OTHER_KEY_")
+	if err != nil || len(keyMap) != 1 || !bytes.Equal(keyMap["sql"], txt) {
+		t.Errorf("LoadKeyMapFromEnv(OTHER_KEY_) = %v, %v, want only the sql key", keyMap, err)
+	}
+}
+
+func TestLoadKeyMapFromEnvInvalid(t *testing.T) {
+	for value, want := range map[string]error{
+		"not base64!": ErrBadKeyEncoding,
+		base64.StdEncoding.EncodeToString(make([]byte, 20)): ErrBadKeyLength,
+	} {
+		t.Setenv("TEST_KEY_CSV", value)
+		_, err := LoadKeyMapFromEnv("TEST_KEY_")
+		if !errors.Is(err, want) || !strings.Contains(err.Error(), `variable = "TEST_KEY_CSV"`) {
+			t.Errorf("value = %q: err = %v, want %v naming the variable", value, err, want)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package aes
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// layout of the output of `NewEncryptWriter`, for streams whose size isnt known until they end
+//
+//	[chunk size][nonce][chunk]...
+//
+// chunk size is a little endian uint32, every chunk but the last is `chunk size` bytes of plaintext and has its own tag
+// the last chunk has 0 to `chunk size` bytes, there is always one so even an empty stream has a tag
+// chunk `n` is sealed with the nonce with `n` xored into its last 4 bytes, like `EncryptGCMStream`
+// the chunk size and nonce, followed by a byte that is 1 for the last chunk and 0 otherwise, are the additional data of every chunk,
+// so a stream cut short at a chunk boundary fails to authenticate instead of reading as a shorter one
+// this isnt the layout of `EncryptGCM`, which records the plaintext size up front, neither one decrypts the other
+const PipeHeaderSize = 4 + GCMNonceSize
+
+// encryptWriter: the `io.WriteCloser` of `NewEncryptWriter`
+type encryptWriter struct {
+	w      io.Writer
+	gcm    cipher.AEAD
+	header []byte
+
+	// plaintext not sealed yet, a full chunk is held until more comes since it might be the last
+	buf    []byte
+	chunk  int64
+	err    error
+	closed bool
+}
+
+// aes.NewEncryptWriter: encrypts and authenticates everything written to it with AES-GCM under a random nonce, into `w`
+// plaintext is sealed `DefaultGCMChunkSize` bytes at a time as it is written, the last chunk is only sealed by `Close`,
+// which has to be called or the output doesnt decrypt, it doesnt close `w`
+// returns: writer, decrypted by `NewDecryptReader`, or error
+func NewEncryptWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, fmt.Errorf("aes.NewEncryptWriter: %w", err)
+	}
+
+	header := make([]byte, PipeHeaderSize)
+	binary.LittleEndian.PutUint32(header[0:4], uint32(DefaultGCMChunkSize))
+	if _, err = io.ReadFull(rand.Reader, header[4:]); err != nil {
+		return nil, fmt.Errorf("aes.NewEncryptWriter: io.ReadFull(rand.Reader, nonce): %w", err)
+	}
+
+	_, err = w.Write(header)
+	if err != nil {
+		return nil, fmt.Errorf("aes.NewEncryptWriter: w.Write(header): %w", err)
+	}
+
+	return &encryptWriter{
+		w:      w,
+		gcm:    gcm,
+		header: header,
+		buf:    make([]byte, 0, DefaultGCMChunkSize+GCMTagSize),
+	}, nil
+}
+
+// aes.encryptWriter.Write: buffers `p`, sealing and writing every chunk that is known not to be the last
+// returns: len(p), or 0 and the error of the first failed write, every later call fails with it too
+func (ew *encryptWriter) Write(p []byte) (int, error) {
+	if ew.err != nil {
+		return 0, ew.err
+	}
+	if ew.closed {
+		return 0, errors.New("aes.encryptWriter.Write: write after close")
+	}
+
+	n := len(p)
+	for len(p) > 0 {
+		if len(ew.buf) == DefaultGCMChunkSize {
+			err := ew.seal(false)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		copied := DefaultGCMChunkSize - len(ew.buf)
+		if len(p) < copied {
+			copied = len(p)
+		}
+		ew.buf = append(ew.buf, p[:copied]...)
+		p = p[copied:]
+	}
+	return n, nil
+}
+
+// aes.encryptWriter.Close: seals and writes the last chunk, calling it again does nothing
+// returns: error
+func (ew *encryptWriter) Close() error {
+	if ew.err != nil {
+		return ew.err
+	}
+	if ew.closed {
+		return nil
+	}
+	ew.closed = true
+	return ew.seal(true)
+}
+
+// aes.encryptWriter.seal: seals the buffered plaintext as the next chunk, `last` or not, and writes it
+// returns: error, also kept for every later call
+func (ew *encryptWriter) seal(last bool) error {
+	if ew.chunk > math.MaxUint32 {
+		ew.err = fmt.Errorf("aes.encryptWriter.seal: chunk = %d: too many chunks for one nonce", ew.chunk)
+		return ew.err
+	}
+
+	sealed := ew.gcm.Seal(ew.buf[:0], chunkNonce(ew.header[4:], ew.chunk), ew.buf, pipeAdditionalData(ew.header, last))
+	_, err := ew.w.Write(sealed)
+	if err != nil {
+		ew.err = fmt.Errorf("aes.encryptWriter.seal: w.Write: %w", err)
+		return ew.err
+	}
+
+	ew.buf = ew.buf[:0]
+	ew.chunk++
+	return nil
+}
+
+// decryptReader: the `io.Reader` of `NewDecryptReader`
+type decryptReader struct {
+	r         io.Reader
+	gcm       cipher.AEAD
+	header    []byte
+	chunkSize int
+
+	// ciphertext of the next chunk, and the first byte after it once read, which tells it isnt the last
+	in      []byte
+	pending int
+	// plaintext of the last chunk opened that hasnt been read yet, in `out`
+	out   []byte
+	plain []byte
+	chunk int64
+	last  bool
+	err   error
+}
+
+// aes.NewDecryptReader: decrypts the output of `NewEncryptWriter` read from `r`
+// each chunk is authenticated before any of its plaintext is returned, a chunk failing stops the stream with the chunks before it already read
+// only the header is read before returning, the chunks are read as the plaintext is
+// returns: reader, whose reads fail wrapping `ErrAuthFailed` if the ciphertext was modified or cut short, or error wrapping `ErrCorrupt`
+func NewDecryptReader(r io.Reader, key []byte) (io.Reader, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, fmt.Errorf("aes.NewDecryptReader: %w", err)
+	}
+
+	header := make([]byte, PipeHeaderSize)
+	_, err = io.ReadFull(r, header)
+	if err != nil {
+		return nil, fmt.Errorf("aes.NewDecryptReader: io.ReadFull(header): %w: %w", ErrCorrupt, err)
+	}
+
+	chunkSize := int(binary.LittleEndian.Uint32(header[0:4]))
+	if chunkSize <= 0 || chunkSize > MaxGCMChunkSize {
+		return nil, fmt.Errorf("aes.NewDecryptReader: chunk size = %d: %w", chunkSize, ErrCorrupt)
+	}
+
+	return &decryptReader{
+		r:         r,
+		gcm:       gcm,
+		header:    header,
+		chunkSize: chunkSize,
+		in:        make([]byte, chunkSize+GCMTagSize+1),
+		out:       make([]byte, 0, chunkSize),
+	}, nil
+}
+
+// aes.decryptReader.Read: reads plaintext, opening the next chunk once the one before is used up
+// returns: bytes read, `io.EOF` after the last chunk, or error
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.plain) == 0 {
+		if dr.err != nil {
+			return 0, dr.err
+		}
+		if dr.last {
+			return 0, io.EOF
+		}
+		dr.err = dr.open()
+	}
+
+	n := copy(p, dr.plain)
+	dr.plain = dr.plain[n:]
+	return n, nil
+}
+
+// aes.decryptReader.open: reads and opens the next chunk, it is the last one if nothing follows it
+// returns: error
+func (dr *decryptReader) open() error {
+	n, err := io.ReadFull(dr.r, dr.in[dr.pending:])
+	got := dr.pending + n
+	switch {
+	case err == nil:
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		dr.last = true
+	default:
+		return fmt.Errorf("aes.decryptReader.open: io.ReadFull: %w", err)
+	}
+
+	sealedLen := got
+	if !dr.last {
+		sealedLen = dr.chunkSize + GCMTagSize
+	}
+	if sealedLen < GCMTagSize {
+		return fmt.Errorf("aes.decryptReader.open: chunk = %d: %w", dr.chunk, ErrCorrupt)
+	}
+	if dr.chunk > math.MaxUint32 {
+		return fmt.Errorf("aes.decryptReader.open: chunk = %d: %w", dr.chunk, ErrCorrupt)
+	}
+
+	plain, err := dr.gcm.Open(dr.out[:0], chunkNonce(dr.header[4:], dr.chunk), dr.in[:sealedLen], pipeAdditionalData(dr.header, dr.last))
+	if err != nil {
+		return fmt.Errorf("aes.decryptReader.open: chunk = %d: %w", dr.chunk, ErrAuthFailed)
+	}
+
+	// the byte read past the chunk starts the next one
+	if !dr.last {
+		dr.in[0] = dr.in[sealedLen]
+		dr.pending = 1
+	}
+	dr.plain = plain
+	dr.chunk++
+	return nil
+}
+
+// aes.pipeAdditionalData: the additional data of a chunk of `NewEncryptWriter` output with `header`, `last` or not
+func pipeAdditionalData(header []byte, last bool) []byte {
+	ad := make([]byte, len(header)+1)
+	copy(ad, header)
+	if last {
+		ad[len(header)] = 1
+	}
+	return ad
+}
@@ -0,0 +1,122 @@
+package aes
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// pipeSeal: `plaintext` through `NewEncryptWriter` with `key`, written in uneven pieces
+func pipeSeal(t *testing.T, key []byte, plaintext []byte) []byte {
+	t.Helper()
+
+	var sealed bytes.Buffer
+	w, err := NewEncryptWriter(&sealed, key)
+	if err != nil {
+		t.Fatalf("NewEncryptWriter: %v", err)
+	}
+	for p := plaintext; len(p) > 0; {
+		n := 7777
+		if len(p) < n {
+			n = len(p)
+		}
+		_, err = w.Write(p[:n])
+		if err != nil {
+			t.Fatalf("encryptWriter.Write: %v", err)
+		}
+		p = p[n:]
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("encryptWriter.Close: %v", err)
+	}
+	return sealed.Bytes()
+}
+
+func TestPipeRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+
+	// the last chunk is short, full, or empty
+	for _, size := range []int{0, 1, DefaultGCMChunkSize - 1, DefaultGCMChunkSize, DefaultGCMChunkSize + 1, 2*DefaultGCMChunkSize + 17} {
+		plaintext := make([]byte, size)
+		for i := range plaintext {
+			plaintext[i] = byte(i * 31)
+		}
+
+		// piped, so neither side knows the size up front
+		pr, pw := io.Pipe()
+		go func() {
+			w, err := NewEncryptWriter(pw, key)
+			if err == nil {
+				_, err = w.Write(plaintext)
+			}
+			if err == nil {
+				err = w.Close()
+			}
+			pw.CloseWithError(err)
+		}()
+
+		r, err := NewDecryptReader(pr, key)
+		if err != nil {
+			t.Fatalf("size = %d: NewDecryptReader: %v", size, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(got, plaintext) {
+			t.Errorf("size = %d: read %d bytes, %v, want %d", size, len(got), err, size)
+		}
+
+		sealed := pipeSeal(t, key, plaintext)
+		// a full last chunk isnt followed by an empty one, only an empty stream has one
+		chunks := (size + DefaultGCMChunkSize - 1) / DefaultGCMChunkSize
+		if chunks == 0 {
+			chunks = 1
+		}
+		if len(sealed) != PipeHeaderSize+size+chunks*GCMTagSize {
+			t.Errorf("size = %d: %d bytes sealed, want %d", size, len(sealed), PipeHeaderSize+size+chunks*GCMTagSize)
+		}
+	}
+}
+
+func TestPipeTampered(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	plaintext := bytes.Repeat([]byte("a"), 2*DefaultGCMChunkSize+17)
+	sealed := pipeSeal(t, key, plaintext)
+	full := DefaultGCMChunkSize + GCMTagSize
+
+	flipped := append([]byte(nil), sealed...)
+	flipped[len(flipped)-1] ^= 0xff
+	for name, in := range map[string][]byte{
+		"flipped":      flipped,
+		"cut in chunk": sealed[:len(sealed)-5],
+		// looks like it ends after two full chunks, but neither was sealed as the last
+		"cut at chunk": sealed[:PipeHeaderSize+2*full],
+	} {
+		r, err := NewDecryptReader(bytes.NewReader(in), key)
+		if err != nil {
+			t.Fatalf("%s: NewDecryptReader: %v", name, err)
+		}
+		got, err := io.ReadAll(r)
+		if !errors.Is(err, ErrAuthFailed) {
+			t.Errorf("%s: err = %v, want ErrAuthFailed", name, err)
+		}
+		// the chunks before the bad one were already read
+		if len(got)%DefaultGCMChunkSize != 0 || !bytes.Equal(got, plaintext[:len(got)]) {
+			t.Errorf("%s: read %d bytes, want whole chunks of the plaintext", name, len(got))
+		}
+	}
+
+	r, err := NewDecryptReader(bytes.NewReader(sealed), bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatalf("NewDecryptReader: %v", err)
+	}
+	_, err = io.ReadAll(r)
+	if !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("other key: err = %v, want ErrAuthFailed", err)
+	}
+
+	_, err = NewDecryptReader(bytes.NewReader(sealed[:PipeHeaderSize-1]), key)
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("short header: err = %v, want ErrCorrupt", err)
+	}
+}
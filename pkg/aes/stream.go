@@ -0,0 +1,141 @@
+package aes
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// aes.CipherSize: size of the output of `Encrypt` or `EncryptStream` for `size` bytes of plaintext
+func CipherSize(size int64) int64 {
+	padded := size
+	if size%aes.BlockSize != 0 {
+		padded += aes.BlockSize - size%aes.BlockSize
+	}
+	return 8 + aes.BlockSize + padded
+}
+
+// aes.bufSize: size of the buffer `size` bytes go through `chunkSize` bytes at a time, a payload smaller than a chunk only needs its own size
+func bufSize(size uint64, chunkSize int) int {
+	if size < uint64(chunkSize) {
+		return int(size)
+	}
+	return chunkSize
+}
+
+// aes.EncryptStream: like `Encrypt` but reads `size` bytes from `in` and writes to `out` `chunkSize` bytes at a time
+// the output is the same format as `Encrypt`, either one can be decrypted by `Decrypt` or `DecryptStream`
+// returns: error, `io.ErrUnexpectedEOF` if `in` has less than `size` bytes
+func EncryptStream(key []byte, in io.Reader, size uint64, out io.Writer, chunkSize int) error {
+	cipherBlock, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("aes.EncryptStream: aes.NewCipher: %w", err)
+	}
+
+	err = binary.Write(out, binary.LittleEndian, size)
+	if err != nil {
+		return fmt.Errorf("aes.EncryptStream: binary.Write: %w", err)
+	}
+
+	iv := make([]byte, cipherBlock.BlockSize())
+	if _, err = io.ReadFull(rand.Reader, iv); err != nil {
+		return fmt.Errorf("aes.EncryptStream: io.ReadFull(rand.Reader, iv): %w", err)
+	}
+
+	_, err = out.Write(iv)
+	if err != nil {
+		return fmt.Errorf("aes.EncryptStream: out.Write(iv): %w", err)
+	}
+
+	stream := cipher.NewCTR(cipherBlock, iv)
+	buf := make([]byte, bufSize(size, chunkSize))
+	for done := uint64(0); done < size; {
+		n := uint64(chunkSize)
+		if size-done < n {
+			n = size - done
+		}
+
+		_, err = io.ReadFull(in, buf[:n])
+		if err != nil {
+			return fmt.Errorf("aes.EncryptStream: io.ReadFull: %w", err)
+		}
+
+		stream.XORKeyStream(buf[:n], buf[:n])
+		_, err = out.Write(buf[:n])
+		if err != nil {
+			return fmt.Errorf("aes.EncryptStream: out.Write: %w", err)
+		}
+		done += n
+	}
+
+	// same random padding as `Encrypt`
+	if size%aes.BlockSize != 0 {
+		padding := make([]byte, aes.BlockSize-size%aes.BlockSize)
+		if _, err := rand.Read(padding); err != nil {
+			return fmt.Errorf("aes.EncryptStream: rand.Read(padding): %w", err)
+		}
+
+		stream.XORKeyStream(padding, padding)
+		_, err = out.Write(padding)
+		if err != nil {
+			return fmt.Errorf("aes.EncryptStream: out.Write(padding): %w", err)
+		}
+	}
+
+	return nil
+}
+
+// aes.DecryptStream: like `Decrypt` but reads the `inSize` bytes of ciphertext from `in` and writes to `out` `chunkSize` bytes at a time
+// returns: error, wrapping `ErrCorrupt` if the sizes dont add up
+func DecryptStream(key []byte, in io.Reader, inSize int64, out io.Writer, chunkSize int) error {
+	cipherBlock, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("aes.DecryptStream: aes.NewCipher: %w", err)
+	}
+
+	if inSize < 8+aes.BlockSize {
+		return fmt.Errorf("aes.DecryptStream: size = %d: %w", inSize, ErrCorrupt)
+	}
+
+	var origSize uint64
+	err = binary.Read(in, binary.LittleEndian, &origSize)
+	if err != nil {
+		return fmt.Errorf("aes.DecryptStream: binary.Read: %w", err)
+	}
+
+	iv := make([]byte, cipherBlock.BlockSize())
+	if _, err := io.ReadFull(in, iv); err != nil {
+		return fmt.Errorf("aes.DecryptStream: io.ReadFull(iv): %w", err)
+	}
+
+	padded := uint64(inSize) - 8 - aes.BlockSize
+	if padded%aes.BlockSize != 0 || origSize > padded || padded-origSize >= aes.BlockSize {
+		return fmt.Errorf("aes.DecryptStream: size = %d, padded = %d: %w", origSize, padded, ErrCorrupt)
+	}
+
+	stream := cipher.NewCTR(cipherBlock, iv)
+	buf := make([]byte, bufSize(origSize, chunkSize))
+	for done := uint64(0); done < origSize; {
+		n := uint64(chunkSize)
+		if origSize-done < n {
+			n = origSize - done
+		}
+
+		_, err = io.ReadFull(in, buf[:n])
+		if err != nil {
+			return fmt.Errorf("aes.DecryptStream: io.ReadFull: %w", err)
+		}
+
+		stream.XORKeyStream(buf[:n], buf[:n])
+		_, err = out.Write(buf[:n])
+		if err != nil {
+			return fmt.Errorf("aes.DecryptStream: out.Write: %w", err)
+		}
+		done += n
+	}
+
+	return nil
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"crypto/rsa"
 	"fmt"
+	"time"
 
 	"github.com/knadh/koanf"
 	"github.com/knadh/koanf/parsers/yaml"
@@ -16,13 +17,174 @@ type Config struct {
 	PublicKeyFile  string `koanf:"public_key"`
 	PrivateKeyFile string `koanf:"private_key"`
 	AESKeyFile     string `koanf:"aes_key"`
+	// public key the AES key of every encrypted file is also wrapped for, so a recovery agent holding its private key can decrypt them
+	EscrowKeyFile string `koanf:"escrow_key"`
 
 	Directories []string `koanf:"directories"`
 	Files       []string `koanf:"files"`
 
+	// what to do when a `.dec` file already exists while decrypting: skip, overwrite, or error
+	DecSibling string `koanf:"dec_sibling"`
+
+	// plaintext line kept above the ciphertext of every encrypted file
+	Banner string `koanf:"banner"`
+
+	// number of files checked against the private key before decrypting, 0 turns the check off
+	PreflightSamples int `koanf:"preflight_samples"`
+
+	// regular expression, only files with matching content are encrypted
+	ContentMatch string `koanf:"content_match"`
+	// bytes of each file scanned for `content_match`
+	ContentMatchLimit int64 `koanf:"content_match_limit"`
+
+	// older AES key files whose keys are also tried when decrypting
+	KeyringFiles []string `koanf:"keyring"`
+
+	// write over files directly instead of through a temp file, not crash safe
+	DirectWrite bool `koanf:"direct_write"`
+
+	// keep extended attributes of files, linux and darwin only
+	PreserveXattrs bool `koanf:"preserve_xattrs"`
+
+	// keep the access and modification times of files, and their owner when running as root
+	PreserveMetadata bool `koanf:"preserve_metadata"`
+	// fail files whose times, owner or mode couldnt be restored instead of logging a warning
+	StrictMetadata bool `koanf:"strict_metadata"`
+
+	// octal permissions of every output file like "0600", empty keeps the mode of the original
+	OutputFileMode string `koanf:"output_file_mode"`
+
+	// fail on plaintext files when decrypting instead of skipping them
+	StrictDecrypt bool `koanf:"strict_decrypt"`
+
+	// suffixes of the temp files written while encrypting and decrypting, empty means ".encryptdir-enc" and ".encryptdir-dec" followed by the pid
+	EncSuffix string `koanf:"enc_suffix"`
+	DecSuffix string `koanf:"dec_suffix"`
+
+	// files taking longer than this are logged, like "2s", 0 turns it off
+	SlowFileThreshold time.Duration `koanf:"slow_file_threshold"`
+	// fail the run if any file is slower than `slow_file_threshold`
+	FailOnSlow bool `koanf:"fail_on_slow"`
+
+	// process files in random order instead of directory order
+	Shuffle bool `koanf:"shuffle"`
+
+	// stop encrypting once this many bytes have been written, 0 means no limit
+	MaxTotalOutputBytes int64 `koanf:"max_total_output_bytes"`
+
+	// only encrypt files owned by this uid, unix only
+	OwnerUID *int `koanf:"owner_uid"`
+
+	// most descriptors open at once, two a file being processed plus one for the journal, 0 means half of the soft open file limit
+	MaxOpenFiles int `koanf:"max_open_files"`
+
+	// most files processed at once, 0 means no cap besides max_open_files
+	MaxWorkers int `koanf:"max_workers"`
+
+	// write the output next to the original instead of replacing it, at `keep_suffix` when encrypting
+	// decrypting strips `keep_suffix` off of the files that have it and appends `keep_dec_suffix` to the others
+	KeepOriginal bool `koanf:"keep_original"`
+	// suffixes of the outputs `keep_original` writes, empty means ".enc" and ".dec"
+	KeepSuffix    string `koanf:"keep_suffix"`
+	KeepDecSuffix string `koanf:"keep_dec_suffix"`
+
+	// write the output into a mirror of the directory under this dir instead of replacing the originals, needs exactly one directory
+	OutputDir string `koanf:"output_dir"`
+	// store each encrypted file in output_dir named by its hash, identical files once, with an index from the original paths
+	ContentAddressed bool `koanf:"content_addressed"`
+
+	// encrypted marker file written into empty directories, its extension needs a key
+	EmptyDirMarker string `koanf:"empty_dir_marker"`
+
+	// refuse weak crypto settings like MD5 signatures or short RSA keys
+	StrictCrypto bool `koanf:"strict_crypto"`
+	// refuse to run with AES keys that arent 32 bytes
+	EnforceAES256 bool `koanf:"enforce_aes256"`
+
+	// files of at least this many bytes are streamed instead of read into memory, 0 means one stream chunk
+	StreamThreshold int64 `koanf:"stream_threshold"`
+	// bytes streamed files are processed in at a time, 0 means 1MiB
+	StreamChunkSize int `koanf:"stream_chunk_size"`
+
+	// check there is disk space for the temp files before encrypting, linux and darwin only
+	CheckFreeSpace bool `koanf:"check_free_space"`
+
+	// hash of the AES key signature written when encrypting: md5, sha256 (default), or sha512
+	HashAlgo string `koanf:"hash_algo"`
+	// sign the whole file header with the AES key, so tampering with any of its fields is caught on decrypt
+	SignHeader bool `koanf:"sign_header"`
+	// fail decrypting files whose signature is of the AES key alone, for trees only encrypted with sign_header
+	RequireSignedHeader bool `koanf:"require_signed_header"`
+	// hash of the integrity digest of the plaintext recorded in every file: md5, sha256, or sha512, none by default
+	IntegrityHash string `koanf:"integrity_hash"`
+	// record a fingerprint of the AES key in every file, so decrypting with the wrong key says so
+	KeyFingerprint bool `koanf:"key_fingerprint"`
+	// key-value metadata recorded in the clear in every file encrypted, like owner or classification
+	Metadata map[string]string `koanf:"metadata"`
+	// extensions of text files whose UTF-8 BOM is recorded in the header when encrypting and restored exactly when decrypting
+	TextExtensions []string `koanf:"text_extensions"`
+	// time every file encrypted is valid until, decrypting fails after it unless allow_expired is set
+	ExpiresAt    time.Time `koanf:"expires_at"`
+	AllowExpired bool      `koanf:"allow_expired"`
+
+	// doublestar globs relative to each directory, only included files are processed and excluded dirs are skipped
+	Include []string `koanf:"include"`
+	Exclude []string `koanf:"exclude"`
+
+	// also decrypt files written before the magic header, format version 1, encrypting never skips them
+	LegacyFormat bool `koanf:"legacy_format"`
+
+	// encrypt and decrypt the targets of symlinks instead of skipping them
+	FollowSymlinks bool `koanf:"follow_symlinks"`
+
+	// only log the files that would be encrypted or decrypted
+	DryRun bool `koanf:"dry_run"`
+	// log which walk worker processed each file, for debugging
+	DebugWorkers bool `koanf:"debug_workers"`
+
+	// fail when not a single file was encrypted or decrypted
+	RequireMatches bool `koanf:"require_matches"`
+
+	// keep hard linked files linked, encrypting and decrypting them once, unix only
+	PreserveHardlinks bool `koanf:"preserve_hardlinks"`
+
+	// stop at the first file that fails instead of collecting the errors of every file
+	FailFast bool `koanf:"fail_fast"`
+	// report dirs and unreadable entries that fail without skipping the files under or next to them
+	ContinueOnError bool `koanf:"continue_on_error"`
+
+	// read every encrypted file back and check it decrypts to the original before replacing it
+	VerifyAfterWrite bool `koanf:"verify_after_write"`
+
+	// gzip files before encrypting them when that makes them smaller
+	Compress bool `koanf:"compress"`
+
+	// how many levels below each directory files are processed, 0 means no limit
+	MaxDepth int `koanf:"max_depth"`
+	// only files with sizes in bytes between these are processed, 0 `max_size` means no limit
+	MinSize int64 `koanf:"min_size"`
+	MaxSize int64 `koanf:"max_size"`
+
+	// skip the files the journal of an interrupted run finished, the journal is `journal_path` or `.encryptdir-journal` in the first directory
+	Resume      bool   `koanf:"resume"`
+	JournalPath string `koanf:"journal_path"`
+
+	// sync each directory once every this many files instead of after each one, faster for many small files, ignored with `resume`
+	SyncBatch int `koanf:"sync_batch"`
+
+	// files never encrypted on top of the key files and this config, which always are
+	ProtectedPaths []string `koanf:"protected_paths"`
+
+	// passphrases per extension the AES keys are derived from, instead of or on top of `aes_key`
+	Passphrases map[string]string `koanf:"passphrases"`
+	// PBKDF2 iterations keys are derived from `passphrases` with, 0 means 600000
+	KDFIterations int `koanf:"kdf_iterations"`
+
 	// FROM OTHER STUFF
 	RSAKey    *rsa.PrivateKey
 	AESKeyMap map[string][]byte
+	// the file `New` loaded this from
+	ConfigPath string
 }
 
 // config.New: load `configPath` into `config.Config`
@@ -36,6 +198,7 @@ func New(configPath string) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("config.New: %w", err)
 	}
+	c.ConfigPath = configPath
 
 	return c, nil
 }
@@ -0,0 +1,18 @@
+//go:build darwin
+
+package encryptdir
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// encryptdir.accessTime: the access time of the file described by `info`, its modification time if there isn't one
+func accessTime(info os.FileInfo) time.Time {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.ModTime()
+	}
+	return time.Unix(int64(stat.Atimespec.Sec), int64(stat.Atimespec.Nsec))
+}
@@ -0,0 +1,13 @@
+//go:build unix && !linux && !darwin
+
+package encryptdir
+
+import (
+	"os"
+	"time"
+)
+
+// encryptdir.accessTime: the `syscall.Stat_t` access time field differs between the other unixes, the modification time is used instead
+func accessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
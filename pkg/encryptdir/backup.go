@@ -0,0 +1,235 @@
+package encryptdir
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	gorsa "crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// sentinel error used for when a backup entry would be restored outside of the root
+var ErrBackupEntryPath = errors.New("backup entry path escapes root")
+
+// sentinel error used for when a stream doesnt start like a backup written by `BackupEncrypt`
+var ErrNotBackup = errors.New("not an encryptdir backup")
+
+// BackupMagic: the bytes a backup starts with
+const BackupMagic = "EDBK"
+
+// BackupVersion: version of the backup layout written by `BackupEncrypt`
+const BackupVersion = 1
+
+// encryptdir.BackupEncrypt: writes every directory and regular file under `root` as a gzip'd tar encrypted by `key` to `dst`
+// backup layout is the sealed stream layout with `BackupMagic`, the tar is encrypted as it is written so no plaintext touches the disk, links are skipped
+// returns: error
+func BackupEncrypt(privKey *gorsa.PrivateKey, key []byte, root string, dst io.Writer) error {
+	w, err := newSealedWriter(dst, BackupMagic, BackupVersion, privKey, key)
+	if err != nil {
+		return fmt.Errorf("encryptdir.BackupEncrypt: %w", err)
+	}
+
+	err = writeTarGz(root, w)
+	if err != nil {
+		return fmt.Errorf("encryptdir.BackupEncrypt: %w", err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		return fmt.Errorf("encryptdir.BackupEncrypt: w.Close: %w", err)
+	}
+	return nil
+}
+
+// encryptdir.BackupDecrypt: restores a backup written by `BackupEncrypt` from `src` into `root`
+// file and directory modes are restored, existing files are never overwritten
+// files come out as the stream is authenticated, a backup tampered part way through fails with the files before it restored
+// returns: error, wrapping `ErrNotBackup` if `src` isnt a backup, `aes.ErrAuthFailed` if it was tampered with
+func BackupDecrypt(privKey *gorsa.PrivateKey, key []byte, src io.Reader, root string) error {
+	r, err := newSealedReader(src, BackupMagic, BackupVersion, privKey, key, ErrNotBackup)
+	if err != nil {
+		return fmt.Errorf("encryptdir.BackupDecrypt: %w", err)
+	}
+
+	err = readTarGz(r, root)
+	if err != nil {
+		return fmt.Errorf("encryptdir.BackupDecrypt: %w", err)
+	}
+
+	// the last chunk is only authenticated once it is read to the end
+	_, err = io.Copy(io.Discard, r)
+	if err != nil {
+		return fmt.Errorf("encryptdir.BackupDecrypt: %w", err)
+	}
+	return nil
+}
+
+// encryptdir.writeTarGz: writes every directory and regular file under `root` to `out` as a gzip'd tar
+// returns: error
+func writeTarGz(root string, out io.Writer) error {
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// only directories and regular files, dont follow links
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return fmt.Errorf("filepath.Rel: path = %q: %w", path, err)
+		}
+		if rel == "." {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("d.Info: path = %q: %w", path, err)
+		}
+
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return fmt.Errorf("tar.FileInfoHeader: path = %q: %w", path, err)
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+
+		err = tw.WriteHeader(hdr)
+		if err != nil {
+			return fmt.Errorf("tw.WriteHeader: path = %q: %w", path, err)
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		in, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("os.Open: %w", err)
+		}
+		defer in.Close()
+
+		_, err = io.Copy(tw, in)
+		if err != nil {
+			return fmt.Errorf("io.Copy: path = %q: %w", path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("encryptdir.writeTarGz: filepath.WalkDir: %w", err)
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return fmt.Errorf("encryptdir.writeTarGz: tw.Close: %w", err)
+	}
+
+	err = gz.Close()
+	if err != nil {
+		return fmt.Errorf("encryptdir.writeTarGz: gz.Close: %w", err)
+	}
+	return nil
+}
+
+// encryptdir.readTarGz: extracts the directories and regular files of the gzip'd tar in `in` into `root`
+// directory modes are set last so a read only directory can still be filled
+// returns: error
+func readTarGz(in io.Reader, root string) error {
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return fmt.Errorf("encryptdir.readTarGz: gzip.NewReader: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	type dirMode struct {
+		path string
+		mode fs.FileMode
+	}
+	var dirs []dirMode
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("encryptdir.readTarGz: tr.Next: %w", err)
+		}
+
+		name := filepath.FromSlash(hdr.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("encryptdir.readTarGz: name = %q: %w", hdr.Name, ErrBackupEntryPath)
+		}
+		outPath := filepath.Join(root, name)
+		mode := hdr.FileInfo().Mode().Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(outPath, 0700)
+			if err != nil {
+				return fmt.Errorf("encryptdir.readTarGz: os.MkdirAll: %w", err)
+			}
+			dirs = append(dirs, dirMode{path: outPath, mode: mode})
+		case tar.TypeReg:
+			err = os.MkdirAll(filepath.Dir(outPath), 0755)
+			if err != nil {
+				return fmt.Errorf("encryptdir.readTarGz: os.MkdirAll: %w", err)
+			}
+
+			err = extractFile(tr, outPath, mode)
+			if err != nil {
+				return fmt.Errorf("encryptdir.readTarGz: %w", err)
+			}
+		}
+	}
+
+	// read to the end so the gzip checksum is checked
+	_, err = io.Copy(io.Discard, gz)
+	if err != nil {
+		return fmt.Errorf("encryptdir.readTarGz: gzip: %w", err)
+	}
+
+	// deepest first, so a parent losing write permission doesnt stop its children
+	for n := len(dirs) - 1; n >= 0; n-- {
+		err = os.Chmod(dirs[n].path, dirs[n].mode)
+		if err != nil {
+			return fmt.Errorf("encryptdir.readTarGz: os.Chmod: %w", err)
+		}
+	}
+	return nil
+}
+
+// encryptdir.extractFile: writes `in` to a new file at `path` with `mode`, regardless of the umask, a file `in` failed part way through is removed
+// returns: error
+func extractFile(in io.Reader, path string, mode fs.FileMode) error {
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return fmt.Errorf("encryptdir.extractFile: os.OpenFile: %w", err)
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		os.Remove(path)
+		return fmt.Errorf("encryptdir.extractFile: io.Copy: path = %q: %w", path, err)
+	}
+
+	err = out.Chmod(mode)
+	if err != nil {
+		return fmt.Errorf("encryptdir.extractFile: out.Chmod: %w", err)
+	}
+	return nil
+}
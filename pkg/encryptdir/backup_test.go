@@ -0,0 +1,154 @@
+package encryptdir
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/aes"
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestBackupRoundTrip(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	key := testutil.NewTestKey("backup")
+	spec := map[string][]byte{
+		"a.txt":           []byte("hello"),
+		"run.sh":          []byte("#!/bin/sh"),
+		"sub/b.sql":       []byte("select 1"),
+		"sub/deep/c.csv":  []byte("a,b"),
+		"sub/deep/secret": []byte("no extension"),
+	}
+	dir, _ := testutil.BuildTree(t, spec)
+	modes := map[string]fs.FileMode{
+		"a.txt":           0644,
+		"run.sh":          0755,
+		"sub/deep/secret": 0600,
+		"sub/deep":        0700,
+		"sub":             0750,
+	}
+	for rel, mode := range modes {
+		err := os.Chmod(filepath.Join(dir, filepath.FromSlash(rel)), mode)
+		if err != nil {
+			t.Fatalf("os.Chmod: %v", err)
+		}
+	}
+
+	var backup bytes.Buffer
+	err := BackupEncrypt(privKey, key, dir, &backup)
+	if err != nil {
+		t.Fatalf("BackupEncrypt: %v", err)
+	}
+	if bytes.Contains(backup.Bytes(), []byte("select 1")) {
+		t.Errorf("BackupEncrypt: plaintext in the backup")
+	}
+
+	out := t.TempDir()
+	err = BackupDecrypt(privKey, key, bytes.NewReader(backup.Bytes()), out)
+	if err != nil {
+		t.Fatalf("BackupDecrypt: %v", err)
+	}
+	assertTree(t, out, spec)
+	for rel, mode := range modes {
+		info, err := os.Stat(filepath.Join(out, filepath.FromSlash(rel)))
+		if err != nil {
+			t.Fatalf("os.Stat: %v", err)
+		}
+		if info.Mode().Perm() != mode {
+			t.Errorf("path = %q: mode = %v, want %v", rel, info.Mode().Perm(), mode)
+		}
+	}
+
+	// restoring again doesnt overwrite what is there
+	err = os.WriteFile(filepath.Join(out, "a.txt"), []byte("changed"), 0644)
+	if err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	err = BackupDecrypt(privKey, key, bytes.NewReader(backup.Bytes()), out)
+	if !errors.Is(err, os.ErrExist) {
+		t.Errorf("BackupDecrypt over a restored tree: err = %v, want os.ErrExist", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(out, "a.txt")); string(got) != "changed" {
+		t.Errorf("a.txt: overwritten by the second restore")
+	}
+}
+
+func TestBackupWrongKey(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello")})
+
+	var backup bytes.Buffer
+	err := BackupEncrypt(privKey, testutil.NewTestKey("backup"), dir, &backup)
+	if err != nil {
+		t.Fatalf("BackupEncrypt: %v", err)
+	}
+
+	out := t.TempDir()
+	err = BackupDecrypt(privKey, testutil.NewTestKey("other"), &backup, out)
+	if err == nil {
+		t.Fatalf("BackupDecrypt with another key: err = nil")
+	}
+	if tree := readTree(t, out); len(tree) != 0 {
+		t.Errorf("BackupDecrypt with another key restored %d files", len(tree))
+	}
+}
+
+func TestBackupNoSpool(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world")})
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	var backup bytes.Buffer
+	err := BackupEncrypt(privKey, testutil.NewTestKey("backup"), dir, &backup)
+	if err != nil {
+		t.Fatalf("BackupEncrypt: %v", err)
+	}
+	if !bytes.HasPrefix(backup.Bytes(), append([]byte(BackupMagic), BackupVersion)) {
+		t.Errorf("BackupEncrypt: backup starts with %q, want the magic and version", backup.Bytes()[:5])
+	}
+
+	// nothing, plaintext or not, is spooled to the temp dir
+	entries, err := os.ReadDir(tmp)
+	if err != nil {
+		t.Fatalf("os.ReadDir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("BackupEncrypt: left %d files in the temp dir", len(entries))
+	}
+}
+
+func TestBackupTampered(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	key := testutil.NewTestKey("backup")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": bytes.Repeat([]byte("hello "), 1000)})
+
+	var backup bytes.Buffer
+	err := BackupEncrypt(privKey, key, dir, &backup)
+	if err != nil {
+		t.Fatalf("BackupEncrypt: %v", err)
+	}
+	b := backup.Bytes()
+	flipped := append([]byte(nil), b...)
+	flipped[len(b)-20] ^= 1
+	newer := append([]byte(nil), b...)
+	newer[len(BackupMagic)]++
+
+	for name, tc := range map[string]struct {
+		backup []byte
+		want   error
+	}{
+		"flipped":   {backup: flipped, want: aes.ErrAuthFailed},
+		"cut short": {backup: b[:len(b)-10]},
+		"not one":   {backup: []byte("just some bytes"), want: ErrNotBackup},
+		"newer":     {backup: newer, want: ErrUnsupportedVersion},
+	} {
+		err := BackupDecrypt(privKey, key, bytes.NewReader(tc.backup), t.TempDir())
+		if err == nil || (tc.want != nil && !errors.Is(err, tc.want)) {
+			t.Errorf("%s: BackupDecrypt: err = %v, want %v", name, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestBannerRoundTrip(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("conf")
+	spec := map[string][]byte{"app.conf": []byte("password = hunter2\n"), "sub/db.conf": []byte("user = admin\n")}
+
+	for name, opts := range map[string]Options{
+		"no newline": {Banner: "# encrypted by encryptdir"},
+		"newline":    {Banner: "# encrypted by encryptdir\n"},
+		"streamed":   {Banner: "# encrypted by encryptdir", StreamThreshold: -1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir, _ := testutil.BuildTree(t, spec)
+			_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+			if err != nil {
+				t.Fatalf("EncryptWithOptions: %v", err)
+			}
+
+			// the banner is the first line as plaintext, once, with the file header right after it
+			want := []byte("# encrypted by encryptdir\n" + FileMagic)
+			for rel, contents := range readTree(t, dir) {
+				if !bytes.HasPrefix(contents, want) {
+					t.Errorf("path = %q: starts with %q, want %q", rel, contents[:len(want)], want)
+				}
+				if bytes.Contains(contents, spec[rel]) {
+					t.Errorf("path = %q: plaintext after the banner", rel)
+				}
+			}
+
+			// the banner doesnt make it look like plaintext, so it isnt encrypted again
+			report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+			if err != nil || report.Processed != 0 {
+				t.Errorf("EncryptWithOptions: again: processed = %d, err = %v, want 0 and nil", report.Processed, err)
+			}
+
+			_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+			if err != nil {
+				t.Fatalf("DecryptWithOptions: %v", err)
+			}
+			assertTree(t, dir, spec)
+		})
+	}
+}
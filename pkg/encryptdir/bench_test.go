@@ -0,0 +1,257 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+// benchTree: a tree of `n` small files across a few directories
+func benchTree(n int) map[string][]byte {
+	spec := make(map[string][]byte, n)
+	for i := 0; i < n; i++ {
+		spec[fmt.Sprintf("d%d/f%d.txt", i%8, i)] = []byte(fmt.Sprintf("file %d", i))
+	}
+	return spec
+}
+
+// BenchmarkEncryptDecryptTree: one encrypt and decrypt of 256 small files a run
+//
+// running the file bodies inline instead of in a goroutine per file, `-benchtime 20x`, median of 3:
+//
+//	before: 407671061 ns/op  543800462 B/op  24771 allocs/op
+//	after:  348028719 ns/op  543800468 B/op  24771 allocs/op
+//
+// the goroutines came off the runtime's free list, so the allocations a file stay the same and only the scheduling is
+// saved, nearly all of the bytes are the chunk buffer each file gets
+func BenchmarkEncryptDecryptTree(b *testing.B) {
+	privKey := testutil.NewPrivateKey(b)
+	keyMap := testutil.NewKeyMap("txt")
+	dir, _ := testutil.BuildTree(b, benchTree(256))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+		if err != nil {
+			b.Fatalf("EncryptWithOptions: %v", err)
+		}
+		_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+		if err != nil {
+			b.Fatalf("DecryptWithOptions: %v", err)
+		}
+	}
+}
+
+// benchEncrypt: encrypts the tree `spec` with `opts` a run, the decrypt putting it back isnt timed
+func benchEncrypt(b *testing.B, spec map[string][]byte, opts Options) {
+	privKey := testutil.NewPrivateKey(b)
+	keyMap := testutil.NewKeyMap("txt")
+	dir, _ := testutil.BuildTree(b, spec)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+		if err != nil {
+			b.Fatalf("EncryptWithOptions: %v", err)
+		}
+
+		b.StopTimer()
+		_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+		if err != nil {
+			b.Fatalf("DecryptWithOptions: %v", err)
+		}
+		b.StartTimer()
+	}
+}
+
+// BenchmarkEncryptManySmallFiles: encrypts 256 small files a run
+//
+// files under `StreamThreshold` are read into memory and sealed in one go, sizing the chunk buffer to the file instead of
+// `aes.DefaultGCMChunkSize`, `-benchtime 20x`, median of 3:
+//
+//	before: 134968649 ns/op  271673653 B/op  10729 allocs/op
+//	after:   87635745 ns/op    1080692 B/op  10699 allocs/op
+func BenchmarkEncryptManySmallFiles(b *testing.B) {
+	benchEncrypt(b, benchTree(256), Options{})
+}
+
+// BenchmarkEncryptLargeFile: encrypts one 32 MiB file a run, read into memory with a `StreamThreshold` above its size
+//
+// unchanged by sizing the chunk buffer, `-benchtime 20x`, median of 3:
+//
+//	before: 71305609 ns/op  111887792 B/op  163 allocs/op
+//	after:  78522045 ns/op  111887808 B/op  163 allocs/op
+func BenchmarkEncryptLargeFile(b *testing.B) {
+	benchEncrypt(b, map[string][]byte{"large.txt": bytes.Repeat([]byte("a"), 32<<20)}, Options{StreamThreshold: 64 << 20})
+}
+
+// BenchmarkEncryptLargeFileStreamed: the same file streamed, `BenchmarkStreamThreshold` compares the two across sizes
+//
+// `-benchtime 20x`, median of 3:
+//
+//	before: 39972555 ns/op  1067208 B/op  125 allocs/op
+//	after:  53307114 ns/op  1067195 B/op  125 allocs/op
+func BenchmarkEncryptLargeFileStreamed(b *testing.B) {
+	benchEncrypt(b, map[string][]byte{"large.txt": bytes.Repeat([]byte("a"), 32<<20)}, Options{StreamThreshold: -1})
+}
+
+// BenchmarkEncryptSyncPerFile: encrypts 256 small files a run, syncing each directory after every file
+func BenchmarkEncryptSyncPerFile(b *testing.B) {
+	benchEncrypt(b, benchTree(256), Options{})
+}
+
+// BenchmarkEncryptSyncBatch: the same files with `SyncBatch` syncing each directory once every 64 files,
+// on ext4, `-benchtime 20x`, median of 3:
+//
+//	per file: 66175141 ns/op  1081540 B/op  10704 allocs/op
+//	batched:  54113451 ns/op  1044286 B/op   9964 allocs/op
+func BenchmarkEncryptSyncBatch(b *testing.B) {
+	benchEncrypt(b, benchTree(256), Options{SyncBatch: 64})
+}
+
+// benchRoundTrip: encrypts and decrypts the tree `spec` with `opts` a run, both timed
+func benchRoundTrip(b *testing.B, spec map[string][]byte, opts Options) {
+	privKey := testutil.NewPrivateKey(b)
+	keyMap := testutil.NewKeyMap("txt")
+	dir, _ := testutil.BuildTree(b, spec)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+		if err != nil {
+			b.Fatalf("EncryptWithOptions: %v", err)
+		}
+		_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+		if err != nil {
+			b.Fatalf("DecryptWithOptions: %v", err)
+		}
+	}
+}
+
+// BenchmarkStreamThreshold: encrypts and decrypts one file a run of each size, read into memory and streamed, what `DefaultStreamThreshold` is derived from
+// on ext4, `-benchtime 20x`, median of 3 in ns/op:
+//
+//	size     memory     stream
+//	4KiB     1742077    1814796
+//	16KiB    2221245    2255414
+//	64KiB    3258532    2558099
+//	256KiB   3335169    4265950
+//	1MiB     7060111    7379434
+//	4MiB     18858444   14534761
+//	16MiB    69400161   61610826
+//	64MiB    259844758  195808810
+//
+// streaming holds 2MiB whatever the size, reading into memory 55MiB for the 16MiB file and 234MiB for the 64MiB one
+func BenchmarkStreamThreshold(b *testing.B) {
+	for _, size := range []int{4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20, 64 << 20} {
+		spec := map[string][]byte{"a.txt": bytes.Repeat([]byte("a"), size)}
+		b.Run(fmt.Sprintf("%dKiB/memory", size>>10), func(b *testing.B) {
+			benchRoundTrip(b, spec, Options{StreamThreshold: int64(size) + 1})
+		})
+		b.Run(fmt.Sprintf("%dKiB/stream", size>>10), func(b *testing.B) {
+			benchRoundTrip(b, spec, Options{StreamThreshold: -1})
+		})
+	}
+}
+
+// BenchmarkSignatureCache: signs the key of 256 files of one extension a run, once per file without a cache and once per key with one
+// `-benchtime 20x`, median of 3:
+//
+//	per file: 313566372 ns/op  256 rsa-signs/op
+//	cached:     1074578 ns/op    1 rsa-signs/op
+func BenchmarkSignatureCache(b *testing.B) {
+	privKey := testutil.NewPrivateKey(b)
+	key := testutil.NewTestKey("txt")
+
+	for name, newCache := range map[string]func() *signatureCache{
+		"per file": func() *signatureCache { return nil },
+		"cached":   newSignatureCache,
+	} {
+		b.Run(name, func(b *testing.B) {
+			signs := 0
+			for i := 0; i < b.N; i++ {
+				c := newCache()
+				for f := 0; f < 256; f++ {
+					_, err := c.sign(privKey, key, nil, DefaultHashAlgo)
+					if err != nil {
+						b.Fatalf("signatureCache.sign: %v", err)
+					}
+				}
+				if c == nil {
+					signs += 256
+				} else {
+					signs += len(c.sigs)
+				}
+			}
+			b.ReportMetric(float64(signs)/float64(b.N), "rsa-signs/op")
+		})
+	}
+}
+
+// blockEvents: how many times goroutines have parked on a channel, select, mutex or wait group since the block profile was turned on
+func blockEvents() int64 {
+	n, _ := runtime.BlockProfile(nil)
+	records := make([]runtime.BlockProfileRecord, n+64)
+	n, _ = runtime.BlockProfile(records)
+	var count int64
+	for _, r := range records[:n] {
+		count += r.Count
+	}
+	return count
+}
+
+// BenchmarkEncryptManyRoots: encrypts 256 roots of 4 small files each a run, where the handoff of each root's result is busiest
+// reports `blocks/op`, every park of a goroutine the block profile sees, cwalk's own workers included
+//
+// buffering the result channel each root sent on, then one slot per root instead of a channel, `-benchtime 20x`, median of 3:
+//
+//	unbuffered: 1388662960 ns/op  2306 blocks/op  6375785 B/op  55436 allocs/op
+//	buffered:   1413376460 ns/op  2306 blocks/op  6396584 B/op  55395 allocs/op
+//	slots:       440537674 ns/op   818 blocks/op  6045183 B/op  115965 allocs/op
+//
+// the collector was already waiting on every root, so buffering its sends saved no parks and there is no buffer size to tune,
+// what the slots run saves is mostly the file bodies running inline since
+func BenchmarkEncryptManyRoots(b *testing.B) {
+	privKey := testutil.NewPrivateKey(b)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := make(map[string][]byte)
+	for i := 0; i < 256*4; i++ {
+		spec[fmt.Sprintf("r%d/f%d.txt", i%256, i)] = []byte(fmt.Sprintf("file %d", i))
+	}
+	dir, _ := testutil.BuildTree(b, spec)
+	roots := make([]string, 256)
+	for i := range roots {
+		roots[i] = filepath.Join(dir, fmt.Sprintf("r%d", i))
+	}
+
+	runtime.SetBlockProfileRate(1)
+	b.Cleanup(func() { runtime.SetBlockProfileRate(0) })
+
+	var blocks int64
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		before := blockEvents()
+		_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, roots, Options{})
+		if err != nil {
+			b.Fatalf("EncryptWithOptions: %v", err)
+		}
+		blocks += blockEvents() - before
+
+		b.StopTimer()
+		_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, roots, Options{})
+		if err != nil {
+			b.Fatalf("DecryptWithOptions: %v", err)
+		}
+		b.StartTimer()
+	}
+	b.ReportMetric(float64(blocks)/float64(b.N), "blocks/op")
+}
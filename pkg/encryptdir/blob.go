@@ -0,0 +1,214 @@
+package encryptdir
+
+import (
+	gorsa "crypto/rsa"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// sentinel error used for when a blob's index doesn't match its contents
+var ErrBlobCorrupt = errors.New("blob is corrupt")
+
+// sentinel error used for when a blob entry would be written outside of the output directory
+var ErrBlobEntryPath = errors.New("blob entry path escapes output directory")
+
+// sentinel error used for when a file changes size between being indexed and being packed into a blob
+var ErrBlobChanged = errors.New("file changed while packing the blob")
+
+// BlobMagic: the bytes a blob starts with
+const BlobMagic = "EDBL"
+
+// BlobVersion: version of the blob layout written by `EncryptBlob`
+const BlobVersion = 1
+
+// BlobEntry: a single file stored in a blob
+// Offset is relative to the end of the index, the entries are in the order of their contents
+type BlobEntry struct {
+	Name   string      `json:"name"`
+	Offset uint64      `json:"offset"`
+	Size   uint64      `json:"size"`
+	Mode   fs.FileMode `json:"mode"`
+}
+
+// encryptdir.EncryptBlob: packs every regular file under `dir` into a single blob encrypted by `key` and writes it to `out`
+// blob layout is the sealed stream layout with `BlobMagic`, its plaintext is [index size][index][file contents]
+// the files are indexed first and then streamed in, one at a time, a file that changes size in between fails with `ErrBlobChanged`
+// returns: error
+func EncryptBlob(privKey *gorsa.PrivateKey, key []byte, dir string, out io.Writer) error {
+	var index []BlobEntry
+	var paths []string
+	var offset uint64
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// only regular files, dont follow links
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("d.Info: path = %q: %w", path, err)
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return fmt.Errorf("filepath.Rel: path = %q: %w", path, err)
+		}
+
+		index = append(index, BlobEntry{
+			Name:   filepath.ToSlash(rel),
+			Offset: offset,
+			Size:   uint64(info.Size()),
+			Mode:   info.Mode().Perm(),
+		})
+		paths = append(paths, path)
+		offset += uint64(info.Size())
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("encryptdir.EncryptBlob: filepath.WalkDir: %w", err)
+	}
+
+	indexBytes, err := json.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("encryptdir.EncryptBlob: json.Marshal: %w", err)
+	}
+
+	w, err := newSealedWriter(out, BlobMagic, BlobVersion, privKey, key)
+	if err != nil {
+		return fmt.Errorf("encryptdir.EncryptBlob: %w", err)
+	}
+
+	_, err = w.Write(binary.LittleEndian.AppendUint64(nil, uint64(len(indexBytes))))
+	if err != nil {
+		return fmt.Errorf("encryptdir.EncryptBlob: w.Write(index size): %w", err)
+	}
+	_, err = w.Write(indexBytes)
+	if err != nil {
+		return fmt.Errorf("encryptdir.EncryptBlob: w.Write(index): %w", err)
+	}
+
+	for i, path := range paths {
+		err = packFile(w, path, int64(index[i].Size))
+		if err != nil {
+			return fmt.Errorf("encryptdir.EncryptBlob: %w", err)
+		}
+	}
+
+	err = w.Close()
+	if err != nil {
+		return fmt.Errorf("encryptdir.EncryptBlob: w.Close: %w", err)
+	}
+	return nil
+}
+
+// encryptdir.packFile: copies the file at `path`, which was `size` bytes when it was indexed, to `w`
+// returns: error, wrapping `ErrBlobChanged` if it isnt `size` bytes anymore
+func packFile(w io.Writer, path string, size int64) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("encryptdir.packFile: os.Open: %w", err)
+	}
+	defer in.Close()
+
+	_, err = io.CopyN(w, in, size)
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("encryptdir.packFile: path = %q: %w", path, ErrBlobChanged)
+	}
+	if err != nil {
+		return fmt.Errorf("encryptdir.packFile: io.CopyN: path = %q: %w", path, err)
+	}
+
+	// grown since, the index would be off
+	n, _ := in.Read(make([]byte, 1))
+	if n > 0 {
+		return fmt.Errorf("encryptdir.packFile: path = %q: %w", path, ErrBlobChanged)
+	}
+	return nil
+}
+
+// encryptdir.DecryptBlob: decrypts a blob written by `EncryptBlob` from `in` and extracts its files into `outDir`
+// existing files are never overwritten, the whole index is checked before any file is written
+// files come out as the stream is authenticated, a blob tampered part way through fails with the files before it extracted
+// returns: error, wrapping `ErrBlobCorrupt` if `in` isnt a blob or its index doesnt match its contents, `aes.ErrAuthFailed` if it was tampered with
+func DecryptBlob(privKey *gorsa.PrivateKey, key []byte, in io.Reader, outDir string) error {
+	r, err := newSealedReader(in, BlobMagic, BlobVersion, privKey, key, ErrBlobCorrupt)
+	if err != nil {
+		return fmt.Errorf("encryptdir.DecryptBlob: %w", err)
+	}
+
+	sizeBytes := make([]byte, 8)
+	_, err = io.ReadFull(r, sizeBytes)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("encryptdir.DecryptBlob: index size: %w", ErrBlobCorrupt)
+	}
+	if err != nil {
+		return fmt.Errorf("encryptdir.DecryptBlob: %w", err)
+	}
+	indexSize := binary.LittleEndian.Uint64(sizeBytes)
+
+	// read up to the size instead of allocating it up front
+	indexBytes, err := io.ReadAll(io.LimitReader(r, int64(indexSize)))
+	if err != nil {
+		return fmt.Errorf("encryptdir.DecryptBlob: %w", err)
+	}
+	if uint64(len(indexBytes)) != indexSize {
+		return fmt.Errorf("encryptdir.DecryptBlob: index size = %d: %w", indexSize, ErrBlobCorrupt)
+	}
+
+	var index []BlobEntry
+	err = json.Unmarshal(indexBytes, &index)
+	if err != nil {
+		return fmt.Errorf("encryptdir.DecryptBlob: json.Unmarshal: %w: %w", ErrBlobCorrupt, err)
+	}
+
+	var offset uint64
+	for _, entry := range index {
+		if entry.Offset != offset {
+			return fmt.Errorf("encryptdir.DecryptBlob: name = %q: %w", entry.Name, ErrBlobCorrupt)
+		}
+		if !filepath.IsLocal(filepath.FromSlash(entry.Name)) {
+			return fmt.Errorf("encryptdir.DecryptBlob: name = %q: %w", entry.Name, ErrBlobEntryPath)
+		}
+		offset += entry.Size
+	}
+
+	for _, entry := range index {
+		outPath := filepath.Join(outDir, filepath.FromSlash(entry.Name))
+
+		err = os.MkdirAll(filepath.Dir(outPath), 0755)
+		if err != nil {
+			return fmt.Errorf("encryptdir.DecryptBlob: os.MkdirAll: %w", err)
+		}
+
+		contents := &io.LimitedReader{R: r, N: int64(entry.Size)}
+		err = extractFile(contents, outPath, entry.Mode.Perm())
+		if err != nil {
+			return fmt.Errorf("encryptdir.DecryptBlob: %w", err)
+		}
+		if contents.N > 0 {
+			os.Remove(outPath)
+			return fmt.Errorf("encryptdir.DecryptBlob: name = %q: %w", entry.Name, ErrBlobCorrupt)
+		}
+	}
+
+	// nothing is left after the last file, reading to the end also authenticates the last chunk
+	n, err := io.Copy(io.Discard, r)
+	if err != nil {
+		return fmt.Errorf("encryptdir.DecryptBlob: %w", err)
+	}
+	if n > 0 {
+		return fmt.Errorf("encryptdir.DecryptBlob: %d bytes after the last file: %w", n, ErrBlobCorrupt)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package encryptdir
+
+import (
+	"bytes"
+	gorsa "crypto/rsa"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/aes"
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestBlobRoundTrip(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	key := testutil.NewTestKey("blob")
+	spec := map[string][]byte{
+		"a.txt":         []byte("hello"),
+		"empty":         {},
+		"sub/b.bin":     bytes.Repeat([]byte{0, 1, 2, 3}, 1000),
+		"sub/deep/c.md": []byte("# c"),
+	}
+	dir, _ := testutil.BuildTree(t, spec)
+	err := os.Chmod(filepath.Join(dir, "a.txt"), 0600)
+	if err != nil {
+		t.Fatalf("os.Chmod: %v", err)
+	}
+
+	var blob bytes.Buffer
+	err = EncryptBlob(privKey, key, dir, &blob)
+	if err != nil {
+		t.Fatalf("EncryptBlob: %v", err)
+	}
+	if bytes.Contains(blob.Bytes(), []byte("hello")) {
+		t.Errorf("EncryptBlob: blob holds plaintext")
+	}
+
+	outDir := t.TempDir()
+	err = DecryptBlob(privKey, key, bytes.NewReader(blob.Bytes()), outDir)
+	if err != nil {
+		t.Fatalf("DecryptBlob: %v", err)
+	}
+	assertTree(t, outDir, spec)
+
+	info, err := os.Stat(filepath.Join(outDir, "a.txt"))
+	if err != nil {
+		t.Fatalf("os.Stat: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("a.txt: mode = %v, want %v", info.Mode().Perm(), os.FileMode(0600))
+	}
+
+	// existing files are never overwritten
+	err = DecryptBlob(privKey, key, bytes.NewReader(blob.Bytes()), outDir)
+	if err == nil {
+		t.Errorf("DecryptBlob: into the same dir again: no error")
+	}
+	assertTree(t, outDir, spec)
+
+	// another key doesnt verify
+	err = DecryptBlob(privKey, testutil.NewTestKey("other"), bytes.NewReader(blob.Bytes()), t.TempDir())
+	if err == nil {
+		t.Errorf("DecryptBlob: wrong key: no error")
+	}
+}
+
+// sealBlob: a blob with `index` and `data` the way `EncryptBlob` writes them, for blobs it wouldnt write
+func sealBlob(t *testing.T, privKey *gorsa.PrivateKey, key []byte, index []BlobEntry, data []byte) []byte {
+	t.Helper()
+	indexBytes, err := json.Marshal(index)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	payload := binary.LittleEndian.AppendUint64(nil, uint64(len(indexBytes)))
+	payload = append(append(payload, indexBytes...), data...)
+
+	var blob bytes.Buffer
+	w, err := newSealedWriter(&blob, BlobMagic, BlobVersion, privKey, key)
+	if err != nil {
+		t.Fatalf("newSealedWriter: %v", err)
+	}
+	_, err = w.Write(payload)
+	if err != nil {
+		t.Fatalf("w.Write: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("w.Close: %v", err)
+	}
+	return blob.Bytes()
+}
+
+func TestBlobBadIndex(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	key := testutil.NewTestKey("blob")
+
+	for name, tc := range map[string]struct {
+		index []BlobEntry
+		want  error
+	}{
+		"escapes":      {index: []BlobEntry{{Name: "../escaped", Size: 4}}, want: ErrBlobEntryPath},
+		"absolute":     {index: []BlobEntry{{Name: "/abs", Size: 4}}, want: ErrBlobEntryPath},
+		"out of range": {index: []BlobEntry{{Name: "a", Offset: 2, Size: 4}}, want: ErrBlobCorrupt},
+		"past the end": {index: []BlobEntry{{Name: "a", Size: 5}}, want: ErrBlobCorrupt},
+		"overlapping":  {index: []BlobEntry{{Name: "a", Size: 4}, {Name: "b", Offset: 2, Size: 2}}, want: ErrBlobCorrupt},
+		"trailing":     {index: []BlobEntry{{Name: "a", Size: 3}}, want: ErrBlobCorrupt},
+	} {
+		t.Run(name, func(t *testing.T) {
+			parent := t.TempDir()
+			outDir := filepath.Join(parent, "out")
+			blob := sealBlob(t, privKey, key, tc.index, []byte("data"))
+
+			err := DecryptBlob(privKey, key, bytes.NewReader(blob), outDir)
+			if !errors.Is(err, tc.want) {
+				t.Errorf("DecryptBlob: err = %v, want %v", err, tc.want)
+			}
+			_, err = os.Stat(filepath.Join(parent, "escaped"))
+			if !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("os.Stat: written outside the output dir")
+			}
+		})
+	}
+
+	err := DecryptBlob(privKey, key, bytes.NewReader([]byte("short")), t.TempDir())
+	if !errors.Is(err, ErrBlobCorrupt) {
+		t.Errorf("DecryptBlob: short blob: err = %v, want %v", err, ErrBlobCorrupt)
+	}
+}
+
+func TestBlobTampered(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	key := testutil.NewTestKey("blob")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello"), "b.txt": bytes.Repeat([]byte("world "), 1000)})
+
+	var blob bytes.Buffer
+	err := EncryptBlob(privKey, key, dir, &blob)
+	if err != nil {
+		t.Fatalf("EncryptBlob: %v", err)
+	}
+	b := blob.Bytes()
+	if !bytes.HasPrefix(b, append([]byte(BlobMagic), BlobVersion)) {
+		t.Errorf("EncryptBlob: blob starts with %q, want the magic and version", b[:5])
+	}
+
+	// the index is in the first chunk, the contents of b.txt in the last
+	indexStart := len(BlobMagic) + 1 + signatureSize(&privKey.PublicKey) + aes.PipeHeaderSize + 20
+	for name, tc := range map[string]struct {
+		off  int
+		want error
+	}{
+		"index":    {off: indexStart, want: aes.ErrAuthFailed},
+		"contents": {off: len(b) - aes.GCMTagSize - 10, want: aes.ErrAuthFailed},
+		"tag":      {off: len(b) - 1, want: aes.ErrAuthFailed},
+		"magic":    {off: 0, want: ErrBlobCorrupt},
+	} {
+		tampered := append([]byte(nil), b...)
+		tampered[tc.off] ^= 1
+		outDir := t.TempDir()
+		err := DecryptBlob(privKey, key, bytes.NewReader(tampered), outDir)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: DecryptBlob: err = %v, want %v", name, err, tc.want)
+		}
+		// nothing of a file that failed part way through is left
+		if _, err := os.Stat(filepath.Join(outDir, "b.txt")); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s: DecryptBlob: b.txt left behind", name)
+		}
+	}
+
+	err = DecryptBlob(privKey, key, bytes.NewReader(b[:len(b)-1]), t.TempDir())
+	if err == nil {
+		t.Errorf("DecryptBlob: cut short: err = nil")
+	}
+}
+
+func TestPackFileChanged(t *testing.T) {
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello")})
+	path := filepath.Join(dir, "a.txt")
+
+	for size, want := range map[int64]error{5: nil, 4: ErrBlobChanged, 6: ErrBlobChanged} {
+		var out bytes.Buffer
+		err := packFile(&out, path, size)
+		if !errors.Is(err, want) {
+			t.Errorf("packFile indexed at %d bytes: err = %v, want %v", size, err, want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package encryptdir
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// the UTF-8 byte order mark some editors put at the start of text files
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// metadata key of a file header recording the plaintext started with `utf8BOM`, "1" if it did
+const metadataBOM = ReservedMetadataPrefix + "bom"
+
+// encryptdir.Options.stripsBOM: if a file at `path` with the plaintext `plain` has its BOM stripped before it is encrypted, for `Options.TextExtensions`
+func (o Options) stripsBOM(path string, plain []byte) bool {
+	if !bytes.HasPrefix(plain, utf8BOM) {
+		return false
+	}
+	ext := normalizeExt(path)
+	for _, textExt := range o.TextExtensions {
+		if textExt == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// encryptdir.restoresBOM: if a file with `header` had its BOM stripped before it was encrypted, so it goes back in front of the plaintext
+func restoresBOM(header *FileHeader) bool {
+	return header != nil && header.Metadata[metadataBOM] == "1"
+}
+
+// encryptdir.writeBOM: writes the BOM to `w` if a file with `header` had it stripped, before anything else of its plaintext
+// returns: error
+func writeBOM(header *FileHeader, w io.Writer) error {
+	if !restoresBOM(header) {
+		return nil
+	}
+	_, err := w.Write(utf8BOM)
+	if err != nil {
+		return fmt.Errorf("encryptdir.writeBOM: w.Write: %w", err)
+	}
+	return nil
+}
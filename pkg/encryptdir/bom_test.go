@@ -0,0 +1,67 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestBOMRoundTrip(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt", "bin")
+	bomText := append(append([]byte(nil), utf8BOM...), []byte("hello\r\nworld\r\n")...)
+	spec := map[string][]byte{"a.txt": bomText, "plain.txt": []byte("no bom"), "b.bin": bomText}
+	encOpts := Options{TextExtensions: []string{"txt"}, Compress: true, IntegrityHash: DefaultHashAlgo, Metadata: map[string]string{"owner": "alice"}}
+
+	// decrypted in memory and streamed
+	for _, decOpts := range []Options{{}, {StreamThreshold: -1}} {
+		dir, _ := testutil.BuildTree(t, spec)
+		_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, encOpts)
+		if err != nil {
+			t.Fatalf("EncryptWithOptions: %v", err)
+		}
+
+		for name, want := range map[string]bool{"a.txt": true, "plain.txt": false, "b.bin": false} {
+			header, err := ReadHeader(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatalf("ReadHeader: %v", err)
+			}
+			if got := header.Metadata[metadataBOM] == "1"; got != want {
+				t.Errorf("ReadHeader(%s): bom = %v, want %v", name, got, want)
+			}
+		}
+
+		// the flag isnt part of the user's metadata
+		metadata, err := ReadMetadata(filepath.Join(dir, "a.txt"))
+		if err != nil || len(metadata) != 1 || metadata["owner"] != "alice" {
+			t.Errorf("ReadMetadata = %v, %v, want only owner", metadata, err)
+		}
+
+		plain, err := decryptBytes(t, keyMap, filepath.Join(dir, "a.txt"), Options{})
+		if err != nil || !bytes.Equal(plain, bomText) {
+			t.Errorf("decryptTo = %q, %v, want %q", plain, err, bomText)
+		}
+
+		_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, decOpts)
+		if err != nil {
+			t.Fatalf("DecryptWithOptions: %v", err)
+		}
+		assertTree(t, dir, spec)
+	}
+}
+
+func TestBOMReservedMetadata(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello")})
+
+	opts := Options{Metadata: map[string]string{metadataBOM: "1"}}
+	_, err := EncryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), keyMap, []string{dir}, opts)
+	if !errors.Is(err, ErrReservedMetadata) {
+		t.Fatalf("EncryptWithOptions: err = %v, want ErrReservedMetadata", err)
+	}
+	assertTree(t, dir, map[string][]byte{"a.txt": []byte("hello")})
+}
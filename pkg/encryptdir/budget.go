@@ -0,0 +1,63 @@
+package encryptdir
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// sentinel error used for when encrypting stopped because of `Options.MaxTotalOutputBytes`
+var ErrOutputBudget = errors.New("output budget reached")
+
+// outputBudget: bytes written by a whole run, shared by every root and worker
+// a nil `*outputBudget` has no limit
+type outputBudget struct {
+	limit int64
+	used  atomic.Int64
+
+	// set by the first file that doesnt fit, every later file is left alone too
+	exhausted atomic.Bool
+	left      atomic.Int64
+}
+
+// encryptdir.newOutputBudget: budget of `limit` bytes, nil if `limit` is 0 or less
+func newOutputBudget(limit int64) *outputBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &outputBudget{limit: limit}
+}
+
+// encryptdir.outputBudget.reserve: takes `n` bytes from the budget
+// returns: false if they dont fit, the file shouldn't be written
+func (b *outputBudget) reserve(n int64) bool {
+	if b == nil {
+		return true
+	}
+
+	if !b.exhausted.Load() {
+		if b.used.Add(n) <= b.limit {
+			return true
+		}
+		b.used.Add(-n)
+		b.exhausted.Store(true)
+	}
+
+	b.left.Add(1)
+	return false
+}
+
+// encryptdir.outputBudget.release: gives back `n` bytes taken by `reserve` for a file that failed or was skipped before its output was kept
+func (b *outputBudget) release(n int64) {
+	if b == nil {
+		return
+	}
+	b.used.Add(-n)
+}
+
+// encryptdir.outputBudget.remaining: number of files left unencrypted because of the budget
+func (b *outputBudget) remaining() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.left.Load()
+}
@@ -0,0 +1,111 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestMaxTotalOutputBytes(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := make(map[string][]byte)
+	for i := 0; i < 5; i++ {
+		spec[fmt.Sprintf("f%d.txt", i)] = bytes.Repeat([]byte{byte('a' + i)}, 1000)
+	}
+
+	// how big a single encrypted file is, every one of them is the same size
+	one, _ := testutil.BuildTree(t, map[string][]byte{"f.txt": spec["f0.txt"]})
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{one}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	size := int64(len(readTree(t, one)["f.txt"]))
+
+	dir, _ := testutil.BuildTree(t, spec)
+	budget := 2*size + size/2
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{MaxTotalOutputBytes: budget})
+	if !errors.Is(err, ErrOutputBudget) {
+		t.Fatalf("EncryptWithOptions: err = %v, want ErrOutputBudget", err)
+	}
+	if !strings.Contains(err.Error(), "3 files left unencrypted") {
+		t.Errorf("EncryptWithOptions: err = %v, want it to report the 3 files left", err)
+	}
+	if report.Processed != 2 {
+		t.Errorf("EncryptWithOptions: processed = %d, want 2", report.Processed)
+	}
+
+	var written int64
+	plaintext := 0
+	for rel, contents := range readTree(t, dir) {
+		if bytes.Equal(contents, spec[rel]) {
+			plaintext++
+			continue
+		}
+		written += int64(len(contents))
+	}
+	if plaintext != 3 {
+		t.Errorf("%d files left as plaintext, want 3", plaintext)
+	}
+	if written > budget {
+		t.Errorf("wrote %d bytes, over the budget of %d", written, budget)
+	}
+
+	// a later run with room for the rest finishes the tree
+	_, err = EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{MaxTotalOutputBytes: 3 * size})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions with room for the rest: %v", err)
+	}
+	_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	assertTree(t, dir, spec)
+}
+
+func TestMaxTotalOutputBytesFailed(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := make(map[string][]byte)
+	for i := 0; i < 3; i++ {
+		spec[fmt.Sprintf("f%d.txt", i)] = bytes.Repeat([]byte{byte('a' + i)}, 1000)
+	}
+
+	one, _ := testutil.BuildTree(t, map[string][]byte{"f.txt": spec["f0.txt"]})
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{one}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	size := int64(len(readTree(t, one)["f.txt"]))
+
+	// the first file to be renamed into place fails, whichever it is, one at a time so it is done before the others reserve
+	var swaps atomic.Int32
+	errSwap := errors.New("swap failed")
+	swap = func(tmpPath string, path string) error {
+		if swaps.Add(1) == 1 {
+			return errSwap
+		}
+		return swapInto(tmpPath, path)
+	}
+	t.Cleanup(func() { swap = swapInto })
+
+	// room for 2 files, the one that failed doesnt take any of it
+	dir, _ := testutil.BuildTree(t, spec)
+	opts := Options{MaxTotalOutputBytes: 2*size + size/2, MaxWorkers: 1}
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if !errors.Is(err, errSwap) {
+		t.Fatalf("EncryptWithOptions: err = %v, want the failed swap", err)
+	}
+	if errors.Is(err, ErrOutputBudget) {
+		t.Errorf("EncryptWithOptions: err = %v, want the budget of the failed file given back", err)
+	}
+	if report.Processed != 2 {
+		t.Errorf("EncryptWithOptions: processed = %d, want 2", report.Processed)
+	}
+}
@@ -0,0 +1,60 @@
+package encryptdir
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestWalkCandidates(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt", "sql")
+	dirA, _ := testutil.BuildTree(t, map[string][]byte{
+		"a.txt":     []byte("a"),
+		"sub/b.sql": []byte("b"),
+		"c.md":      []byte("no key"),
+	})
+	dirB, _ := testutil.BuildTree(t, map[string][]byte{"d.txt": []byte("d")})
+
+	var got []string
+	err := WalkCandidates(keyMap, []string{dirA, dirB}, func(path string) error {
+		got = append(got, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkCandidates: %v", err)
+	}
+	sort.Strings(got)
+
+	want := []string{
+		filepath.Join(dirA, "a.txt"),
+		filepath.Join(dirA, "sub", "b.sql"),
+		filepath.Join(dirB, "d.txt"),
+	}
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WalkCandidates visited %v, want %v", got, want)
+	}
+}
+
+func TestWalkCandidatesStop(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	dirA, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("a"), "b.txt": []byte("b"), "c.txt": []byte("c")})
+	dirB, _ := testutil.BuildTree(t, map[string][]byte{"d.txt": []byte("d")})
+
+	errStop := errors.New("stop")
+	calls := 0
+	err := WalkCandidates(keyMap, []string{dirA, dirB}, func(path string) error {
+		calls++
+		return errStop
+	})
+	if !errors.Is(err, errStop) {
+		t.Fatalf("WalkCandidates: err = %v, want %v", err, errStop)
+	}
+	if calls != 1 {
+		t.Errorf("WalkCandidates: called %d times after returning an error, want 1", calls)
+	}
+}
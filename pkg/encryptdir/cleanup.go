@@ -0,0 +1,106 @@
+package encryptdir
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// temp files modified this recently are never stale, a run can create one a moment before it locks it
+const CleanupGrace = time.Minute
+
+// encryptdir.Cleanup: removes the temp files interrupted runs left in `dirs`, with the default suffixes
+// returns: paths of the removed temp files, or error
+func Cleanup(dirs []string) ([]string, error) {
+	removed, err := CleanupWithOptions(dirs, Options{})
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.Cleanup: %w", err)
+	}
+	return removed, nil
+}
+
+// encryptdir.CleanupWithOptions: removes the temp files interrupted runs left in `dirs`, named by the `Namer` or suffixes of `opts`
+// a temp file is stale when the file it was written for is still there, the process its name has isnt running, no run holds its lock,
+// and it is older than `CleanupGrace`
+// on platforms without file locks only its age tells, so nothing should be running on `dirs` at the same time
+// the outputs of `Options.KeepOriginal` runs end in `Options.KeepSuffix` or `Options.KeepDecSuffix` instead, they arent temp files and are left alone,
+// temp files in an `Options.OutputDir` mirror are only found once the output they were written for is there
+// with `opts.DryRun` nothing is removed
+// returns: paths of the stale temp files, or error
+func CleanupWithOptions(dirs []string, opts Options) ([]string, error) {
+	namer := opts.namer()
+	var stale []string
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !d.Type().IsRegular() || !namer.IsTemp(path) {
+				return nil
+			}
+
+			ok, err := isStaleTemp(namer, path)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return nil
+			}
+
+			stale = append(stale, path)
+			if opts.DryRun {
+				return nil
+			}
+
+			err = os.Remove(path)
+			// removed by another cleanup
+			if err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("os.Remove: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return stale, fmt.Errorf("encryptdir.CleanupWithOptions: dir = %q: %w", dir, err)
+		}
+	}
+	return stale, nil
+}
+
+// encryptdir.isStaleTemp: if the temp file at `path` was left behind rather than being written right now
+// returns: if it is stale or error
+func isStaleTemp(namer Namer, path string) (bool, error) {
+	if n, ok := namer.(SuffixNamer); ok {
+		original, pid, _ := n.split(path)
+
+		// a file that only looks like a temp file has no original next to it
+		_, err := os.Lstat(original)
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("encryptdir.isStaleTemp: os.Lstat: %w", err)
+		}
+
+		// another process that is still running owns it, temp files of this one go by their lock
+		if pid != 0 && pid != os.Getpid() && processAlive(pid) {
+			return false, nil
+		}
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return false, fmt.Errorf("encryptdir.isStaleTemp: os.Lstat: %w", err)
+	}
+	if time.Since(info.ModTime()) < CleanupGrace {
+		return false, nil
+	}
+
+	locked, err := tempLocked(path)
+	if err != nil {
+		return false, fmt.Errorf("encryptdir.isStaleTemp: %w", err)
+	}
+	return !locked, nil
+}
@@ -0,0 +1,96 @@
+package encryptdir
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+// pidNamer: the default namer as the process `pid` would have named its temp files
+func pidNamer(pid int) SuffixNamer {
+	return SuffixNamer{EncSuffix: DefaultEncSuffix, DecSuffix: DefaultDecSuffix, PID: pid}
+}
+
+func TestCleanup(t *testing.T) {
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world"), "c.txt": []byte("busy")}
+	dir, _ := testutil.BuildTree(t, spec)
+	path := func(rel string) string { return filepath.Join(dir, filepath.FromSlash(rel)) }
+
+	// past any pid_max, so never running
+	dead := pidNamer(1 << 22)
+	stale := []string{dead.TempName(path("a.txt"), false), dead.TempName(path("sub/b.txt"), true)}
+	kept := []string{
+		// pid 1 is always running, its temp file may still be written
+		pidNamer(1).TempName(path("c.txt"), false),
+		// looks like a temp file, but there is no original next to it
+		dead.TempName(path("gone.txt"), false),
+	}
+	old := time.Now().Add(-2 * CleanupGrace)
+	for _, p := range append(append([]string(nil), stale...), kept...) {
+		err := os.WriteFile(p, []byte("partial"), 0644)
+		if err != nil {
+			t.Fatalf("os.WriteFile: %v", err)
+		}
+		err = os.Chtimes(p, old, old)
+		if err != nil {
+			t.Fatalf("os.Chtimes: %v", err)
+		}
+	}
+	// too recent, a run may be about to lock it
+	fresh := dead.TempName(path("sub/b.txt"), false)
+	err := os.WriteFile(fresh, []byte("partial"), 0644)
+	if err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	kept = append(kept, fresh)
+	before := readTree(t, dir)
+	sort.Strings(stale)
+
+	// a dry run finds them without removing anything
+	found, err := CleanupWithOptions([]string{dir}, Options{DryRun: true})
+	sort.Strings(found)
+	if err != nil || !equalStrings(found, stale) {
+		t.Errorf("CleanupWithOptions(DryRun) = %q, %v, want %q", found, err, stale)
+	}
+	assertTree(t, dir, before)
+
+	removed, err := Cleanup([]string{dir})
+	sort.Strings(removed)
+	if err != nil || !equalStrings(removed, stale) {
+		t.Errorf("Cleanup = %q, %v, want %q", removed, err, stale)
+	}
+	for _, p := range stale {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("path = %q: not removed", p)
+		}
+	}
+	for _, p := range kept {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("path = %q: %v, want it kept", p, err)
+		}
+	}
+	// the originals are untouched
+	got := readTree(t, dir)
+	for rel, contents := range spec {
+		if string(got[rel]) != string(contents) {
+			t.Errorf("path = %q: changed by Cleanup", rel)
+		}
+	}
+}
+
+// equalStrings: if `a` and `b` hold the same strings in the same order
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
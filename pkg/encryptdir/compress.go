@@ -0,0 +1,81 @@
+package encryptdir
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// encryptdir.compress: gzips `plain` for `Options.Compress`
+// returns: compressed plaintext and true, or false if it isnt smaller than `plain`, like for media or archives that are already compressed
+func compress(plain []byte) ([]byte, bool, error) {
+	var out bytes.Buffer
+	zw := gzip.NewWriter(&out)
+
+	// writes to a `bytes.Buffer` dont fail
+	_, err := zw.Write(plain)
+	if err != nil {
+		return nil, false, fmt.Errorf("encryptdir.compress: gzip.Writer.Write: %w", err)
+	}
+	err = zw.Close()
+	if err != nil {
+		return nil, false, fmt.Errorf("encryptdir.compress: gzip.Writer.Close: %w", err)
+	}
+
+	if out.Len() >= len(plain) {
+		return nil, false, nil
+	}
+	return out.Bytes(), true, nil
+}
+
+// encryptdir.decompress: gunzips `payload` if `header` says it was compressed, otherwise returns it as is
+// returns: plaintext or error
+func decompress(header *FileHeader, payload []byte) ([]byte, error) {
+	if header == nil || header.Compression != CompressionGzip {
+		return payload, nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.decompress: gzip.NewReader: %w", err)
+	}
+	plain, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.decompress: io.ReadAll: %w", err)
+	}
+	return plain, nil
+}
+
+// encryptdir.decompressWriter: a writer for the streamed payload of a file with `header` that writes the plaintext to `out`, gunzipping it if it was compressed
+// the returned func has to be called with the error of the stream once it is done, it waits for the rest of the plaintext to reach `out`
+// returns: writer, and the func returning the first error of the stream or of gunzipping it
+func decompressWriter(header *FileHeader, out io.Writer) (io.Writer, func(error) error) {
+	if header == nil || header.Compression != CompressionGzip {
+		return out, func(err error) error { return err }
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		zr, err := gzip.NewReader(pr)
+		if err == nil {
+			_, err = io.Copy(out, zr)
+		}
+		if err != nil {
+			err = fmt.Errorf("encryptdir.decompressWriter: %w", err)
+		}
+		// unblocks the stream if gunzipping stopped early
+		pr.CloseWithError(err)
+		done <- err
+	}()
+
+	return pw, func(err error) error {
+		pw.CloseWithError(err)
+		zErr := <-done
+		if err != nil {
+			return err
+		}
+		return zErr
+	}
+}
@@ -0,0 +1,51 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestCompress(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt", "bin")
+	noise := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(noise)
+	spec := map[string][]byte{
+		"a.txt":     bytes.Repeat([]byte("the same line over and over\n"), 2000),
+		"noise.bin": noise,
+	}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{Compress: true})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	got := readTree(t, dir)
+
+	// header, signature, and all, still smaller than the plaintext
+	if len(got["a.txt"]) >= len(spec["a.txt"]) {
+		t.Errorf("a.txt: %d bytes on disk, want fewer than the %d of the plaintext", len(got["a.txt"]), len(spec["a.txt"]))
+	}
+	for rel, want := range map[string]string{"a.txt": "gzip", "noise.bin": ""} {
+		header, err := ReadHeaderWithKey(&privKey.PublicKey, filepath.Join(dir, rel))
+		if err != nil {
+			t.Fatalf("ReadHeaderWithKey(%s): %v", rel, err)
+		}
+		// gzip doesnt make the noise any smaller, so it is stored as is
+		if header.Compression != want {
+			t.Errorf("%s: compression = %q, want %q", rel, header.Compression, want)
+		}
+	}
+
+	// decrypting doesnt need the option, the file header says to gunzip
+	_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	assertTree(t, dir, spec)
+}
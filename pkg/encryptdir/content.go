@@ -0,0 +1,155 @@
+package encryptdir
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	gorsa "crypto/rsa"
+)
+
+// name of the index `Options.ContentAddressed` writes in the output dir, a JSON object from the slash separated path of each original under the root to the name of its object
+const ContentIndexName = "index.json"
+
+// sentinel error used for when a path isnt in the index of a content addressed output dir
+var ErrNotInIndex = errors.New("path not in content index")
+
+// contentIndex: the objects a run with `Options.ContentAddressed` stored in the output dir so far, shared by every worker
+// a nil `*contentIndex` stores nothing, the output goes where `Options.outputPath` says
+type contentIndex struct {
+	dir string
+
+	mu sync.Mutex
+	// slash separated path of each original to its object, with what the index held before the run
+	entries map[string]string
+}
+
+// encryptdir.newContentIndex: index for `opts`, starting from the one already in the output dir, nil without `Options.ContentAddressed`
+// returns: index, or error if the one there doesnt parse
+func newContentIndex(opts Options) (*contentIndex, error) {
+	if !opts.ContentAddressed || len(opts.OutputDir) == 0 {
+		return nil, nil
+	}
+
+	entries, err := ReadContentIndex(opts.OutputDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("encryptdir.newContentIndex: %w", err)
+	}
+	if entries == nil {
+		entries = make(map[string]string)
+	}
+	return &contentIndex{dir: opts.OutputDir, entries: entries}, nil
+}
+
+// encryptdir.contentIndex.stagingPath: where the output for the file at `rel` is written before it is stored, one flat name per path so workers dont collide
+func (c *contentIndex) stagingPath(rel string) string {
+	sum := sha256.Sum256([]byte(filepath.ToSlash(rel)))
+	return filepath.Join(c.dir, "staging-"+hex.EncodeToString(sum[:]))
+}
+
+// encryptdir.contentIndex.store: moves the finished output still open as `tmp` of the file at `rel` to the object named by its SHA-256 and records it
+// an object already there has the same bytes, so it is just replaced
+// returns: error
+func (c *contentIndex) store(syncer *dirSyncer, tmp *os.File, rel string) error {
+	info, err := tmp.Stat()
+	if err != nil {
+		return fmt.Errorf("encryptdir.contentIndex.store: tmp.Stat: %w", err)
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, io.NewSectionReader(tmp, 0, info.Size()))
+	if err != nil {
+		return fmt.Errorf("encryptdir.contentIndex.store: io.Copy: %w", err)
+	}
+	name := hex.EncodeToString(h.Sum(nil))
+
+	err = syncer.finalize(tmp, filepath.Join(c.dir, name))
+	if err != nil {
+		return fmt.Errorf("encryptdir.contentIndex.store: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[filepath.ToSlash(rel)] = name
+	return nil
+}
+
+// encryptdir.contentIndex.write: writes the index next to the objects, replacing the one there all at once
+// returns: error
+func (c *contentIndex) write() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	b, err := json.MarshalIndent(c.entries, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("encryptdir.contentIndex.write: json.MarshalIndent: %w", err)
+	}
+
+	path := filepath.Join(c.dir, ContentIndexName)
+	tmpPath := path + ".tmp"
+	err = os.WriteFile(tmpPath, b, 0600)
+	if err != nil {
+		return fmt.Errorf("encryptdir.contentIndex.write: os.WriteFile: %w", err)
+	}
+	err = finalize(tmpPath, path)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("encryptdir.contentIndex.write: %w", err)
+	}
+	return nil
+}
+
+// encryptdir.ReadContentIndex: the index of the content addressed output dir `outputDir`, from the slash separated path of each original to the name of its object
+// returns: index, or error wrapping `os.ErrNotExist` if there is none
+func ReadContentIndex(outputDir string) (map[string]string, error) {
+	b, err := os.ReadFile(filepath.Join(outputDir, ContentIndexName))
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.ReadContentIndex: os.ReadFile: %w", err)
+	}
+
+	var entries map[string]string
+	err = json.Unmarshal(b, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.ReadContentIndex: json.Unmarshal: %w", err)
+	}
+	return entries, nil
+}
+
+// encryptdir.LookupContent: the object the file at `rel` under the root was stored as in the content addressed output dir `outputDir`
+// returns: full path of the object, or error wrapping `ErrNotInIndex`
+func LookupContent(outputDir string, rel string) (string, error) {
+	entries, err := ReadContentIndex(outputDir)
+	if err != nil {
+		return "", fmt.Errorf("encryptdir.LookupContent: %w", err)
+	}
+
+	name, ok := entries[filepath.ToSlash(filepath.Clean(rel))]
+	if !ok {
+		return "", fmt.Errorf("encryptdir.LookupContent: path = %q: %w", rel, ErrNotInIndex)
+	}
+	return filepath.Join(outputDir, name), nil
+}
+
+// encryptdir.DecryptContent: decrypts the object the file at `rel` under the root was stored as in the content addressed output dir `outputDir` into `w`
+// the key is the one of `keyMap` for the extension its file header recorded, the object itself has none
+// returns: error wrapping `ErrNotInIndex` like `LookupContent`, or like `DecryptTo`
+func DecryptContent(privKey *gorsa.PrivateKey, keyMap map[string][]byte, outputDir string, rel string, w io.Writer) error {
+	path, err := LookupContent(outputDir, rel)
+	if err != nil {
+		return fmt.Errorf("encryptdir.DecryptContent: %w", err)
+	}
+
+	err = decryptTo(privKey, keyMap, path, w, Options{}, nil)
+	if err != nil {
+		return fmt.Errorf("encryptdir.DecryptContent: %w", err)
+	}
+	return nil
+}
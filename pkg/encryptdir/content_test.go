@@ -0,0 +1,106 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestContentAddressedRoundTrip(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("same"), "sub/b.txt": []byte("same"), "c.txt": []byte("other")}
+
+	for _, opts := range []Options{{}, {StreamThreshold: -1}} {
+		dir, _ := testutil.BuildTree(t, spec)
+		out := t.TempDir()
+		opts.OutputDir = out
+		opts.ContentAddressed = true
+
+		_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+		if err != nil {
+			t.Fatalf("EncryptWithOptions: %v", err)
+		}
+
+		index, err := ReadContentIndex(out)
+		if err != nil {
+			t.Fatalf("ReadContentIndex: %v", err)
+		}
+		if len(index) != len(spec) {
+			t.Fatalf("ReadContentIndex = %v, want an entry for each of %d files", index, len(spec))
+		}
+		// identical inputs are one object
+		if index["a.txt"] != index["sub/b.txt"] || index["a.txt"] == index["c.txt"] {
+			t.Errorf("ReadContentIndex = %v, want a.txt and sub/b.txt the same object and c.txt another", index)
+		}
+		entries, err := os.ReadDir(out)
+		if err != nil {
+			t.Fatalf("os.ReadDir: %v", err)
+		}
+		if len(entries) != 3 {
+			t.Errorf("os.ReadDir: %d entries, want 2 objects and the index", len(entries))
+		}
+
+		for name, want := range spec {
+			var plain bytes.Buffer
+			err = DecryptContent(privKey, keyMap, out, name, &plain)
+			if err != nil || !bytes.Equal(plain.Bytes(), want) {
+				t.Errorf("DecryptContent(%s) = %q, %v, want %q", name, plain.Bytes(), err, want)
+			}
+		}
+		// the root is left alone
+		assertTree(t, dir, spec)
+	}
+}
+
+func TestContentAddressedConvergent(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+
+	// separate runs of the same file name it the same
+	var names []string
+	for i := 0; i < 2; i++ {
+		dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello")})
+		out := t.TempDir()
+		_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{OutputDir: out, ContentAddressed: true})
+		if err != nil {
+			t.Fatalf("EncryptWithOptions: %v", err)
+		}
+		path, err := LookupContent(out, "a.txt")
+		if err != nil {
+			t.Fatalf("LookupContent: %v", err)
+		}
+		names = append(names, filepath.Base(path))
+	}
+	if names[0] != names[1] {
+		t.Errorf("LookupContent: names = %v, want the same", names)
+	}
+}
+
+func TestContentAddressedLookup(t *testing.T) {
+	out := t.TempDir()
+	_, err := LookupContent(out, "a.txt")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LookupContent: err = %v, want os.ErrNotExist without an index", err)
+	}
+
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello")})
+	_, err = EncryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), testutil.NewKeyMap("txt"), []string{dir}, Options{OutputDir: out, ContentAddressed: true})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	_, err = LookupContent(out, "b.txt")
+	if !errors.Is(err, ErrNotInIndex) {
+		t.Errorf("LookupContent: err = %v, want ErrNotInIndex", err)
+	}
+
+	_, err = EncryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), testutil.NewKeyMap("txt"), []string{dir}, Options{ContentAddressed: true})
+	if !errors.Is(err, ErrBadOutputDir) {
+		t.Errorf("EncryptWithOptions: err = %v, want ErrBadOutputDir without an output dir", err)
+	}
+}
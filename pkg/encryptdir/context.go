@@ -0,0 +1,11 @@
+package encryptdir
+
+import "context"
+
+// encryptdir.ctxErr: `ctx.Err()`, nil for a nil `ctx`
+func ctxErr(ctx context.Context) error {
+	if ctx == nil {
+		return nil
+	}
+	return ctx.Err()
+}
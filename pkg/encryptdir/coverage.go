@@ -0,0 +1,99 @@
+package encryptdir
+
+import (
+	gorsa "crypto/rsa"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ExtCoverage: how many files matching an extension are encrypted
+type ExtCoverage struct {
+	Encrypted int
+	Plaintext int
+}
+
+// encryptdir.ExtCoverage.Percent: percentage of files that are encrypted, 100 if there are no files
+func (c ExtCoverage) Percent() float64 {
+	total := c.Encrypted + c.Plaintext
+	if total == 0 {
+		return 100
+	}
+	return 100 * float64(c.Encrypted) / float64(total)
+}
+
+// CoverageReport: encryption coverage of a set of directories
+type CoverageReport struct {
+	// keyed by extension without the `.`, every extension in the key map has an entry
+	Extensions map[string]ExtCoverage
+	// sum of every extension
+	Total ExtCoverage
+}
+
+// encryptdir.Coverage: counts how many files with a key in `keyMap` are encrypted and how many are still plaintext in `dirs`
+// read only, files written with a banner are counted as plaintext
+// returns: report or error
+func Coverage(privKey *gorsa.PrivateKey, keyMap map[string][]byte, dirs []string) (CoverageReport, error) {
+	report := CoverageReport{Extensions: make(map[string]ExtCoverage, len(keyMap))}
+	for ext := range keyMap {
+		report.Extensions[ext] = ExtCoverage{}
+	}
+
+	err := walkCandidates(keyMap, dirs, func(path string, info os.FileInfo) error {
+		// files falling back to the `FallbackExt` key are counted under it
+		key, ext, _ := lookupKeyExt(keyMap, path)
+
+		encrypted, err := isEncrypted(&privKey.PublicKey, key, nil, path)
+		if err != nil {
+			return err
+		}
+
+		c := report.Extensions[ext]
+		if encrypted {
+			c.Encrypted++
+			report.Total.Encrypted++
+		} else {
+			c.Plaintext++
+			report.Total.Plaintext++
+		}
+		report.Extensions[ext] = c
+		return nil
+	})
+	if err != nil {
+		return CoverageReport{}, fmt.Errorf("encryptdir.Coverage: %w", err)
+	}
+
+	return report, nil
+}
+
+// encryptdir.ExtensionCoverage: counts the regular files in `dirs` by extension, split by whether `keyMap` has a key for them
+// extensions are written like `keyMap` keys, files without one are counted under "", with a `FallbackExt` key every file is covered
+// read only, nothing is opened, so it can run before there is a key map to check one against the tree
+// returns: counts of covered and uncovered extensions, or error
+func ExtensionCoverage(dirs []string, keyMap map[string][]byte) (map[string]int, map[string]int, error) {
+	covered := make(map[string]int)
+	uncovered := make(map[string]int)
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+
+			ext := normalizeExt(path)
+			if _, ok := lookupKey(keyMap, path); ok {
+				covered[ext]++
+			} else {
+				uncovered[ext]++
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("encryptdir.ExtensionCoverage: dir = %q: %w", dir, err)
+		}
+	}
+	return covered, uncovered, nil
+}
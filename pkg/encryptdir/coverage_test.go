@@ -0,0 +1,108 @@
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestCoverage(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt", "sql", "csv")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{
+		"a.txt":     []byte("a"),
+		"sub/b.txt": []byte("b"),
+		"sub/c.txt": []byte("c"),
+		"d.sql":     []byte("d"),
+		"e.sql":     []byte("e"),
+		"sub/f.sql": []byte("f"),
+		"g.md":      []byte("no key"),
+	})
+
+	// only `sub` is encrypted, leaving the tree partly covered
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{filepath.Join(dir, "sub")}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+
+	report, err := Coverage(privKey, keyMap, []string{dir})
+	if err != nil {
+		t.Fatalf("Coverage: %v", err)
+	}
+
+	for ext, want := range map[string]struct {
+		cov     ExtCoverage
+		percent float64
+	}{
+		"txt": {ExtCoverage{Encrypted: 2, Plaintext: 1}, 200.0 / 3},
+		"sql": {ExtCoverage{Encrypted: 1, Plaintext: 2}, 100.0 / 3},
+		// no files, nothing left to encrypt
+		"csv": {ExtCoverage{}, 100},
+	} {
+		got, ok := report.Extensions[ext]
+		if !ok {
+			t.Errorf("Coverage: no entry for %q", ext)
+			continue
+		}
+		if got != want.cov {
+			t.Errorf("Coverage[%q] = %+v, want %+v", ext, got, want.cov)
+		}
+		if math.Abs(got.Percent()-want.percent) > 0.01 {
+			t.Errorf("Coverage[%q].Percent() = %.2f, want %.2f", ext, got.Percent(), want.percent)
+		}
+	}
+	if len(report.Extensions) != len(keyMap) {
+		t.Errorf("Coverage: %d extensions, want one per key map entry", len(report.Extensions))
+	}
+
+	if want := (ExtCoverage{Encrypted: 3, Plaintext: 3}); report.Total != want {
+		t.Errorf("Coverage total = %+v, want %+v", report.Total, want)
+	}
+	if report.Total.Percent() != 50 {
+		t.Errorf("Coverage total percent = %.2f, want 50", report.Total.Percent())
+	}
+}
+
+func TestExtensionCoverage(t *testing.T) {
+	first, _ := testutil.BuildTree(t, map[string][]byte{
+		"a.txt":          []byte("a"),
+		"sub/b.txt":      []byte("b"),
+		"sub/c.md":       []byte("c"),
+		"archive.tar.gz": []byte("d"),
+		"noext":          []byte("e"),
+	})
+	// counted across every dir
+	second, _ := testutil.BuildTree(t, map[string][]byte{"f.txt": []byte("f"), "g.md": []byte("g")})
+	dirs := []string{first, second}
+
+	covered, uncovered, err := ExtensionCoverage(dirs, testutil.NewKeyMap("txt", "gz"))
+	if err != nil {
+		t.Fatalf("ExtensionCoverage: %v", err)
+	}
+	if want := map[string]int{"txt": 3, "gz": 1}; !reflect.DeepEqual(covered, want) {
+		t.Errorf("covered = %v, want %v", covered, want)
+	}
+	if want := map[string]int{"md": 2, "": 1}; !reflect.DeepEqual(uncovered, want) {
+		t.Errorf("uncovered = %v, want %v", uncovered, want)
+	}
+
+	// the fallback key covers the rest, still counted by their own extension
+	covered, uncovered, err = ExtensionCoverage(dirs, testutil.NewKeyMap("txt", FallbackExt))
+	if err != nil {
+		t.Fatalf("ExtensionCoverage with a fallback: %v", err)
+	}
+	if want := map[string]int{"txt": 3, "gz": 1, "md": 2, "": 1}; !reflect.DeepEqual(covered, want) || len(uncovered) != 0 {
+		t.Errorf("covered = %v, uncovered = %v, want %v and none", covered, uncovered, want)
+	}
+
+	_, _, err = ExtensionCoverage([]string{filepath.Join(first, "missing")}, testutil.NewKeyMap("txt"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ExtensionCoverage of a missing dir: err = %v, want fs.ErrNotExist", err)
+	}
+}
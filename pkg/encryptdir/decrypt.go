@@ -1,50 +1,100 @@
 package encryptdir
 
 import (
-	"crypto"
+	"context"
 	gorsa "crypto/rsa"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"time"
 
-	"github.com/iafan/cwalk"
 	"github.com/prairir/encryptdir/pkg/aes"
-	"github.com/prairir/encryptdir/pkg/rsa"
 	"go.uber.org/zap"
 )
 
-func decryptDirectories(log *zap.SugaredLogger,
+// sentinel error used for when a file being decrypted isn't encrypted
+var ErrNotEncrypted = errors.New("file is not encrypted")
+
+func decryptDirectories(ctx context.Context,
+	log *zap.SugaredLogger,
 	privKey *gorsa.PrivateKey, keyMap map[string][]byte,
 	directories []string,
+	opts Options,
 ) error {
+	if log == nil {
+		log = zap.NewNop().Sugar()
+	}
+
+	err := opts.validate(privKey)
+	if err != nil {
+		return fmt.Errorf("encryptdir.decryptDirectories: %w", err)
+	}
 
-	errC := make(chan error, 0)
+	directories, err = dedupeDirs(directories)
+	if err != nil {
+		return fmt.Errorf("encryptdir.decryptDirectories: %w", err)
+	}
 
-	for _, dir := range directories {
-		w := Walker{
-			privKey:   privKey,
-			keyMap:    keyMap,
-			startPath: dir,
-		}
-		go func(dir string) { errC <- cwalk.Walk(dir, w.decryptWalk) }(dir)
+	keyMap, derived, err := opts.deriveKeys(keyMap)
+	if err != nil {
+		return fmt.Errorf("encryptdir.decryptDirectories: %w", err)
 	}
 
-	// best way to handle the errors
-	// loop over directories, merge errors, return if errors exist
-	var errList cwalk.WalkerErrorList
-	for range directories {
-		err := <-errC
+	err = validateKeyMap(keyMap, opts.EnforceAES256)
+	if err != nil {
+		return fmt.Errorf("encryptdir.decryptDirectories: %w", err)
+	}
 
-		var eList cwalk.WalkerErrorList
-		if errors.As(err, &cwalk.WalkerErrorList{}) {
-			errList.ErrorList = append(errList.ErrorList, eList.ErrorList...)
-		}
+	err = validateRules(opts.rules, keyMap)
+	if err != nil {
+		return fmt.Errorf("encryptdir.decryptDirectories: %w", err)
 	}
 
-	if len(errList.ErrorList) > 0 {
-		return fmt.Errorf("aes.Encrypt: cwalk.Walk: %s", errList.Error())
+	err = opts.filter().validate()
+	if err != nil {
+		return fmt.Errorf("encryptdir.decryptDirectories: %w", err)
+	}
+
+	err = opts.checkOutputDir(directories)
+	if err != nil {
+		return fmt.Errorf("encryptdir.decryptDirectories: %w", err)
+	}
+
+	progress, err := newProgress(opts.Hooks.OnProgress, keyMap, directories, opts.CountTotal, opts.filter())
+	if err != nil {
+		return fmt.Errorf("encryptdir.decryptDirectories: %w", err)
+	}
+
+	journal, err := openJournal(opts, directories, "decrypt")
+	if err != nil {
+		return fmt.Errorf("encryptdir.decryptDirectories: %w", err)
+	}
+
+	walker := newWalker(log, privKey, keyMap, opts, progress, derived, journal)
+	errList := walkRoots(ctx, directories, walker, func(w Walker) filepath.WalkFunc { return w.decryptWalk })
+
+	// the renames of the last files batched by `Options.SyncBatch`
+	err = walker.syncer.flush()
+	if err != nil {
+		errList = append(errList, err)
+	}
+
+	// files after the cancel were never started, so every error is from before it
+	if ctx.Err() != nil {
+		errList = append([]error{ctx.Err()}, errList...)
+	}
+
+	// kept for resuming unless every file got through
+	err = journal.close(len(errList) == 0)
+	if err != nil {
+		errList = append(errList, err)
+	}
+
+	if len(errList) > 0 {
+		return fmt.Errorf("encryptdir.decryptDirectories: %w", errors.Join(errList...))
 	}
 
 	return nil
@@ -52,93 +102,618 @@ func decryptDirectories(log *zap.SugaredLogger,
 
 func (w Walker) decryptWalk(path string, info os.FileInfo, err error) error {
 	if err != nil {
+		// cwalk only passes an error for the root, like it not existing
+		// errors below the root are collected by cwalk itself
+		if len(path) == 0 {
+			return err
+		}
 		return nil
 	}
 
-	errC := make(chan error, 1)
+	// canceled, dont start on any more files
+	if ctxErr(w.ctx) != nil {
+		return nil
+	}
+
+	// excluded dirs and the output dir arent descended into, filtered files are left alone before anything else
+	if info.IsDir() && (w.opts.filter().excludesDir(path) || w.opts.filter().holdsOutput(filepath.Join(w.startPath, path))) {
+		return errPruned
+	}
+	if !info.IsDir() && !w.opts.filter().allows(path) {
+		return nil
+	}
+
+	// links are left alone before anything is opened, following one can write outside the tree
+	if isSymlink(info) && !w.opts.FollowSymlinks {
+		return nil
+	}
+
+	// tagged with the worker it runs on, for `Options.DebugWorkers`
+	w = w.onWorker()
+	defer w.workers.put(w.worker)
+
+	start := time.Now()
+	err = w.decryptPath(w.ctx, path, info)
+
+	// cwalk passes `path` relative to the root, errors name the full path like `encryptWalk`s do
+	fullPath := filepath.Join(w.startPath, path)
+	w.stats.visit(fullPath, info, w.worker, err)
+	if _, ok := lookupKey(w.keyMap, path); ok && info.Mode().IsRegular() {
+		w.progress.file(fullPath)
+	}
+	if err == nil && !info.IsDir() {
+		err = w.checkSlow(path, time.Since(start))
+	}
+	if err != nil {
+		w.log.Warnw("file failed", "path", fullPath, "error", err)
+		if w.cancel != nil {
+			w.cancel()
+		}
+		err = fmt.Errorf("encryptdir.Walker.walk: path = %q: %w", fullPath, err)
+
+		// kept with their chain, so callers can still tell the sentinels apart with `errors.Is`
+		w.errs.add(err)
 
-	go func(startPath string, path string, info os.FileInfo, privKey *gorsa.PrivateKey, keyMap map[string][]byte, errChan chan error) {
-		if info.IsDir() { // skip dirs
-			errChan <- nil
-			return
+		// cwalk doesnt descend into a dir whose walk func errored, with `Options.ContinueOnError` the files under it are still processed
+		if info.IsDir() && !w.opts.ContinueOnError {
+			return errPruned
 		}
+		return nil
+	}
+	return nil
+}
 
-		ext := filepath.Ext(path)
-		key, ok := keyMap[ext[1:]]
+// encryptdir.Walker.decryptPath: decrypts the file or dir at `path` under the root of `w` for `Walker.decryptWalk`, on the walker goroutine cwalk called it from, until `ctx` is canceled
+// runs inline like `Walker.encryptPath` does
+// returns: error
+func (w Walker) decryptPath(ctx context.Context, path string, info os.FileInfo) (err error) {
+	// one bad file shouldnt take down the whole run, the panic comes back as the error of the file
+	defer recoverFile(w.log, path, &err)
 
-		// skip this file if not in key map
-		if !ok {
-			errChan <- nil
-			return
+	if info.IsDir() {
+		// only mirrored, there is nothing to decrypt in a dir
+		if len(w.opts.OutputDir) > 0 && !w.opts.DryRun {
+			err := mirrorDirs(w.startPath, w.opts.OutputDir, path)
+			if err != nil {
+				return fmt.Errorf("encryptdir.Walker.decryptPath: %w", err)
+			}
 		}
+		return nil
+	}
+
+	// temp files of either operation, left behind by a crash or still being written
+	if w.opts.namer().IsTemp(path) {
+		return nil
+	}
+
+	fullPath := filepath.Join(w.startPath, path)
+	// the default journal is in the first root
+	if w.journal.is(fullPath) {
+		return nil
+	}
 
-		fullPath := filepath.Join(startPath, path)
+	// finished by the run this one resumes, left alone without reading it
+	if w.journal.done(fullPath) {
+		w.log.Debugw("skipping file, decrypted before resuming", "path", fullPath)
+		return nil
+	}
+	// the journal and the report go by the path walked, not the target of a link
+	walkPath := fullPath
 
-		cipherFile, err := os.OpenFile(fullPath, os.O_RDONLY, info.Mode())
+	// a followed link is decrypted at its target, the link itself stays
+	if isSymlink(info) {
+		var err error
+		fullPath, info, err = followLink(fullPath)
+		// broken links have nothing to decrypt
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
 		if err != nil {
-			errChan <- fmt.Errorf("encryptdir.Walker.decryptWalk: os.OpenFile: %w", err)
-			return
+			return fmt.Errorf("encryptdir.Walker.decryptPath: %w", err)
 		}
-		defer cipherFile.Close()
+		w.stats.link()
+	}
 
-		sig := make([]byte, aes.SIGNATURE_SIZE)
-		_, err = cipherFile.Read(sig)
+	// like a link to a directory or a device
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	if !w.opts.sizeAllowed(info.Size()) {
+		w.log.Debugw("skipping file, size out of range", "path", fullPath, "bytes", info.Size())
+		return nil
+	}
+
+	// like encrypting, the other paths of a hard linked file link to the output of the first one
+	leader, first := w.links.claim(fullPath, info)
+	if !first {
+		linked, err := leader.follow(ctx, fullPath, w.opts.namer().TempName(fullPath, true))
 		if err != nil {
-			errChan <- fmt.Errorf("encryptdir.Walker.decryptWalk: cipherFile.Read: %w", err)
-			return
+			return fmt.Errorf("encryptdir.Walker.decryptPath: %w", err)
+		}
+		if linked {
+			err = w.journal.record(walkPath)
+			if err != nil {
+				return fmt.Errorf("encryptdir.Walker.decryptPath: %w", err)
+			}
+			w.log.Infow("decrypted file, linked to another path of it", "path", fullPath, "link", leader.path)
+			w.stats.done(walkPath)
+			return nil
 		}
+	}
+	defer leader.finish(false)
+
+	// held until the temp file is renamed, the deferred closes run first
+	w.files.acquire()
+	defer w.files.release()
+
+	// the file header is read once, it picks the key as well as telling if the file was written by encryptdir
+	cipherFile, err := os.OpenFile(fullPath, os.O_RDONLY, info.Mode())
+	if err != nil {
+		return fmt.Errorf("encryptdir.Walker.decryptPath: os.OpenFile: %w", err)
+	}
+	defer cipherFile.Close()
 
-		err = rsa.VerifySignature(&privKey.PublicKey, sig, key, crypto.MD5)
-		if err != nil { // means signature isnt valid, meaning decrypted
-			errChan <- nil
-			return
+	// version 1 files have no file header and start with the signature, they are only decrypted with `Options.LegacyFormat`
+	fileHeader, key, err := openEncrypted(cipherFile, &w.privKey.PublicKey, path, w.keyMap, w.opts, w.derived)
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		// plainly encrypted by encryptdir, but with a key that isnt there
+		if w.opts.StrictDecrypt && fileHeader != nil {
+			return fmt.Errorf("encryptdir.Walker.decryptPath: %w", err)
+		}
+		w.log.Debugw("skipping file, no key for its extension", "path", fullPath)
+		return nil
+	case errors.Is(err, ErrNotEncrypted):
+		if w.opts.StrictDecrypt {
+			return fmt.Errorf("encryptdir.Walker.decryptPath: %w", err)
 		}
+		return nil
+	case err != nil:
+		return fmt.Errorf("encryptdir.Walker.decryptPath: %w", err)
+	}
 
+	if w.opts.TrustKey != nil {
+		err = verifyDetached(w.opts.TrustKey, fullPath)
+		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.decryptPath: %w", err)
+		}
+	}
+
+	// the signature verified, so the file would be decrypted, the ciphertext isn't read or authenticated
+	if w.opts.DryRun {
+		w.log.Infow("dry run: would decrypt file", "path", fullPath, "bytes", info.Size())
+		w.stats.done(walkPath)
+		return nil
+	}
+
+	// big files are decrypted straight from `cipherFile` a chunk at a time instead of read into memory
+	stream := w.opts.streams(info.Size())
+
+	var plain []byte
+	if !stream {
 		cipher, err := io.ReadAll(cipherFile)
 		if err != nil {
-			errChan <- fmt.Errorf("encryptdir.Walker.decryptWalk: io.ReadAll: %w", err)
-			return
+			return fmt.Errorf("encryptdir.Walker.decryptPath: io.ReadAll: %w", err)
+		}
+
+		plain, err = openPayload(fileHeader, key, cipher)
+		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.decryptPath: %w", err)
+		}
+	}
+
+	// hashed as it is decrypted, nil unless `Options.HashContents` or `Options.Manifest` is set
+	h := newContentHash(w.opts.HashContents || w.opts.Manifest != nil)
+	if !stream {
+		h.Write(plain)
+		err = checkManifest(w.opts.Manifest, fullPath, h)
+		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.decryptPath: %w", err)
+		}
+	}
+
+	// a streamed file cant be read and truncated at the same time, so it always goes through the temp file
+	if w.opts.DirectWrite && !w.opts.KeepOriginal && len(w.opts.OutputDir) == 0 && !stream {
+		err = writeDirect(fullPath, plain)
+		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.decryptPath: %w", err)
+		}
+
+		if fileHeader != nil || w.opts.OutputFileMode != 0 {
+			err = os.Chmod(fullPath, w.opts.outputMode(originalMode(info, fileHeader)))
+			if w.opts.OutputFileMode == 0 {
+				err = w.restored(err, fullPath)
+			}
+			if err != nil {
+				return fmt.Errorf("encryptdir.Walker.decryptPath: os.Chmod: %w", err)
+			}
 		}
 
-		plain, err := aes.Decrypt(key, cipher)
+		if w.opts.PreserveMetadata {
+			err = w.restored(copyMetadata(info, fullPath), fullPath)
+			if err != nil {
+				return fmt.Errorf("encryptdir.Walker.decryptPath: %w", err)
+			}
+		}
+		leader.finish(true)
+		err = w.journal.record(walkPath)
 		if err != nil {
-			errChan <- fmt.Errorf("encryptdir.Walker.decryptWalk: aes.Decrypt: %w", err)
-			return
+			return fmt.Errorf("encryptdir.Walker.decryptPath: %w", err)
 		}
+		w.log.Infow("decrypted file", "path", fullPath, "bytes", info.Size())
+		w.stats.hashed(walkPath, h)
+		w.stats.done(walkPath)
+		return nil
+	}
 
-		decFile, err := os.OpenFile(fullPath+".dec", os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode())
+	// the original is replaced unless there is an output dir to mirror the tree into
+	outPath := w.opts.outputPath(fullPath, path)
+	if len(w.opts.OutputDir) > 0 {
+		err = mirrorDirs(w.startPath, w.opts.OutputDir, filepath.Dir(path))
 		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.decryptPath: %w", err)
+		}
+	}
+
+	// the kept original, or the output of an earlier run, is already where the output goes
+	if w.opts.KeepOriginal && len(w.opts.OutputDir) == 0 {
+		_, err = os.Lstat(w.opts.keptPath(fullPath, true))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("encryptdir.Walker.decryptPath: os.Lstat: %w", err)
+		}
+		if err == nil {
+			switch w.opts.DecSibling {
+			case SiblingOverwrite:
+			case SiblingError:
+				return fmt.Errorf("encryptdir.Walker.decryptPath: path = %q: %w", w.opts.keptPath(fullPath, true), ErrDecSiblingExists)
+			default:
+				w.log.Debugw("skipping file, its output is already there", "path", fullPath)
+				return nil
+			}
+		}
+	}
+
+	tmpPath := w.opts.namer().TempName(outPath, true)
+	// read and written, it is copied through this one descriptor
+	decFile, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, w.opts.outputMode(originalMode(info, fileHeader)))
+	if err != nil && errors.Is(err, os.ErrExist) {
+		switch w.opts.DecSibling {
+		case SiblingOverwrite:
+			err = os.Remove(tmpPath)
+			if err != nil {
+				return fmt.Errorf("encryptdir.Walker.decryptPath: os.Remove: %w", err)
+			}
+			decFile, err = os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, w.opts.outputMode(originalMode(info, fileHeader)))
+		case SiblingError:
+			return fmt.Errorf("encryptdir.Walker.decryptPath: %w", ErrDecSiblingExists)
+		default:
 			// if `.dec` file already exists, another goroutine is touchine
 			// so move on
-			if errors.Is(err, os.ErrExist) {
-				errChan <- nil
-				return
-			}
+			return nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("encryptdir.Walker.decryptPath: os.OpenFile: %w", err)
+	}
+	defer decFile.Close()
+
+	// held until the temp file is renamed or removed, so `Cleanup` leaves it alone
+	lockTemp(decFile)
+
+	// the temp file is only kept once it is the output, any early return removes it so no empty or partial `.dec` is left behind
+	keepTmp := false
+	defer func() {
+		if !keepTmp {
+			decFile.Close()
+			os.Remove(tmpPath)
+		}
+	}()
 
-			errChan <- fmt.Errorf("encryptdir.Walker.decryptWalk: os.OpenFile: %w", err)
-			return
+	if stream {
+		var dst io.Writer = decFile
+		if h != nil {
+			dst = io.MultiWriter(decFile, h)
 		}
-		defer decFile.Close()
 
+		// every chunk is authenticated before it is written, a modified one fails and the temp file is removed
+		err = decryptPayload(cipherFile, info.Size(), fileHeader, key, dst, w.opts.streamChunkSize())
+		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.decryptPath: %w", err)
+		}
+
+		// only known once all of it is written, a mismatch removes the temp file and leaves the original
+		err = checkManifest(w.opts.Manifest, fullPath, h)
+		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.decryptPath: %w", err)
+		}
+	} else {
 		_, err = decFile.Write(plain)
 		if err != nil {
-			errChan <- fmt.Errorf("encryptdir.Walker.decryptWalk:  decFile.Write: %w", err)
-			return
+			return fmt.Errorf("encryptdir.Walker.decryptPath:  decFile.Write: %w", err)
 		}
+	}
+	// all of it is read, so only the temp file is open from here on
+	cipherFile.Close()
 
-		err = os.Rename(fullPath+".dec", fullPath)
+	if w.opts.PreserveXattrs {
+		err = copyXattrs(fullPath, tmpPath)
 		if err != nil {
-			errChan <- fmt.Errorf("encryptdir.Walker.decryptWalk:  os.Rename: %w", err)
-			return
+			return fmt.Errorf("encryptdir.Walker.decryptPath: %w", err)
+		}
+	}
+
+	// the mode the original had before it was encrypted, or `Options.OutputFileMode`
+	if fileHeader != nil || w.opts.OutputFileMode != 0 {
+		err = decFile.Chmod(w.opts.outputMode(originalMode(info, fileHeader)))
+		if w.opts.OutputFileMode == 0 {
+			err = w.restored(err, tmpPath)
+		}
+		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.decryptPath: decFile.Chmod: %w", err)
+		}
+	}
+
+	// rename keeps the times and owner of the temp file, so they are set on it
+	if w.opts.PreserveMetadata {
+		err = w.restored(copyMetadata(info, tmpPath), tmpPath)
+		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.decryptPath: %w", err)
+		}
+	}
+
+	// canceled while writing, dont replace the original with it
+	if ctxErr(ctx) != nil {
+		return fmt.Errorf("encryptdir.Walker.decryptPath: %w", ctxErr(ctx))
+	}
+
+	// the output goes next to the original, which stays as is
+	if w.opts.KeepOriginal && len(w.opts.OutputDir) == 0 {
+		err = w.syncer.finalize(decFile, w.opts.keptPath(fullPath, true))
+		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.decryptPath: %w", err)
+		}
+		keepTmp = true
+		leader.finish(true)
+
+		err = w.journal.record(walkPath)
+		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.decryptPath: %w", err)
+		}
+		w.log.Infow("decrypted file", "path", fullPath, "bytes", info.Size())
+		w.stats.hashed(walkPath, h)
+		w.stats.done(walkPath)
+		return nil
+	}
+
+	err = w.syncer.finalize(decFile, outPath)
+	if err != nil {
+		return fmt.Errorf("encryptdir.Walker.decryptPath: %w", err)
+	}
+	keepTmp = true
+	leader.finish(true)
+
+	err = w.journal.record(walkPath)
+	if err != nil {
+		return fmt.Errorf("encryptdir.Walker.decryptPath: %w", err)
+	}
+	w.log.Infow("decrypted file", "path", fullPath, "bytes", info.Size())
+	w.stats.hashed(walkPath, h)
+	w.stats.done(walkPath)
+	return nil
+}
+
+// encryptdir.openEncrypted: reads the banner, file header, and signature of the file `in` at `path`, and finds the key it was encrypted with, `in` is left at the payload
+// the key is the one for the extension the file header recorded, or for `path`, derived again from the salt it recorded with `Options.Passphrases`,
+// or else the first of `Options.Keyring` the signature verifies with, files without a file header are only read with `Options.LegacyFormat`
+// returns: file header, nil for a file without one, and key, or error wrapping `ErrKeyNotFound` if there is no key for the file or `ErrNotEncrypted` if no key verifies,
+// `ErrKeyFingerprint` instead if the file recorded a key fingerprint none of the keys has, `ErrExpired` with the key if it is past its `Options.ExpiresAt`,
+// the file header is returned with either, `ErrWeakCrypto` if it records md5 with `Options.StrictCrypto`, `ErrUnsignedHeader` if its signature is of the key alone
+// with `Options.RequireSignedHeader`, or `ErrInvalidHeader` if the file has the magic and its file header doesnt parse
+func openEncrypted(in io.ReadSeeker, pubKey *gorsa.PublicKey, path string, keyMap map[string][]byte, opts Options, derived *derivedKeys) (*FileHeader, []byte, error) {
+	err := skipBanner(in, opts.bannerLine())
+	if err != nil {
+		return nil, nil, fmt.Errorf("encryptdir.openEncrypted: %w", err)
+	}
+
+	fileHeader, err := readFileHeader(in)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encryptdir.openEncrypted: %w", err)
+	}
+
+	// nothing the file header says is trusted until the signature verifies with it, and with `SignsHeader` every field of it is part of what verifies,
+	// before that its extension and salt only pick the key to try and its fingerprint the keys not to, a wrong one fails the signature
+	// a renamed file is still decrypted with the key for the extension its file header recorded
+	key, ok := lookupKey(keyMap, path)
+	if fileHeader != nil && len(fileHeader.Ext) > 0 {
+		if k, found := keyMap[fileHeader.Ext]; found {
+			key, ok = k, true
+		}
+	}
+	if !ok {
+		ext := normalizeExt(path)
+		if fileHeader != nil {
+			ext = fileHeader.Ext
+		}
+		return fileHeader, nil, fmt.Errorf("encryptdir.openEncrypted: extension = %q: %w", ext, ErrKeyNotFound)
+	}
+
+	// files without the magic werent written by encryptdir
+	if fileHeader == nil && (!opts.LegacyFormat || opts.RequireSignedHeader) {
+		return nil, nil, fmt.Errorf("encryptdir.openEncrypted: %w", ErrNotEncrypted)
+	}
+	// refused before any key is derived or tried
+	err = checkSignedHeader(fileHeader, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encryptdir.openEncrypted: path = %q: %w", path, err)
+	}
+	if fileHeader != nil && fileHeader.KDF != nil {
+		// derived again from the passphrase with the salt the file was encrypted with, the run's own salt is likely another one
+		ext := fileHeader.Ext
+		if len(ext) == 0 {
+			ext = normalizeExt(path)
+		}
+		k, ok, err := derived.key(ext, *fileHeader.KDF)
+		if err != nil {
+			return fileHeader, nil, fmt.Errorf("encryptdir.openEncrypted: %w", err)
+		}
+		if ok {
+			key = k
 		}
+	}
+
+	// the signature is as long as the RSA modulus, 256 bytes for the 2048 bit keys `SignatureSize` assumes
+	sig := make([]byte, signatureSize(pubKey))
+	_, err = io.ReadFull(in, sig)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return fileHeader, nil, fmt.Errorf("encryptdir.openEncrypted: io.ReadFull: %w", err)
+	}
+	// too short to hold a signature, so not encrypted
+	if err != nil {
+		return fileHeader, nil, fmt.Errorf("encryptdir.openEncrypted: %w", ErrNotEncrypted)
+	}
+
+	// a key without the fingerprint the file recorded isnt even tried
+	if matchesKeyID(fileHeader, key) {
+		err = verifyKey(pubKey, sig, key, fileHeader, opts)
+		if err == nil {
+			return fileHeader, key, openExpiry(fileHeader, path, opts)
+		}
+		// signed with md5, which strict mode refuses whatever the key
+		if errors.Is(err, ErrWeakCrypto) {
+			return fileHeader, nil, fmt.Errorf("encryptdir.openEncrypted: path = %q: %w", path, err)
+		}
+	}
+	// maybe it was encrypted with an older key
+	for _, k := range opts.Keyring {
+		if matchesKeyID(fileHeader, k) && verifyKey(pubKey, sig, k, fileHeader, opts) == nil {
+			return fileHeader, k, openExpiry(fileHeader, path, opts)
+		}
+	}
+	// the fingerprint says which key it needs, rather than the file looking like it isnt encrypted
+	if fileHeader != nil && fileHeader.KeyID != nil {
+		return fileHeader, nil, fmt.Errorf("encryptdir.openEncrypted: path = %q, key fingerprint = %x: %w", path, fileHeader.KeyID, ErrKeyFingerprint)
+	}
+	return fileHeader, nil, fmt.Errorf("encryptdir.openEncrypted: %w", ErrNotEncrypted)
+}
 
-		errChan <- nil
-	}(w.startPath, path, info, w.privKey, w.keyMap, errC)
+// encryptdir.openExpiry: `checkExpiry` for the file at `path` `openEncrypted` found the key of
+// returns: error wrapping `ErrExpired`
+func openExpiry(header *FileHeader, path string, opts Options) error {
+	err := checkExpiry(header, opts)
+	if err != nil {
+		return fmt.Errorf("encryptdir.openEncrypted: path = %q: %w", path, err)
+	}
+	return nil
+}
+
+// encryptdir.decryptPayload: decrypts the payload of `in`, from its offset to the end of the `size` byte file, with the `header` and `key` `openEncrypted` found, into `w`
+// version 2 payloads are streamed and every chunk is authenticated before it is written, version 1 AES-CTR ones are decrypted `chunkSize` bytes at a time
+// a file recording an integrity digest is checked against it once all of it is written
+// returns: error wrapping `ErrDecryptFailed`, and `aes.ErrAuthFailed` if the file was modified or `ErrIntegrityMismatch` if its plaintext doesnt match its digest
+func decryptPayload(in io.ReadSeeker, size int64, header *FileHeader, key []byte, w io.Writer, chunkSize int) error {
+	offset, err := in.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("encryptdir.decryptPayload: in.Seek: %w", err)
+	}
+
+	// the digest is of the plaintext once it is gunzipped again
+	// and with its BOM back in front, which was stripped after it was hashed
+	mac := headerIntegrity(header, key)
+	out := integrityWriter(mac, w)
+	err = writeBOM(header, out)
+	if err != nil {
+		return fmt.Errorf("encryptdir.decryptPayload: %w", err)
+	}
+	dst, finish := decompressWriter(header, out)
+	if isGCM(header) {
+		err = aes.DecryptGCMStream(key, in, size-offset, dst)
+	} else {
+		err = aes.DecryptStream(key, in, size-offset, dst, chunkSize)
+	}
+	err = finish(err)
+	if err == nil {
+		err = checkIntegrity(mac, header)
+	}
+	if err != nil {
+		return fmt.Errorf("encryptdir.decryptPayload: %w: %w", ErrDecryptFailed, err)
+	}
+	return nil
+}
+
+// encryptdir.DecryptFileToBytes: decrypts the file at `path` with `key` in memory, nothing is written to disk
+// returns: plaintext, or error wrapping `ErrNotEncrypted` if the file isn't encrypted with `key`
+func DecryptFileToBytes(privKey *gorsa.PrivateKey, key []byte, path string) ([]byte, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.DecryptFileToBytes: os.ReadFile: %w", err)
+	}
+
+	err = checkFileHeader(contents)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.DecryptFileToBytes: path = %q: %w", path, err)
+	}
+	header, sig, payload := splitSignature(&privKey.PublicKey, contents, nil)
+	if sig == nil {
+		return nil, fmt.Errorf("encryptdir.DecryptFileToBytes: path = %q: %w", path, ErrNotEncrypted)
+	}
+
+	err = verifyKey(&privKey.PublicKey, sig, key, header, Options{})
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.DecryptFileToBytes: path = %q: %w", path, ErrNotEncrypted)
+	}
+
+	plain, err := openPayload(header, key, payload)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.DecryptFileToBytes: %w", err)
+	}
+	return plain, nil
+}
+
+// encryptdir.DecryptTo: decrypts the file at `src` with `key` into `w`, nothing is written to disk, like for piping a file somewhere
+// version 2 files are streamed and every chunk is authenticated before it is written, so a modified file fails part way with the chunks before it already in `w`
+// version 1 AES-CTR files are decrypted a chunk at a time too, nothing about them is authenticated but the signature
+// files with a banner aren't supported, `VerifyDecryptable` takes the options of a run for those
+// returns: error wrapping `ErrNotEncrypted` if `src` isn't encrypted with `key`, `ErrInvalidHeader` if its file header doesnt parse,
+// or `ErrDecryptFailed` and `aes.ErrAuthFailed` if it was modified
+func DecryptTo(privKey *gorsa.PrivateKey, key []byte, src string, w io.Writer) error {
+	// the key is used whatever the extension of `src`, and files without a file header are decrypted like `DecryptFileToBytes` does
+	err := decryptTo(privKey, map[string][]byte{FallbackExt: key}, src, w, Options{LegacyFormat: true}, nil)
+	if err != nil {
+		return fmt.Errorf("encryptdir.DecryptTo: %w", err)
+	}
+	return nil
+}
+
+// encryptdir.decryptTo: decrypts the file at `src` into `w` like `Walker.decryptPath` would, with the key of `keyMap` or `opts` it was encrypted with
+// returns: error wrapping `ErrNotEncrypted` or `ErrKeyNotFound` like `openEncrypted`, or `ErrDecryptFailed` like `decryptPayload`
+func decryptTo(privKey *gorsa.PrivateKey, keyMap map[string][]byte, src string, w io.Writer, opts Options, derived *derivedKeys) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("encryptdir.decryptTo: os.Open: %w", err)
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("encryptdir.decryptTo: in.Stat: %w", err)
+	}
+
+	header, key, err := openEncrypted(in, &privKey.PublicKey, src, keyMap, opts, derived)
+	if err != nil {
+		return fmt.Errorf("encryptdir.decryptTo: path = %q: %w", src, err)
+	}
+
+	if opts.TrustKey != nil {
+		err = verifyDetached(opts.TrustKey, src)
+		if err != nil {
+			return fmt.Errorf("encryptdir.decryptTo: %w", err)
+		}
+	}
 
-	err = <-errC
-	close(errC)
+	err = decryptPayload(in, info.Size(), header, key, w, opts.streamChunkSize())
 	if err != nil {
-		return fmt.Errorf("encryptdir.Walker.walk: path = %q: %w", path, err)
+		return fmt.Errorf("encryptdir.decryptTo: path = %q: %w", src, err)
 	}
 	return nil
 }
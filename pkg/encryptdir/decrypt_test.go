@@ -0,0 +1,286 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/aes"
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestRoundTripKeySizes(t *testing.T) {
+	for _, bits := range keySizes {
+		t.Run(fmt.Sprint(bits), func(t *testing.T) {
+			privKey := testutil.NewPrivateKeyBits(t, bits)
+			keyMap := testutil.NewKeyMap("txt", "sql")
+			spec := map[string][]byte{
+				"a.txt":     []byte("hello"),
+				"sub/b.sql": []byte("select 1;"),
+				"c.md":      []byte("left alone"),
+				"empty.txt": {},
+			}
+			dir, _ := testutil.BuildTree(t, spec)
+
+			// every file is streamed too, the signature is read from the file instead of from memory then
+			for _, opts := range []Options{{}, {StreamThreshold: -1}} {
+				_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+				if err != nil {
+					t.Fatalf("EncryptWithOptions: %v", err)
+				}
+
+				for _, rel := range []string{"a.txt", "sub/b.sql", "empty.txt"} {
+					path := filepath.Join(dir, filepath.FromSlash(rel))
+					contents, err := os.ReadFile(path)
+					if err != nil {
+						t.Fatalf("os.ReadFile: %v", err)
+					}
+					if want := FileHeaderSize + bits/8; len(contents) < want || !bytes.HasPrefix(contents, []byte(FileMagic)) {
+						t.Errorf("path = %q: %d bytes, want the magic and at least %d", rel, len(contents), want)
+					}
+
+					header, err := ReadHeaderWithKey(&privKey.PublicKey, path)
+					if err != nil {
+						t.Fatalf("ReadHeaderWithKey: %v", err)
+					}
+					if len(header.Signature) != bits/8 || header.PlaintextSize != uint64(len(spec[rel])) {
+						t.Errorf("path = %q: signature = %d bytes, plaintext size = %d, want %d and %d", rel, len(header.Signature), header.PlaintextSize, bits/8, len(spec[rel]))
+					}
+
+					plain, err := DecryptFileToBytes(privKey, keyMap[normalizeExt(rel)], path)
+					if err != nil {
+						t.Fatalf("DecryptFileToBytes: %v", err)
+					}
+					if !bytes.Equal(plain, spec[rel]) {
+						t.Errorf("path = %q: DecryptFileToBytes = %q, want %q", rel, plain, spec[rel])
+					}
+				}
+
+				_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+				if err != nil {
+					t.Fatalf("DecryptWithOptions: %v", err)
+				}
+				assertTree(t, dir, spec)
+			}
+		})
+	}
+}
+
+func TestDecryptWrongKeySize(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	err := Encrypt(nil, testutil.NewPrivateKeyBits(t, 4096), keyMap, []string{dir})
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	encrypted := readTree(t, dir)
+
+	// the signature of a 2048 bit key is a prefix of the 4096 bit one, it must not verify
+	err = Decrypt(nil, testutil.NewPrivateKey(t), keyMap, []string{dir})
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	assertTree(t, dir, encrypted)
+}
+
+func TestDecryptFileToBytes(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "plain.txt": []byte("never encrypted")}
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": spec["a.txt"]})
+
+	err := Encrypt(nil, privKey, keyMap, []string{dir})
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "plain.txt"), spec["plain.txt"], 0644)
+	if err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	onDisk := readTree(t, dir)
+
+	plain, err := DecryptFileToBytes(privKey, keyMap["txt"], filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatalf("DecryptFileToBytes: %v", err)
+	}
+	if !bytes.Equal(plain, spec["a.txt"]) {
+		t.Errorf("DecryptFileToBytes = %q, want %q", plain, spec["a.txt"])
+	}
+
+	_, err = DecryptFileToBytes(privKey, keyMap["txt"], filepath.Join(dir, "plain.txt"))
+	if !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("DecryptFileToBytes of a plaintext file: err = %v, want ErrNotEncrypted", err)
+	}
+
+	_, err = DecryptFileToBytes(privKey, testutil.NewTestKey("other"), filepath.Join(dir, "a.txt"))
+	if !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("DecryptFileToBytes with another key: err = %v, want ErrNotEncrypted", err)
+	}
+
+	// nothing is written next to or over the files
+	assertTree(t, dir, onDisk)
+}
+
+func TestDecryptTo(t *testing.T) {
+	for _, bits := range keySizes {
+		t.Run(fmt.Sprint(bits), func(t *testing.T) {
+			privKey := testutil.NewPrivateKeyBits(t, bits)
+			keyMap := testutil.NewKeyMap("txt")
+			plain := bytes.Repeat([]byte("plaintext "), 10000)
+			dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": plain, "b.txt": []byte("tampered")})
+			// a chunk smaller than the file, so it is decrypted a chunk at a time
+			_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{StreamThreshold: -1, StreamChunkSize: 4096})
+			if err != nil {
+				t.Fatalf("EncryptWithOptions: %v", err)
+			}
+			encrypted := readTree(t, dir)
+
+			var out bytes.Buffer
+			err = DecryptTo(privKey, keyMap["txt"], filepath.Join(dir, "a.txt"), &out)
+			if err != nil {
+				t.Fatalf("DecryptTo: %v", err)
+			}
+			if !bytes.Equal(out.Bytes(), plain) {
+				t.Error("DecryptTo output isnt the original")
+			}
+			// nothing is written next to it
+			assertTree(t, dir, encrypted)
+
+			tampered := filepath.Join(dir, "b.txt")
+			contents := encrypted["b.txt"]
+			contents[len(contents)-1] ^= 1
+			err = os.WriteFile(tampered, contents, 0600)
+			if err != nil {
+				t.Fatalf("os.WriteFile: %v", err)
+			}
+			err = DecryptTo(privKey, keyMap["txt"], tampered, io.Discard)
+			if !errors.Is(err, ErrDecryptFailed) || !errors.Is(err, aes.ErrAuthFailed) {
+				t.Errorf("DecryptTo of a tampered file: error = %v, want `ErrDecryptFailed` and `aes.ErrAuthFailed`", err)
+			}
+
+			err = DecryptTo(privKey, testutil.NewTestKey("other"), filepath.Join(dir, "a.txt"), io.Discard)
+			if !errors.Is(err, ErrNotEncrypted) {
+				t.Errorf("DecryptTo with another key: error = %v, want `ErrNotEncrypted`", err)
+			}
+		})
+	}
+}
+
+// countingWriter: discards what is written to it, counting the bytes
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+func TestDecryptToLarge(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("bin")
+	size := int64(64 << 20)
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.bin": nil})
+	path := filepath.Join(dir, "a.bin")
+	// sparse, so the plaintext is never in memory either
+	err := os.Truncate(path, size)
+	if err != nil {
+		t.Fatalf("os.Truncate: %v", err)
+	}
+
+	_, err = EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{StreamThreshold: -1})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+
+	// each chunk is authenticated and written before the next is read, the file is never held whole
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	var out countingWriter
+	err = DecryptTo(privKey, keyMap["bin"], path, &out)
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatalf("DecryptTo: %v", err)
+	}
+	if out.n != size {
+		t.Errorf("DecryptTo wrote %d bytes, want %d", out.n, size)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 8<<20 {
+		t.Errorf("DecryptTo allocated %d bytes for a %d byte file, want a few chunks", alloc, size)
+	}
+}
+
+func TestDecryptLeavesNoTempFiles(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{
+		"a.txt":     []byte("hello"),
+		"bad.txt":   []byte("corrupted below"),
+		"b.md":      []byte("no key"),
+		"sub/c.sql": []byte("no key either"),
+		"noext":     []byte("no extension"),
+	}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	// fails after its temp file is created
+	tamper(t, filepath.Join(dir, "bad.txt"))
+	bad := readTree(t, dir)["bad.txt"]
+
+	_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err == nil {
+		t.Fatalf("DecryptWithOptions: err = nil, want the error of bad.txt")
+	}
+
+	// files without a key are skipped before anything is created, not a single `.dec` is left
+	want := make(map[string][]byte)
+	for rel, contents := range spec {
+		want[rel] = contents
+	}
+	want["bad.txt"] = bad
+	assertTree(t, dir, want)
+}
+
+func TestDecryptTampered(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	plain := bytes.Repeat([]byte("attack at dawn "), 500)
+
+	for name, opts := range map[string]Options{"in memory": {}, "streamed": {StreamThreshold: -1, StreamChunkSize: 1024}} {
+		t.Run(name, func(t *testing.T) {
+			dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": plain})
+			_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+			if err != nil {
+				t.Fatalf("EncryptWithOptions: %v", err)
+			}
+
+			// a byte of the ciphertext in the middle of the file, past the headers and signature
+			path := filepath.Join(dir, "a.txt")
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("os.Stat: %v", err)
+			}
+			flipByte(t, path, int(info.Size()/2), 0x80)
+			tampered := readTree(t, dir)["a.txt"]
+
+			_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+			if !errors.Is(err, aes.ErrAuthFailed) {
+				t.Fatalf("DecryptWithOptions: err = %v, want aes.ErrAuthFailed", err)
+			}
+			// no garbage written over it
+			assertTree(t, dir, map[string][]byte{"a.txt": tampered})
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package encryptdir
+
+import (
+	"context"
+	"crypto"
+	gorsa "crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/prairir/encryptdir/pkg/rsa"
+	"go.uber.org/zap"
+)
+
+// suffix of detached signature files
+const DetachedSigSuffix = ".sig"
+
+// sentinel error used for when a detached signature is missing or doesn't verify
+var ErrDetachedSig = errors.New("detached signature not valid")
+
+// encryptdir.DecryptWithDetachedSig: decrypts `directories` like `decryptDirectories`
+// but every encrypted file must have a detached signature, made by `SignDetached` with the private half of `trustPub`, or it is not decrypted
+// returns: error
+func DecryptWithDetachedSig(log *zap.SugaredLogger,
+	trustPub *gorsa.PublicKey,
+	privKey *gorsa.PrivateKey,
+	keyMap map[string][]byte,
+	directories []string,
+) error {
+	err := decryptDirectories(context.Background(), log, privKey, keyMap, directories, Options{TrustKey: trustPub})
+	if err != nil {
+		return fmt.Errorf("encryptdir.DecryptWithDetachedSig: %w", err)
+	}
+	return nil
+}
+
+// encryptdir.SignDetached: signs the contents of the file at `path` with `signKey` and writes the signature to `path` + `DetachedSigSuffix`
+// returns: error
+func SignDetached(signKey *gorsa.PrivateKey, path string) error {
+	payload, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("encryptdir.SignDetached: os.ReadFile: %w", err)
+	}
+
+	sig, err := rsa.CreateSignature(signKey, payload, crypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("encryptdir.SignDetached: rsa.CreateSignature: %w", err)
+	}
+
+	err = writeNewFile(path+DetachedSigSuffix, sig, 0644)
+	if err != nil {
+		return fmt.Errorf("encryptdir.SignDetached: %w", err)
+	}
+	return nil
+}
+
+// encryptdir.verifyDetached: verifies the file at `path` against its detached signature with `trustPub`
+// returns: error wrapping `ErrDetachedSig` if the signature is missing or invalid
+func verifyDetached(trustPub *gorsa.PublicKey, path string) error {
+	sig, err := os.ReadFile(path + DetachedSigSuffix)
+	if err != nil {
+		return fmt.Errorf("encryptdir.verifyDetached: %w: %s", ErrDetachedSig, err)
+	}
+
+	payload, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("encryptdir.verifyDetached: os.ReadFile: %w", err)
+	}
+
+	err = rsa.VerifySignature(trustPub, sig, payload, crypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("encryptdir.verifyDetached: %w: %s", ErrDetachedSig, err)
+	}
+	return nil
+}
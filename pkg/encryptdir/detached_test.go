@@ -0,0 +1,86 @@
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestDecryptWithDetachedSig(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	// the trust key is apart from the key the files are encrypted with
+	trustKey := testutil.NewPrivateKeyBits(t, 3072)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+
+	for name, tc := range map[string]struct {
+		// makes the signature of the encrypted file at `path`
+		sign func(t *testing.T, path string)
+		ok   bool
+	}{
+		"valid": {
+			sign: func(t *testing.T, path string) {
+				err := SignDetached(trustKey, path)
+				if err != nil {
+					t.Fatalf("SignDetached: %v", err)
+				}
+			},
+			ok: true,
+		},
+		"other signer": {
+			sign: func(t *testing.T, path string) {
+				err := SignDetached(privKey, path)
+				if err != nil {
+					t.Fatalf("SignDetached: %v", err)
+				}
+			},
+		},
+		"tampered": {
+			sign: func(t *testing.T, path string) {
+				err := SignDetached(trustKey, path)
+				if err != nil {
+					t.Fatalf("SignDetached: %v", err)
+				}
+				flipByte(t, path+DetachedSigSuffix, 0, 1)
+			},
+		},
+		"missing": {sign: func(*testing.T, string) {}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir, _ := testutil.BuildTree(t, spec)
+			_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+			if err != nil {
+				t.Fatalf("EncryptWithOptions: %v", err)
+			}
+			path := filepath.Join(dir, "a.txt")
+			tc.sign(t, path)
+			encrypted, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("os.ReadFile: %v", err)
+			}
+
+			err = DecryptWithDetachedSig(nil, &trustKey.PublicKey, privKey, keyMap, []string{dir})
+			if tc.ok {
+				if err != nil {
+					t.Fatalf("DecryptWithDetachedSig: %v", err)
+				}
+				if got := readTree(t, dir)["a.txt"]; string(got) != "hello" {
+					t.Errorf("a.txt = %q, want %q", got, "hello")
+				}
+				return
+			}
+
+			// aborted before anything is written, the file is still encrypted
+			if !errors.Is(err, ErrDetachedSig) {
+				t.Errorf("DecryptWithDetachedSig: err = %v, want %v", err, ErrDetachedSig)
+			}
+			if got := readTree(t, dir)["a.txt"]; string(got) != string(encrypted) {
+				t.Errorf("a.txt: changed though its signature didnt verify")
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+package encryptdir
+
+import (
+	"bufio"
+	"bytes"
+	gorsa "crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prairir/encryptdir/pkg/aes"
+)
+
+// encryptdir.normalizeExt: the extension of `path` the way `keyMap` keys are written, without the leading `.`
+// returns: extension, empty if `path` has none
+func normalizeExt(path string) string {
+	return strings.TrimPrefix(filepath.Ext(path), ".")
+}
+
+// sentinel error used for when a `keyMap` key starts with a `.`, it would never match
+var ErrDottedExt = errors.New("key map extension starts with a dot")
+
+// sentinel error used for when a `keyMap` has no keys, not even a `FallbackExt` one, so a walk with it would never do anything
+var ErrEmptyKeyMap = errors.New("key map is empty")
+
+// encryptdir.validateKeyMap: checks every key of `keyMap` is written like `normalizeExt` returns it, and every AES key is 16, 24, or 32 bytes
+// dotted keys are an error rather than normalized, so a key file is never silently rewritten
+// with `aes256` only 32 byte keys are allowed, for deployments that cant have AES-128 or AES-192
+// returns: error wrapping `ErrEmptyKeyMap` without a key, `ErrDottedExt` naming the first dotted key, or joined over every extension with a bad key,
+// wrapping `aes.ErrBadKeyLength`, or `ErrWeakCrypto` for one that isnt 32 bytes with `aes256`
+func validateKeyMap(keyMap map[string][]byte, aes256 bool) error {
+	if len(keyMap) == 0 {
+		return fmt.Errorf("encryptdir.validateKeyMap: %w", ErrEmptyKeyMap)
+	}
+
+	for ext := range keyMap {
+		if strings.HasPrefix(ext, ".") {
+			return fmt.Errorf("encryptdir.validateKeyMap: extension = %q, drop the leading dot and use %q: %w", ext, strings.TrimLeft(ext, "."), ErrDottedExt)
+		}
+	}
+
+	exts := make([]string, 0, len(keyMap))
+	for ext := range keyMap {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+
+	var errList []error
+	for _, ext := range exts {
+		n := len(keyMap[ext])
+		switch {
+		case n != 16 && n != 24 && n != 32:
+			errList = append(errList, fmt.Errorf("extension = %q, key = %d bytes, want 16, 24, or 32: %w", ext, n, aes.ErrBadKeyLength))
+		case aes256 && n != 32:
+			errList = append(errList, fmt.Errorf("extension = %q, key = %d bytes, want 32 for AES-256: %w", ext, n, ErrWeakCrypto))
+		}
+	}
+	if len(errList) > 0 {
+		return fmt.Errorf("encryptdir.validateKeyMap: %w", errors.Join(errList...))
+	}
+	return nil
+}
+
+// sentinel error used for when a file needs a key its extension has none for in the key map
+var ErrKeyNotFound = errors.New("no key for the file's extension")
+
+// extension of the `keyMap` key for files whose extension has none, files without an extension included
+// it covers every file of the tree, exclude files like `.sig` sidecars with `Options.Exclude` so they stay readable
+const FallbackExt = "*"
+
+// encryptdir.lookupKey: finds the AES key for the extension of `path`, or the `FallbackExt` key
+// returns: key and if it was found
+func lookupKey(keyMap map[string][]byte, path string) ([]byte, bool) {
+	key, _, ok := lookupKeyExt(keyMap, path)
+	return key, ok
+}
+
+// encryptdir.lookupKeyExt: like `lookupKey` but also returns the `keyMap` extension the key is under, `FallbackExt` for the fallback
+// returns: key, its extension, and if it was found
+func lookupKeyExt(keyMap map[string][]byte, path string) ([]byte, string, bool) {
+	ext := normalizeExt(path)
+	if len(ext) > 0 {
+		if key, ok := keyMap[ext]; ok {
+			return key, ext, true
+		}
+	}
+
+	key, ok := keyMap[FallbackExt]
+	if !ok {
+		return nil, "", false
+	}
+	return key, FallbackExt, true
+}
+
+// encryptdir.isEncrypted: checks if the file at `path` starts with the signature of `key`, after `banner` if it has one
+// files too short to hold a signature are not encrypted
+// returns: if the file is encrypted or error
+func isEncrypted(pubKey *gorsa.PublicKey, key []byte, banner []byte, path string) (bool, error) {
+	in, err := os.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return false, fmt.Errorf("encryptdir.isEncrypted: os.OpenFile: %w", err)
+	}
+	defer in.Close()
+
+	encrypted, err := hasSignature(in, pubKey, key, banner)
+	if err != nil {
+		return false, fmt.Errorf("encryptdir.isEncrypted: %w", err)
+	}
+	return encrypted, nil
+}
+
+// encryptdir.hasSignature: like `isEncrypted` for an open file, `in` is moved back to the start of the file after
+// returns: if the file is encrypted or error
+func hasSignature(in io.ReadSeeker, pubKey *gorsa.PublicKey, key []byte, banner []byte) (bool, error) {
+	header, sig, err := readSignature(in, pubKey, banner)
+	if err != nil {
+		return false, fmt.Errorf("encryptdir.hasSignature: %w", err)
+	}
+
+	if sig == nil {
+		return false, nil
+	}
+	return verifyKey(pubKey, sig, key, header, Options{}) == nil, nil
+}
+
+// encryptdir.readSignature: reads the file header and signature of the file, after `banner` if it has one, `in` is moved back to the start of the file after
+// the signature is `signatureSize` bytes, like `splitSignature` for a file that isnt read into memory
+// returns: header, nil for a file without one, and signature, nil if the file is too short to hold one, or error
+func readSignature(in io.ReadSeeker, pubKey *gorsa.PublicKey, banner []byte) (*FileHeader, []byte, error) {
+	err := skipBanner(in, banner)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encryptdir.readSignature: %w", err)
+	}
+
+	header, err := readFileHeader(in)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encryptdir.readSignature: %w", err)
+	}
+
+	sig := make([]byte, signatureSize(pubKey))
+	_, err = io.ReadFull(in, sig)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, nil, fmt.Errorf("encryptdir.readSignature: io.ReadFull: %w", err)
+	}
+	if err != nil {
+		sig = nil
+	}
+
+	_, err = in.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encryptdir.readSignature: in.Seek: %w", err)
+	}
+	return header, sig, nil
+}
+
+// encryptdir.signedByKnownKey: if `sig` is the signature of any key the file at `path` can already be encrypted with
+// that is `key`, the key for the extension its `header` recorded, the key derived again from the salt it recorded, or one of `Options.Keyring`
+// encrypting a file signed by any of them would wrap it a second time, so running encrypt again is a no-op
+// `sig` is nil for a file too short to hold a signature, which isnt encrypted
+// returns: if a key verifies or error
+func signedByKnownKey(pubKey *gorsa.PublicKey, sig []byte, key []byte, header *FileHeader, path string, keyMap map[string][]byte, opts Options, derived *derivedKeys) (bool, error) {
+	if sig == nil {
+		return false, nil
+	}
+
+	keys := [][]byte{key}
+	if header != nil {
+		if k, ok := keyMap[header.Ext]; ok {
+			keys = append(keys, k)
+		}
+
+		if header.KDF != nil {
+			ext := header.Ext
+			if len(ext) == 0 {
+				ext = normalizeExt(path)
+			}
+			k, ok, err := derived.key(ext, *header.KDF)
+			if err != nil {
+				return false, fmt.Errorf("encryptdir.signedByKnownKey: %w", err)
+			}
+			if ok {
+				keys = append(keys, k)
+			}
+		}
+	}
+	keys = append(keys, opts.Keyring...)
+
+	// a file signed with md5 is encrypted all the same, strict mode only refuses to decrypt it
+	detect := opts
+	detect.StrictCrypto = false
+	for _, k := range keys {
+		if verifyKey(pubKey, sig, k, header, detect) == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// encryptdir.plaintext: decrypts `contents` with `key` if it starts with the signature of `key`
+// returns: plaintext, or `contents` as is if it isn't encrypted, or error
+func plaintext(pubKey *gorsa.PublicKey, key []byte, contents []byte) ([]byte, error) {
+	header, sig, payload := splitSignature(pubKey, contents, nil)
+	if sig == nil {
+		return contents, nil
+	}
+
+	err := verifyKey(pubKey, sig, key, header, Options{})
+	if err != nil { // not encrypted
+		return contents, nil
+	}
+
+	plain, err := openPayload(header, key, payload)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.plaintext: %w", err)
+	}
+	return plain, nil
+}
+
+// encryptdir.signatureSize: how many bytes the signature of a file encrypted with `pubKey` takes up, as many as its modulus
+// that is `SignatureSize` for 2048 bit keys, the offsets of `format.go` after the signature are shifted by the difference for others
+func signatureSize(pubKey *gorsa.PublicKey) int {
+	return pubKey.Size()
+}
+
+// encryptdir.splitSignature: splits the contents of a file, after `banner` if it starts with it, into its file header, signature, and payload
+// the banner is only ever stripped here and in `skipBanner`, the signature is `signatureSize` bytes
+// returns: header, nil for a file without one, and signature and payload after it, both nil if the file is too short to hold a signature
+func splitSignature(pubKey *gorsa.PublicKey, contents []byte, banner []byte) (*FileHeader, []byte, []byte) {
+	header, rest := splitFileHeader(bytes.TrimPrefix(contents, banner))
+	n := signatureSize(pubKey)
+	if len(rest) < n {
+		return header, nil, nil
+	}
+	return header, rest[:n], rest[n:]
+}
+
+// encryptdir.skipBanner: moves `in` past `banner` if the file starts with it, otherwise back to the start of the file
+func skipBanner(in io.ReadSeeker, banner []byte) error {
+	if len(banner) == 0 {
+		return nil
+	}
+
+	prefix := make([]byte, len(banner))
+	_, err := io.ReadFull(in, prefix)
+	if err == nil && bytes.Equal(prefix, banner) {
+		return nil
+	}
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("encryptdir.skipBanner: io.ReadFull: %w", err)
+	}
+
+	_, err = in.Seek(0, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("encryptdir.skipBanner: in.Seek: %w", err)
+	}
+	return nil
+}
+
+// encryptdir.contentMatches: checks if the first `limit` bytes of `in` match `re` then moves `in` back to the start of the file
+// the content is streamed so large files aren't held in memory, `limit` <= 0 means `DefaultContentMatchLimit`
+// returns: if it matched or error
+func contentMatches(in io.ReadSeeker, re *regexp.Regexp, limit int64) (bool, error) {
+	if limit <= 0 {
+		limit = DefaultContentMatchLimit
+	}
+
+	matched := re.MatchReader(bufio.NewReader(io.LimitReader(in, limit)))
+
+	_, err := in.Seek(0, io.SeekStart)
+	if err != nil {
+		return false, fmt.Errorf("encryptdir.contentMatches: in.Seek: %w", err)
+	}
+	return matched, nil
+}
+
+// encryptdir.WalkCandidates: calls `fn` with the path of every regular file in `dirs` that has a key in `keyMap`
+// paths are streamed to `fn` one at a time so large trees are never held in memory
+// returning an error from `fn` stops the walk
+// returns: error, wrapping the error from `fn` if it stopped the walk
+func WalkCandidates(keyMap map[string][]byte, dirs []string, fn func(path string) error) error {
+	err := walkCandidates(keyMap, dirs, func(path string, _ os.FileInfo) error {
+		return fn(path)
+	})
+	if err != nil {
+		return fmt.Errorf("encryptdir.WalkCandidates: %w", err)
+	}
+	return nil
+}
+
+// encryptdir.walkCandidates: calls `fn` with the full path of every regular file in `directories` that has a key in `keyMap`
+// walks one directory at a time in lexical order, stops at the first error
+// returns: error
+func walkCandidates(keyMap map[string][]byte, directories []string, fn func(path string, info os.FileInfo) error) error {
+	for _, dir := range directories {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !d.Type().IsRegular() {
+				return nil
+			}
+
+			if _, ok := lookupKey(keyMap, path); !ok {
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return fmt.Errorf("d.Info: path = %q: %w", path, err)
+			}
+
+			return fn(path, info)
+		})
+		if err != nil {
+			return fmt.Errorf("encryptdir.walkCandidates: dir = %q: %w", dir, err)
+		}
+	}
+
+	return nil
+}
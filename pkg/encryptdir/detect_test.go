@@ -0,0 +1,211 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/prairir/encryptdir/pkg/aes"
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestSignatureSizeReaders(t *testing.T) {
+	for _, bits := range keySizes {
+		t.Run(fmt.Sprint(bits), func(t *testing.T) {
+			privKey := testutil.NewPrivateKeyBits(t, bits)
+			key := testutil.NewTestKey("txt")
+			keyMap := map[string][]byte{"txt": key}
+			plain := bytes.Repeat([]byte("0123456789"), 1000)
+			spec := map[string][]byte{"a.txt": plain, "sub/b.txt": []byte("b")}
+
+			t.Run("EncryptFile", func(t *testing.T) {
+				dir, _ := testutil.BuildTree(t, spec)
+				src := filepath.Join(dir, "a.txt")
+				dst := filepath.Join(dir, "a.txt.out")
+				err := EncryptFile(privKey, key, src, src)
+				if err != nil {
+					t.Fatalf("EncryptFile: %v", err)
+				}
+				err = EncryptFile(privKey, key, src, src)
+				if err == nil {
+					t.Fatal("EncryptFile of an encrypted file: error = nil, want `ErrAlreadyEncrypted`")
+				}
+
+				err = DecryptFile(privKey, key, src, dst)
+				if err != nil {
+					t.Fatalf("DecryptFile: %v", err)
+				}
+				got, err := os.ReadFile(dst)
+				if err != nil {
+					t.Fatalf("os.ReadFile: %v", err)
+				}
+				if !bytes.Equal(got, plain) {
+					t.Error("DecryptFile output isnt the original")
+				}
+
+				r, err := os.Open(src)
+				if err != nil {
+					t.Fatalf("os.Open: %v", err)
+				}
+				defer r.Close()
+				info, err := r.Stat()
+				if err != nil {
+					t.Fatalf("r.Stat: %v", err)
+				}
+				ra, err := NewRandomReader(privKey, key, r, info.Size())
+				if err != nil {
+					t.Fatalf("NewRandomReader: %v", err)
+				}
+				part := make([]byte, 100)
+				_, err = ra.ReadAt(part, 4321)
+				if err != nil && err != io.EOF {
+					t.Fatalf("ReadAt: %v", err)
+				}
+				if !bytes.Equal(part, plain[4321:4421]) {
+					t.Error("ReadAt isnt the original range")
+				}
+
+				var out bytes.Buffer
+				err = DecryptTo(privKey, key, src, &out)
+				if err != nil {
+					t.Fatalf("DecryptTo: %v", err)
+				}
+				if !bytes.Equal(out.Bytes(), plain) {
+					t.Error("DecryptTo output isnt the original")
+				}
+			})
+
+			t.Run("Blob", func(t *testing.T) {
+				dir, _ := testutil.BuildTree(t, spec)
+				var blob bytes.Buffer
+				err := EncryptBlob(privKey, key, dir, &blob)
+				if err != nil {
+					t.Fatalf("EncryptBlob: %v", err)
+				}
+				out := t.TempDir()
+				err = DecryptBlob(privKey, key, &blob, out)
+				if err != nil {
+					t.Fatalf("DecryptBlob: %v", err)
+				}
+				assertTree(t, out, spec)
+			})
+
+			t.Run("Backup", func(t *testing.T) {
+				dir, _ := testutil.BuildTree(t, spec)
+				var backup bytes.Buffer
+				err := BackupEncrypt(privKey, key, dir, &backup)
+				if err != nil {
+					t.Fatalf("BackupEncrypt: %v", err)
+				}
+				out := t.TempDir()
+				err = BackupDecrypt(privKey, key, &backup, out)
+				if err != nil {
+					t.Fatalf("BackupDecrypt: %v", err)
+				}
+				assertTree(t, out, spec)
+			})
+
+			t.Run("Sidecar", func(t *testing.T) {
+				dir, _ := testutil.BuildTree(t, spec)
+				err := Encrypt(nil, privKey, keyMap, []string{dir})
+				if err != nil {
+					t.Fatalf("Encrypt: %v", err)
+				}
+				encrypted := readTree(t, dir)
+				err = ConvertInPlaceToSidecar(privKey, keyMap, []string{dir})
+				if err != nil {
+					t.Fatalf("ConvertInPlaceToSidecar: %v", err)
+				}
+				assertTree(t, dir, map[string][]byte{
+					"a.txt":                     plain,
+					"a.txt" + SidecarSuffix:     encrypted["a.txt"],
+					"sub/b.txt":                 []byte("b"),
+					"sub/b.txt" + SidecarSuffix: encrypted["sub/b.txt"],
+				})
+			})
+
+			t.Run("FS", func(t *testing.T) {
+				out := t.TempDir()
+				fsys := fstest.MapFS{"a.txt": {Data: plain, Mode: 0600}}
+				err := EncryptFS(privKey, keyMap, fsys, out)
+				if err != nil {
+					t.Fatalf("EncryptFS: %v", err)
+				}
+
+				// an already encrypted file isnt copied to the output again
+				encrypted, err := os.ReadFile(filepath.Join(out, "a.txt"))
+				if err != nil {
+					t.Fatalf("os.ReadFile: %v", err)
+				}
+				again := t.TempDir()
+				err = EncryptFS(privKey, keyMap, fstest.MapFS{"a.txt": {Data: encrypted, Mode: 0600}}, again)
+				if err != nil {
+					t.Fatalf("EncryptFS: %v", err)
+				}
+				if _, err := os.Stat(filepath.Join(again, "a.txt")); !os.IsNotExist(err) {
+					t.Errorf("EncryptFS of an encrypted file: os.Stat error = %v, want not exist", err)
+				}
+
+				got, err := fs.ReadFile(DecryptingFS(out, privKey, keyMap), "a.txt")
+				if err != nil {
+					t.Fatalf("fs.ReadFile: %v", err)
+				}
+				if !bytes.Equal(got, plain) {
+					t.Error("DecryptingFS contents arent the original")
+				}
+			})
+		})
+	}
+}
+
+func TestKeyLengths(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+
+	for _, test := range []struct {
+		size    int
+		enforce bool
+		want    error
+	}{
+		{16, false, nil},
+		{24, false, nil},
+		{32, false, nil},
+		{20, false, aes.ErrBadKeyLength},
+		{16, true, ErrWeakCrypto},
+		{24, true, ErrWeakCrypto},
+		{32, true, nil},
+		{20, true, aes.ErrBadKeyLength},
+	} {
+		t.Run(fmt.Sprintf("%d bytes, enforce %t", test.size, test.enforce), func(t *testing.T) {
+			keyMap := map[string][]byte{"txt": bytes.Repeat([]byte{7}, test.size)}
+			dir, _ := testutil.BuildTree(t, spec)
+			opts := Options{EnforceAES256: test.enforce}
+
+			for _, decrypt := range []bool{false, true} {
+				name, run := "EncryptWithOptions", EncryptWithOptions
+				if decrypt {
+					name, run = "DecryptWithOptions", DecryptWithOptions
+				}
+
+				_, err := run(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+				if test.want == nil && err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				if test.want != nil && (!errors.Is(err, test.want) || !strings.Contains(err.Error(), fmt.Sprintf(`extension = "txt", key = %d bytes`, test.size))) {
+					t.Errorf("%s: err = %v, want %v naming the extension and length", name, err, test.want)
+				}
+			}
+
+			// a rejected key map runs nothing, an accepted one round trips
+			assertTree(t, dir, spec)
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package encryptdir
+
+import (
+	"bytes"
+	gorsa "crypto/rsa"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// DiffReport: differences between two trees, paths are relative to the tree roots
+type DiffReport struct {
+	// files in both trees whose plaintext differs
+	Mismatched []string
+	// files only in the first tree
+	Missing []string
+	// files only in the second tree
+	Extra []string
+}
+
+// encryptdir.DiffReport.Equal: if the trees had the same plaintext
+func (d DiffReport) Equal() bool {
+	return len(d.Mismatched) == 0 && len(d.Missing) == 0 && len(d.Extra) == 0
+}
+
+// encryptdir.Diff: compares the plaintext of every file in `dirA` and `dirB`, decrypting encrypted files in memory on the fly
+// meant for checking an encrypted tree against a plaintext copy, either tree can be the encrypted one
+// returns: report or error
+func Diff(privKey *gorsa.PrivateKey, keyMap map[string][]byte, dirA string, dirB string) (DiffReport, error) {
+	filesA, err := listFiles(dirA)
+	if err != nil {
+		return DiffReport{}, fmt.Errorf("encryptdir.Diff: %w", err)
+	}
+
+	filesB, err := listFiles(dirB)
+	if err != nil {
+		return DiffReport{}, fmt.Errorf("encryptdir.Diff: %w", err)
+	}
+
+	var report DiffReport
+	for rel := range filesA {
+		if !filesB[rel] {
+			report.Missing = append(report.Missing, rel)
+			continue
+		}
+
+		plainA, err := readPlaintext(privKey, keyMap, filepath.Join(dirA, rel))
+		if err != nil {
+			return DiffReport{}, fmt.Errorf("encryptdir.Diff: %w", err)
+		}
+
+		plainB, err := readPlaintext(privKey, keyMap, filepath.Join(dirB, rel))
+		if err != nil {
+			return DiffReport{}, fmt.Errorf("encryptdir.Diff: %w", err)
+		}
+
+		if !bytes.Equal(plainA, plainB) {
+			report.Mismatched = append(report.Mismatched, rel)
+		}
+	}
+
+	for rel := range filesB {
+		if !filesA[rel] {
+			report.Extra = append(report.Extra, rel)
+		}
+	}
+
+	sort.Strings(report.Mismatched)
+	sort.Strings(report.Missing)
+	sort.Strings(report.Extra)
+	return report, nil
+}
+
+// encryptdir.listFiles: set of regular files under `dir`, relative to `dir`
+func listFiles(dir string) (map[string]bool, error) {
+	files := make(map[string]bool)
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return fmt.Errorf("filepath.Rel: path = %q: %w", path, err)
+		}
+
+		files[rel] = true
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.listFiles: dir = %q: %w", dir, err)
+	}
+
+	return files, nil
+}
+
+// encryptdir.readPlaintext: reads the file at `path`, decrypting it if it is encrypted
+func readPlaintext(privKey *gorsa.PrivateKey, keyMap map[string][]byte, path string) ([]byte, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.readPlaintext: os.ReadFile: %w", err)
+	}
+
+	key, ok := lookupKey(keyMap, path)
+	if !ok {
+		return contents, nil
+	}
+
+	plain, err := plaintext(&privKey.PublicKey, key, contents)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.readPlaintext: path = %q: %w", path, err)
+	}
+	return plain, nil
+}
@@ -0,0 +1,70 @@
+package encryptdir
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestDiffEqual(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world"), "c.md": []byte("no key")}
+	encDir, _ := testutil.BuildTree(t, spec)
+	plainDir, _ := testutil.BuildTree(t, spec)
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{encDir}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+
+	// either tree can be the encrypted one
+	for _, dirs := range [][2]string{{encDir, plainDir}, {plainDir, encDir}} {
+		report, err := Diff(privKey, keyMap, dirs[0], dirs[1])
+		if err != nil {
+			t.Fatalf("Diff: %v", err)
+		}
+		if !report.Equal() {
+			t.Errorf("Diff(%q, %q) = %+v, want no differences", dirs[0], dirs[1], report)
+		}
+	}
+}
+
+func TestDiffMismatch(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	encDir, _ := testutil.BuildTree(t, map[string][]byte{
+		"a.txt":       []byte("hello"),
+		"sub/b.txt":   []byte("world"),
+		"missing.txt": []byte("only encrypted"),
+	})
+	plainDir, _ := testutil.BuildTree(t, map[string][]byte{
+		"a.txt":     []byte("hello"),
+		"sub/b.txt": []byte("w0rld"),
+		"extra.txt": []byte("only plaintext"),
+	})
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{encDir}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+
+	report, err := Diff(privKey, keyMap, encDir, plainDir)
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	want := DiffReport{
+		Mismatched: []string{filepath.Join("sub", "b.txt")},
+		Missing:    []string{"missing.txt"},
+		Extra:      []string{"extra.txt"},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("Diff = %+v, want %+v", report, want)
+	}
+	if report.Equal() {
+		t.Errorf("Diff.Equal() = true, want false")
+	}
+}
@@ -0,0 +1,66 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestDirectWrite(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{
+		"a.txt":     []byte("hello"),
+		"sub/b.txt": bytes.Repeat([]byte("world"), 1000),
+		"empty.txt": {},
+	}
+	dir, _ := testutil.BuildTree(t, spec)
+	opts := Options{DirectWrite: true}
+
+	path := filepath.Join(dir, "a.txt")
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("os.Stat: %v", err)
+	}
+
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if report.Processed != len(spec) {
+		t.Errorf("EncryptWithOptions: processed = %d, want %d", report.Processed, len(spec))
+	}
+
+	// written over in place, not replaced by a temp file
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("os.Stat: %v", err)
+	}
+	if !os.SameFile(before, after) {
+		t.Errorf("%s was replaced, want it written over in place", path)
+	}
+
+	encrypted := readTree(t, dir)
+	if len(encrypted) != len(spec) {
+		t.Errorf("tree has %d files after encrypting, want %d without temp files", len(encrypted), len(spec))
+	}
+	for name, plain := range spec {
+		got, err := decryptBytes(t, keyMap, filepath.Join(dir, name), Options{})
+		if err != nil {
+			t.Fatalf("decryptBytes(%q): %v", name, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("decryptBytes(%q) = %q, want %q", name, got, plain)
+		}
+	}
+
+	_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	assertTree(t, dir, spec)
+}
@@ -0,0 +1,79 @@
+package encryptdir
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+// snapshotTree: the contents and modification times of every entry under `dir`, dirs included
+func snapshotTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	snap := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		snap[path] = info.ModTime().Format(time.RFC3339Nano)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("filepath.WalkDir: %v", err)
+	}
+	return snap
+}
+
+func TestDryRun(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt", "keep")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world"), "c.md": []byte("no key")}
+	dir, _ := testutil.BuildTree(t, spec)
+	// no marker is written into it either
+	err := os.Mkdir(filepath.Join(dir, "empty"), 0755)
+	if err != nil {
+		t.Fatalf("os.Mkdir: %v", err)
+	}
+	opts := Options{DryRun: true, EmptyDirMarker: ".keep"}
+
+	for _, decrypt := range []bool{false, true} {
+		name, run := "EncryptWithOptions", EncryptWithOptions
+		if decrypt {
+			name, run = "DecryptWithOptions", DecryptWithOptions
+			// something to decrypt
+			_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+			if err != nil {
+				t.Fatalf("EncryptWithOptions: %v", err)
+			}
+		}
+		tree, snap := readTree(t, dir), snapshotTree(t, dir)
+
+		report, err := run(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		// what would change, without changing it
+		if report.Processed != 2 {
+			t.Errorf("%s: processed = %d, want the 2 files it would change", name, report.Processed)
+		}
+		assertTree(t, dir, tree)
+		got := snapshotTree(t, dir)
+		for path, mtime := range snap {
+			if got[path] != mtime {
+				t.Errorf("%s: path = %q: modified by a dry run", name, path)
+			}
+		}
+		if len(got) != len(snap) {
+			t.Errorf("%s: %d entries after a dry run, want %d", name, len(got), len(snap))
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/aes"
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestEmptyFiles(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+
+	for name, opts := range map[string]Options{"in memory": {}, "streamed": {StreamThreshold: -1}} {
+		t.Run(name, func(t *testing.T) {
+			spec := map[string][]byte{"empty.txt": {}, "sub/empty.txt": {}}
+			dir, _ := testutil.BuildTree(t, spec)
+
+			report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+			if err != nil || report.Processed != 2 {
+				t.Fatalf("EncryptWithOptions = processed %d, %v, want 2", report.Processed, err)
+			}
+
+			// a file header, signature, and a single empty chunk with its tag
+			contents, err := os.ReadFile(filepath.Join(dir, "empty.txt"))
+			if err != nil {
+				t.Fatalf("os.ReadFile: %v", err)
+			}
+			if !bytes.HasPrefix(contents, []byte(FileMagic)) || len(contents) != CiphertextOffset+aes.GCMTagSize {
+				t.Errorf("%d bytes, want %d with the magic", len(contents), CiphertextOffset+aes.GCMTagSize)
+			}
+			if size := binary.LittleEndian.Uint64(contents[PlaintextSizeOffset:]); size != 0 {
+				t.Errorf("plaintext size = %d, want 0", size)
+			}
+
+			// encrypted once, not again
+			report, err = EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+			if err != nil || report.Processed != 0 {
+				t.Errorf("EncryptWithOptions again = processed %d, %v, want 0", report.Processed, err)
+			}
+
+			report, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+			if err != nil || report.Processed != 2 {
+				t.Fatalf("DecryptWithOptions = processed %d, %v, want 2", report.Processed, err)
+			}
+			assertTree(t, dir, spec)
+		})
+	}
+}
+
+func TestEmptyFileWithoutChunk(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"empty.txt": {}})
+	path := filepath.Join(dir, "empty.txt")
+
+	err := Encrypt(nil, privKey, keyMap, []string{dir})
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	// the tag of the empty chunk cut off, which is corrupt rather than empty
+	err = os.Truncate(path, CiphertextOffset)
+	if err != nil {
+		t.Fatalf("os.Truncate: %v", err)
+	}
+
+	err = Decrypt(nil, privKey, keyMap, []string{dir})
+	if err == nil {
+		t.Fatalf("Decrypt: err = nil, want the file without its chunk to fail")
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil || len(contents) != CiphertextOffset {
+		t.Errorf("empty.txt: %d bytes, %v, want it left as it was", len(contents), err)
+	}
+}
@@ -1,158 +1,794 @@
 package encryptdir
 
 import (
-	"crypto"
+	"bytes"
+	"context"
 	gorsa "crypto/rsa"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"time"
 
-	"github.com/iafan/cwalk"
 	"github.com/prairir/encryptdir/pkg/aes"
-	"github.com/prairir/encryptdir/pkg/rsa"
 	"go.uber.org/zap"
 )
 
-func encryptDirectories(log *zap.SugaredLogger,
+// aes.EncryptGCM, swapped out by tests for one that seals the wrong plaintext like a bug in the cipher path
+var encryptGCM = aes.EncryptGCM
+
+func encryptDirectories(ctx context.Context,
+	log *zap.SugaredLogger,
 	privKey *gorsa.PrivateKey,
 	keyMap map[string][]byte,
 	directories []string,
+	opts Options,
 ) error {
+	// every file step is logged from the cwalk workers
+	if log == nil {
+		log = zap.NewNop().Sugar()
+	}
 
-	errC := make(chan error, 0)
+	err := opts.validate(privKey)
+	if err != nil {
+		return fmt.Errorf("encryptdir.encryptDirectories: %w", err)
+	}
 
-	for _, dir := range directories {
-		w := Walker{
-			privKey:   privKey,
-			keyMap:    keyMap,
-			startPath: dir,
-		}
-		go func(dir string) { errC <- cwalk.Walk(dir, w.encryptWalk) }(dir)
+	directories, err = dedupeDirs(directories)
+	if err != nil {
+		return fmt.Errorf("encryptdir.encryptDirectories: %w", err)
+	}
+
+	keyMap, derived, err := opts.deriveKeys(keyMap)
+	if err != nil {
+		return fmt.Errorf("encryptdir.encryptDirectories: %w", err)
 	}
 
-	// best way to handle the errors
-	// loop over directories, merge errors, return if errors exist
-	var errList cwalk.WalkerErrorList
-	for range directories {
-		err := <-errC
+	err = validateKeyMap(keyMap, opts.EnforceAES256)
+	if err != nil {
+		return fmt.Errorf("encryptdir.encryptDirectories: %w", err)
+	}
+
+	err = validateRules(opts.rules, keyMap)
+	if err != nil {
+		return fmt.Errorf("encryptdir.encryptDirectories: %w", err)
+	}
+
+	err = opts.filter().validate()
+	if err != nil {
+		return fmt.Errorf("encryptdir.encryptDirectories: %w", err)
+	}
 
-		var eList cwalk.WalkerErrorList
-		if errors.As(err, &cwalk.WalkerErrorList{}) {
-			errList.ErrorList = append(errList.ErrorList, eList.ErrorList...)
+	opts.protected, err = protectedSet(opts.ProtectedPaths)
+	if err != nil {
+		return fmt.Errorf("encryptdir.encryptDirectories: %w", err)
+	}
+
+	err = opts.checkOutputDir(directories)
+	if err != nil {
+		return fmt.Errorf("encryptdir.encryptDirectories: %w", err)
+	}
+
+	if opts.CheckFreeSpace {
+		err = checkFreeSpace(keyMap, directories, opts)
+		if err != nil {
+			return fmt.Errorf("encryptdir.encryptDirectories: %w", err)
 		}
 	}
 
-	if len(errList.ErrorList) > 0 {
-		return fmt.Errorf("aes.Encrypt: cwalk.Walk: %s", errList.Error())
+	progress, err := newProgress(opts.Hooks.OnProgress, keyMap, directories, opts.CountTotal, opts.filter())
+	if err != nil {
+		return fmt.Errorf("encryptdir.encryptDirectories: %w", err)
+	}
+
+	journal, err := openJournal(opts, directories, "encrypt")
+	if err != nil {
+		return fmt.Errorf("encryptdir.encryptDirectories: %w", err)
+	}
+
+	walker := newWalker(log, privKey, keyMap, opts, progress, derived, journal)
+	walker.budget = newOutputBudget(opts.MaxTotalOutputBytes)
+	walker.signatures = newSignatureCache()
+	walker.content, err = newContentIndex(opts)
+	if err != nil {
+		return fmt.Errorf("encryptdir.encryptDirectories: %w", err)
+	}
+	errList := walkRoots(ctx, directories, walker, func(w Walker) filepath.WalkFunc { return w.encryptWalk })
+
+	if n := walker.budget.remaining(); n > 0 {
+		errList = append(errList, fmt.Errorf("%d files left unencrypted: %w", n, ErrOutputBudget))
+	}
+
+	// the renames of the last files batched by `Options.SyncBatch`
+	err = walker.syncer.flush()
+	if err != nil {
+		errList = append(errList, err)
+	}
+
+	// every object stored, also when some files failed
+	err = walker.content.write()
+	if err != nil {
+		errList = append(errList, err)
+	}
+
+	// files after the cancel were never started, so every error is from before it
+	if ctx.Err() != nil {
+		errList = append([]error{ctx.Err()}, errList...)
+	}
+
+	// kept for resuming unless every file got through
+	err = journal.close(len(errList) == 0)
+	if err != nil {
+		errList = append(errList, err)
+	}
+
+	if len(errList) > 0 {
+		return fmt.Errorf("encryptdir.encryptDirectories: %w", errors.Join(errList...))
 	}
 
 	return nil
 }
 
 type Walker struct {
+	// nil when not cancelable
+	ctx context.Context
+	// cancels the walks of every root, nil unless `Options.FailFast`
+	cancel  context.CancelFunc
+	log     *zap.SugaredLogger
 	privKey *gorsa.PrivateKey
 	keyMap  map[string][]byte
 
 	startPath string
+
+	opts Options
+
+	// nil when not counting
+	stats *walkStats
+
+	// nil when there is no limit, only used when encrypting
+	budget *outputBudget
+
+	// signatures of the keys files were encrypted with so far, only used when encrypting
+	signatures *signatureCache
+
+	// nil when there is no limit
+	files fileSemaphore
+
+	// nil when nobody is listening
+	progress *progress
+
+	// nil without `Options.Passphrases`
+	derived *derivedKeys
+
+	// errors of the files and dirs of the root, `cwalk.WalkerError` doesnt unwrap so they are kept here instead of handed to cwalk
+	errs *errorList
+
+	// nil without `Options.Resume` or `Options.JournalPath`
+	journal *journal
+
+	// nil unless `Options.PreserveHardlinks` applies
+	links *hardlinks
+
+	// nil when every rename is synced on its own
+	syncer *dirSyncer
+
+	// nil unless `Options.ContentAddressed`, only used when encrypting
+	content *contentIndex
+
+	// nil unless `Options.DebugWorkers`, one set per root
+	workers workerIDs
+	// id of the worker processing the current file, 0 between files or without `Options.DebugWorkers`
+	worker int
+}
+
+// encryptdir.newWalker: the walker shared by every root of a run, `Walker.forRoot` copies it for each one
+// the open file limit is shared across the roots, the output budget and signatures are set by `encryptDirectories` since only encrypting has them
+func newWalker(log *zap.SugaredLogger, privKey *gorsa.PrivateKey, keyMap map[string][]byte, opts Options, progress *progress, derived *derivedKeys, journal *journal) Walker {
+	return Walker{
+		log:      log,
+		privKey:  privKey,
+		keyMap:   keyMap,
+		opts:     opts,
+		files:    newFileSemaphore(opts.maxOpenFiles(), opts.sharedOpenFiles(), opts.MaxWorkers),
+		progress: progress,
+		derived:  derived,
+		journal:  journal,
+		links:    newHardlinks(opts),
+		syncer:   newDirSyncer(opts, journal),
+	}
+}
+
+// encryptdir.Walker.forRoot: copy of `w` walking the root `dir`, with its own stats and dir errors, canceled by `ctx` and `cancel`
+func (w Walker) forRoot(ctx context.Context, cancel context.CancelFunc, dir string) Walker {
+	w.ctx = ctx
+	w.cancel = cancel
+	w.startPath = dir
+	w.stats = &walkStats{results: w.opts.results}
+	w.errs = &errorList{}
+	return w
 }
 
 func (w Walker) encryptWalk(path string, info os.FileInfo, err error) error {
 	if err != nil {
+		// cwalk only passes an error for the root, like it not existing
+		// errors below the root are collected by cwalk itself
+		if len(path) == 0 {
+			return err
+		}
+		return nil
+	}
+
+	// canceled, dont start on any more files
+	if ctxErr(w.ctx) != nil {
+		return nil
+	}
+
+	// excluded dirs and the output dir arent descended into, filtered files are left alone before anything else
+	if info.IsDir() && (w.opts.filter().excludesDir(path) || w.opts.filter().holdsOutput(filepath.Join(w.startPath, path))) {
+		return errPruned
+	}
+	if !info.IsDir() && !w.opts.filter().allows(path) {
+		return nil
+	}
+
+	// links are left alone before anything is opened, following one can write outside the tree
+	if isSymlink(info) && !w.opts.FollowSymlinks {
+		return nil
+	}
+
+	// tagged with the worker it runs on, for `Options.DebugWorkers`
+	w = w.onWorker()
+	defer w.workers.put(w.worker)
+
+	start := time.Now()
+	err = w.encryptPath(w.ctx, path, info)
+
+	fullPath := filepath.Join(w.startPath, path)
+	w.stats.visit(fullPath, info, w.worker, err)
+	if _, ok := lookupKey(w.keyMap, path); ok && info.Mode().IsRegular() {
+		w.progress.file(fullPath)
+	}
+	if err == nil && !info.IsDir() {
+		err = w.checkSlow(path, time.Since(start))
+	}
+	if err != nil {
+		w.log.Warnw("file failed", "path", fullPath, "error", err)
+		if w.cancel != nil {
+			w.cancel()
+		}
+		err = fmt.Errorf("encryptdir.Walker.walk: path = %q: %w", fullPath, err)
+
+		// kept with their chain, so callers can still tell the sentinels apart with `errors.Is`
+		w.errs.add(err)
+
+		// cwalk doesnt descend into a dir whose walk func errored, with `Options.ContinueOnError` the files under it are still processed
+		if info.IsDir() && !w.opts.ContinueOnError {
+			return errPruned
+		}
 		return nil
 	}
+	return nil
+}
 
-	errC := make(chan error, 1)
-	go func(startPath string, path string, info os.FileInfo, privKey *gorsa.PrivateKey, keyMap map[string][]byte, errChan chan error) {
-		// dont touch dirs
-		if info.IsDir() {
-			errChan <- nil
-			return
+// encryptdir.Walker.encryptPath: encrypts the file or dir at `path` under the root of `w` for `Walker.encryptWalk`, on the walker goroutine cwalk called it from, until `ctx` is canceled
+// cwalk already runs the walk on several goroutines, so this doesnt start another
+// returns: error
+func (w Walker) encryptPath(ctx context.Context, path string, info os.FileInfo) (err error) {
+	// one bad file shouldnt take down the whole run, the panic comes back as the error of the file
+	defer recoverFile(w.log, path, &err)
+
+	// dont touch dirs, other than giving empty ones a marker
+	if info.IsDir() {
+		dir := filepath.Join(w.startPath, path)
+		// content addressed objects all go straight into the output dir
+		if len(w.opts.OutputDir) > 0 && !w.opts.DryRun && w.content == nil {
+			err := mirrorDirs(w.startPath, w.opts.OutputDir, path)
+			if err != nil {
+				return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
+			}
 		}
 
-		ext := filepath.Ext(path)
-		key, ok := keyMap[ext[1:]] // get rid of `.` in extension
-		// skip this file if not in key map
-		if !ok {
-			errChan <- nil
-			return
+		if len(w.opts.EmptyDirMarker) > 0 && !w.opts.DryRun && w.content == nil {
+			err := ensureMarker(w.privKey, w.keyMap, dir, w.opts.outputPath(dir, path), w.opts.EmptyDirMarker, w.opts.signatureHash())
+			if err != nil {
+				return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
+			}
 		}
+		return nil
+	}
+
+	key, ok := lookupKey(w.keyMap, path)
+	// skip this file if not in key map
+	if !ok {
+		w.log.Debugw("skipping file, no key for its extension", "path", filepath.Join(w.startPath, path))
+		return nil
+	}
+
+	// temp files of either operation, left behind by a crash or still being written
+	if w.opts.namer().IsTemp(path) {
+		return nil
+	}
 
-		fullPath := filepath.Join(startPath, path)
+	fullPath := filepath.Join(w.startPath, path)
+	// the default journal is in the first root
+	if w.journal.is(fullPath) {
+		return nil
+	}
 
-		plainFile, err := os.OpenFile(fullPath, os.O_RDONLY, info.Mode())
+	// finished by the run this one resumes, left alone without reading it
+	if w.journal.done(fullPath) {
+		w.log.Debugw("skipping file, encrypted before resuming", "path", fullPath)
+		return nil
+	}
+	// the journal and the report go by the path walked, not the target of a link
+	walkPath := fullPath
+
+	// a followed link is encrypted at its target, the link itself stays
+	if isSymlink(info) {
+		var err error
+		fullPath, info, err = followLink(fullPath)
+		// broken links have nothing to encrypt
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
 		if err != nil {
-			errChan <- fmt.Errorf("encryptdir.Walker.encryptWalk: os.OpenFile: %w", err)
-			return
+			return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
 		}
-		defer plainFile.Close()
+		w.stats.link()
+	}
+
+	// like a link to a directory or a device
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	// checked at a followed link as well as its target, the file that would be overwritten
+	if w.opts.protects(walkPath) || w.opts.protects(fullPath) {
+		w.log.Warnw("skipping protected file", "path", fullPath)
+		return nil
+	}
+
+	// big or small files can be left as they are, a followed link goes by the size of its target
+	if !w.opts.sizeAllowed(info.Size()) {
+		w.log.Debugw("skipping file, size out of range", "path", fullPath, "bytes", info.Size())
+		return nil
+	}
 
-		plain, err := io.ReadAll(plainFile)
+	if w.opts.OwnerUID != nil {
+		owned, err := ownedBy(info, *w.opts.OwnerUID)
 		if err != nil {
-			errChan <- fmt.Errorf("encryptdir.Walker.encryptWalk: io.ReadAll: %w", err)
-			return
+			return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
 		}
 
-		sig := plain[:aes.SIGNATURE_SIZE]
+		// someone elses file
+		if !owned {
+			return nil
+		}
+	}
 
-		err = rsa.VerifySignature(&privKey.PublicKey, sig, key, crypto.MD5)
-		if err == nil { // means signature verified and already encrypted
-			errChan <- nil
-			return
+	// the other paths of a hard linked file wait for the first one, then link to its output instead of encrypting it again
+	// they wait before taking an open file, so the first one is never kept from its own
+	leader, first := w.links.claim(fullPath, info)
+	if !first {
+		linked, err := leader.follow(ctx, fullPath, w.opts.namer().TempName(fullPath, false))
+		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
+		}
+		if linked {
+			err = w.journal.record(walkPath)
+			if err != nil {
+				return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
+			}
+			w.log.Infow("encrypted file, linked to another path of it", "path", fullPath, "link", leader.path)
+			w.stats.done(walkPath)
+			return nil
 		}
+		// the first one left it alone, like for being encrypted already, so this one has its own go
+	}
+	// the others go on if this one returns without finishing
+	defer leader.finish(false)
+
+	// held until the temp file is renamed, the deferred closes run first
+	w.files.acquire()
+	defer w.files.release()
+
+	plainFile, err := os.OpenFile(fullPath, os.O_RDONLY, info.Mode())
+	if err != nil {
+		return fmt.Errorf("encryptdir.Walker.encryptPath: os.OpenFile: %w", err)
+	}
+	defer plainFile.Close()
 
-		wSig, err := rsa.CreateSignature(privKey, key, crypto.MD5)
+	if w.opts.ContentMatch != nil {
+		matched, err := contentMatches(plainFile, w.opts.ContentMatch, w.opts.ContentMatchLimit)
 		if err != nil {
-			errChan <- fmt.Errorf("encryptdir.Walker.encryptWalk: rsa.CreateSignature: %w", err)
-			return
+			return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
+		}
+
+		// skip files without secrets
+		if !matched {
+			return nil
 		}
+	}
+
+	banner := w.opts.bannerLine()
 
-		encFile, err := os.OpenFile(fullPath+".enc", os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode())
+	// big files are encrypted straight from `plainFile` a chunk at a time instead of read into memory
+	stream := w.opts.streams(info.Size())
+
+	// files without the magic are plaintext, the signature is only checked for ones with it
+	// that holds with `Options.LegacyFormat` too, a plaintext starting with bytes that happen to verify would otherwise be left unencrypted
+	// a streamed file only has its file header and signature read here, before being read once more to encrypt it
+	var plain []byte
+	if stream {
+		fileHeader, sig, err := readSignature(plainFile, &w.privKey.PublicKey, banner)
 		if err != nil {
-			// if `.enc` file already exists, another goroutine is touching
-			// the file, so move on
-			if errors.Is(err, os.ErrExist) {
-				errChan <- nil
-				return
+			return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
+		}
+
+		encrypted := false
+		if fileHeader != nil {
+			encrypted, err = signedByKnownKey(&w.privKey.PublicKey, sig, key, fileHeader, path, w.keyMap, w.opts, w.derived)
+			if err != nil {
+				return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
 			}
+		}
+
+		if encrypted {
+			return nil
+		}
+	} else {
+		plain, err = io.ReadAll(plainFile)
+		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.encryptPath: io.ReadAll: %w", err)
+		}
+
+		// the banner and file header come before the signature
+		// files too short to hold a signature arent encrypted yet
+		// a file with the magic and a header that doesnt parse was encrypted by something newer, wrapping it again would hide that
+		err = checkFileHeader(bytes.TrimPrefix(plain, banner))
+		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
+		}
+		fileHeader, sig, _ := splitSignature(&w.privKey.PublicKey, plain, banner)
+		if fileHeader != nil {
+			encrypted, err := signedByKnownKey(&w.privKey.PublicKey, sig, key, fileHeader, path, w.keyMap, w.opts, w.derived)
+			if err != nil {
+				return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
+			}
+
+			// means signature verified and already encrypted
+			if encrypted {
+				return nil
+			}
+		}
+	}
+
+	// every check passed, nothing is written past here
+	if w.opts.DryRun {
+		w.log.Infow("dry run: would encrypt file", "path", fullPath, "bytes", info.Size())
+		w.stats.done(walkPath)
+		return nil
+	}
+
+	// hashed as it is read, before it is encrypted, nil without `Options.HashContents` or `Options.VerifyAfterWrite`
+	h := newContentHash(w.opts.HashContents || w.opts.VerifyAfterWrite)
+	if !stream {
+		h.Write(plain)
+	}
+
+	// the original is replaced unless there is an output dir to mirror the tree into
+	outPath := w.opts.outputPath(fullPath, path)
+	if w.content != nil {
+		outPath = w.content.stagingPath(path)
+	} else if len(w.opts.OutputDir) > 0 {
+		err = mirrorDirs(w.startPath, w.opts.OutputDir, filepath.Dir(path))
+		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
+		}
+	}
+
+	header := newFileHeader(fullPath, info.Mode(), w.opts.signatureHash())
+	header.SignsHeader = w.opts.SignHeader
+	// the digest below is of the file with its BOM, it is back in front once decrypted
+	bom := !stream && w.opts.stripsBOM(fullPath, plain)
+	var escrow string
+	if w.opts.EscrowKey != nil {
+		escrow, err = wrapEscrow(w.opts.EscrowKey, key)
+		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
+		}
+	}
+	header.Metadata = w.opts.fileMetadata(bom, escrow)
+	if w.opts.KeyFingerprint {
+		header.KeyID = KeyFingerprint(key)
+	}
+	_, keyExt, _ := lookupKeyExt(w.keyMap, path)
+	header.KDF = w.derived.kdf(keyExt)
+
+	// the digest is of the original plaintext, a streamed file's is only known once it is written and goes into its file header after
+	mac := newIntegrity(w.opts.IntegrityHash, key)
+	if mac != nil {
+		header.IntegrityHash = w.opts.IntegrityHash
+		if !stream {
+			mac.Write(plain)
+			header.Integrity = mac.Sum(nil)
+		}
+	}
+
+	if bom {
+		plain = plain[len(utf8BOM):]
+	}
 
-			errChan <- fmt.Errorf("encryptdir.Walker.encryptWalk: os.OpenFile: %w", err)
-			return
+	// streamed files are never compressed, their size has to be known before the first chunk is written
+	if w.opts.compresses(keyExt) && !stream {
+		compressed, ok, err := compress(plain)
+		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
+		}
+		if ok {
+			plain = compressed
+			header.Compression = CompressionGzip
 		}
-		defer encFile.Close()
+	}
+	fileHeader := header.marshal()
 
-		_, err = encFile.Write(wSig)
+	wSig, err := w.signatures.sign(w.privKey, key, &header, w.opts.signatureHash())
+	if err != nil {
+		return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
+	}
+
+	var cipher []byte
+	if !stream && w.content != nil {
+		cipher, err = aes.EncryptGCMConvergent(key, plain)
+		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
+		}
+	} else if !stream {
+		cipher, err = encryptGCM(key, plain)
 		if err != nil {
-			errChan <- fmt.Errorf("encryptdir.Walker.encryptWalk: encFile.Write(wSig): %w", err)
-			return
+			return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
 		}
+	}
 
-		cipher, err := aes.Encrypt(key, plain)
+	// out of budget, leave the file as is
+	reserved := int64(len(banner)+len(fileHeader)+len(wSig)) + w.opts.payloadSize(info.Size())
+	if !w.budget.reserve(reserved) {
+		return nil
+	}
+	// given back if no output is kept, so a file that fails or is skipped from here on leaves room for the rest
+	written := false
+	defer func() {
+		if !written {
+			w.budget.release(reserved)
+		}
+	}()
+
+	// a streamed file cant be read and truncated at the same time, so it always goes through the temp file
+	// so does a verified one, the original is what is left when it doesnt verify
+	if w.opts.DirectWrite && !w.opts.KeepOriginal && len(w.opts.OutputDir) == 0 && !stream && !w.opts.VerifyAfterWrite {
+		err = writeDirect(fullPath, banner, fileHeader, wSig, cipher)
 		if err != nil {
-			errChan <- fmt.Errorf("encryptdir.Walker.encryptWalk: aes.Encrypt: %w", err)
-			return
+			return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
 		}
+		written = true
 
+		if w.opts.OutputFileMode != 0 {
+			err = os.Chmod(fullPath, w.opts.outputMode(info.Mode()))
+			if err != nil {
+				return fmt.Errorf("encryptdir.Walker.encryptPath: os.Chmod: %w", err)
+			}
+		}
+
+		if w.opts.PreserveMetadata {
+			err = w.restored(copyMetadata(info, fullPath), fullPath)
+			if err != nil {
+				return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
+			}
+		}
+		leader.finish(true)
+		err = w.journal.record(walkPath)
+		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
+		}
+		w.log.Infow("encrypted file", "path", fullPath, "bytes", info.Size())
+		w.stats.hashed(walkPath, h)
+		w.stats.done(walkPath)
+		return nil
+	}
+
+	tmpPath := w.opts.namer().TempName(outPath, false)
+	// read and written, it is read back, and copied or hashed, through this one descriptor
+	encFile, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, w.opts.outputMode(info.Mode()))
+	if err != nil {
+		// if `.enc` file already exists, another goroutine is touching
+		// the file, so move on
+		if errors.Is(err, os.ErrExist) {
+			return nil
+		}
+
+		return fmt.Errorf("encryptdir.Walker.encryptPath: os.OpenFile: %w", err)
+	}
+	defer encFile.Close()
+
+	// held until the temp file is renamed or removed, so `Cleanup` leaves it alone
+	lockTemp(encFile)
+
+	// the temp file is only kept once it is the output, any early return removes it so no partial `.enc` is left behind
+	keepTmp := false
+	defer func() {
+		if !keepTmp {
+			encFile.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	_, err = encFile.Write(banner)
+	if err != nil {
+		return fmt.Errorf("encryptdir.Walker.encryptPath: encFile.Write(banner): %w", err)
+	}
+
+	_, err = encFile.Write(fileHeader)
+	if err != nil {
+		return fmt.Errorf("encryptdir.Walker.encryptPath: encFile.Write(fileHeader): %w", err)
+	}
+
+	_, err = encFile.Write(wSig)
+	if err != nil {
+		return fmt.Errorf("encryptdir.Walker.encryptPath: encFile.Write(wSig): %w", err)
+	}
+
+	if stream {
+		var src io.Reader = plainFile
+		if h != nil {
+			src = io.TeeReader(plainFile, h)
+		}
+		if mac != nil {
+			src = io.TeeReader(src, mac)
+		}
+		err = w.encryptStream(key, plainFile, src, info.Size(), encFile)
+		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
+		}
+
+		if mac != nil {
+			err = w.patchIntegrity(encFile, len(banner), &header, key, mac.Sum(nil))
+			if err != nil {
+				return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
+			}
+		}
+	} else {
 		_, err = encFile.Write(cipher)
 		if err != nil {
-			errChan <- fmt.Errorf("encryptdir.Walker.encryptWalk: encFile.Write: %w", err)
-			return
+			return fmt.Errorf("encryptdir.Walker.encryptPath: encFile.Write: %w", err)
 		}
+	}
+	// all of it is read, so only the temp file is open from here on
+	plainFile.Close()
 
-		err = os.Rename(fullPath+".enc", fullPath)
+	if w.opts.PreserveXattrs {
+		err = copyXattrs(fullPath, tmpPath)
 		if err != nil {
-			errChan <- fmt.Errorf("encryptdir.Walker.encryptWalk: os.Rename: %w", err)
-			return
+			return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
 		}
+	}
 
-		errChan <- nil
-	}(w.startPath, path, info, w.privKey, w.keyMap, errC)
+	// the umask can take bits off of `os.OpenFile`
+	if w.opts.OutputFileMode != 0 {
+		err = encFile.Chmod(w.opts.outputMode(info.Mode()))
+		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.encryptPath: encFile.Chmod: %w", err)
+		}
+	}
+
+	// rename keeps the times and owner of the temp file, so they are set on it
+	if w.opts.PreserveMetadata {
+		err = w.restored(copyMetadata(info, tmpPath), tmpPath)
+		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
+		}
+	}
+
+	// canceled while writing, dont replace the original with it
+	if ctxErr(ctx) != nil {
+		return fmt.Errorf("encryptdir.Walker.encryptPath: %w", ctxErr(ctx))
+	}
 
-	err = <-errC
-	close(errC)
+	// read back before it replaces the original, so a bad write leaves the original as it was
+	if w.opts.VerifyAfterWrite {
+		err = verifyWritten(&w.privKey.PublicKey, key, encFile, banner, w.opts, h)
+		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
+		}
+	}
+
+	// the output goes next to the original, which stays as is
+	if w.opts.KeepOriginal && len(w.opts.OutputDir) == 0 {
+		err = w.syncer.finalize(encFile, w.opts.keptPath(fullPath, false))
+		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
+		}
+		keepTmp = true
+		written = true
+		leader.finish(true)
+
+		err = w.journal.record(walkPath)
+		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
+		}
+		w.log.Infow("encrypted file", "path", fullPath, "bytes", info.Size())
+		w.stats.hashed(walkPath, h)
+		w.stats.done(walkPath)
+		return nil
+	}
+
+	if w.content != nil {
+		err = w.content.store(w.syncer, encFile, path)
+	} else {
+		err = w.syncer.finalize(encFile, outPath)
+	}
+	if err != nil {
+		return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
+	}
+	keepTmp = true
+	written = true
+	leader.finish(true)
+
+	err = w.journal.record(walkPath)
+	if err != nil {
+		return fmt.Errorf("encryptdir.Walker.encryptPath: %w", err)
+	}
+	w.log.Infow("encrypted file", "path", fullPath, "bytes", info.Size())
+	w.stats.hashed(walkPath, h)
+	w.stats.done(walkPath)
+	return nil
+}
+
+// encryptdir.Walker.encryptStream: seals the `size` bytes read from `src` into `out` a chunk at a time, `src` reading `plainFile` from its start
+// content addressed files are sealed under the `aes.ConvergentNonce` of `plainFile`, which is read through once more for it first
+// returns: error
+func (w Walker) encryptStream(key []byte, plainFile *os.File, src io.Reader, size int64, out io.Writer) error {
+	if w.content == nil {
+		err := aes.EncryptGCMStream(key, src, uint64(size), out, w.opts.streamChunkSize())
+		if err != nil {
+			return fmt.Errorf("encryptdir.Walker.encryptStream: %w", err)
+		}
+		return nil
+	}
+
+	nonce, err := aes.ConvergentNonce(key, io.NewSectionReader(plainFile, 0, size))
+	if err != nil {
+		return fmt.Errorf("encryptdir.Walker.encryptStream: %w", err)
+	}
+	err = aes.EncryptGCMStreamWithNonce(key, src, uint64(size), out, w.opts.streamChunkSize(), nonce)
+	if err != nil {
+		return fmt.Errorf("encryptdir.Walker.encryptStream: %w", err)
+	}
+	return nil
+}
+
+// encryptdir.Walker.patchIntegrity: writes the file header of the streamed file `out` again with the digest `integrity`, only known once the plaintext was read
+// with `Options.SignHeader` the signature after it is written again too, it covers the digest
+// returns: error
+func (w Walker) patchIntegrity(out *os.File, offset int, header *FileHeader, key []byte, integrity []byte) error {
+	header.Integrity = integrity
+	fileHeader := header.marshal()
+	_, err := out.WriteAt(fileHeader, int64(offset))
+	if err != nil {
+		return fmt.Errorf("encryptdir.Walker.patchIntegrity: out.WriteAt(fileHeader): %w", err)
+	}
+	if !header.SignsHeader {
+		return nil
+	}
+
+	sig, err := w.signatures.sign(w.privKey, key, header, w.opts.signatureHash())
+	if err != nil {
+		return fmt.Errorf("encryptdir.Walker.patchIntegrity: %w", err)
+	}
+	_, err = out.WriteAt(sig, int64(offset+len(fileHeader)))
 	if err != nil {
-		return fmt.Errorf("encryptdir.Walker.walk: path = %q: %w", filepath.Join(w.startPath, path), err)
+		return fmt.Errorf("encryptdir.Walker.patchIntegrity: out.WriteAt(sig): %w", err)
 	}
 	return nil
 }
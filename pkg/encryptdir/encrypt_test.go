@@ -0,0 +1,133 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/aes"
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestIdempotent(t *testing.T) {
+	for _, bits := range keySizes {
+		for name, opts := range map[string]Options{
+			"default":  {},
+			"banner":   {Banner: "encrypted by encryptdir"},
+			"streamed": {StreamThreshold: -1},
+		} {
+			t.Run(fmt.Sprintf("%d/%s", bits, name), func(t *testing.T) {
+				privKey := testutil.NewPrivateKeyBits(t, bits)
+				keyMap := testutil.NewKeyMap("txt", "sql")
+				spec := map[string][]byte{
+					"a.txt":     []byte("hello"),
+					"sub/b.sql": []byte("select 1;"),
+					"empty.txt": {},
+					"c.md":      []byte("no key"),
+				}
+				dir, _ := testutil.BuildTree(t, spec)
+
+				_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+				if err != nil {
+					t.Fatalf("EncryptWithOptions: %v", err)
+				}
+				once := readTree(t, dir)
+
+				report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+				if err != nil {
+					t.Fatalf("EncryptWithOptions again: %v", err)
+				}
+				if report.Processed != 0 {
+					t.Errorf("encrypting again: processed = %d, want 0", report.Processed)
+				}
+				assertTree(t, dir, once)
+
+				_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+				if err != nil {
+					t.Fatalf("DecryptWithOptions: %v", err)
+				}
+				assertTree(t, dir, spec)
+
+				report, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+				if err != nil {
+					t.Fatalf("DecryptWithOptions again: %v", err)
+				}
+				if report.Processed != 0 {
+					t.Errorf("decrypting again: processed = %d, want 0", report.Processed)
+				}
+				assertTree(t, dir, spec)
+			})
+		}
+	}
+}
+
+func TestEncryptSmallFiles(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	// both shorter than a signature
+	spec := map[string][]byte{"empty.txt": {}, "tiny.txt": []byte("abc")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if report.Processed != 2 {
+		t.Errorf("EncryptWithOptions: processed = %d, want 2", report.Processed)
+	}
+	for rel, contents := range readTree(t, dir) {
+		if len(contents) <= SignatureSize {
+			t.Errorf("path = %q: %d bytes, want it encrypted", rel, len(contents))
+		}
+	}
+
+	_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	assertTree(t, dir, spec)
+}
+
+func TestEncryptedLayout(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	// starts with what could pass for a signature, it is plaintext like the rest
+	plain := append(bytes.Repeat([]byte{0xaa}, SignatureSize), "and the rest"...)
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": plain})
+	path := filepath.Join(dir, "a.txt")
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("os.ReadFile: %v", err)
+	}
+
+	// [file header][signature][ciphertext], nothing before, between, or after
+	if len(contents) != CiphertextOffset+len(plain)+aes.GCMTagSize {
+		t.Fatalf("%d bytes, want %d of layout and %d of sealed plaintext", len(contents), CiphertextOffset, len(plain)+aes.GCMTagSize)
+	}
+	header, ok := parseFileHeader(contents)
+	if !ok || header.size() != SignatureOffset {
+		t.Fatalf("parseFileHeader = %d bytes, %t, want %d", header.size(), ok, SignatureOffset)
+	}
+	err = verifyKey(&privKey.PublicKey, contents[SignatureOffset:SignatureOffset+SignatureSize], keyMap["txt"], &header, Options{})
+	if err != nil {
+		t.Errorf("signature at %d: %v", SignatureOffset, err)
+	}
+	if bytes.Contains(contents, plain[:SignatureSize]) {
+		t.Errorf("the leading bytes of the plaintext are in the file as they were")
+	}
+
+	// the whole plaintext comes back, not less its first `SignatureSize` bytes
+	_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	assertTree(t, dir, map[string][]byte{"a.txt": plain})
+}
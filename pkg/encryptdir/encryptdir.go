@@ -1,10 +1,12 @@
 package encryptdir
 
 import (
+	"context"
 	gorsa "crypto/rsa"
 	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/prairir/encryptdir/pkg/aes"
 	"github.com/prairir/encryptdir/pkg/config"
@@ -19,7 +21,10 @@ func Run(log *zap.SugaredLogger, configPath string, password string, decrypt boo
 		return fmt.Errorf("encryptdir.Run: encryptdir.Startup: %w", err)
 	}
 
-	Operation(log, decrypt, c)
+	err = Operation(log, decrypt, c)
+	if err != nil {
+		return fmt.Errorf("encryptdir.Run: %w", err)
+	}
 	return nil
 }
 
@@ -41,6 +46,11 @@ func Startup(log *zap.SugaredLogger, configPath string, password string) (*confi
 		return nil, fmt.Errorf("encryptdir.Startup: encryptdir.getAESKeys: %w", err)
 	}
 
+	err = validateKeyMap(c.AESKeyMap, c.EnforceAES256)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.Startup: %w", err)
+	}
+
 	// if its already written then we dont care
 	err = aes.WriteKeys(c.AESKeyMap, c.RSAKey, c.AESKeyFile)
 	if err != nil && !errors.Is(err, os.ErrExist) {
@@ -81,20 +91,183 @@ func getAESKeys(log *zap.SugaredLogger,
 }
 
 func Operation(log *zap.SugaredLogger, decrypt bool, c *config.Config) error {
+	return OperationWithHooks(log, decrypt, c, Hooks{})
+}
+
+// encryptdir.OperationWithHooks: like `Operation` but calls `hooks` around each directory root
+func OperationWithHooks(log *zap.SugaredLogger, decrypt bool, c *config.Config, hooks Hooks) error {
+	opts, err := optionsFromConfig(c)
+	if err != nil {
+		return fmt.Errorf("encryptdir.OperationWithHooks: %w", err)
+	}
+	opts.Hooks = hooks
+	if opts.DryRun {
+		opts.results = newResultCollector()
+		defer func() {
+			action := "encrypt"
+			if decrypt {
+				action = "decrypt"
+			}
+			log.Infof("dry run: would %s %d files", action, opts.results.snapshot().Processed)
+		}()
+	}
+	if opts.Hooks.OnSlowFile == nil {
+		opts.Hooks.OnSlowFile = func(path string, took time.Duration) {
+			log.Warnf("slow file: %s took %s", path, took)
+		}
+	}
+
 	if decrypt {
+		if c.PreflightSamples > 0 {
+			err = PreflightCheck(c.RSAKey, c.AESKeyMap, c.Directories, c.PreflightSamples, opts)
+			if err != nil {
+				return fmt.Errorf("encryptdir.OperationWithHooks: %w", err)
+			}
+		}
+
 		log.Infof("decrypting directories: %v", c.Directories)
 		//decryptDirectories(log, c.PrivKey, c.KeyMap, c.Directories)
-		err := decryptDirectories(log, c.RSAKey, c.AESKeyMap, c.Directories)
+		err := decryptDirectories(context.Background(), log, c.RSAKey, c.AESKeyMap, c.Directories, opts)
 		if err != nil {
-			return fmt.Errorf("encryptdir.Operation: encryptdir.decryptDirectories: %w", err)
+			return fmt.Errorf("encryptdir.OperationWithHooks: encryptdir.decryptDirectories: %w", err)
 		}
 		return nil
 	}
 
 	log.Infof("encrypting directories: %v", c.Directories)
-	err := encryptDirectories(log, c.RSAKey, c.AESKeyMap, c.Directories)
+	err = encryptDirectories(context.Background(), log, c.RSAKey, c.AESKeyMap, c.Directories, opts)
+	if err != nil {
+		return fmt.Errorf("encryptdir.OperationWithHooks: encryptdir.encryptDirectories: %w", err)
+	}
+	return nil
+}
+
+// encryptdir.Encrypt: `EncryptContext` without cancellation
+func Encrypt(log *zap.SugaredLogger, privKey *gorsa.PrivateKey, keyMap map[string][]byte, dirs []string) error {
+	return EncryptContext(context.Background(), log, privKey, keyMap, dirs)
+}
+
+// encryptdir.EncryptContext: encrypts every file in `dirs` whose extension has a key in `keyMap`, files already encrypted are skipped
+// `log` may be nil, nothing is logged then
+// `privKey` must not be nil, it signs the AES keys
+// a nil `keyMap` or `dirs` encrypts nothing, glob patterns in `dirs` like `/data/*/secrets` are expanded, a dir under another of `dirs` is only walked as part of it
+// once `ctx` is canceled no new files are started and the temp files of files in flight are removed
+// returns: error, joined over every directory and file that failed, wrapping `ctx.Err()` if it was canceled
+func EncryptContext(ctx context.Context, log *zap.SugaredLogger, privKey *gorsa.PrivateKey, keyMap map[string][]byte, dirs []string) error {
+	_, err := EncryptWithResults(ctx, log, privKey, keyMap, dirs)
+	if err != nil {
+		return fmt.Errorf("encryptdir.EncryptContext: %w", err)
+	}
+	return nil
+}
+
+// encryptdir.EncryptWithResults: like `EncryptContext` but also reports what happened to every file
+// `Report.Processed` counts the files encrypted, files without a key or already encrypted are skipped, directories aren't listed
+// returns: report, also on error, and error like `EncryptContext`
+func EncryptWithResults(ctx context.Context, log *zap.SugaredLogger, privKey *gorsa.PrivateKey, keyMap map[string][]byte, dirs []string) (*Report, error) {
+	report, err := EncryptWithOptions(ctx, log, privKey, keyMap, dirs, Options{})
+	if err != nil {
+		return report, fmt.Errorf("encryptdir.EncryptWithResults: %w", err)
+	}
+	return report, nil
+}
+
+// encryptdir.EncryptWithOptions: like `EncryptWithResults` but with `opts`, like `Options.HashContents` for a report to write a manifest of
+// returns: report, also on error, and error like `EncryptContext`
+func EncryptWithOptions(ctx context.Context, log *zap.SugaredLogger, privKey *gorsa.PrivateKey, keyMap map[string][]byte, dirs []string, opts Options) (*Report, error) {
+	if log == nil {
+		log = zap.NewNop().Sugar()
+	}
+
+	results := newResultCollector()
+
+	dirs, err := expandDirs(dirs)
+	if err != nil {
+		return results.snapshot(), fmt.Errorf("encryptdir.EncryptWithOptions: %w", err)
+	}
+
+	opts.results = results
+	err = encryptDirectories(ctx, log, privKey, keyMap, dirs, opts)
+	if err != nil {
+		return results.snapshot(), fmt.Errorf("encryptdir.EncryptWithOptions: %w", err)
+	}
+	return results.snapshot(), nil
+}
+
+// encryptdir.Decrypt: `DecryptContext` without cancellation
+func Decrypt(log *zap.SugaredLogger, privKey *gorsa.PrivateKey, keyMap map[string][]byte, dirs []string) error {
+	return DecryptContext(context.Background(), log, privKey, keyMap, dirs)
+}
+
+// encryptdir.DecryptContext: decrypts every file in `dirs` whose extension has a key in `keyMap`, files that aren't encrypted are skipped
+// `log` may be nil, nothing is logged then
+// `privKey` must not be nil, it verifies the AES key signatures
+// a nil `keyMap` or `dirs` decrypts nothing, glob patterns in `dirs` like `/data/*/secrets` are expanded, a dir under another of `dirs` is only walked as part of it
+// once `ctx` is canceled no new files are started and the temp files of files in flight are removed
+// returns: error, joined over every directory and file that failed, wrapping `ctx.Err()` if it was canceled
+func DecryptContext(ctx context.Context, log *zap.SugaredLogger, privKey *gorsa.PrivateKey, keyMap map[string][]byte, dirs []string) error {
+	if log == nil {
+		log = zap.NewNop().Sugar()
+	}
+
+	dirs, err := expandDirs(dirs)
 	if err != nil {
-		return fmt.Errorf("encryptdir.Operation: encryptdir.encryptDirectories: %w", err)
+		return fmt.Errorf("encryptdir.DecryptContext: %w", err)
+	}
+
+	err = decryptDirectories(ctx, log, privKey, keyMap, dirs, Options{})
+	if err != nil {
+		return fmt.Errorf("encryptdir.DecryptContext: %w", err)
 	}
 	return nil
 }
+
+// encryptdir.DecryptWithOptions: like `DecryptContext` with `opts`, like `Options.Manifest` to check the plaintext against, and reports what happened to every file
+// `Report.Processed` counts the files decrypted, files without a key or that aren't encrypted are skipped, directories aren't listed
+// returns: report, also on error, and error like `DecryptContext`
+func DecryptWithOptions(ctx context.Context, log *zap.SugaredLogger, privKey *gorsa.PrivateKey, keyMap map[string][]byte, dirs []string, opts Options) (*Report, error) {
+	if log == nil {
+		log = zap.NewNop().Sugar()
+	}
+
+	results := newResultCollector()
+
+	dirs, err := expandDirs(dirs)
+	if err != nil {
+		return results.snapshot(), fmt.Errorf("encryptdir.DecryptWithOptions: %w", err)
+	}
+
+	opts.results = results
+	err = decryptDirectories(ctx, log, privKey, keyMap, dirs, opts)
+	if err != nil {
+		return results.snapshot(), fmt.Errorf("encryptdir.DecryptWithOptions: %w", err)
+	}
+	return results.snapshot(), nil
+}
+
+// encryptdir.DryRun: runs every check of `EncryptWithResults`, or decrypting with `decrypt`, without writing anything
+// `Report.Processed` counts the files that would be encrypted or decrypted
+// returns: report, also on error, and error like `EncryptContext` or `DecryptContext`
+func DryRun(ctx context.Context, log *zap.SugaredLogger, privKey *gorsa.PrivateKey, keyMap map[string][]byte, dirs []string, decrypt bool) (*Report, error) {
+	if log == nil {
+		log = zap.NewNop().Sugar()
+	}
+
+	results := newResultCollector()
+
+	dirs, err := expandDirs(dirs)
+	if err != nil {
+		return results.snapshot(), fmt.Errorf("encryptdir.DryRun: %w", err)
+	}
+
+	opts := Options{DryRun: true, results: results}
+	if decrypt {
+		err = decryptDirectories(ctx, log, privKey, keyMap, dirs, opts)
+	} else {
+		err = encryptDirectories(ctx, log, privKey, keyMap, dirs, opts)
+	}
+	if err != nil {
+		return results.snapshot(), fmt.Errorf("encryptdir.DryRun: %w", err)
+	}
+	return results.snapshot(), nil
+}
@@ -0,0 +1,68 @@
+package encryptdir
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// RSA key sizes the round trips are run with, the signature is as long as the modulus so 2048 bits isnt the only size that has to work
+var keySizes = []int{1024, 2048, 4096}
+
+// readTree: the contents of every regular file under `dir`, by slash separated path relative to it
+func readTree(t testing.TB, dir string) map[string][]byte {
+	t.Helper()
+
+	tree := make(map[string][]byte)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		tree[filepath.ToSlash(rel)] = contents
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("readTree: dir = %q: %v", dir, err)
+	}
+	return tree
+}
+
+// assertTree: fails `t` for every file of `dir` that isnt in `want` with the same contents, and every file of `want` missing from it
+func assertTree(t testing.TB, dir string, want map[string][]byte) {
+	t.Helper()
+
+	got := readTree(t, dir)
+	for rel, contents := range want {
+		g, ok := got[rel]
+		if !ok {
+			t.Errorf("path = %q: missing", rel)
+			continue
+		}
+		if string(g) != string(contents) {
+			t.Errorf("path = %q: contents = %q, want %q", rel, trim(g), trim(contents))
+		}
+	}
+	for rel := range got {
+		if _, ok := want[rel]; !ok {
+			t.Errorf("path = %q: unexpected file", rel)
+		}
+	}
+}
+
+// trim: the start of `b`, so a failing comparison of big files doesnt flood the log
+func trim(b []byte) []byte {
+	if len(b) > 64 {
+		return b[:64]
+	}
+	return b
+}
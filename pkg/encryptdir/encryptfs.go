@@ -0,0 +1,78 @@
+package encryptdir
+
+import (
+	gorsa "crypto/rsa"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// encryptdir.EncryptFS: encrypts every file of `fsys` whose extension has a key in `keyMap` into the same path under `outDir`, like an `embed.FS` at build time
+// `fsys` is only read, so unlike `Encrypt` the output always goes to `outDir`, files without a key or already encrypted aren't copied
+// files are walked one at a time and held in memory, each output is written to a temp file that is renamed into place like `EncryptFile` does
+// an `fs.FS` doesnt always report permission bits, files without any are written, and recorded in the file header, as 0600
+// returns: error, stops at the first file that fails
+func EncryptFS(privKey *gorsa.PrivateKey, keyMap map[string][]byte, fsys fs.FS, outDir string) error {
+	err := validateKeyMap(keyMap, false)
+	if err != nil {
+		return fmt.Errorf("encryptdir.EncryptFS: %w", err)
+	}
+
+	err = fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		key, ok := lookupKey(keyMap, path)
+		if !ok {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("fs.DirEntry.Info: path = %q: %w", path, err)
+		}
+		mode := info.Mode().Perm()
+		if mode == 0 {
+			mode = 0600
+		}
+
+		plain, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return fmt.Errorf("fs.ReadFile: %w", err)
+		}
+
+		// already encrypted, like by an earlier build
+		fileHeader, sig, _ := splitSignature(&privKey.PublicKey, plain, nil)
+		if fileHeader != nil && sig != nil && verifyKey(&privKey.PublicKey, sig, key, fileHeader, Options{}) == nil {
+			return nil
+		}
+
+		out, err := seal(privKey, key, path, mode, plain)
+		if err != nil {
+			return fmt.Errorf("path = %q: %w", path, err)
+		}
+
+		// `fsys` paths are always slash separated
+		dst := filepath.Join(outDir, filepath.FromSlash(path))
+		err = os.MkdirAll(filepath.Dir(dst), 0755)
+		if err != nil {
+			return fmt.Errorf("os.MkdirAll: %w", err)
+		}
+
+		tmpPath := Options{}.namer().TempName(dst, false)
+		err = writeNewFile(tmpPath, out, mode)
+		if err != nil {
+			return err
+		}
+		return finalize(tmpPath, dst)
+	})
+	if err != nil {
+		return fmt.Errorf("encryptdir.EncryptFS: %w", err)
+	}
+	return nil
+}
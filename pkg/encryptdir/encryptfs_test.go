@@ -0,0 +1,56 @@
+package encryptdir
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestEncryptFS(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt", "json")
+	fsys := fstest.MapFS{
+		"a.txt":             {Data: []byte("hello"), Mode: 0640},
+		"assets/conf.json":  {Data: []byte(`{"a": 1}`), Mode: 0644},
+		"assets/deep/b.txt": {Data: []byte("no mode")},
+		"assets/logo.png":   {Data: []byte("no key"), Mode: 0644},
+	}
+	out := t.TempDir()
+
+	err := EncryptFS(privKey, keyMap, fsys, out)
+	if err != nil {
+		t.Fatalf("EncryptFS: %v", err)
+	}
+
+	// only the files with a key are written, at the same paths, encrypted
+	got := readTree(t, out)
+	if len(got) != 3 {
+		t.Errorf("EncryptFS: %d files in the output dir, want 3", len(got))
+	}
+	for rel, mode := range map[string]os.FileMode{"a.txt": 0640, "assets/conf.json": 0644, "assets/deep/b.txt": 0600} {
+		if string(got[rel]) == string(fsys[rel].Data) {
+			t.Errorf("path = %q: not encrypted", rel)
+		}
+		info, err := os.Stat(filepath.Join(out, filepath.FromSlash(rel)))
+		if err != nil {
+			t.Fatalf("os.Stat: %v", err)
+		}
+		if info.Mode().Perm() != mode {
+			t.Errorf("path = %q: mode = %v, want %v", rel, info.Mode().Perm(), mode)
+		}
+	}
+
+	// the output decrypts like any encrypted tree
+	err = Decrypt(nil, privKey, keyMap, []string{out})
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	assertTree(t, out, map[string][]byte{
+		"a.txt":             fsys["a.txt"].Data,
+		"assets/conf.json":  fsys["assets/conf.json"].Data,
+		"assets/deep/b.txt": fsys["assets/deep/b.txt"].Data,
+	})
+}
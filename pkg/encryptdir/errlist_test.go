@@ -0,0 +1,221 @@
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/aes"
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestFileErrorsReturned(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello"), "sub/newer.txt": newerFile})
+
+	// the failing file is deep in the tree, on another worker than the root
+	err := Encrypt(nil, privKey, keyMap, []string{dir})
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("Encrypt: err = %v, want ErrUnsupportedVersion", err)
+	}
+	if !strings.Contains(err.Error(), "newer.txt") {
+		t.Errorf("Encrypt: err = %v, want the failing file in it", err)
+	}
+
+	// a.txt is encrypted, corrupting it makes decrypting it fail
+	tamper(t, filepath.Join(dir, "a.txt"))
+	err = Decrypt(nil, privKey, keyMap, []string{dir})
+	if err == nil || !strings.Contains(err.Error(), "a.txt") {
+		t.Errorf("Decrypt: err = %v, want the failing file in it", err)
+	}
+}
+
+func TestUnreadableFileErrorReturned(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root reads files whatever their mode")
+	}
+
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello"), "sub/locked.txt": []byte("no reading")})
+	locked := filepath.Join(dir, "sub", "locked.txt")
+	err := os.Chmod(locked, 0)
+	if err != nil {
+		t.Fatalf("os.Chmod: %v", err)
+	}
+	t.Cleanup(func() { os.Chmod(locked, 0644) })
+
+	err = Encrypt(nil, testutil.NewPrivateKey(t), testutil.NewKeyMap("txt"), []string{dir})
+	if !errors.Is(err, os.ErrPermission) {
+		t.Errorf("Encrypt: err = %v, want os.ErrPermission", err)
+	}
+}
+
+func TestDecryptErrorPaths(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/bad.txt": []byte("corrupted below")}
+	first, _ := testutil.BuildTree(t, spec)
+	second, _ := testutil.BuildTree(t, spec)
+	roots := []string{first, second}
+
+	err := Encrypt(nil, privKey, keyMap, roots)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	for _, root := range roots {
+		tamper(t, filepath.Join(root, "sub", "bad.txt"))
+	}
+
+	// each root names its own file in full, like encrypt does
+	err = Decrypt(nil, privKey, keyMap, roots)
+	if err == nil {
+		t.Fatalf("Decrypt: err = nil, want the errors of both bad.txt")
+	}
+	for _, root := range roots {
+		want := fmt.Sprintf("path = %q", filepath.Join(root, "sub", "bad.txt"))
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Decrypt: err = %v, want it to contain %s", err, want)
+		}
+	}
+}
+
+func TestContinueOnError(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{
+		"a.txt":         []byte("hello"),
+		"sub/b.txt":     []byte("world"),
+		"sub/newer.txt": newerFile,
+		"other.txt":     newerFile,
+	}
+	dir, _ := testutil.BuildTree(t, spec)
+	opts := Options{ContinueOnError: true}
+
+	for _, decrypt := range []bool{false, true} {
+		name, run := "EncryptWithOptions", EncryptWithOptions
+		if decrypt {
+			name, run = "DecryptWithOptions", DecryptWithOptions
+		}
+
+		report, err := run(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+		if !errors.Is(err, ErrUnsupportedVersion) {
+			t.Fatalf("%s: err = %v, want ErrUnsupportedVersion", name, err)
+		}
+		// every failure is in the error, the files next to them are still processed
+		for _, rel := range []string{"sub/newer.txt", "other.txt"} {
+			if !strings.Contains(err.Error(), fmt.Sprintf("path = %q", filepath.Join(dir, filepath.FromSlash(rel)))) {
+				t.Errorf("%s: err = %v, want %s in it", name, err, rel)
+			}
+		}
+		if report.Processed != 2 || report.Failed != 2 {
+			t.Errorf("%s: processed = %d, failed = %d, want 2 and 2", name, report.Processed, report.Failed)
+		}
+	}
+	assertTree(t, dir, spec)
+
+	// once nothing fails the error is nil
+	for _, rel := range []string{"sub/newer.txt", "other.txt"} {
+		err := os.Remove(filepath.Join(dir, filepath.FromSlash(rel)))
+		if err != nil {
+			t.Fatalf("os.Remove: %v", err)
+		}
+	}
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil || report.Processed != 2 || report.Failed != 0 {
+		t.Errorf("EncryptWithOptions without failures = processed %d, failed %d, %v, want 2, 0, and no error", report.Processed, report.Failed, err)
+	}
+}
+
+func TestContinueOnErrorUnreadable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root reads files whatever their mode")
+	}
+
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world"), "sub/locked.txt": []byte("no reading")}
+	dir, _ := testutil.BuildTree(t, spec)
+	locked := filepath.Join(dir, "sub", "locked.txt")
+	err := os.Chmod(locked, 0)
+	if err != nil {
+		t.Fatalf("os.Chmod: %v", err)
+	}
+	t.Cleanup(func() { os.Chmod(locked, 0644) })
+
+	report, err := EncryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), testutil.NewKeyMap("txt"), []string{dir}, Options{ContinueOnError: true})
+	if !errors.Is(err, os.ErrPermission) || !strings.Contains(err.Error(), fmt.Sprintf("path = %q", locked)) {
+		t.Errorf("EncryptWithOptions: err = %v, want os.ErrPermission naming locked.txt", err)
+	}
+	if report.Processed != 2 || report.Failed != 1 {
+		t.Errorf("EncryptWithOptions: processed = %d, failed = %d, want 2 and 1", report.Processed, report.Failed)
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt", "md")
+	// the magic, and a file header that doesnt parse after it
+	badHeader := append([]byte(FileMagic), make([]byte, CiphertextOffset)...)
+	spec := map[string][]byte{"a.txt": []byte("hello"), "b.md": []byte("notes")}
+
+	t.Run("ErrInvalidHeader", func(t *testing.T) {
+		dir, _ := testutil.BuildTree(t, map[string][]byte{"bad.txt": badHeader})
+		for _, decrypt := range []bool{false, true} {
+			name, run := "EncryptWithOptions", EncryptWithOptions
+			if decrypt {
+				name, run = "DecryptWithOptions", DecryptWithOptions
+			}
+			_, err := run(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+			if !errors.Is(err, ErrInvalidHeader) {
+				t.Errorf("%s: err = %v, want ErrInvalidHeader", name, err)
+			}
+		}
+		_, err := DecryptFileToBytes(privKey, keyMap["txt"], filepath.Join(dir, "bad.txt"))
+		if !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("DecryptFileToBytes: err = %v, want ErrInvalidHeader", err)
+		}
+	})
+
+	t.Run("ErrDecryptFailed", func(t *testing.T) {
+		dir, _ := testutil.BuildTree(t, spec)
+		err := Encrypt(nil, privKey, keyMap, []string{dir})
+		if err != nil {
+			t.Fatalf("Encrypt: %v", err)
+		}
+		tamper(t, filepath.Join(dir, "a.txt"))
+
+		err = Decrypt(nil, privKey, keyMap, []string{dir})
+		if !errors.Is(err, ErrDecryptFailed) || !errors.Is(err, aes.ErrAuthFailed) {
+			t.Errorf("Decrypt: err = %v, want ErrDecryptFailed and aes.ErrAuthFailed", err)
+		}
+	})
+
+	t.Run("ErrKeyNotFound", func(t *testing.T) {
+		dir, _ := testutil.BuildTree(t, spec)
+		err := Encrypt(nil, privKey, keyMap, []string{dir})
+		if err != nil {
+			t.Fatalf("Encrypt: %v", err)
+		}
+
+		// the file header names md, which has no key any more
+		_, err = DecryptWithOptions(context.Background(), nil, privKey, testutil.NewKeyMap("txt"), []string{dir}, Options{StrictDecrypt: true})
+		if !errors.Is(err, ErrKeyNotFound) || !strings.Contains(err.Error(), `extension = "md"`) {
+			t.Errorf("DecryptWithOptions: err = %v, want ErrKeyNotFound naming md", err)
+		}
+	})
+
+	t.Run("ErrAlreadyEncrypted", func(t *testing.T) {
+		dir, _ := testutil.BuildTree(t, spec)
+		path := filepath.Join(dir, "a.txt")
+		err := EncryptFile(privKey, keyMap["txt"], path, path)
+		if err != nil {
+			t.Fatalf("EncryptFile: %v", err)
+		}
+		err = EncryptFile(privKey, keyMap["txt"], path, path)
+		if !errors.Is(err, ErrAlreadyEncrypted) {
+			t.Errorf("EncryptFile again: err = %v, want ErrAlreadyEncrypted", err)
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package encryptdir
+
+import (
+	"crypto/rand"
+	gorsa "crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// sentinel error used for when a file has no escrow copy of its key, or the escrow private key doesnt unwrap it
+var ErrNoEscrow = errors.New("no escrow key slot for this key")
+
+// metadata key of a file header holding the AES key wrapped for `Options.EscrowKey`, as standard base64
+const metadataEscrow = ReservedMetadataPrefix + "escrow"
+
+// label of the RSA-OAEP wrapping of escrow keys, so they cant be passed off as anything else encrypted to the same key
+var escrowLabel = []byte("encryptdir escrow key")
+
+// encryptdir.wrapEscrow: `key` encrypted to `escrowKey` with RSA-OAEP and SHA-256, for the escrow slot of a file header
+// returns: base64 of the wrapped key, or error
+func wrapEscrow(escrowKey *gorsa.PublicKey, key []byte) (string, error) {
+	wrapped, err := gorsa.EncryptOAEP(sha256.New(), rand.Reader, escrowKey, key, escrowLabel)
+	if err != nil {
+		return "", fmt.Errorf("encryptdir.wrapEscrow: rsa.EncryptOAEP: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(wrapped), nil
+}
+
+// encryptdir.escrowSlotLen: how long the escrow slot `wrapEscrow` makes for `escrowKey` is, without wrapping anything
+func escrowSlotLen(escrowKey *gorsa.PublicKey) int {
+	return base64.StdEncoding.EncodedLen(escrowKey.Size())
+}
+
+// encryptdir.unwrapEscrow: the AES key the escrow slot of `header` holds, unwrapped with `escrowKey`
+// returns: key, or error wrapping `ErrNoEscrow`
+func unwrapEscrow(escrowKey *gorsa.PrivateKey, header *FileHeader) ([]byte, error) {
+	if header == nil {
+		return nil, fmt.Errorf("encryptdir.unwrapEscrow: no file header: %w", ErrNoEscrow)
+	}
+	slot, ok := header.Metadata[metadataEscrow]
+	if !ok {
+		return nil, fmt.Errorf("encryptdir.unwrapEscrow: %w", ErrNoEscrow)
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(slot)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.unwrapEscrow: base64: %v: %w", err, ErrNoEscrow)
+	}
+	key, err := gorsa.DecryptOAEP(sha256.New(), nil, escrowKey, wrapped, escrowLabel)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.unwrapEscrow: rsa.DecryptOAEP: %v: %w", err, ErrNoEscrow)
+	}
+	return key, nil
+}
+
+// encryptdir.DecryptWithEscrow: decrypts the file at `src` into `w` with the AES key unwrapped from its escrow slot by `escrowKey`, for recovering it without the AES key file or the private key
+// the signature is still checked like any decrypt, with `pubKey`, the public half of the key the file was signed with, so a recovered file is one encryptdir wrote
+// streamed like `DecryptTo`, files with a banner aren't supported
+// returns: error wrapping `ErrNoEscrow` if the file wasnt encrypted with `Options.EscrowKey` of `escrowKey`, or like `DecryptTo`
+func DecryptWithEscrow(pubKey *gorsa.PublicKey, escrowKey *gorsa.PrivateKey, src string, w io.Writer) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("encryptdir.DecryptWithEscrow: os.Open: %w", err)
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("encryptdir.DecryptWithEscrow: in.Stat: %w", err)
+	}
+
+	header, err := readFileHeader(in)
+	if err != nil {
+		return fmt.Errorf("encryptdir.DecryptWithEscrow: path = %q: %w", src, err)
+	}
+	key, err := unwrapEscrow(escrowKey, header)
+	if err != nil {
+		return fmt.Errorf("encryptdir.DecryptWithEscrow: path = %q: %w", src, err)
+	}
+
+	_, err = in.Seek(0, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("encryptdir.DecryptWithEscrow: in.Seek: %w", err)
+	}
+	keyMap := map[string][]byte{FallbackExt: key, header.Ext: key}
+	header, key, err = openEncrypted(in, pubKey, src, keyMap, Options{}, nil)
+	if err != nil {
+		return fmt.Errorf("encryptdir.DecryptWithEscrow: path = %q: %w", src, err)
+	}
+
+	err = decryptPayload(in, info.Size(), header, key, w, DefaultStreamChunkSize)
+	if err != nil {
+		return fmt.Errorf("encryptdir.DecryptWithEscrow: path = %q: %w", src, err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	gorsa "crypto/rsa"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+// newEscrowKey: an RSA key pair apart from the one the files are signed with
+func newEscrowKey(t *testing.T) *gorsa.PrivateKey {
+	t.Helper()
+	escrowKey, err := gorsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey: %v", err)
+	}
+	return escrowKey
+}
+
+func TestDecryptWithEscrow(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	escrowKey := newEscrowKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world")}
+
+	for name, opts := range map[string]Options{
+		"in memory": {EscrowKey: &escrowKey.PublicKey},
+		"streamed":  {EscrowKey: &escrowKey.PublicKey, StreamThreshold: -1},
+		"signed":    {EscrowKey: &escrowKey.PublicKey, SignHeader: true, Metadata: map[string]string{"owner": "ops"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir, _ := testutil.BuildTree(t, spec)
+			_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+			if err != nil {
+				t.Fatalf("EncryptWithOptions: %v", err)
+			}
+
+			// only the public half of the signing key and the escrow pair, no AES key
+			for rel, plain := range spec {
+				var out bytes.Buffer
+				err = DecryptWithEscrow(&privKey.PublicKey, escrowKey, filepath.Join(dir, rel), &out)
+				if err != nil {
+					t.Fatalf("DecryptWithEscrow: path = %q: %v", rel, err)
+				}
+				if !bytes.Equal(out.Bytes(), plain) {
+					t.Errorf("DecryptWithEscrow: path = %q: got %q, want %q", rel, out.Bytes(), plain)
+				}
+			}
+
+			// the slot is reserved, users dont see it among their metadata
+			metadata, err := ReadMetadata(filepath.Join(dir, "a.txt"))
+			if err != nil {
+				t.Fatalf("ReadMetadata: %v", err)
+			}
+			if _, ok := metadata[metadataEscrow]; ok {
+				t.Errorf("ReadMetadata: has the escrow slot")
+			}
+
+			// the primary keys still decrypt it like any other file
+			_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+			if err != nil {
+				t.Fatalf("DecryptWithOptions: %v", err)
+			}
+			assertTree(t, dir, spec)
+		})
+	}
+}
+
+func TestDecryptWithEscrowRefused(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	escrowKey := newEscrowKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+
+	// without an escrow key there is no slot
+	dir := encryptIntegrity(t, spec, keyMap, Options{})
+	err := DecryptWithEscrow(&privKey.PublicKey, escrowKey, filepath.Join(dir, "a.txt"), &bytes.Buffer{})
+	if !errors.Is(err, ErrNoEscrow) {
+		t.Errorf("DecryptWithEscrow: no slot: err = %v, want %v", err, ErrNoEscrow)
+	}
+
+	// another escrow key cant unwrap it
+	dir = encryptIntegrity(t, spec, keyMap, Options{EscrowKey: &escrowKey.PublicKey})
+	err = DecryptWithEscrow(&privKey.PublicKey, newEscrowKey(t), filepath.Join(dir, "a.txt"), &bytes.Buffer{})
+	if !errors.Is(err, ErrNoEscrow) {
+		t.Errorf("DecryptWithEscrow: other escrow key: err = %v, want %v", err, ErrNoEscrow)
+	}
+
+	// and the file still has to be signed by the key it is said to be
+	err = DecryptWithEscrow(&escrowKey.PublicKey, escrowKey, filepath.Join(dir, "a.txt"), &bytes.Buffer{})
+	if !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("DecryptWithEscrow: other signing key: err = %v, want %v", err, ErrNotEncrypted)
+	}
+}
@@ -0,0 +1,50 @@
+package encryptdir
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/iafan/cwalk"
+)
+
+// roughly how many bytes the in-memory path holds per plaintext byte
+// the `io.ReadAll` buffer and the ciphertext buffer `aes.EncryptGCM` seals into
+const memoryPerByte = 2
+
+// encryptdir.EstimateMemory: estimates peak memory of encrypting `dirs` with `concurrency` files in flight at once
+// only stats files, the estimate is `concurrency` * average candidate file size * `memoryPerByte`
+// files that are streamed count as one `DefaultStreamChunkSize` buffer
+// if `concurrency` <= 0 it defaults to one `cwalk` worker pool per directory
+// returns: estimate in bytes or error
+func EstimateMemory(keyMap map[string][]byte, dirs []string, concurrency int) (int64, error) {
+	if concurrency <= 0 {
+		concurrency = cwalk.NumWorkers * len(dirs)
+	}
+
+	var count int64
+	var total int64
+	err := walkCandidates(keyMap, dirs, func(path string, info os.FileInfo) error {
+		count++
+		if (Options{}).streams(info.Size()) {
+			total += DefaultStreamChunkSize / memoryPerByte
+			return nil
+		}
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("encryptdir.EstimateMemory: %w", err)
+	}
+
+	if count == 0 {
+		return 0, nil
+	}
+
+	// cant have more files in flight than there are files
+	inFlight := int64(concurrency)
+	if inFlight > count {
+		inFlight = count
+	}
+
+	return inFlight * (total / count) * memoryPerByte, nil
+}
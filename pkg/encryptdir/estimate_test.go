@@ -0,0 +1,86 @@
+package encryptdir
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/iafan/cwalk"
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestEstimateMemory(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	// 100, 200 and 300 bytes with keys average 200, the jpg has no key and isnt counted
+	dir, _ := testutil.BuildTree(t, map[string][]byte{
+		"a.txt":     bytes.Repeat([]byte("a"), 100),
+		"sub/b.txt": bytes.Repeat([]byte("b"), 200),
+		"sub/c.txt": bytes.Repeat([]byte("c"), 300),
+		"d.jpg":     bytes.Repeat([]byte("d"), 10000),
+	})
+
+	for name, tc := range map[string]struct {
+		concurrency int
+		want        int64
+	}{
+		"one at a time": {concurrency: 1, want: 1 * 200 * memoryPerByte},
+		"two":           {concurrency: 2, want: 2 * 200 * memoryPerByte},
+		// no more in flight than there are files
+		"more than files": {concurrency: 10, want: 3 * 200 * memoryPerByte},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := EstimateMemory(keyMap, []string{dir}, tc.concurrency)
+			if err != nil {
+				t.Fatalf("EstimateMemory: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("EstimateMemory: got %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEstimateMemoryStreamed(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	// a streamed file only holds a chunk, however big it is
+	dir, _ := testutil.BuildTree(t, map[string][]byte{
+		"big.txt":   make([]byte, 4*DefaultStreamChunkSize),
+		"small.txt": bytes.Repeat([]byte("s"), 1000),
+	})
+
+	got, err := EstimateMemory(keyMap, []string{dir}, 1)
+	if err != nil {
+		t.Fatalf("EstimateMemory: %v", err)
+	}
+	want := int64((DefaultStreamChunkSize/memoryPerByte + 1000) / 2 * memoryPerByte)
+	if got != want {
+		t.Errorf("EstimateMemory: got %d, want %d", got, want)
+	}
+}
+
+func TestEstimateMemoryDefaults(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	spec := make(map[string][]byte)
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		spec[name+".txt"] = bytes.Repeat([]byte("x"), 50)
+	}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	numWorkers := cwalk.NumWorkers
+	cwalk.NumWorkers = 2
+	t.Cleanup(func() { cwalk.NumWorkers = numWorkers })
+
+	// 0 is a worker pool per dir
+	got, err := EstimateMemory(keyMap, []string{dir, dir}, 0)
+	if err != nil {
+		t.Fatalf("EstimateMemory: %v", err)
+	}
+	if want := int64(2 * 2 * 50 * memoryPerByte); got != want {
+		t.Errorf("EstimateMemory: got %d, want %d", got, want)
+	}
+
+	// nothing to encrypt needs nothing
+	got, err = EstimateMemory(map[string][]byte{}, []string{dir}, 4)
+	if err != nil || got != 0 {
+		t.Errorf("EstimateMemory: no keys: got %d, %v, want 0", got, err)
+	}
+}
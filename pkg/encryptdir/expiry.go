@@ -0,0 +1,35 @@
+package encryptdir
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// sentinel error used for when a file is decrypted after the `Options.ExpiresAt` it was encrypted with, without `Options.AllowExpired`
+var ErrExpired = errors.New("file has expired")
+
+// metadata key of a file header recording `Options.ExpiresAt`, as RFC 3339 in UTC
+const metadataExpires = ReservedMetadataPrefix + "expires"
+
+// encryptdir.checkExpiry: checks a file with `header` is still valid, unless `opts` allows expired files
+// a file header with an expiry that doesnt parse is taken as expired, a file without one never expires
+// returns: error wrapping `ErrExpired`
+func checkExpiry(header *FileHeader, opts Options) error {
+	if header == nil || opts.AllowExpired {
+		return nil
+	}
+	expires, ok := header.Metadata[metadataExpires]
+	if !ok {
+		return nil
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, expires)
+	if err != nil {
+		return fmt.Errorf("encryptdir.checkExpiry: expires = %q: %w", expires, ErrExpired)
+	}
+	if time.Now().After(expiresAt) {
+		return fmt.Errorf("encryptdir.checkExpiry: expires = %s: %w", expiresAt, ErrExpired)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+// encryptExpiring: `spec` written to a new dir and encrypted to expire at `expiresAt`
+// returns: dir
+func encryptExpiring(t *testing.T, spec map[string][]byte, keyMap map[string][]byte, expiresAt time.Time) string {
+	t.Helper()
+
+	dir, _ := testutil.BuildTree(t, spec)
+	_, err := EncryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), keyMap, []string{dir}, Options{ExpiresAt: expiresAt, SignHeader: true})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	return dir
+}
+
+func TestExpiryValid(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+	dir := encryptExpiring(t, spec, keyMap, time.Now().Add(time.Hour))
+
+	_, err := DecryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	assertTree(t, dir, spec)
+}
+
+func TestExpiryExpired(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+	dir := encryptExpiring(t, spec, keyMap, time.Now().Add(-time.Hour))
+
+	_, err := decryptBytes(t, keyMap, filepath.Join(dir, "a.txt"), Options{})
+	if !errors.Is(err, ErrExpired) {
+		t.Fatalf("decryptTo: err = %v, want ErrExpired", err)
+	}
+
+	report, err := DecryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), keyMap, []string{dir}, Options{})
+	if !errors.Is(err, ErrExpired) || report.Failed != 1 {
+		t.Fatalf("DecryptWithOptions: failed = %d, err = %v, want 1 and ErrExpired", report.Failed, err)
+	}
+
+	// left encrypted
+	header, err := ReadHeader(filepath.Join(dir, "a.txt"))
+	if err != nil || header.Version != FormatVersion {
+		t.Fatalf("ReadHeader: version = %d, %v, want the file still encrypted", header.Version, err)
+	}
+}
+
+func TestExpiryAllowExpired(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+	dir := encryptExpiring(t, spec, keyMap, time.Now().Add(-time.Hour))
+
+	_, err := DecryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), keyMap, []string{dir}, Options{AllowExpired: true})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	assertTree(t, dir, spec)
+}
@@ -0,0 +1,144 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestNormalizeExt(t *testing.T) {
+	for path, want := range map[string]string{
+		"a.txt":          "txt",
+		"dir/a.txt":      "txt",
+		"archive.tar.gz": "gz",
+		"noext":          "",
+		"dir.d/noext":    "",
+		".bashrc":        "bashrc",
+		"trailing.":      "",
+	} {
+		if got := normalizeExt(path); got != want {
+			t.Errorf("normalizeExt(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestLookupKeyFallback(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt", FallbackExt)
+	for path, want := range map[string]string{
+		"a.txt": "txt",
+		"a.md":  FallbackExt,
+		"noext": FallbackExt,
+	} {
+		key, ext, ok := lookupKeyExt(keyMap, path)
+		if !ok || ext != want || !bytes.Equal(key, keyMap[want]) {
+			t.Errorf("lookupKeyExt(%q) = %q, %t, want the key of %q", path, ext, ok, want)
+		}
+	}
+
+	_, ok := lookupKey(testutil.NewKeyMap("txt"), "a.md")
+	if ok {
+		t.Errorf("lookupKey without a fallback found a key for a.md")
+	}
+}
+
+func TestRoundTripSameKeyMap(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	// the same key map encrypts and decrypts, the walkers look keys up the same way
+	keyMap := testutil.NewKeyMap("txt", "gz", "bashrc")
+	spec := map[string][]byte{
+		"a.txt":              []byte("hello"),
+		"sub/archive.tar.gz": []byte("not really gzip"),
+		".bashrc":            []byte("export PS1"),
+		"noext":              []byte("no key"),
+	}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if report.Processed != 3 {
+		t.Errorf("EncryptWithOptions: processed = %d, want 3", report.Processed)
+	}
+
+	report, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	if report.Processed != 3 {
+		t.Errorf("DecryptWithOptions: processed = %d, want 3", report.Processed)
+	}
+	assertTree(t, dir, spec)
+}
+
+func TestDottedExtRejected(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	// rejected rather than normalized, the error says how to spell it
+	keyMap := map[string][]byte{"txt": testutil.NewTestKey("txt"), ".sql": testutil.NewTestKey("sql")}
+	spec := map[string][]byte{"a.txt": []byte("hello"), "b.sql": []byte("select 1")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	for _, decrypt := range []bool{false, true} {
+		name, run := "EncryptWithOptions", EncryptWithOptions
+		if decrypt {
+			name, run = "DecryptWithOptions", DecryptWithOptions
+		}
+
+		_, err := run(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+		if !errors.Is(err, ErrDottedExt) {
+			t.Fatalf("%s: err = %v, want ErrDottedExt", name, err)
+		}
+		if !strings.Contains(err.Error(), `".sql"`) || !strings.Contains(err.Error(), `use "sql"`) {
+			t.Errorf("%s: err = %v, want the key and how to spell it", name, err)
+		}
+	}
+
+	// nothing ran, not even the files of the keys without a dot
+	assertTree(t, dir, spec)
+}
+
+func TestFallbackKeyMixedDir(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt", FallbackExt)
+	spec := map[string][]byte{
+		"a.txt":     []byte("own key"),
+		"b.md":      []byte("falls back"),
+		"sub/c.csv": []byte("falls back too"),
+		"noext":     []byte("no extension"),
+	}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if report.Processed != len(spec) || report.Skipped != 0 {
+		t.Errorf("EncryptWithOptions: processed = %d, skipped = %d, want %d and 0", report.Processed, report.Skipped, len(spec))
+	}
+
+	// each file is under the key it matched, the rest under the fallback one
+	for rel, plain := range spec {
+		ext := FallbackExt
+		if rel == "a.txt" {
+			ext = "txt"
+		}
+		got, err := DecryptFileToBytes(privKey, keyMap[ext], filepath.Join(dir, filepath.FromSlash(rel)))
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("path = %q: DecryptFileToBytes with the %q key = %q, %v, want %q", rel, ext, got, err, plain)
+		}
+	}
+
+	report, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	if report.Processed != len(spec) {
+		t.Errorf("DecryptWithOptions: processed = %d, want %d", report.Processed, len(spec))
+	}
+	assertTree(t, dir, spec)
+}
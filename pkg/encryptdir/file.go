@@ -0,0 +1,188 @@
+package encryptdir
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// encryptdir.writeNewFile: writes `contents` to `path`, error if `path` already exists
+// a failed write removes the file again, so no partial file is left behind
+func writeNewFile(path string, contents []byte, mode fs.FileMode) error {
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return fmt.Errorf("encryptdir.writeNewFile: os.OpenFile: %w", err)
+	}
+	defer out.Close()
+
+	_, err = out.Write(contents)
+	if err != nil {
+		out.Close()
+		os.Remove(path)
+		return fmt.Errorf("encryptdir.writeNewFile: out.Write: path = %q: %w", path, err)
+	}
+
+	return nil
+}
+
+// encryptdir.writeDirect: truncates the file at `path` and writes `parts` to it in order
+// returns: error
+func writeDirect(path string, parts ...[]byte) error {
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return fmt.Errorf("encryptdir.writeDirect: os.OpenFile: %w", err)
+	}
+	defer out.Close()
+
+	for _, part := range parts {
+		_, err = out.Write(part)
+		if err != nil {
+			return fmt.Errorf("encryptdir.writeDirect: out.Write: path = %q: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+// `swapInto`, swapped out by tests for one that fails like a rename across filesystems or one refused outright
+var swap = swapInto
+
+// encryptdir.finalize: replaces the file at `path` with the temp file at `tmpPath`
+// the temp file is synced first so a crash never leaves `path` pointing at a partly written file, and the directory after so the rename sticks
+// when they are on different filesystems the temp file is copied next to `path` and renamed over it from there, so `path` is still replaced atomically
+// the temp file is removed whether or not it worked, `path` is untouched on failure
+// returns: error
+func finalize(tmpPath string, path string) error {
+	err := replaceFile(tmpPath, path)
+	if err != nil {
+		return fmt.Errorf("encryptdir.finalize: %w", err)
+	}
+
+	err = syncDir(filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("encryptdir.finalize: %w", err)
+	}
+	return nil
+}
+
+// encryptdir.finalizeOpen: `finalize` for a temp file still open for reading and writing as `tmp`, which is synced and copied through instead of opened again
+// `tmp` is left open for the caller to close
+// returns: error
+func finalizeOpen(tmp *os.File, path string) error {
+	err := replaceOpen(tmp, path)
+	if err != nil {
+		return fmt.Errorf("encryptdir.finalizeOpen: %w", err)
+	}
+
+	err = syncDir(filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("encryptdir.finalizeOpen: %w", err)
+	}
+	return nil
+}
+
+// encryptdir.replaceFile: `finalize` without syncing the directory, the temp file is opened once and replaced through it by `replaceOpen`
+// returns: error, the temp file is removed on failure
+func replaceFile(tmpPath string, path string) error {
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR, 0)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("encryptdir.replaceFile: os.OpenFile: %w", err)
+	}
+	defer tmp.Close()
+
+	err = replaceOpen(tmp, path)
+	if err != nil {
+		return fmt.Errorf("encryptdir.replaceFile: %w", err)
+	}
+	return nil
+}
+
+// encryptdir.replaceOpen: `finalizeOpen` without syncing the directory, `tmp` is synced and swapped into `path` by `swapInto`
+// no other descriptor of the temp file is opened, across filesystems the copy is read from `tmp`
+// returns: error, the temp file is removed on failure
+func replaceOpen(tmp *os.File, path string) error {
+	err := tmp.Sync()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("encryptdir.replaceOpen: tmp.Sync: %w", err)
+	}
+
+	err = swap(tmp.Name(), path)
+	if errors.Is(err, syscall.EXDEV) {
+		err = copyReplace(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("encryptdir.replaceOpen: %w", err)
+	}
+	return nil
+}
+
+// encryptdir.swapInto: moves the temp file at `tmpPath` to `path` with `os.Rename`, which replaces an existing file at `path` atomically
+// the original is unlinked by the rename itself, so it never lingers under another name, for a plaintext original that would be plaintext left behind
+// returns: error from `os.Rename`
+func swapInto(tmpPath string, path string) error {
+	err := os.Rename(tmpPath, path)
+	if err != nil {
+		return fmt.Errorf("encryptdir.swapInto: os.Rename: %w", err)
+	}
+	return nil
+}
+
+// encryptdir.copyReplace: copies the file open as `in` to a new file next to `dst`, renames it over `dst`, and removes `in`
+// the copy keeps the mode and modification time of `in`, which is read from its start whatever its offset
+// returns: error, the copy is removed on failure
+func copyReplace(in *os.File, dst string) error {
+	info, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("encryptdir.copyReplace: in.Stat: %w", err)
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*")
+	if err != nil {
+		return fmt.Errorf("encryptdir.copyReplace: os.CreateTemp: %w", err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	_, err = io.Copy(out, io.NewSectionReader(in, 0, info.Size()))
+	if err != nil {
+		return fmt.Errorf("encryptdir.copyReplace: io.Copy: %w", err)
+	}
+
+	err = out.Chmod(info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("encryptdir.copyReplace: out.Chmod: %w", err)
+	}
+
+	err = out.Sync()
+	if err != nil {
+		return fmt.Errorf("encryptdir.copyReplace: out.Sync: %w", err)
+	}
+
+	err = out.Close()
+	if err != nil {
+		return fmt.Errorf("encryptdir.copyReplace: out.Close: %w", err)
+	}
+
+	err = os.Chtimes(out.Name(), info.ModTime(), info.ModTime())
+	if err != nil {
+		return fmt.Errorf("encryptdir.copyReplace: os.Chtimes: %w", err)
+	}
+
+	err = os.Rename(out.Name(), dst)
+	if err != nil {
+		return fmt.Errorf("encryptdir.copyReplace: os.Rename: %w", err)
+	}
+
+	err = os.Remove(in.Name())
+	if err != nil {
+		return fmt.Errorf("encryptdir.copyReplace: os.Remove: %w", err)
+	}
+	return nil
+}
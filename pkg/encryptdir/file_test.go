@@ -0,0 +1,153 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+// failSwap: makes `swap` fail with `errno` like `os.Rename` does until the test ends
+func failSwap(t *testing.T, errno syscall.Errno) {
+	t.Helper()
+	swap = func(tmpPath string, path string) error {
+		return &os.LinkError{Op: "rename", Old: tmpPath, New: path, Err: errno}
+	}
+	t.Cleanup(func() { swap = swapInto })
+}
+
+func TestFinalizeCrossDevice(t *testing.T) {
+	failSwap(t, syscall.EXDEV)
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	// copied next to the original and renamed from there instead
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if report.Processed != 2 {
+		t.Errorf("EncryptWithOptions: processed = %d, want 2", report.Processed)
+	}
+	// only the outputs are left, no temp file or copy of one
+	if tree := readTree(t, dir); len(tree) != len(spec) {
+		t.Errorf("EncryptWithOptions: %d files, want %d", len(tree), len(spec))
+	}
+
+	_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	assertTree(t, dir, spec)
+}
+
+func TestFinalizeRenameFails(t *testing.T) {
+	failSwap(t, syscall.EPERM)
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	report, err := EncryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), testutil.NewKeyMap("txt"), []string{dir}, Options{})
+	if !errors.Is(err, syscall.EPERM) {
+		t.Fatalf("EncryptWithOptions: err = %v, want EPERM", err)
+	}
+	if report.Failed != 2 {
+		t.Errorf("EncryptWithOptions: failed = %d, want 2", report.Failed)
+	}
+
+	// the originals are as they were and the temp files are gone
+	assertTree(t, dir, spec)
+}
+
+// hookNamer: names temp files like the default, calling `hook` with the file first, right before its temp file is created
+type hookNamer struct {
+	SuffixNamer
+	hook func(path string)
+}
+
+func (n hookNamer) TempName(path string, decrypt bool) string {
+	n.hook(path)
+	return n.SuffixNamer.TempName(path, decrypt)
+}
+
+func TestTempFileRemovedOnError(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": bytes.Repeat([]byte("hello "), 1000)}
+	namer := SuffixNamer{EncSuffix: DefaultEncSuffix, DecSuffix: DefaultDecSuffix}
+
+	for _, decrypt := range []bool{false, true} {
+		name, run := "EncryptWithOptions", EncryptWithOptions
+		if decrypt {
+			name, run = "DecryptWithOptions", DecryptWithOptions
+		}
+
+		t.Run(name, func(t *testing.T) {
+			for _, test := range []struct {
+				name string
+				opts Options
+				// makes the file fail once its temp file is being written
+				hook func(cancel context.CancelFunc, path string)
+			}{
+				{"canceled", Options{}, func(cancel context.CancelFunc, path string) { cancel() }},
+				{"canceled streaming", Options{StreamThreshold: -1, StreamChunkSize: 1024}, func(cancel context.CancelFunc, path string) { cancel() }},
+				// the file is shorter than it was when streaming it began
+				{"truncated streaming", Options{StreamThreshold: -1, StreamChunkSize: 1024}, func(cancel context.CancelFunc, path string) { os.Truncate(path, 2000) }},
+			} {
+				t.Run(test.name, func(t *testing.T) {
+					dir, _ := testutil.BuildTree(t, spec)
+					if decrypt {
+						_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, test.opts)
+						if err != nil {
+							t.Fatalf("EncryptWithOptions: %v", err)
+						}
+					}
+
+					ctx, cancel := context.WithCancel(context.Background())
+					defer cancel()
+					var before map[string][]byte
+					opts := test.opts
+					opts.Namer = hookNamer{SuffixNamer: namer, hook: func(path string) {
+						test.hook(cancel, path)
+						before = readTree(t, dir)
+					}}
+
+					_, err := run(ctx, nil, privKey, keyMap, []string{dir}, opts)
+					if err == nil {
+						t.Fatalf("%s: err = nil, want the file to fail", name)
+					}
+					// as it was right before its temp file was created, which is gone
+					assertTree(t, dir, before)
+				})
+			}
+		})
+	}
+}
+
+func TestSwapInto(t *testing.T) {
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"file": []byte("original"), "file.tmp": []byte("output")})
+
+	err := swapInto(filepath.Join(dir, "file.tmp"), filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatalf("swapInto: %v", err)
+	}
+	// the original isnt kept under any name, not even the temp one
+	assertTree(t, dir, map[string][]byte{"file": []byte("output")})
+
+	// nothing at `path` yet
+	err = os.WriteFile(filepath.Join(dir, "new.tmp"), []byte("fresh"), 0600)
+	if err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	err = swapInto(filepath.Join(dir, "new.tmp"), filepath.Join(dir, "new"))
+	if err != nil {
+		t.Fatalf("swapInto: new: %v", err)
+	}
+	assertTree(t, dir, map[string][]byte{"file": []byte("output"), "new": []byte("fresh")})
+}
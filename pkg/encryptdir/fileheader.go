@@ -0,0 +1,292 @@
+package encryptdir
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/prairir/encryptdir/pkg/aes"
+)
+
+// FileMagic: the bytes a file encrypted with `FormatVersion` 2 starts with, after the banner if there is one
+const FileMagic = "EDIR"
+
+// sentinel error used for when a file starts with `FileMagic` but the file header after it doesnt parse, like one written by a newer version
+var ErrInvalidHeader = errors.New("invalid file header")
+
+// sentinel error used for when a file header has a version newer than `FormatVersion`, it wraps `ErrInvalidHeader`
+// the file was written by a newer encryptdir, and is left alone instead of being read wrong
+var ErrUnsupportedVersion = fmt.Errorf("unsupported format version: %w", ErrInvalidHeader)
+
+// FileHeader: what an encrypted file records about its original, in front of the signature
+// files written with `FormatVersion` 1 have no file header
+type FileHeader struct {
+	Version int
+	// extension of the original file without the dot, empty if it was longer than `ExtSize`
+	// decrypting picks the key by it, so a renamed file still decrypts
+	Ext string
+	// permission bits of the original file, restored when decrypting
+	Mode fs.FileMode
+	// what the key was derived with, nil if it wasnt derived from a passphrase
+	// decrypting derives the key again from `Options.Passphrases` with it
+	KDF *aes.KDFParams
+	// `CompressionGzip` if the plaintext was gzipped before it was encrypted, `CompressionNone` if not
+	Compression int
+	// hash the signature was written with, the only one it is verified with
+	Hash crypto.Hash
+	// the signature is of the AES key followed by the marshaled file header instead of the key alone
+	SignsHeader bool
+	// hash of `Integrity`, 0 if the file didnt record one
+	IntegrityHash crypto.Hash
+	// HMAC of the original plaintext keyed with the AES key, before it was compressed, checked once it is decrypted
+	Integrity []byte
+	// `KeyFingerprint` of the AES key, nil if the file didnt record one
+	KeyID []byte
+	// user metadata from `Options.Metadata`, stored in the clear after the fixed part of the header
+	Metadata map[string]string
+}
+
+// encryptdir.newFileHeader: the file header for encrypting the file at `path` with `mode`, signing its key with `hash`
+func newFileHeader(path string, mode fs.FileMode, hash crypto.Hash) FileHeader {
+	ext := normalizeExt(path)
+	if len(ext) > ExtSize {
+		ext = ""
+	}
+	return FileHeader{Version: FormatVersion, Ext: ext, Mode: mode.Perm(), Hash: hash}
+}
+
+// encryptdir.FileHeader.size: how many bytes `h` takes up in its file, more than `FileHeaderSize` with metadata
+func (h FileHeader) size() int {
+	return fileHeaderSize(h.Version) + len(h.marshalMetadata())
+}
+
+// encryptdir.FileHeader.marshalMetadata: the metadata bytes of `h`, nil without metadata
+// a map of strings always marshals, with its keys sorted, so the same metadata is the same bytes
+func (h FileHeader) marshalMetadata() []byte {
+	if len(h.Metadata) == 0 {
+		return nil
+	}
+	b, _ := json.Marshal(h.Metadata)
+	return b
+}
+
+// encryptdir.fileHeaderSize: how many bytes the fixed part of the file header of a file with format `version` takes up, 0 for version 1
+func fileHeaderSize(version int) int {
+	if version < 2 {
+		return 0
+	}
+	return FileHeaderSize
+}
+
+// encryptdir.FileHeader.marshal: the bytes of `h`, the same ones it was parsed from, so a signature over the file header verifies
+func (h FileHeader) marshal() []byte {
+	metadata := h.marshalMetadata()
+	b := make([]byte, FileHeaderSize+len(metadata))
+	copy(b[MagicOffset:], FileMagic)
+	b[VersionOffset] = byte(h.Version)
+	binary.LittleEndian.PutUint32(b[ModeOffset:], uint32(h.Mode.Perm()))
+	b[ExtLenOffset] = byte(len(h.Ext))
+	copy(b[ExtOffset:ExtOffset+ExtSize], h.Ext)
+	if h.KDF != nil {
+		b[KDFOffset] = KDFPBKDF2SHA256
+		binary.LittleEndian.PutUint32(b[IterationsOffset:], uint32(h.KDF.Iterations))
+		b[SaltLenOffset] = byte(len(h.KDF.Salt))
+		copy(b[SaltOffset:SaltOffset+SaltSize], h.KDF.Salt)
+	}
+	b[CompressionOffset] = byte(h.Compression)
+	b[HashOffset] = hashIDs[h.Hash]
+	if h.SignsHeader {
+		b[SignedOffset] = SignedHeader
+	}
+	b[IntegrityHashOffset] = hashIDs[h.IntegrityHash]
+	copy(b[IntegrityOffset:IntegrityOffset+IntegritySize], h.Integrity)
+	copy(b[KeyIDOffset:KeyIDOffset+KeyIDSize], h.KeyID)
+	binary.LittleEndian.PutUint16(b[MetadataLenOffset:], uint16(len(metadata)))
+	copy(b[FileHeaderSize:], metadata)
+	return b
+}
+
+// encryptdir.metadataLen: the length of the metadata after the fixed part of the file header at the start of `b`, 0 for a file without metadata
+// `b` only has to hold the fixed part, so callers can read the rest once they know how much there is
+func metadataLen(b []byte) int {
+	if len(b) < FileHeaderSize || !bytes.Equal(b[MagicOffset:MagicOffset+MagicSize], []byte(FileMagic)) || int(b[VersionOffset]) != FormatVersion {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint16(b[MetadataLenOffset:]))
+}
+
+// encryptdir.parseFileHeader: parses the file header at the start of `b`
+// returns: header and true, or false if `b` doesnt start with one
+func parseFileHeader(b []byte) (FileHeader, bool) {
+	if len(b) < FileHeaderSize || !bytes.Equal(b[MagicOffset:MagicOffset+MagicSize], []byte(FileMagic)) {
+		return FileHeader{}, false
+	}
+
+	version := int(b[VersionOffset])
+	extLen := int(b[ExtLenOffset])
+	if version != FormatVersion || extLen > ExtSize {
+		return FileHeader{}, false
+	}
+
+	header := FileHeader{
+		Version: version,
+		Ext:     string(b[ExtOffset : ExtOffset+extLen]),
+		Mode:    fs.FileMode(binary.LittleEndian.Uint32(b[ModeOffset:])).Perm(),
+	}
+
+	switch b[KDFOffset] {
+	case KDFNone:
+	case KDFPBKDF2SHA256:
+		saltLen := int(b[SaltLenOffset])
+		if saltLen > SaltSize {
+			return FileHeader{}, false
+		}
+		header.KDF = &aes.KDFParams{
+			Salt:       append([]byte(nil), b[SaltOffset:SaltOffset+saltLen]...),
+			Iterations: int(binary.LittleEndian.Uint32(b[IterationsOffset:])),
+		}
+	default:
+		return FileHeader{}, false
+	}
+
+	switch b[CompressionOffset] {
+	case CompressionNone, CompressionGzip:
+		header.Compression = int(b[CompressionOffset])
+	default:
+		return FileHeader{}, false
+	}
+
+	hash, ok := hashByID(b[HashOffset])
+	if !ok {
+		return FileHeader{}, false
+	}
+	header.Hash = hash
+
+	switch b[SignedOffset] {
+	case SignedKey:
+	case SignedHeader:
+		header.SignsHeader = true
+	default:
+		return FileHeader{}, false
+	}
+
+	if b[IntegrityHashOffset] != 0 {
+		integrityHash, ok := hashByID(b[IntegrityHashOffset])
+		if !ok {
+			return FileHeader{}, false
+		}
+		header.IntegrityHash = integrityHash
+		header.Integrity = append([]byte(nil), b[IntegrityOffset:IntegrityOffset+integrityHash.Size()]...)
+	}
+
+	// all zero is no fingerprint
+	keyID := b[KeyIDOffset : KeyIDOffset+KeyIDSize]
+	if !bytes.Equal(keyID, make([]byte, KeyIDSize)) {
+		header.KeyID = append([]byte(nil), keyID...)
+	}
+
+	metaLen := metadataLen(b)
+	if metaLen == 0 {
+		return header, true
+	}
+	if metaLen > MaxMetadataSize || len(b) < FileHeaderSize+metaLen {
+		return FileHeader{}, false
+	}
+	metadata := b[FileHeaderSize : FileHeaderSize+metaLen]
+	err := json.Unmarshal(metadata, &header.Metadata)
+	// only the form `marshalMetadata` writes is taken, anything else would be another size or signed message once marshaled again
+	if err != nil || len(header.Metadata) == 0 || !bytes.Equal(header.marshalMetadata(), metadata) {
+		return FileHeader{}, false
+	}
+	return header, true
+}
+
+// encryptdir.splitFileHeader: splits the file header off of `contents`, the banner has to be gone already
+// returns: header, nil for a file without one, and the rest of `contents` starting at the signature
+func splitFileHeader(contents []byte) (*FileHeader, []byte) {
+	header, ok := parseFileHeader(contents)
+	if !ok {
+		return nil, contents
+	}
+	return &header, contents[header.size():]
+}
+
+// encryptdir.checkFileHeader: checks `b`, the start of a file past its banner, either doesnt have the magic or has a file header that parses
+// such a file was written by encryptdir, so it is neither taken for plaintext nor guessed at
+// returns: error wrapping `ErrInvalidHeader`, `ErrUnsupportedVersion` for a version newer than `FormatVersion`
+func checkFileHeader(b []byte) error {
+	if len(b) < MagicOffset+MagicSize || !bytes.Equal(b[MagicOffset:MagicOffset+MagicSize], []byte(FileMagic)) {
+		return nil
+	}
+
+	_, ok := parseFileHeader(b)
+	if ok {
+		return nil
+	}
+	if len(b) > VersionOffset && int(b[VersionOffset]) > FormatVersion {
+		return fmt.Errorf("encryptdir.checkFileHeader: version = %d, newest known = %d: %w", b[VersionOffset], FormatVersion, ErrUnsupportedVersion)
+	}
+	return fmt.Errorf("encryptdir.checkFileHeader: %w", ErrInvalidHeader)
+}
+
+// encryptdir.headerLen: how many bytes `header` takes up in its file, 0 for a file without one
+func headerLen(header *FileHeader) int {
+	if header == nil {
+		return 0
+	}
+	return header.size()
+}
+
+// encryptdir.originalMode: the mode `header` recorded for the original of the file `info` is about, the mode of that file for one without a header
+func originalMode(info fs.FileInfo, header *FileHeader) fs.FileMode {
+	if header == nil {
+		return info.Mode()
+	}
+	return header.Mode
+}
+
+// encryptdir.readFileHeader: reads the file header at the current offset of `in` and moves past it
+// without a header `in` is moved back to where it was
+// returns: header, nil for a file without one, or error, wrapping `ErrInvalidHeader` for a file with the magic whose header doesnt parse
+func readFileHeader(in io.ReadSeeker) (*FileHeader, error) {
+	start, err := in.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.readFileHeader: in.Seek: %w", err)
+	}
+
+	// a file without one can be shorter, whatever was read past the start is seeked back over
+	b := make([]byte, FileHeaderSize)
+	n, err := io.ReadFull(in, b)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("encryptdir.readFileHeader: io.ReadFull: %w", err)
+	}
+	b, err = readMetadata(in, b[:n])
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.readFileHeader: %w", err)
+	}
+	n = len(b)
+
+	header, ok := parseFileHeader(b[:n])
+	end := start
+	if ok {
+		end += int64(header.size())
+	}
+
+	_, err = in.Seek(end, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.readFileHeader: in.Seek: %w", err)
+	}
+	if !ok {
+		err = checkFileHeader(b[:n])
+		if err != nil {
+			return nil, fmt.Errorf("encryptdir.readFileHeader: %w", err)
+		}
+		return nil, nil
+	}
+	return &header, nil
+}
@@ -0,0 +1,162 @@
+package encryptdir
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/iafan/cwalk"
+)
+
+// sentinel error used for when an include or exclude pattern is malformed
+var ErrBadPattern = errors.New("malformed include or exclude pattern")
+
+// returned by the walk funcs for excluded dirs, cwalk doesnt descend into a dir whose walk func errored
+// `filepath.SkipDir` cant be used, cwalk skips the rest of the parent dir with it
+var errPruned = errors.New("encryptdir: dir excluded")
+
+// encryptdir.isPruned: if `e` is an `errPruned` from a walk func
+// `cwalk.WalkerError` doesnt unwrap, so its message is all there is to compare
+func isPruned(e cwalk.WalkerError) bool {
+	return e.Error() == errPruned.Error()
+}
+
+// pathFilter: `Options.Include`, `Options.Exclude`, and `Options.MaxDepth`, matched against slash separated paths relative to the root
+// and `Options.OutputDir`, which is never walked when it is under the root
+type pathFilter struct {
+	include []string
+	exclude []string
+	// 0 means no limit
+	maxDepth int
+	// absolute, empty without one
+	outputDir string
+}
+
+// encryptdir.Options.filter: the include and exclude patterns, depth limit, and output dir of `o`
+func (o Options) filter() pathFilter {
+	f := pathFilter{include: o.Include, exclude: o.Exclude, maxDepth: o.MaxDepth}
+	if len(o.OutputDir) > 0 {
+		// `Options.checkOutputDir` already failed the run if it isnt absolute
+		f.outputDir, _ = filepath.Abs(o.OutputDir)
+	}
+	return f
+}
+
+// encryptdir.depth: how many levels below the root `rel` is, 1 for the entries of the root itself, 0 for the root
+func depth(rel string) int {
+	rel = path.Clean(filepath.ToSlash(rel))
+	if rel == "." || len(rel) == 0 {
+		return 0
+	}
+	return strings.Count(rel, "/") + 1
+}
+
+// encryptdir.pathFilter.validate: checks every pattern is well formed
+// returns: error wrapping `ErrBadPattern` naming the first bad pattern
+func (f pathFilter) validate() error {
+	for _, pattern := range append(append([]string(nil), f.include...), f.exclude...) {
+		for _, seg := range strings.Split(pattern, "/") {
+			_, err := path.Match(seg, "")
+			if err != nil {
+				return fmt.Errorf("encryptdir.pathFilter.validate: pattern = %q: %w", pattern, ErrBadPattern)
+			}
+		}
+	}
+	return nil
+}
+
+// encryptdir.pathFilter.holdsOutput: if the dir at `fullPath` is the output dir or under it, so walking it would walk the files the run just wrote
+func (f pathFilter) holdsOutput(fullPath string) bool {
+	if len(f.outputDir) == 0 {
+		return false
+	}
+	fullPath, err := filepath.Abs(fullPath)
+	if err != nil {
+		return false
+	}
+	return within(f.outputDir, fullPath)
+}
+
+// encryptdir.pathFilter.excludesDir: if the dir at `rel` matches an exclude pattern or is at the depth limit, so nothing under it is touched
+// the root itself is never excluded
+func (f pathFilter) excludesDir(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	if len(rel) == 0 || rel == "." {
+		return false
+	}
+
+	// its entries would be past the limit
+	if f.maxDepth > 0 && depth(rel) >= f.maxDepth {
+		return true
+	}
+
+	for _, pattern := range f.exclude {
+		if matchGlob(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// encryptdir.pathFilter.allows: if the file at `rel` is processed
+// exclude wins over include, a file is excluded if it or any dir above it matches an exclude pattern
+// with include patterns the file has to match one of them, without any every file is included
+// files past the depth limit never are, the walkers dont reach them but the ones of `EncryptPaths` can
+func (f pathFilter) allows(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	if f.maxDepth > 0 && depth(rel) > f.maxDepth {
+		return false
+	}
+
+	for dir := rel; dir != "." && dir != "/" && len(dir) > 0; dir = path.Dir(dir) {
+		for _, pattern := range f.exclude {
+			if matchGlob(pattern, dir) {
+				return false
+			}
+		}
+	}
+
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, pattern := range f.include {
+		if matchGlob(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// encryptdir.matchGlob: matches the slash separated `name` against `pattern`
+// `**` as a whole segment matches any number of segments, including none, other segments are `path.Match` patterns
+// returns: if it matches, malformed patterns never match
+func matchGlob(pattern string, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// encryptdir.matchSegments: `matchGlob` over the segments of the pattern and name
+func matchSegments(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for n := 0; n <= len(name); n++ {
+				if matchSegments(pattern[1:], name[n:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		ok, err := path.Match(pattern[0], name[0])
+		if err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,222 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestMatchGlob(t *testing.T) {
+	for _, test := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*.txt", "a.txt", true},
+		{"*.txt", "sub/a.txt", false},
+		{"**/*.txt", "a.txt", true},
+		{"**/*.txt", "sub/deep/a.txt", true},
+		{"sub/**", "sub/deep/a.txt", true},
+		{"**/node_modules", "web/node_modules", true},
+		{".git", ".git", true},
+		{".git", "sub/.git", false},
+		{"[", "[", false},
+	} {
+		if got := matchGlob(test.pattern, test.name); got != test.want {
+			t.Errorf("matchGlob(%q, %q) = %t, want %t", test.pattern, test.name, got, test.want)
+		}
+	}
+}
+
+func TestExcludePrunesDirs(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{
+		"a.txt":                    []byte("hello"),
+		".git/config.txt":          []byte("git"),
+		"web/node_modules/m.txt":   []byte("dep"),
+		"web/node_modules/x/y.txt": newerFile,
+		"web/app.txt":              []byte("app"),
+	}
+	dir, _ := testutil.BuildTree(t, spec)
+	opts := Options{Exclude: []string{".git", "**/node_modules"}}
+
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if report.Processed != 2 {
+		t.Errorf("EncryptWithOptions: processed = %d, want 2", report.Processed)
+	}
+	// not even visited, the file that would fail never is
+	for _, f := range report.Files {
+		if strings.Contains(f.Path, ".git") || strings.Contains(f.Path, "node_modules") {
+			t.Errorf("path = %q: in an excluded dir but walked", f.Path)
+		}
+	}
+
+	got := readTree(t, dir)
+	for rel, plain := range spec {
+		encrypted := string(got[rel]) != string(plain)
+		if want := rel == "a.txt" || rel == "web/app.txt"; encrypted != want {
+			t.Errorf("path = %q: encrypted = %t, want %t", rel, encrypted, want)
+		}
+	}
+
+	_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	assertTree(t, dir, spec)
+}
+
+func TestMaxDepth(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{
+		"a.txt":          []byte("depth 1"),
+		"d1/b.txt":       []byte("depth 2"),
+		"d1/d2/c.txt":    []byte("depth 3"),
+		"d1/d2/d3/d.txt": []byte("depth 4"),
+	}
+	dir, _ := testutil.BuildTree(t, spec)
+	shallow := func(rel string) bool { return rel == "a.txt" || rel == "d1/b.txt" }
+
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{MaxDepth: 2})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if report.Processed != 2 || len(report.Files) != 2 {
+		t.Errorf("EncryptWithOptions: processed = %d of %d visited, want 2 of 2", report.Processed, len(report.Files))
+	}
+	got := readTree(t, dir)
+	for rel, plain := range spec {
+		if encrypted := string(got[rel]) != string(plain); encrypted != shallow(rel) {
+			t.Errorf("path = %q: encrypted = %t, want %t", rel, encrypted, shallow(rel))
+		}
+	}
+
+	// everything encrypted, decrypting stops at the limit the same way
+	_, err = EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions without a limit: %v", err)
+	}
+	report, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{MaxDepth: 2})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	if report.Processed != 2 || len(report.Files) != 2 {
+		t.Errorf("DecryptWithOptions: processed = %d of %d visited, want 2 of 2", report.Processed, len(report.Files))
+	}
+	got = readTree(t, dir)
+	for rel, plain := range spec {
+		if decrypted := string(got[rel]) == string(plain); decrypted != shallow(rel) {
+			t.Errorf("path = %q: decrypted = %t, want %t", rel, decrypted, shallow(rel))
+		}
+	}
+}
+
+func TestDepth(t *testing.T) {
+	for rel, want := range map[string]int{".": 0, "": 0, "a.txt": 1, "d1/b.txt": 2, "d1/d2/": 2} {
+		if got := depth(rel); got != want {
+			t.Errorf("depth(%q) = %d, want %d", rel, got, want)
+		}
+	}
+}
+
+func TestSizeRange(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{
+		"small.txt":   bytes.Repeat([]byte("s"), 10),
+		"sub/mid.txt": bytes.Repeat([]byte("m"), 1000),
+		"big.txt":     bytes.Repeat([]byte("b"), 10000),
+	}
+
+	for _, test := range []struct {
+		name    string
+		opts    Options
+		encrypt []string
+	}{
+		{"min and max", Options{MinSize: 100, MaxSize: 5000}, []string{"sub/mid.txt"}},
+		{"min only", Options{MinSize: 100}, []string{"sub/mid.txt", "big.txt"}},
+		{"max only", Options{MaxSize: 5000}, []string{"small.txt", "sub/mid.txt"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir, _ := testutil.BuildTree(t, spec)
+
+			report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, test.opts)
+			if err != nil {
+				t.Fatalf("EncryptWithOptions: %v", err)
+			}
+			// the others are visited and reported as skipped
+			if report.Processed != len(test.encrypt) || report.Skipped != len(spec)-len(test.encrypt) {
+				t.Errorf("processed = %d, skipped = %d, want %d and %d", report.Processed, report.Skipped, len(test.encrypt), len(spec)-len(test.encrypt))
+			}
+			got := readTree(t, dir)
+			for rel, plain := range spec {
+				want := false
+				for _, e := range test.encrypt {
+					want = want || e == rel
+				}
+				if encrypted := !bytes.Equal(got[rel], plain); encrypted != want {
+					t.Errorf("path = %q: encrypted = %t, want %t", rel, encrypted, want)
+				}
+			}
+
+			// the encrypted files are still in range with their header, so the same options decrypt them
+			_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, test.opts)
+			if err != nil {
+				t.Fatalf("DecryptWithOptions: %v", err)
+			}
+			assertTree(t, dir, spec)
+		})
+	}
+}
+
+func TestIncludeExcludePrecedence(t *testing.T) {
+	f := pathFilter{include: []string{"**/*.txt"}, exclude: []string{"secret/**", "**/skip.txt"}}
+	for rel, want := range map[string]bool{
+		"a.txt":           true,
+		"sub/b.txt":       true,
+		"a.md":            false,
+		"secret/c.txt":    false,
+		"secret/d/e.txt":  false,
+		"sub/skip.txt":    false,
+		"notsecret/c.txt": true,
+	} {
+		if got := f.allows(rel); got != want {
+			t.Errorf("allows(%q) = %t, want %t", rel, got, want)
+		}
+	}
+
+	// exclude wins over include, whole runs the same
+	privKey := testutil.NewPrivateKey(t)
+	spec := map[string][]byte{"a.txt": []byte("a"), "sub/skip.txt": []byte("skip"), "b.sql": []byte("select 1")}
+	dir, _ := testutil.BuildTree(t, spec)
+	opts := Options{Include: []string{"**/*.txt"}, Exclude: []string{"**/skip.txt"}}
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, testutil.NewKeyMap("txt", "sql"), []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if report.Processed != 1 {
+		t.Errorf("EncryptWithOptions: processed = %d, want only a.txt", report.Processed)
+	}
+	got := readTree(t, dir)
+	if string(got["sub/skip.txt"]) != "skip" || string(got["b.sql"]) != "select 1" {
+		t.Errorf("EncryptWithOptions: touched a file that isnt included or is excluded")
+	}
+}
+
+func TestBadPattern(t *testing.T) {
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello")})
+
+	_, err := EncryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), testutil.NewKeyMap("txt"), []string{dir}, Options{Exclude: []string{"sub/["}})
+	if !errors.Is(err, ErrBadPattern) {
+		t.Errorf("EncryptWithOptions: err = %v, want ErrBadPattern", err)
+	}
+	assertTree(t, dir, map[string][]byte{"a.txt": []byte("hello")})
+}
@@ -0,0 +1,34 @@
+package encryptdir
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// sentinel error used for when a file recorded the fingerprint of an AES key and none of the keys given has it
+var ErrKeyFingerprint = errors.New("file was encrypted with another AES key")
+
+// encryptdir.KeyFingerprint: what `Options.KeyFingerprint` records in the file header for the AES key `key`,
+// the first `KeyIDSize` bytes of its SHA-256, with a prefix so it isnt the plain hash of the key
+func KeyFingerprint(key []byte) []byte {
+	sum := sha256.Sum256(append([]byte("encryptdir key id\x00"), key...))
+	return sum[:KeyIDSize]
+}
+
+// encryptdir.matchesKeyID: if `key` can be the key of a file with `header`, any key can for a file that didnt record a fingerprint
+func matchesKeyID(header *FileHeader, key []byte) bool {
+	return header == nil || header.KeyID == nil || bytes.Equal(KeyFingerprint(key), header.KeyID)
+}
+
+// encryptdir.RequiredKeyFingerprint: the `KeyFingerprint` of the AES key the file at `path` needs, from its header alone
+// compare it with `KeyFingerprint` of each key to tell which one decrypts the file, nothing is verified or decrypted
+// returns: fingerprint, nil for a file that didnt record one, or error like `ReadHeader`
+func RequiredKeyFingerprint(path string) ([]byte, error) {
+	header, err := ReadHeader(path)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.RequiredKeyFingerprint: %w", err)
+	}
+	return header.KeyID, nil
+}
@@ -0,0 +1,94 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestKeyFingerprintRecorded(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello")})
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{KeyFingerprint: true})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+
+	header, err := ReadHeader(filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatalf("ReadHeader: %v", err)
+	}
+	if !bytes.Equal(header.KeyID, KeyFingerprint(keyMap["txt"])) {
+		t.Errorf("ReadHeader: key id = %x, want %x", header.KeyID, KeyFingerprint(keyMap["txt"]))
+	}
+}
+
+func TestKeyFingerprintOff(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	dir := encryptSigned(t, map[string][]byte{"a.txt": []byte("hello")}, keyMap, false)
+
+	header, err := ReadHeader(filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatalf("ReadHeader: %v", err)
+	}
+	if header.KeyID != nil {
+		t.Errorf("ReadHeader: key id = %x, want nil", header.KeyID)
+	}
+}
+
+func TestKeyFingerprintWrongKey(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	oldKeys := testutil.NewKeyMap("txt")
+	newKeys := map[string][]byte{"txt": testutil.NewTestKey("new txt")}
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, oldKeys, []string{dir}, Options{KeyFingerprint: true})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+
+	// another key fails the files by their fingerprint instead of skipping them
+	_, err = decryptBytes(t, newKeys, filepath.Join(dir, "a.txt"), Options{})
+	if !errors.Is(err, ErrKeyFingerprint) {
+		t.Fatalf("decryptTo: err = %v, want ErrKeyFingerprint", err)
+	}
+	stats, err := DecryptWithOptions(context.Background(), nil, privKey, newKeys, []string{dir}, Options{})
+	if !errors.Is(err, ErrKeyFingerprint) || stats.Failed != 2 {
+		t.Fatalf("DecryptWithOptions: failed = %d, err = %v, want 2 and ErrKeyFingerprint", stats.Failed, err)
+	}
+
+	// the keyring key with the fingerprint is the one used
+	_, err = DecryptWithOptions(context.Background(), nil, privKey, newKeys, []string{dir}, Options{Keyring: [][]byte{newKeys["txt"], oldKeys["txt"]}})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	assertTree(t, dir, spec)
+}
+
+func TestRequiredKeyFingerprint(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := map[string][]byte{"txt": testutil.NewTestKey("txt"), "sql": testutil.NewTestKey("sql")}
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello"), "b.sql": []byte("select 1")})
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{KeyFingerprint: true})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+
+	for ext, name := range map[string]string{"txt": "a.txt", "sql": "b.sql"} {
+		got, err := RequiredKeyFingerprint(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("RequiredKeyFingerprint: %v", err)
+		}
+		if !bytes.Equal(got, KeyFingerprint(keyMap[ext])) {
+			t.Errorf("RequiredKeyFingerprint(%s) = %x, want %x", name, got, KeyFingerprint(keyMap[ext]))
+		}
+	}
+}
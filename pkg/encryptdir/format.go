@@ -0,0 +1,314 @@
+package encryptdir
+
+import (
+	goaes "crypto/aes"
+	"crypto/sha512"
+
+	"github.com/prairir/encryptdir/pkg/aes"
+)
+
+// FormatVersion: version of the on-disk format written by `encryptWalk`
+// version 1 files have no file header and start with the signature, the walkers only decrypt them with `Options.LegacyFormat` and never skip encrypting one
+// version 1 files have an unauthenticated AES-CTR payload, they are still decrypted
+// files of a newer version fail with `ErrUnsupportedVersion` and are left as they are
+const FormatVersion = 2
+
+// on-disk layout of an encrypted file, offsets are in bytes from the start of the file
+//
+//	[magic][version][mode][ext length][ext][kdf][iterations][salt length][salt][compression][hash][signed][integrity hash][integrity][key id][metadata length][metadata][signature][plaintext size][chunk size][nonce][chunk]...
+//
+// the file header is `FileMagic`, the version as a byte, the original permission bits as a little endian uint32,
+// and the original extension without the dot, zero padded to `ExtSize` bytes after its length as a byte
+// kdf is `KDFPBKDF2SHA256` if the key was derived from a passphrase and `KDFNone` if not,
+// iterations a little endian uint32 and salt zero padded to `SaltSize` bytes after its length as a byte, both zero without a kdf
+// compression is `CompressionGzip` if the plaintext was gzipped before it was encrypted and `CompressionNone` if not
+// hash is `HashSHA256`, `HashSHA512`, or `HashMD5`, the hash the signature was written with, which is the only one it is verified with
+// signed is `SignedHeader` if the signature is of the AES key followed by the whole file header, so changing any field of it fails the file, and `SignedKey` if it is of the AES key alone
+// integrity hash is `HashSHA256`, `HashSHA512`, or `HashMD5` if the file recorded an integrity digest and 0 if not,
+// integrity is the HMAC of the original plaintext, before it was compressed, keyed with the AES key, zero padded to `IntegritySize` bytes
+// key id is the `KeyFingerprint` of the AES key if the file recorded it and all zero if not, decrypting only tries a key with that fingerprint
+// metadata length is a little endian uint16, the length of metadata, `Options.Metadata` as a JSON object, right after the fixed part of the file header,
+// at most `MaxMetadataSize` bytes and none if the length is 0, every offset from the signature on is shifted by its length
+// signature is the RSA PKCS#1 v1.5 signature of the AES key, or of it and the file header, as long as the RSA modulus, the offsets after it are for 2048 bit keys and shifted by the difference for others
+// everything after the signature is `aes.EncryptGCM` output, the plaintext sealed with AES-GCM a chunk at a time
+// plaintext size is a little endian uint64, chunk size a little endian uint32, both of the gzipped plaintext if it was compressed
+// an empty plaintext is sealed as a single empty chunk, so an empty file still gets a file header and signature, its tag is authenticated,
+// and it decrypts back to an empty file, streamed or not, a zero plaintext size with no chunk after it is corrupt rather than empty
+// if `Options.Banner` is set, the banner line comes first and every offset is shifted by its length
+// version 1 files are `[signature][plaintext size][IV][ciphertext]` without a file header, the signature is of the AES key alone and its hash isnt recorded,
+// it is one of md5, sha256, or sha512 and they are verified with each, a 16 byte AES-CTR IV takes the place of the chunk size and nonce,
+// and the ciphertext is padded with random bytes to a multiple of the AES block size
+const (
+	MagicOffset = 0
+	MagicSize   = 4
+
+	VersionOffset = MagicOffset + MagicSize
+	VersionSize   = 1
+
+	ModeOffset = VersionOffset + VersionSize
+	ModeSize   = 4
+
+	ExtLenOffset = ModeOffset + ModeSize
+	ExtLenSize   = 1
+
+	ExtOffset = ExtLenOffset + ExtLenSize
+	ExtSize   = 32
+
+	KDFOffset = ExtOffset + ExtSize
+	KDFSize   = 1
+
+	IterationsOffset = KDFOffset + KDFSize
+	IterationsSize   = 4
+
+	SaltLenOffset = IterationsOffset + IterationsSize
+	SaltLenSize   = 1
+
+	SaltOffset = SaltLenOffset + SaltLenSize
+	SaltSize   = aes.MaxSaltSize
+
+	CompressionOffset = SaltOffset + SaltSize
+	CompressionSize   = 1
+
+	HashOffset = CompressionOffset + CompressionSize
+	HashSize   = 1
+
+	SignedOffset = HashOffset + HashSize
+	SignedSize   = 1
+
+	IntegrityHashOffset = SignedOffset + SignedSize
+	IntegrityHashSize   = 1
+
+	IntegrityOffset = IntegrityHashOffset + IntegrityHashSize
+	IntegritySize   = sha512.Size
+
+	KeyIDOffset = IntegrityOffset + IntegritySize
+	KeyIDSize   = 8
+
+	MetadataLenOffset = KeyIDOffset + KeyIDSize
+	MetadataLenSize   = 2
+
+	// the fixed part of the file header, the metadata follows it
+	FileHeaderSize = MetadataLenOffset + MetadataLenSize
+
+	SignatureOffset = FileHeaderSize
+	SignatureSize   = aes.SIGNATURE_SIZE
+
+	PlaintextSizeOffset = SignatureOffset + SignatureSize
+	PlaintextSizeSize   = 8
+
+	ChunkSizeOffset = PlaintextSizeOffset + PlaintextSizeSize
+	ChunkSizeSize   = 4
+
+	NonceOffset = ChunkSizeOffset + ChunkSizeSize
+	NonceSize   = aes.GCMNonceSize
+
+	CiphertextOffset = NonceOffset + NonceSize
+)
+
+// what the kdf field of the file header holds
+const (
+	KDFNone         = 0
+	KDFPBKDF2SHA256 = 1
+)
+
+// what the compression field of the file header holds
+const (
+	CompressionNone = 0
+	CompressionGzip = 1
+)
+
+// what the hash field of the file header holds
+const (
+	HashMD5    = 1
+	HashSHA256 = 2
+	HashSHA512 = 3
+)
+
+// what the signed field of the file header holds
+const (
+	SignedKey    = 0
+	SignedHeader = 1
+)
+
+// size of the AES-CTR IV of version 1 files, it takes up the same bytes as the chunk size and nonce
+const LegacyIVSize = goaes.BlockSize
+
+// FormatField: a single field of the on-disk format
+// Size is -1 when the field runs to the end of the file
+type FormatField struct {
+	Name        string `json:"name"`
+	Offset      int    `json:"offset"`
+	Size        int    `json:"size"`
+	Encoding    string `json:"encoding"`
+	Description string `json:"description"`
+}
+
+// Format: machine readable description of the on-disk format
+type Format struct {
+	Version int           `json:"version"`
+	Fields  []FormatField `json:"fields"`
+}
+
+// encryptdir.FormatSpec: describes the on-disk format for `FormatVersion`
+// returns: format description, can be serialized with `encoding/json`
+func FormatSpec() Format {
+	return Format{
+		Version: FormatVersion,
+		Fields: []FormatField{
+			{
+				Name:        "magic",
+				Offset:      MagicOffset,
+				Size:        MagicSize,
+				Encoding:    "ascii",
+				Description: "always " + FileMagic + ", marks the file as written by encryptdir",
+			},
+			{
+				Name:        "version",
+				Offset:      VersionOffset,
+				Size:        VersionSize,
+				Encoding:    "uint8",
+				Description: "format version the file was written with",
+			},
+			{
+				Name:        "mode",
+				Offset:      ModeOffset,
+				Size:        ModeSize,
+				Encoding:    "uint32-le",
+				Description: "permission bits of the original file, restored when decrypting",
+			},
+			{
+				Name:        "ext_length",
+				Offset:      ExtLenOffset,
+				Size:        ExtLenSize,
+				Encoding:    "uint8",
+				Description: "length of ext, 0 if the extension didnt fit",
+			},
+			{
+				Name:        "ext",
+				Offset:      ExtOffset,
+				Size:        ExtSize,
+				Encoding:    "utf-8",
+				Description: "extension of the original file without the dot, zero padded, picks the key when decrypting",
+			},
+			{
+				Name:        "kdf",
+				Offset:      KDFOffset,
+				Size:        KDFSize,
+				Encoding:    "uint8",
+				Description: "1 if the AES key was derived from a passphrase with PBKDF2-HMAC-SHA256, 0 if not",
+			},
+			{
+				Name:        "iterations",
+				Offset:      IterationsOffset,
+				Size:        IterationsSize,
+				Encoding:    "uint32-le",
+				Description: "PBKDF2 iterations of the key, 0 without a kdf",
+			},
+			{
+				Name:        "salt_length",
+				Offset:      SaltLenOffset,
+				Size:        SaltLenSize,
+				Encoding:    "uint8",
+				Description: "length of salt, 0 without a kdf",
+			},
+			{
+				Name:        "salt",
+				Offset:      SaltOffset,
+				Size:        SaltSize,
+				Encoding:    "raw",
+				Description: "PBKDF2 salt of the key, zero padded, lets decrypting derive the key again from the passphrase",
+			},
+			{
+				Name:        "compression",
+				Offset:      CompressionOffset,
+				Size:        CompressionSize,
+				Encoding:    "uint8",
+				Description: "1 if the plaintext was gzipped before it was encrypted, 0 if not, decrypting gunzips it again",
+			},
+			{
+				Name:        "hash",
+				Offset:      HashOffset,
+				Size:        HashSize,
+				Encoding:    "uint8",
+				Description: "hash of the signature, 1 for md5, 2 for sha256, 3 for sha512, the signature is only verified with it",
+			},
+			{
+				Name:        "signed",
+				Offset:      SignedOffset,
+				Size:        SignedSize,
+				Encoding:    "uint8",
+				Description: "1 if the signature is of the AES key followed by the file header, 0 if it is of the AES key alone",
+			},
+			{
+				Name:        "integrity_hash",
+				Offset:      IntegrityHashOffset,
+				Size:        IntegrityHashSize,
+				Encoding:    "uint8",
+				Description: "hash of the integrity digest, 1 for md5, 2 for sha256, 3 for sha512, 0 if there is none",
+			},
+			{
+				Name:        "integrity",
+				Offset:      IntegrityOffset,
+				Size:        IntegritySize,
+				Encoding:    "bytes",
+				Description: "HMAC of the original plaintext keyed with the AES key, checked after decrypting and gunzipping it, zero padded",
+			},
+			{
+				Name:        "key_id",
+				Offset:      KeyIDOffset,
+				Size:        KeyIDSize,
+				Encoding:    "bytes",
+				Description: "truncated SHA-256 fingerprint of the AES key, only a key with it is tried when decrypting, all zero if there is none",
+			},
+			{
+				Name:        "metadata_length",
+				Offset:      MetadataLenOffset,
+				Size:        MetadataLenSize,
+				Encoding:    "uint16-le",
+				Description: "length of metadata, 0 if there is none, every later offset is shifted by it",
+			},
+			{
+				Name:        "metadata",
+				Offset:      FileHeaderSize,
+				Size:        0,
+				Encoding:    "json",
+				Description: "user metadata as a JSON object of strings, metadata_length bytes, readable without the keys, the size and later offsets are for none",
+			},
+			{
+				Name:        "signature",
+				Offset:      SignatureOffset,
+				Size:        SignatureSize,
+				Encoding:    "rsa-pkcs1v15",
+				Description: "RSA signature of the AES key, or of it and the file header, with the hash, marks the file as encrypted, as long as the RSA modulus, the size and later offsets are for 2048 bit keys",
+			},
+			{
+				Name:        "plaintext_size",
+				Offset:      PlaintextSizeOffset,
+				Size:        PlaintextSizeSize,
+				Encoding:    "uint64-le",
+				Description: "size of the plaintext in bytes, after gzip if it was compressed",
+			},
+			{
+				Name:        "chunk_size",
+				Offset:      ChunkSizeOffset,
+				Size:        ChunkSizeSize,
+				Encoding:    "uint32-le",
+				Description: "plaintext bytes sealed per chunk, the last chunk can be shorter",
+			},
+			{
+				Name:        "nonce",
+				Offset:      NonceOffset,
+				Size:        NonceSize,
+				Encoding:    "raw",
+				Description: "AES-GCM nonce, chunk n is sealed with n xored into its last 4 bytes",
+			},
+			{
+				Name:        "ciphertext",
+				Offset:      CiphertextOffset,
+				Size:        -1,
+				Encoding:    "aes-gcm",
+				Description: "AES-GCM chunks each followed by their 16 byte tag, the plaintext and chunk sizes are the additional data of every chunk",
+			},
+		},
+	}
+}
@@ -0,0 +1,252 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	gorsa "crypto/rsa"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/aes"
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+// formatField: the field of `FormatSpec` named `name`
+func formatField(t *testing.T, name string) FormatField {
+	t.Helper()
+	for _, f := range FormatSpec().Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	t.Fatalf("FormatSpec: no field %q", name)
+	return FormatField{}
+}
+
+// fieldBytes: the bytes of the field of `FormatSpec` named `name` in `contents`
+func fieldBytes(t *testing.T, contents []byte, name string) []byte {
+	t.Helper()
+	f := formatField(t, name)
+	if f.Size < 0 {
+		return contents[f.Offset:]
+	}
+	return contents[f.Offset : f.Offset+f.Size]
+}
+
+func TestFormatSpecLayout(t *testing.T) {
+	spec := FormatSpec()
+	if spec.Version != FormatVersion {
+		t.Errorf("FormatSpec: version = %d, want %d", spec.Version, FormatVersion)
+	}
+
+	// the fields tile the file from the magic to the ciphertext, which runs to the end
+	offset := 0
+	for i, f := range spec.Fields {
+		if f.Offset != offset {
+			t.Errorf("field %q: offset = %d, want %d", f.Name, f.Offset, offset)
+		}
+		if f.Size < 0 && i != len(spec.Fields)-1 {
+			t.Errorf("field %q: runs to the end but isnt last", f.Name)
+		}
+		offset = f.Offset + f.Size
+	}
+
+	_, err := json.Marshal(spec)
+	if err != nil {
+		t.Errorf("json.Marshal: %v", err)
+	}
+}
+
+func TestFormatSpecMatchesEncoder(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	plain := []byte("hello format")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": plain})
+	path := filepath.Join(dir, "a.txt")
+	err := os.Chmod(path, 0640)
+	if err != nil {
+		t.Fatalf("os.Chmod: %v", err)
+	}
+
+	opts := Options{HashAlgo: crypto.SHA256, SignHeader: true, IntegrityHash: crypto.SHA256, KeyFingerprint: true}
+	_, err = EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("os.ReadFile: %v", err)
+	}
+	key := keyMap["txt"]
+
+	mac := newIntegrity(crypto.SHA256, key)
+	mac.Write(plain)
+	ext := make([]byte, ExtSize)
+	copy(ext, "txt")
+	header, _ := parseFileHeader(contents)
+
+	// every field is checked against what it should hold, so a field added to the spec has to be added here too
+	checks := map[string]func(b []byte) bool{
+		"magic":           func(b []byte) bool { return string(b) == FileMagic },
+		"version":         func(b []byte) bool { return int(b[0]) == FormatVersion },
+		"mode":            func(b []byte) bool { return binary.LittleEndian.Uint32(b) == 0640 },
+		"ext_length":      func(b []byte) bool { return b[0] == 3 },
+		"ext":             func(b []byte) bool { return bytes.Equal(b, ext) },
+		"kdf":             func(b []byte) bool { return b[0] == KDFNone },
+		"iterations":      func(b []byte) bool { return binary.LittleEndian.Uint32(b) == 0 },
+		"salt_length":     func(b []byte) bool { return b[0] == 0 },
+		"salt":            func(b []byte) bool { return bytes.Equal(b, make([]byte, SaltSize)) },
+		"compression":     func(b []byte) bool { return b[0] == CompressionNone },
+		"hash":            func(b []byte) bool { return b[0] == HashSHA256 },
+		"signed":          func(b []byte) bool { return b[0] == SignedHeader },
+		"integrity_hash":  func(b []byte) bool { return b[0] == HashSHA256 },
+		"integrity":       func(b []byte) bool { return hmac.Equal(b[:mac.Size()], mac.Sum(nil)) },
+		"key_id":          func(b []byte) bool { return bytes.Equal(b, KeyFingerprint(key)) },
+		"metadata_length": func(b []byte) bool { return binary.LittleEndian.Uint16(b) == 0 },
+		"metadata":        func(b []byte) bool { return len(b) == 0 },
+		"signature": func(b []byte) bool {
+			return verifyKey(&privKey.PublicKey, b, key, &header, Options{}) == nil
+		},
+		"plaintext_size": func(b []byte) bool { return binary.LittleEndian.Uint64(b) == uint64(len(plain)) },
+		"chunk_size":     func(b []byte) bool { return binary.LittleEndian.Uint32(b) == DefaultStreamChunkSize },
+		"nonce":          func(b []byte) bool { return !bytes.Equal(b, make([]byte, NonceSize)) },
+		"ciphertext":     func(b []byte) bool { return len(b) == len(plain)+aes.GCMTagSize },
+	}
+	for _, f := range FormatSpec().Fields {
+		check, ok := checks[f.Name]
+		if !ok {
+			t.Errorf("field %q: not checked", f.Name)
+			continue
+		}
+		if !check(fieldBytes(t, contents, f.Name)) {
+			t.Errorf("field %q: offset = %d, size = %d: got %x", f.Name, f.Offset, f.Size, fieldBytes(t, contents, f.Name))
+		}
+	}
+}
+
+func TestFormatSpecKDF(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello")})
+
+	opts := Options{Passphrases: map[string]string{"txt": "correct horse"}, KDF: aes.KDFParams{Iterations: 1000}}
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, map[string][]byte{}, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	contents, err := os.ReadFile(filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatalf("os.ReadFile: %v", err)
+	}
+
+	if b := fieldBytes(t, contents, "kdf"); b[0] != KDFPBKDF2SHA256 {
+		t.Errorf("kdf = %d, want %d", b[0], KDFPBKDF2SHA256)
+	}
+	if b := fieldBytes(t, contents, "iterations"); binary.LittleEndian.Uint32(b) != 1000 {
+		t.Errorf("iterations = %d, want 1000", binary.LittleEndian.Uint32(b))
+	}
+	saltLen := int(fieldBytes(t, contents, "salt_length")[0])
+	salt := fieldBytes(t, contents, "salt")
+	if saltLen == 0 || saltLen > SaltSize || bytes.Equal(salt[:saltLen], make([]byte, saltLen)) || !bytes.Equal(salt[saltLen:], make([]byte, SaltSize-saltLen)) {
+		t.Errorf("salt = %x, length %d: want a zero padded salt", salt, saltLen)
+	}
+}
+
+func TestFormatSpecMetadataShift(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello")})
+	metadata := map[string]string{"owner": "ops"}
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{Metadata: metadata})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	contents, err := os.ReadFile(filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatalf("os.ReadFile: %v", err)
+	}
+
+	// the metadata takes up metadata_length bytes and everything after it is shifted by as many
+	want, _ := json.Marshal(metadata)
+	metaLen := int(binary.LittleEndian.Uint16(fieldBytes(t, contents, "metadata_length")))
+	start := formatField(t, "metadata").Offset
+	if metaLen != len(want) || !bytes.Equal(contents[start:start+metaLen], want) {
+		t.Errorf("metadata = %q, length %d, want %q", contents[start:start+metaLen], metaLen, want)
+	}
+	shifted := contents[metaLen:]
+	if got := binary.LittleEndian.Uint64(fieldBytes(t, shifted, "plaintext_size")); got != 5 {
+		t.Errorf("plaintext_size after the metadata = %d, want 5", got)
+	}
+}
+
+// legacyFile: `contents`, a file written by `Encrypt` with the default options, as a version 1 file
+// its signature of the AES key alone followed by `plain` sealed with AES-CTR, without a file header
+func legacyFile(t *testing.T, privKey *gorsa.PrivateKey, key []byte, plain []byte, contents []byte) []byte {
+	t.Helper()
+
+	payload, err := aes.Encrypt(key, plain)
+	if err != nil {
+		t.Fatalf("aes.Encrypt: %v", err)
+	}
+	return append(append([]byte(nil), contents[SignatureOffset:SignatureOffset+signatureSize(&privKey.PublicKey)]...), payload...)
+}
+
+func TestDecryptOlderVersions(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	plain := []byte("written before the format moved on")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": plain})
+	err := Encrypt(nil, privKey, keyMap, []string{dir})
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	current, err := os.ReadFile(filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatalf("os.ReadFile: %v", err)
+	}
+
+	t.Run("version 1", func(t *testing.T) {
+		spec := map[string][]byte{"a.txt": legacyFile(t, privKey, keyMap["txt"], plain, current)}
+		dir, _ := testutil.BuildTree(t, spec)
+
+		// version 1 files have no magic, they are only looked for with `LegacyFormat`
+		report, err := DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{LegacyFormat: true})
+		if err != nil || report.Processed != 1 {
+			t.Fatalf("DecryptWithOptions = processed %d, %v, want 1", report.Processed, err)
+		}
+		assertTree(t, dir, map[string][]byte{"a.txt": plain})
+	})
+
+	// a file header with the magic is only ever `FormatVersion`, version 1 files have none
+	t.Run("version 1 header", func(t *testing.T) {
+		older := append([]byte(nil), current...)
+		older[VersionOffset] = 1
+		spec := map[string][]byte{"a.txt": older}
+		dir, _ := testutil.BuildTree(t, spec)
+
+		_, err := DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+		if !errors.Is(err, ErrInvalidHeader) || errors.Is(err, ErrUnsupportedVersion) {
+			t.Errorf("DecryptWithOptions: err = %v, want ErrInvalidHeader", err)
+		}
+		assertTree(t, dir, spec)
+	})
+
+	t.Run("newer version", func(t *testing.T) {
+		newer := append([]byte(nil), current...)
+		newer[VersionOffset] = FormatVersion + 1
+		spec := map[string][]byte{"a.txt": newer}
+		dir, _ := testutil.BuildTree(t, spec)
+
+		_, err := DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+		if !errors.Is(err, ErrUnsupportedVersion) {
+			t.Errorf("DecryptWithOptions: err = %v, want ErrUnsupportedVersion", err)
+		}
+		assertTree(t, dir, spec)
+	})
+}
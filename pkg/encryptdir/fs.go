@@ -0,0 +1,79 @@
+package encryptdir
+
+import (
+	"bytes"
+	gorsa "crypto/rsa"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// encryptdir.DecryptingFS: read only view of the tree at `root` where encrypted files read as their plaintext
+// files are decrypted in memory on `Open`, nothing on disk is changed
+// files that aren't encrypted, or have no key in `keyMap`, are read as is
+// directory listings report the on-disk size, `Stat` on an opened file reports the plaintext size
+func DecryptingFS(root string, privKey *gorsa.PrivateKey, keyMap map[string][]byte) fs.FS {
+	return decryptingFS{
+		fsys:    os.DirFS(root),
+		privKey: privKey,
+		keyMap:  keyMap,
+	}
+}
+
+type decryptingFS struct {
+	fsys    fs.FS
+	privKey *gorsa.PrivateKey
+	keyMap  map[string][]byte
+}
+
+func (d decryptingFS) Open(name string) (fs.File, error) {
+	f, err := d.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	key, ok := lookupKey(d.keyMap, name)
+	if info.IsDir() || !ok {
+		return f, nil
+	}
+	defer f.Close()
+
+	contents, err := io.ReadAll(f)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	contents, err = plaintext(&d.privKey.PublicKey, key, contents)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return &decryptedFile{
+		Reader: bytes.NewReader(contents),
+		info:   sizedFileInfo{FileInfo: info, size: int64(len(contents))},
+	}, nil
+}
+
+// decryptedFile: in memory plaintext of an encrypted file
+type decryptedFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (f *decryptedFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *decryptedFile) Close() error { return nil }
+
+// sizedFileInfo: `fs.FileInfo` with the size replaced by the plaintext size
+type sizedFileInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i sizedFileInfo) Size() int64 { return i.size }
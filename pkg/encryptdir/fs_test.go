@@ -0,0 +1,84 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestDecryptingFS(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{
+		"a.txt":     []byte("hello"),
+		"sub/b.txt": []byte("world"),
+		"c.md":      []byte("no key"),
+	}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	encrypted := readTree(t, dir)
+
+	fsys := DecryptingFS(dir, privKey, keyMap)
+	for name, want := range spec {
+		got, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Fatalf("fs.ReadFile(%q): %v", name, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("fs.ReadFile(%q) = %q, want %q", name, got, want)
+		}
+	}
+
+	info, err := fs.Stat(fsys, "a.txt")
+	if err != nil {
+		t.Fatalf("fs.Stat: %v", err)
+	}
+	if info.Size() != int64(len(spec["a.txt"])) {
+		t.Errorf("fs.Stat(a.txt).Size() = %d, want %d", info.Size(), len(spec["a.txt"]))
+	}
+
+	entries, err := fs.ReadDir(fsys, "sub")
+	if err != nil {
+		t.Fatalf("fs.ReadDir: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "b.txt" {
+		t.Errorf("fs.ReadDir(sub) = %v, want just b.txt", entries)
+	}
+
+	// reading through the fs doesnt decrypt anything on disk
+	assertTree(t, dir, encrypted)
+}
+
+func TestDecryptingFSOtherKey(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello")})
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+
+	// a file whose signature doesnt verify with the AES key isnt one it encrypted, so it reads as is like a walk would skip it
+	otherKeyMap := map[string][]byte{"txt": testutil.NewTestKey("other")}
+	got, err := fs.ReadFile(DecryptingFS(dir, privKey, otherKeyMap), "a.txt")
+	if err != nil {
+		t.Fatalf("fs.ReadFile with another AES key: %v", err)
+	}
+	if want := readTree(t, dir)["a.txt"]; !bytes.Equal(got, want) {
+		t.Errorf("fs.ReadFile with another AES key = %q, want the ciphertext on disk", got)
+	}
+
+	_, err = fs.ReadFile(DecryptingFS(dir, privKey, keyMap), "missing.txt")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("fs.ReadFile(missing.txt): err = %v, want %v", err, fs.ErrNotExist)
+	}
+}
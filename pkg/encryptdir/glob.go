@@ -0,0 +1,35 @@
+package encryptdir
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// sentinel error used for when a glob pattern in the directories matches nothing
+var ErrNoGlobMatch = errors.New("pattern matches no directories")
+
+// encryptdir.expandDirs: expands every glob pattern in `dirs` with `filepath.Glob`, paths without glob characters are kept as is
+// returns: directories in order, or error wrapping `ErrNoGlobMatch` for the first pattern that matches nothing
+func expandDirs(dirs []string) ([]string, error) {
+	var expanded []string
+	for _, dir := range dirs {
+		if !strings.ContainsAny(dir, "*?[") {
+			expanded = append(expanded, dir)
+			continue
+		}
+
+		matches, err := filepath.Glob(dir)
+		if err != nil {
+			return nil, fmt.Errorf("encryptdir.expandDirs: filepath.Glob: pattern = %q: %w", dir, err)
+		}
+
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("encryptdir.expandDirs: pattern = %q: %w", dir, ErrNoGlobMatch)
+		}
+		expanded = append(expanded, matches...)
+	}
+
+	return expanded, nil
+}
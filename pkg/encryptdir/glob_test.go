@@ -0,0 +1,54 @@
+package encryptdir
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestEncryptGlob(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{
+		"one/secrets/a.txt":  []byte("hello"),
+		"two/secrets/b.txt":  []byte("world"),
+		"three/public/c.txt": []byte("not matched"),
+	}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	// matches one and two, not three
+	pattern := filepath.Join(dir, "*", "secrets")
+	err := Encrypt(nil, privKey, keyMap, []string{pattern})
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	got := readTree(t, dir)
+	for rel, plain := range spec {
+		encrypted := string(got[rel]) != string(plain)
+		if want := rel != "three/public/c.txt"; encrypted != want {
+			t.Errorf("path = %q: encrypted = %t, want %t", rel, encrypted, want)
+		}
+	}
+
+	err = Decrypt(nil, privKey, keyMap, []string{pattern})
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	assertTree(t, dir, spec)
+}
+
+func TestEncryptGlobNoMatch(t *testing.T) {
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"one/a.txt": []byte("hello")})
+	pattern := filepath.Join(dir, "*", "secrets")
+
+	err := Encrypt(nil, testutil.NewPrivateKey(t), testutil.NewKeyMap("txt"), []string{dir, pattern})
+	if !errors.Is(err, ErrNoGlobMatch) {
+		t.Fatalf("Encrypt: err = %v, want ErrNoGlobMatch", err)
+	}
+
+	// nothing is walked, not even the directory before the pattern
+	assertTree(t, dir, map[string][]byte{"one/a.txt": []byte("hello")})
+}
@@ -0,0 +1,181 @@
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/iafan/cwalk"
+	"golang.org/x/sync/errgroup"
+)
+
+// sentinel error used for when a run with `Options.RequireMatches` finished without processing a single file
+var ErrNoMatchingFiles = errors.New("no files matched")
+
+// encryptdir.dedupeDirs: `directories` as absolute paths, without the ones that are the same as or under another
+// walks of overlapping roots would race on the same files, the temp file of one makes the other skip it or fail
+// `Options.Include` and `Options.Exclude` are matched relative to the root that is kept
+// returns: directories in the order they were given, or error
+func dedupeDirs(directories []string) ([]string, error) {
+	abs := make([]string, len(directories))
+	for i, dir := range directories {
+		a, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, fmt.Errorf("encryptdir.dedupeDirs: filepath.Abs: %w", err)
+		}
+		abs[i] = a
+	}
+
+	var deduped []string
+	for i, dir := range abs {
+		covered := false
+		for j, other := range abs {
+			// of two that are the same the first is kept
+			if i == j || (dir == other && j > i) {
+				continue
+			}
+			if within(other, dir) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			deduped = append(deduped, dir)
+		}
+	}
+	return deduped, nil
+}
+
+// encryptdir.walkRoots: walks every one of `directories` in its own goroutine of an errgroup, with a copy of `walker` for it and the walk func `walkFunc` picks
+// with `Options.FailFast` the first file or root that fails cancels the walks of every root, files in flight finish and no new ones start
+// otherwise every root is walked to the end and nothing is canceled but `ctx`, and the roots canceled with `Options.RootCancels`
+// returns: every file and root error of every root, in the order of `directories`, with pruned dirs left out and `ErrRootCanceled` for the roots canceled on their own,
+// or `ErrNoMatchingFiles` with `Options.RequireMatches` if there were none and no file was processed
+func walkRoots(ctx context.Context, directories []string, walker Walker, walkFunc func(w Walker) filepath.WalkFunc) []error {
+	opts := walker.opts
+
+	g, gctx := errgroup.WithContext(ctx)
+
+	// only canceled by a file failing with `Options.FailFast`, the errgroup cancels `gctx` itself when a root fails
+	walkCtx, cancel := context.WithCancel(gctx)
+	defer cancel()
+	if !opts.FailFast {
+		cancel = nil
+	}
+
+	// one slot per root, so no root waits on another to hand off its errors
+	rootErrs := make([][]error, len(directories))
+	processed := make([]int, len(directories))
+	for i, dir := range directories {
+		i, dir := i, dir
+		rootCtx, release := opts.RootCancels.start(walkCtx, dir)
+		w := walker.forRoot(rootCtx, cancel, dir)
+		w.workers = newWorkerIDs(opts.DebugWorkers, i)
+		g.Go(func() error {
+			defer release()
+
+			if opts.Hooks.OnRootStart != nil {
+				opts.Hooks.OnRootStart(dir)
+			}
+
+			walk := cwalk.Walk
+			if opts.paths != nil {
+				walk = func(root string, walkFn filepath.WalkFunc) error {
+					return listedWalk(root, opts.paths, walkFn)
+				}
+			} else if opts.Shuffle {
+				walk = func(root string, walkFn filepath.WalkFunc) error {
+					return shuffledWalk(root, walkFn, opts.ContinueOnError)
+				}
+			}
+
+			err := walk(dir, walkFunc(w))
+			if err != nil {
+				err = fmt.Errorf("dir = %q: %w", dir, err)
+			}
+
+			if opts.Hooks.OnRootFinish != nil {
+				opts.Hooks.OnRootFinish(dir, w.stats.stats(), err)
+			}
+
+			errs := append(rootErrors(err), w.errs.list()...)
+			processed[i] = w.stats.stats().Processed
+
+			// only this root was canceled, it doesnt fail the others with `Options.FailFast`
+			if rootCtx.Err() != nil && walkCtx.Err() == nil {
+				rootErrs[i] = append(errs, fmt.Errorf("dir = %q: %w", dir, ErrRootCanceled))
+			} else {
+				rootErrs[i] = errs
+			}
+			if opts.FailFast && len(errs) > 0 {
+				return errs[0]
+			}
+			return nil
+		})
+	}
+
+	// every root error was kept in `rootErrs`, the errgroup only returns the first
+	_ = g.Wait()
+
+	var errList []error
+	total := 0
+	for i, errs := range rootErrs {
+		errList = append(errList, errs...)
+		total += processed[i]
+	}
+
+	// a run that failed or was canceled already says so
+	if opts.RequireMatches && total == 0 && len(errList) == 0 && ctx.Err() == nil {
+		errList = append(errList, ErrNoMatchingFiles)
+	}
+	return errList
+}
+
+// errorList: errors added from the cwalk workers of a root concurrently
+// a nil `*errorList` holds nothing
+type errorList struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+// encryptdir.errorList.add: adds `err`
+func (l *errorList) add(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errs = append(l.errs, err)
+}
+
+// encryptdir.errorList.list: every error added so far
+func (l *errorList) list() []error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]error(nil), l.errs...)
+}
+
+// encryptdir.rootErrors: the errors of a root from the error its walk returned, those of the walk funcs are in `Walker.errs` instead
+// returns: errors cwalk ran into itself, like a dir it couldnt read, with pruned dirs left out, or the root level failure, like the directory not existing, on its own
+func rootErrors(err error) []error {
+	if err == nil {
+		return nil
+	}
+
+	var eList cwalk.WalkerErrorList
+	if !errors.As(err, &eList) {
+		// root level failures arent a `cwalk.WalkerErrorList`
+		return []error{err}
+	}
+
+	var errList []error
+	for _, e := range eList.ErrorList {
+		if isPruned(e) {
+			continue
+		}
+		errList = append(errList, e)
+	}
+	return errList
+}
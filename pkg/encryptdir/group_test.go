@@ -0,0 +1,210 @@
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+// newerFile: the start of a file written by a newer encryptdir, encrypting it fails with `ErrUnsupportedVersion`
+var newerFile = append([]byte(FileMagic), byte(FormatVersion+1), 0, 0, 0, 0)
+
+// blockingRoots: two roots that each have a file that fails, the second one only starts walking once the first has finished
+// returns: the roots, and hooks holding the second back
+func blockingRoots(t *testing.T, spec map[string][]byte) ([]string, Hooks) {
+	t.Helper()
+
+	first, _ := testutil.BuildTree(t, spec)
+	second, _ := testutil.BuildTree(t, spec)
+
+	done := make(chan struct{})
+	hooks := Hooks{
+		OnRootStart: func(dir string) {
+			if dir == second {
+				<-done
+			}
+		},
+		OnRootFinish: func(dir string, _ Stats, _ error) {
+			if dir == first {
+				close(done)
+			}
+		},
+	}
+	return []string{first, second}, hooks
+}
+
+func TestWalkRootsCollectAll(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "b.txt": []byte("world"), "newer.txt": newerFile}
+	roots, hooks := blockingRoots(t, spec)
+
+	report, err := EncryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), keyMap, roots, Options{Hooks: hooks})
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("EncryptWithOptions: err = %v, want ErrUnsupportedVersion", err)
+	}
+
+	// every root is walked to the end, the failing file of each is in the report
+	if report.Processed != 4 || report.Failed != 2 {
+		t.Errorf("EncryptWithOptions: processed = %d, failed = %d, want 4 and 2", report.Processed, report.Failed)
+	}
+	for _, f := range report.Files {
+		if f.Status == FileFailed && !errors.Is(f.Err, ErrUnsupportedVersion) {
+			t.Errorf("path = %q: err = %v, want ErrUnsupportedVersion", f.Path, f.Err)
+		}
+	}
+}
+
+func TestWalkRootsFailFast(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "b.txt": []byte("world"), "newer.txt": newerFile}
+	roots, hooks := blockingRoots(t, spec)
+
+	report, err := EncryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), keyMap, roots, Options{Hooks: hooks, FailFast: true})
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("EncryptWithOptions: err = %v, want ErrUnsupportedVersion", err)
+	}
+
+	// the first root failing canceled the second before it started any file
+	assertTree(t, roots[1], spec)
+	if report.Failed != 1 {
+		t.Errorf("EncryptWithOptions: failed = %d, want 1", report.Failed)
+	}
+	for _, f := range report.Files {
+		if f.Status == FileFailed && !errors.Is(f.Err, ErrUnsupportedVersion) {
+			t.Errorf("path = %q: err = %v, want ErrUnsupportedVersion", f.Path, f.Err)
+		}
+	}
+}
+
+func TestWalkRootsMissingRoot(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+	dir, _ := testutil.BuildTree(t, spec)
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	// the root that is there is still walked
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{missing, dir}, Options{})
+	if !errors.Is(err, fs.ErrNotExist) || !strings.Contains(err.Error(), missing) {
+		t.Errorf("EncryptWithOptions: err = %v, want fs.ErrNotExist naming %q", err, missing)
+	}
+	if !strings.HasPrefix(err.Error(), "encryptdir.EncryptWithOptions: encryptdir.encryptDirectories: ") {
+		t.Errorf("EncryptWithOptions: err = %v, want it prefixed with encryptdir.encryptDirectories", err)
+	}
+
+	_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{missing, dir}, Options{})
+	if !errors.Is(err, fs.ErrNotExist) || !strings.Contains(err.Error(), missing) {
+		t.Errorf("DecryptWithOptions: err = %v, want fs.ErrNotExist naming %q", err, missing)
+	}
+	if !strings.HasPrefix(err.Error(), "encryptdir.DecryptWithOptions: encryptdir.decryptDirectories: ") {
+		t.Errorf("DecryptWithOptions: err = %v, want it prefixed with encryptdir.decryptDirectories", err)
+	}
+	assertTree(t, dir, spec)
+}
+
+func TestOverlappingRoots(t *testing.T) {
+	spec := map[string][]byte{"a.txt": []byte("a"), "sub/b.txt": []byte("b"), "sub/deep/c.txt": []byte("c"), "subway/d.txt": []byte("d")}
+	dir, _ := testutil.BuildTree(t, spec)
+	// the root, twice and spelled another way, and dirs under it
+	roots := []string{filepath.Join(dir, "sub"), dir, filepath.Join(dir, "sub", "..") + string(filepath.Separator), filepath.Join(dir, "sub", "deep"), dir}
+
+	// no lock of its own, the calls are serialized
+	seen := make(map[string]int)
+	opts := Options{Hooks: Hooks{OnProgress: func(path string, _, _ int) { seen[path]++ }}}
+	report, err := EncryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), testutil.NewKeyMap("txt"), roots, opts)
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+
+	if report.Processed != len(spec) || len(report.Files) != len(spec) {
+		t.Errorf("EncryptWithOptions: processed = %d of %d files, want %d", report.Processed, len(report.Files), len(spec))
+	}
+	if len(seen) != len(spec) {
+		t.Errorf("EncryptWithOptions: %d files processed, want %d", len(seen), len(spec))
+	}
+	for path, n := range seen {
+		if n != 1 {
+			t.Errorf("path = %q: processed %d times, want once", path, n)
+		}
+	}
+}
+
+func TestDedupeDirs(t *testing.T) {
+	root := t.TempDir()
+	a, ab, abc := filepath.Join(root, "a"), filepath.Join(root, "a", "b"), filepath.Join(root, "a", "bc")
+
+	// `a/bc` isnt under `a/b`, only under `a`
+	got, err := dedupeDirs([]string{ab, abc, a, ab})
+	if err != nil || !equalStrings(got, []string{a}) {
+		t.Errorf("dedupeDirs = %q, %v, want only %q", got, err, a)
+	}
+	got, err = dedupeDirs([]string{abc, ab, ab + string(filepath.Separator)})
+	if err != nil || !equalStrings(got, []string{abc, ab}) {
+		t.Errorf("dedupeDirs = %q, %v, want %q and %q", got, err, abc, ab)
+	}
+}
+
+func TestEmptyKeyMap(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	for name, keyMap := range map[string]map[string][]byte{"nil": nil, "empty": {}} {
+		for _, decrypt := range []bool{false, true} {
+			run := EncryptWithOptions
+			if decrypt {
+				run = DecryptWithOptions
+			}
+			_, err := run(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+			if !errors.Is(err, ErrEmptyKeyMap) {
+				t.Errorf("%s key map, decrypt = %t: err = %v, want ErrEmptyKeyMap", name, decrypt, err)
+			}
+		}
+	}
+	assertTree(t, dir, spec)
+
+	// a fallback key alone is enough
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, testutil.NewKeyMap(FallbackExt), []string{dir}, Options{})
+	if err != nil || report.Processed != 1 {
+		t.Errorf("EncryptWithOptions with only a fallback key = processed %d, %v, want 1", report.Processed, err)
+	}
+}
+
+func TestRequireMatches(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.md": []byte("no key"), "sub/b.csv": []byte("none either")})
+
+	for _, decrypt := range []bool{false, true} {
+		name, run := "EncryptWithOptions", EncryptWithOptions
+		if decrypt {
+			name, run = "DecryptWithOptions", DecryptWithOptions
+		}
+
+		_, err := run(context.Background(), nil, privKey, keyMap, []string{dir}, Options{RequireMatches: true})
+		if !errors.Is(err, ErrNoMatchingFiles) {
+			t.Errorf("%s: err = %v, want ErrNoMatchingFiles", name, err)
+		}
+		// not an error without it
+		_, err = run(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+		if err != nil {
+			t.Errorf("%s without RequireMatches: %v", name, err)
+		}
+	}
+
+	// a single file processed is enough
+	err := os.WriteFile(filepath.Join(dir, "c.txt"), []byte("matches"), 0644)
+	if err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{RequireMatches: true})
+	if err != nil || report.Processed != 1 {
+		t.Errorf("EncryptWithOptions with a match = processed %d, %v, want 1", report.Processed, err)
+	}
+}
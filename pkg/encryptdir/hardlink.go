@@ -0,0 +1,121 @@
+package encryptdir
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// inode: a file on disk however many paths link to it
+type inode struct {
+	dev uint64
+	ino uint64
+}
+
+// hardlinks: the first path walked of every file with several hard links, shared by every root and worker
+// a nil `*hardlinks` doesnt track any, every path is encrypted or decrypted on its own
+type hardlinks struct {
+	mu      sync.Mutex
+	leaders map[inode]*linkLeader
+}
+
+// linkLeader: the path of a hard linked file that is encrypted or decrypted, the other paths of it are linked to its output
+// a nil `*linkLeader` has no other paths waiting on it
+type linkLeader struct {
+	path string
+	once sync.Once
+	done chan struct{}
+	// set before `done` is closed
+	ok bool
+}
+
+// encryptdir.Options.preservesHardlinks: if `PreserveHardlinks` applies, only files replaced in place are relinked
+func (o Options) preservesHardlinks() bool {
+	return o.PreserveHardlinks && !o.DryRun && !o.KeepOriginal && len(o.OutputDir) == 0
+}
+
+// encryptdir.newHardlinks: tracks hard links if `opts` preserves them, nil otherwise
+func newHardlinks(opts Options) *hardlinks {
+	if !opts.preservesHardlinks() {
+		return nil
+	}
+	return &hardlinks{leaders: make(map[inode]*linkLeader)}
+}
+
+// encryptdir.hardlinks.claim: makes `path` the leader of the file `info` describes, unless another path of it already is
+// a path of a file with a leader follows it even once it is the last link left, the others were relinked before it was walked
+// returns: leader, nil for a file with no other links, and true if it is `path`
+func (h *hardlinks) claim(path string, info os.FileInfo) (*linkLeader, bool) {
+	if h == nil {
+		return nil, true
+	}
+	id, links := inodeOf(info)
+	if links == 0 {
+		return nil, true
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if leader, ok := h.leaders[id]; ok {
+		return leader, false
+	}
+	if links < 2 {
+		return nil, true
+	}
+	leader := &linkLeader{path: path, done: make(chan struct{})}
+	h.leaders[id] = leader
+	return leader, true
+}
+
+// encryptdir.linkLeader.finish: lets the paths waiting on `l` go on, `ok` if its output replaced it, only the first call counts
+func (l *linkLeader) finish(ok bool) {
+	if l == nil {
+		return
+	}
+	l.once.Do(func() {
+		l.ok = ok
+		close(l.done)
+	})
+}
+
+// encryptdir.linkLeader.follow: waits for `l` to finish, then replaces the file at `path` with a hard link to its output through `tmpPath`
+// nothing is replaced when `l` left its file as is, or `path` already is its output like after `Options.DirectWrite`
+// returns: if `path` is the output of `l` now, or error
+func (l *linkLeader) follow(ctx context.Context, path string, tmpPath string) (bool, error) {
+	var canceled <-chan struct{}
+	if ctx != nil {
+		canceled = ctx.Done()
+	}
+	select {
+	case <-l.done:
+	case <-canceled:
+		return false, fmt.Errorf("encryptdir.linkLeader.follow: %w", ctx.Err())
+	}
+	if !l.ok {
+		return false, nil
+	}
+
+	out, err := os.Stat(l.path)
+	if err != nil {
+		return false, fmt.Errorf("encryptdir.linkLeader.follow: os.Stat: %w", err)
+	}
+	in, err := os.Stat(path)
+	if err != nil {
+		return false, fmt.Errorf("encryptdir.linkLeader.follow: os.Stat: %w", err)
+	}
+	if os.SameFile(out, in) {
+		return true, nil
+	}
+
+	err = os.Link(l.path, tmpPath)
+	if err != nil {
+		return false, fmt.Errorf("encryptdir.linkLeader.follow: os.Link: %w", err)
+	}
+	err = finalize(tmpPath, path)
+	if err != nil {
+		return false, fmt.Errorf("encryptdir.linkLeader.follow: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package encryptdir
+
+import (
+	"os"
+	"syscall"
+)
+
+// encryptdir.inodeOf: the device and inode of the file described by `info`, and how many hard links it has
+// returns: inode and links, 0 links if they arent known
+func inodeOf(info os.FileInfo) (inode, uint64) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return inode{}, 0
+	}
+	return inode{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, uint64(stat.Nlink)
+}
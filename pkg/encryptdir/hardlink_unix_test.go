@@ -0,0 +1,81 @@
+//go:build unix
+
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+// sameFile: if the files at `a` and `b` are one file on disk
+func sameFile(t *testing.T, a string, b string) bool {
+	t.Helper()
+	infoA, err := os.Stat(a)
+	if err != nil {
+		t.Fatalf("os.Stat: %v", err)
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		t.Fatalf("os.Stat: %v", err)
+	}
+	return os.SameFile(infoA, infoB)
+}
+
+func TestPreserveHardlinks(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	plain := []byte("one file, two paths")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": plain, "c.txt": []byte("not linked")})
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "sub", "b.txt")
+	err := os.MkdirAll(filepath.Dir(b), 0755)
+	if err != nil {
+		t.Fatalf("os.MkdirAll: %v", err)
+	}
+	err = os.Link(a, b)
+	if err != nil {
+		t.Fatalf("os.Link: %v", err)
+	}
+	opts := Options{PreserveHardlinks: true}
+
+	_, err = EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if !sameFile(t, a, b) {
+		t.Errorf("EncryptWithOptions: a.txt and sub/b.txt arent linked anymore")
+	}
+	// encrypted once, not once more through the other path
+	got, err := DecryptFileToBytes(privKey, keyMap["txt"], b)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("path = %q: DecryptFileToBytes = %q, %v, want %q", b, got, err, plain)
+	}
+
+	_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	if !sameFile(t, a, b) {
+		t.Errorf("DecryptWithOptions: a.txt and sub/b.txt arent linked anymore")
+	}
+	assertTree(t, dir, map[string][]byte{"a.txt": plain, "sub/b.txt": plain, "c.txt": []byte("not linked")})
+
+	// without it every path gets a file of its own
+	_, err = EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if sameFile(t, a, b) {
+		t.Errorf("EncryptWithOptions without PreserveHardlinks: a.txt and sub/b.txt are still linked")
+	}
+	for _, path := range []string{a, b} {
+		got, err := DecryptFileToBytes(privKey, keyMap["txt"], path)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("path = %q: DecryptFileToBytes = %q, %v, want %q", path, got, err, plain)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package encryptdir
+
+import (
+	"crypto"
+	_ "crypto/md5"
+	gorsa "crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"errors"
+	"fmt"
+
+	"github.com/prairir/encryptdir/pkg/rsa"
+)
+
+// sentinel error used for when the config names a signature hash that isn't supported
+var ErrUnknownHash = errors.New("unknown signature hash")
+
+// sentinel error used for when a file's signature is of the AES key alone, and `Options.RequireSignedHeader` is set
+var ErrUnsignedHeader = errors.New("file header is not signed")
+
+// DefaultHashAlgo: hash of the AES key signature written by encrypting when `Options.HashAlgo` isn't set
+const DefaultHashAlgo = crypto.SHA256
+
+// every hash a signature may have been written with, tried in this order when verifying a file that doesnt record its hash
+// file headers say which one a file used and only that one is tried
+var signatureHashes = []crypto.Hash{crypto.SHA256, crypto.SHA512, crypto.MD5}
+
+// encryptdir.ParseHashAlgo: the signature hash named `name`, one of md5, sha256, or sha512
+// returns: hash, 0 for an empty `name`, or error wrapping `ErrUnknownHash`
+func ParseHashAlgo(name string) (crypto.Hash, error) {
+	switch name {
+	case "":
+		return 0, nil
+	case "md5":
+		return crypto.MD5, nil
+	case "sha256":
+		return crypto.SHA256, nil
+	case "sha512":
+		return crypto.SHA512, nil
+	default:
+		return 0, fmt.Errorf("encryptdir.ParseHashAlgo: name = %q: %w", name, ErrUnknownHash)
+	}
+}
+
+// what the hash field of the file header holds for each of `signatureHashes`
+var hashIDs = map[crypto.Hash]byte{
+	crypto.MD5:    HashMD5,
+	crypto.SHA256: HashSHA256,
+	crypto.SHA512: HashSHA512,
+}
+
+// encryptdir.hashByID: the hash the hash field `id` of a file header stands for
+// returns: hash, or false for an id that isnt one of `hashIDs`
+func hashByID(id byte) (crypto.Hash, bool) {
+	for hash, hashID := range hashIDs {
+		if hashID == id {
+			return hash, true
+		}
+	}
+	return 0, false
+}
+
+// encryptdir.hashName: the name `ParseHashAlgo` parses to `hash`, empty for 0
+func hashName(hash crypto.Hash) string {
+	switch hash {
+	case crypto.MD5:
+		return "md5"
+	case crypto.SHA256:
+		return "sha256"
+	case crypto.SHA512:
+		return "sha512"
+	default:
+		return ""
+	}
+}
+
+// encryptdir.Options.signatureHash: hash used to sign the AES key when encrypting
+func (o Options) signatureHash() crypto.Hash {
+	if o.HashAlgo == 0 {
+		return DefaultHashAlgo
+	}
+	return o.HashAlgo
+}
+
+// encryptdir.signedMessage: what the signature of a file with `key` and `header` is of, `key` followed by the marshaled `header` if it signs the header
+// the header is marshaled again from its fields, so a field changed in the file changes the message and the signature doesnt verify
+func signedMessage(key []byte, header *FileHeader) []byte {
+	if header == nil || !header.SignsHeader {
+		return key
+	}
+	return append(append([]byte(nil), key...), header.marshal()...)
+}
+
+// encryptdir.verifyKey: checks `sig` is a signature of `key`, and of `header` if it signs the header, with the hash `header` recorded
+// files that dont record one, version 1 ones without a file header, are tried with any of `signatureHashes`, `Options.HashAlgo` first
+// with `Options.StrictCrypto` md5 is never tried, a file recording md5 fails wrapping `ErrWeakCrypto`
+// returns: error if no hash verifies, or wrapping `ErrUnsignedHeader` before any is tried, see `checkSignedHeader`
+func verifyKey(pubKey *gorsa.PublicKey, sig []byte, key []byte, header *FileHeader, opts Options) error {
+	err := checkSignedHeader(header, opts)
+	if err != nil {
+		return fmt.Errorf("encryptdir.verifyKey: %w", err)
+	}
+
+	if header != nil && header.Hash != 0 {
+		if opts.StrictCrypto && header.Hash == crypto.MD5 {
+			return fmt.Errorf("encryptdir.verifyKey: hash = md5: %w", ErrWeakCrypto)
+		}
+		return rsa.VerifySignature(pubKey, sig, signedMessage(key, header), header.Hash)
+	}
+
+	preferred := opts.signatureHash()
+	err = rsa.VerifySignature(pubKey, sig, key, preferred)
+	if err == nil {
+		return nil
+	}
+
+	for _, hash := range signatureHashes {
+		if hash == preferred || (opts.StrictCrypto && hash == crypto.MD5) {
+			continue
+		}
+
+		if rsa.VerifySignature(pubKey, sig, key, hash) == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// encryptdir.checkSignedHeader: with `Options.RequireSignedHeader`, refuses a file `header` says is signed for its AES key alone
+// a key alone signs the same for every file, so its signature can be copied from any other file of the key along with a header that says so,
+// the fields of the header are then whatever was written over them, a file without a file header is left to the caller as not encrypted
+// returns: error wrapping `ErrUnsignedHeader`
+func checkSignedHeader(header *FileHeader, opts Options) error {
+	if opts.RequireSignedHeader && header != nil && !header.SignsHeader {
+		return fmt.Errorf("encryptdir.checkSignedHeader: %w", ErrUnsignedHeader)
+	}
+	return nil
+}
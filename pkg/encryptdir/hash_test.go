@@ -0,0 +1,168 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+// encryptHashed: `spec` written to a new dir and encrypted with `hash`
+// returns: dir
+func encryptHashed(t *testing.T, spec map[string][]byte, keyMap map[string][]byte, hash crypto.Hash) string {
+	t.Helper()
+
+	dir, _ := testutil.BuildTree(t, spec)
+	_, err := EncryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), keyMap, []string{dir}, Options{HashAlgo: hash})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	return dir
+}
+
+// decryptBytes: `decryptTo` of the file at `path` into memory
+func decryptBytes(t *testing.T, keyMap map[string][]byte, path string, opts Options) ([]byte, error) {
+	t.Helper()
+
+	var out bytes.Buffer
+	err := decryptTo(testutil.NewPrivateKey(t), keyMap, path, &out, opts, nil)
+	return out.Bytes(), err
+}
+
+func TestHashRecorded(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+
+	for _, name := range []string{"md5", "sha256", "sha512"} {
+		t.Run(name, func(t *testing.T) {
+			hash, err := ParseHashAlgo(name)
+			if err != nil {
+				t.Fatalf("ParseHashAlgo: %v", err)
+			}
+			dir := encryptHashed(t, spec, keyMap, hash)
+			path := filepath.Join(dir, "a.txt")
+
+			header, err := ReadHeader(path)
+			if err != nil {
+				t.Fatalf("ReadHeader: %v", err)
+			}
+			if header.Version != FormatVersion || header.Hash != name {
+				t.Errorf("ReadHeader: version = %d, hash = %q, want %d and %q", header.Version, header.Hash, FormatVersion, name)
+			}
+
+			// the recorded hash is used whatever `Options.HashAlgo` decrypting runs with
+			plain, err := decryptBytes(t, keyMap, path, Options{HashAlgo: crypto.SHA512})
+			if err != nil || !bytes.Equal(plain, spec["a.txt"]) {
+				t.Errorf("decryptTo = %q, %v, want %q", plain, err, spec["a.txt"])
+			}
+
+			_, err = DecryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), keyMap, []string{dir}, Options{})
+			if err != nil {
+				t.Fatalf("DecryptWithOptions: %v", err)
+			}
+			assertTree(t, dir, spec)
+		})
+	}
+}
+
+func TestHashOnlyRecorded(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+	dir := encryptHashed(t, spec, keyMap, crypto.SHA256)
+	path := filepath.Join(dir, "a.txt")
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("os.ReadFile: %v", err)
+	}
+	// the signature is sha256, a header saying sha512 doesnt fall back to it
+	contents[HashOffset] = HashSHA512
+	err = os.WriteFile(path, contents, 0600)
+	if err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+
+	_, err = decryptBytes(t, keyMap, path, Options{})
+	if !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("decryptTo: err = %v, want ErrNotEncrypted", err)
+	}
+
+	_, err = DecryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	assertTree(t, dir, map[string][]byte{"a.txt": contents})
+
+	// an id that isnt a hash isnt a file header
+	contents[HashOffset] = 0
+	if _, ok := parseFileHeader(contents); ok {
+		t.Errorf("parseFileHeader: hash id 0 parsed")
+	}
+}
+
+func TestHashStrict(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+
+	dir := encryptHashed(t, spec, keyMap, crypto.MD5)
+	_, err := decryptBytes(t, keyMap, filepath.Join(dir, "a.txt"), Options{StrictCrypto: true})
+	if !errors.Is(err, ErrWeakCrypto) {
+		t.Errorf("decryptTo md5: err = %v, want ErrWeakCrypto", err)
+	}
+
+	dir = encryptHashed(t, spec, keyMap, crypto.SHA512)
+	plain, err := decryptBytes(t, keyMap, filepath.Join(dir, "a.txt"), Options{StrictCrypto: true})
+	if err != nil || !bytes.Equal(plain, spec["a.txt"]) {
+		t.Errorf("decryptTo sha512 = %q, %v, want %q", plain, err, spec["a.txt"])
+	}
+}
+
+func TestHashLegacyVersion(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+
+	for _, hash := range signatureHashes {
+		t.Run(hashName(hash), func(t *testing.T) {
+			dir := encryptHashed(t, spec, keyMap, hash)
+			path := filepath.Join(dir, "a.txt")
+
+			// a version 1 file has no file header to record the hash in
+			contents, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("os.ReadFile: %v", err)
+			}
+			err = os.WriteFile(path, legacyFile(t, privKey, keyMap["txt"], spec["a.txt"], contents), 0600)
+			if err != nil {
+				t.Fatalf("os.WriteFile: %v", err)
+			}
+
+			header, err := ReadHeader(path)
+			if err != nil {
+				t.Fatalf("ReadHeader: %v", err)
+			}
+			if header.Version != 1 || header.Hash != "" {
+				t.Errorf("ReadHeader: version = %d, hash = %q, want 1 and no hash", header.Version, header.Hash)
+			}
+
+			// with no hash recorded every one of `signatureHashes` is tried, md5 only without strict mode
+			plain, err := decryptBytes(t, keyMap, path, Options{LegacyFormat: true})
+			if err != nil || !bytes.Equal(plain, spec["a.txt"]) {
+				t.Errorf("decryptTo = %q, %v, want %q", plain, err, spec["a.txt"])
+			}
+			plain, err = decryptBytes(t, keyMap, path, Options{LegacyFormat: true, StrictCrypto: true})
+			if hash == crypto.MD5 {
+				if !errors.Is(err, ErrNotEncrypted) {
+					t.Errorf("decryptTo with StrictCrypto: err = %v, want ErrNotEncrypted", err)
+				}
+			} else if err != nil || !bytes.Equal(plain, spec["a.txt"]) {
+				t.Errorf("decryptTo with StrictCrypto = %q, %v, want %q", plain, err, spec["a.txt"])
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package encryptdir
+
+import (
+	gorsa "crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/prairir/encryptdir/pkg/aes"
+)
+
+// sentinel error used for when a file is too short to hold a header, it wraps `ErrInvalidHeader`
+var ErrShortHeader = fmt.Errorf("file is too short to hold a header: %w", ErrInvalidHeader)
+
+// Header: the fields in front of the ciphertext of an encrypted file
+// `Version` is 1 for files without a file header, `Ext` and `Mode` are only set from a file header
+// `Cipher` is aes-gcm for version 2 files and aes-ctr for version 1 ones, `KeyID` is only set for files encrypted with `Options.KeyFingerprint`
+// `Hash` is md5, sha256, or sha512 from the file header, version 1 files dont record it and it is empty
+// nothing here is verified, checking `Signature` needs the AES key
+type Header struct {
+	Version int
+	Cipher  string
+	Hash    string
+	// the signature covers the file header as well as the AES key
+	SignsHeader bool
+	// md5, sha256, or sha512 if the file recorded an integrity digest of its plaintext, empty if not
+	IntegrityHash string
+	// `KeyFingerprint` of the AES key if the file recorded it, nil if not
+	KeyID []byte
+	// `Options.Metadata` the file was encrypted with, nil if none
+	Metadata map[string]string
+
+	Ext  string
+	Mode fs.FileMode
+	// what the key was derived with, nil unless it was derived from a passphrase
+	KDF *aes.KDFParams
+	// gzip if the plaintext was compressed before it was encrypted, empty if not
+	Compression string
+
+	Signature []byte
+	// after gzip if `Compression` is set
+	PlaintextSize uint64
+	// AES-GCM nonce of version 2 files, AES-CTR IV of version 1 ones
+	IV []byte
+	// plaintext bytes per AES-GCM chunk, 0 for AES-CTR files
+	ChunkSize int
+}
+
+// encryptdir.ReadHeader: parses the header of the file at `path`, only the first `CiphertextOffset` bytes and the metadata are read
+// the signature is taken to be `SignatureSize` bytes, the size for 2048 bit keys, use `ReadHeaderWithKey` for files encrypted with other keys
+// files with a banner aren't supported
+// returns: header, or error wrapping `ErrShortHeader` if the file is too short, or `ErrInvalidHeader` if its file header doesnt parse, `ErrUnsupportedVersion` if it is newer than `FormatVersion`
+func ReadHeader(path string) (Header, error) {
+	header, err := readHeader(path, SignatureSize)
+	if err != nil {
+		return Header{}, fmt.Errorf("encryptdir.ReadHeader: %w", err)
+	}
+	return header, nil
+}
+
+// encryptdir.ReadHeaderWithKey: `ReadHeader` for a file encrypted with the private key of `pubKey`, whose signature is as long as its modulus
+// returns: header, or error like `ReadHeader`
+func ReadHeaderWithKey(pubKey *gorsa.PublicKey, path string) (Header, error) {
+	header, err := readHeader(path, signatureSize(pubKey))
+	if err != nil {
+		return Header{}, fmt.Errorf("encryptdir.ReadHeaderWithKey: %w", err)
+	}
+	return header, nil
+}
+
+// encryptdir.readHeader: parses the header of the file at `path` with a `sigSize` byte signature
+// returns: header or error
+func readHeader(path string, sigSize int) (Header, error) {
+	in, err := os.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return Header{}, fmt.Errorf("encryptdir.readHeader: os.OpenFile: %w", err)
+	}
+	defer in.Close()
+
+	// the offsets after the signature are shifted by how much longer it is than `SignatureSize`
+	shift := sigSize - SignatureSize
+	prefix := make([]byte, CiphertextOffset+shift)
+	n, err := io.ReadFull(in, prefix)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return Header{}, fmt.Errorf("encryptdir.readHeader: io.ReadFull: %w", err)
+	}
+
+	prefix, err = readMetadata(in, prefix[:n])
+	if err != nil {
+		return Header{}, fmt.Errorf("encryptdir.readHeader: %w", err)
+	}
+	n = len(prefix)
+
+	err = checkFileHeader(prefix[:n])
+	if err != nil {
+		return Header{}, fmt.Errorf("encryptdir.readHeader: path = %q: %w", path, err)
+	}
+
+	header := Header{Version: 1, Cipher: "aes-ctr"}
+	fileHeader, rest := splitFileHeader(prefix[:n])
+	if fileHeader != nil {
+		header.Version = fileHeader.Version
+		header.Ext = fileHeader.Ext
+		header.Mode = fileHeader.Mode
+		header.KDF = fileHeader.KDF
+		header.Hash = hashName(fileHeader.Hash)
+		header.SignsHeader = fileHeader.SignsHeader
+		header.IntegrityHash = hashName(fileHeader.IntegrityHash)
+		header.KeyID = fileHeader.KeyID
+		header.Metadata = fileHeader.Metadata
+		if fileHeader.Compression == CompressionGzip {
+			header.Compression = "gzip"
+		}
+	}
+
+	// offsets of the fields after the file header
+	var (
+		plaintextSizeOffset = PlaintextSizeOffset - SignatureOffset + shift
+		chunkSizeOffset     = ChunkSizeOffset - SignatureOffset + shift
+		nonceOffset         = NonceOffset - SignatureOffset + shift
+	)
+	if len(rest) < CiphertextOffset-SignatureOffset+shift {
+		return Header{}, fmt.Errorf("encryptdir.readHeader: path = %q: %w", path, ErrShortHeader)
+	}
+
+	header.Signature = rest[:sigSize]
+	header.PlaintextSize = binary.LittleEndian.Uint64(rest[plaintextSizeOffset : plaintextSizeOffset+PlaintextSizeSize])
+	if isGCM(fileHeader) {
+		header.Cipher = "aes-gcm"
+		header.ChunkSize = int(binary.LittleEndian.Uint32(rest[chunkSizeOffset : chunkSizeOffset+ChunkSizeSize]))
+		header.IV = rest[nonceOffset : nonceOffset+NonceSize]
+		return header, nil
+	}
+
+	// the IV of AES-CTR files starts where the chunk size is
+	header.IV = rest[chunkSizeOffset : chunkSizeOffset+LegacyIVSize]
+	return header, nil
+}
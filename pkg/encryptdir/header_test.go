@@ -0,0 +1,130 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestReadHeaderFields(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+	dir, _ := testutil.BuildTree(t, spec)
+	path := filepath.Join(dir, "a.txt")
+	err := os.Chmod(path, 0640)
+	if err != nil {
+		t.Fatalf("os.Chmod: %v", err)
+	}
+
+	opts := Options{
+		HashAlgo:       crypto.SHA512,
+		SignHeader:     true,
+		IntegrityHash:  crypto.SHA256,
+		KeyFingerprint: true,
+		Metadata:       map[string]string{"owner": "alice"},
+	}
+	_, err = EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+
+	header, err := ReadHeader(path)
+	if err != nil {
+		t.Fatalf("ReadHeader: %v", err)
+	}
+	for _, c := range []struct {
+		field     string
+		got, want any
+	}{
+		{"version", header.Version, FormatVersion},
+		{"cipher", header.Cipher, "aes-gcm"},
+		{"hash", header.Hash, "sha512"},
+		{"signs header", header.SignsHeader, true},
+		{"integrity hash", header.IntegrityHash, "sha256"},
+		{"ext", header.Ext, "txt"},
+		{"mode", header.Mode.Perm(), os.FileMode(0640)},
+		{"compression", header.Compression, ""},
+		{"kdf", header.KDF == nil, true},
+		{"owner metadata", header.Metadata["owner"], "alice"},
+		{"signature size", len(header.Signature), SignatureSize},
+		{"plaintext size", header.PlaintextSize, uint64(len(spec["a.txt"]))},
+		{"iv size", len(header.IV), NonceSize},
+		{"chunk size", header.ChunkSize, opts.streamChunkSize()},
+	} {
+		if c.got != c.want {
+			t.Errorf("ReadHeader: %s = %v, want %v", c.field, c.got, c.want)
+		}
+	}
+	if !bytes.Equal(header.KeyID, KeyFingerprint(keyMap["txt"])) {
+		t.Errorf("ReadHeader: key id = %x, want %x", header.KeyID, KeyFingerprint(keyMap["txt"]))
+	}
+
+	// the signature read is the one that verifies
+	_, err = DecryptFileToBytes(privKey, keyMap["txt"], path)
+	if err != nil {
+		t.Errorf("DecryptFileToBytes: %v", err)
+	}
+}
+
+func TestReadHeaderNotEncrypted(t *testing.T) {
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"short.txt": []byte("hi"), "empty.txt": {}})
+
+	for _, name := range []string{"short.txt", "empty.txt"} {
+		_, err := ReadHeader(filepath.Join(dir, name))
+		if !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("ReadHeader(%s): err = %v, want ErrInvalidHeader", name, err)
+		}
+	}
+}
+
+func TestDecryptRenamed(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt", "sql")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello"), "b.sql": []byte("select 1")})
+	err := os.Chmod(filepath.Join(dir, "a.txt"), 0640)
+	if err != nil {
+		t.Fatalf("os.Chmod: %v", err)
+	}
+
+	_, err = EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+
+	// one to an extension without a key, one to the extension of another key
+	for from, to := range map[string]string{"a.txt": "a.dat", "b.sql": "b.txt"} {
+		err := os.Rename(filepath.Join(dir, from), filepath.Join(dir, to))
+		if err != nil {
+			t.Fatalf("os.Rename: %v", err)
+		}
+	}
+	// the mode comes back from the file header too
+	err = os.Chmod(filepath.Join(dir, "a.dat"), 0600)
+	if err != nil {
+		t.Fatalf("os.Chmod: %v", err)
+	}
+
+	report, err := DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	if report.Processed != 2 {
+		t.Errorf("DecryptWithOptions: processed = %d, want 2", report.Processed)
+	}
+	assertTree(t, dir, map[string][]byte{"a.dat": []byte("hello"), "b.txt": []byte("select 1")})
+
+	info, err := os.Stat(filepath.Join(dir, "a.dat"))
+	if err != nil {
+		t.Fatalf("os.Stat: %v", err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("a.dat: mode = %v, want the 0640 of a.txt", info.Mode().Perm())
+	}
+}
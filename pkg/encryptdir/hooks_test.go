@@ -0,0 +1,72 @@
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestRootHooks(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	specs := []map[string][]byte{
+		{"a.txt": []byte("a")},
+		{"a.txt": []byte("a"), "sub/b.txt": []byte("b"), "c.md": []byte("no key")},
+		{"a.txt": []byte("a"), "b.txt": []byte("b"), "c.txt": []byte("c"), "newer.txt": newerFile},
+	}
+	want := map[string]Stats{}
+	var dirs []string
+	for i, spec := range specs {
+		dir, _ := testutil.BuildTree(t, spec)
+		dirs = append(dirs, dir)
+		want[dir] = []Stats{
+			{Processed: 1},
+			{Processed: 2, Skipped: 1},
+			{Processed: 3, Failed: 1},
+		}[i]
+	}
+
+	var mu sync.Mutex
+	started := map[string]int{}
+	finished := map[string]int{}
+	stats := map[string]Stats{}
+	opts := Options{Hooks: Hooks{
+		OnRootStart: func(dir string) {
+			mu.Lock()
+			defer mu.Unlock()
+			started[dir]++
+		},
+		OnRootFinish: func(dir string, s Stats, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if started[dir] != 1 {
+				t.Errorf("OnRootFinish(%q) before OnRootStart", dir)
+			}
+			if err != nil {
+				t.Errorf("OnRootFinish(%q): err = %v, want nil, a failed file isnt a walk error", dir, err)
+			}
+			finished[dir]++
+			stats[dir] = s
+		},
+	}}
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, dirs, opts)
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("EncryptWithOptions: err = %v, want ErrUnsupportedVersion", err)
+	}
+
+	for _, dir := range dirs {
+		if started[dir] != 1 || finished[dir] != 1 {
+			t.Errorf("dir = %q: OnRootStart called %d times, OnRootFinish %d times, want once each", dir, started[dir], finished[dir])
+		}
+		if stats[dir] != want[dir] {
+			t.Errorf("dir = %q: OnRootFinish stats = %+v, want %+v", dir, stats[dir], want[dir])
+		}
+	}
+	if len(started) != len(dirs) || len(finished) != len(dirs) {
+		t.Errorf("hooks called for %d and %d roots, want %d", len(started), len(finished), len(dirs))
+	}
+}
@@ -0,0 +1,50 @@
+package encryptdir
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// sentinel error used for when a decrypted file doesnt match the integrity digest its file header recorded
+var ErrIntegrityMismatch = errors.New("plaintext doesn't match its integrity digest")
+
+// encryptdir.newIntegrity: HMAC of the original plaintext keyed with the AES key `key`, with the hash `hash`, nil for 0
+// keyed so the file header doesnt give away a plain hash of the contents anyone could check a guess against
+func newIntegrity(hash crypto.Hash, key []byte) hash.Hash {
+	if hash == 0 {
+		return nil
+	}
+	return hmac.New(hash.New, key)
+}
+
+// encryptdir.integrityWriter: `w` with everything written to it hashed into `mac` too, `w` itself if `mac` is nil
+func integrityWriter(mac hash.Hash, w io.Writer) io.Writer {
+	if mac == nil {
+		return w
+	}
+	return io.MultiWriter(w, mac)
+}
+
+// encryptdir.checkIntegrity: checks the digest `mac` hashed from the decrypted plaintext is the one `header` recorded
+// returns: error wrapping `ErrIntegrityMismatch`, nil for a nil `mac`
+func checkIntegrity(mac hash.Hash, header *FileHeader) error {
+	if mac == nil {
+		return nil
+	}
+	if !hmac.Equal(mac.Sum(nil), header.Integrity) {
+		return fmt.Errorf("encryptdir.checkIntegrity: hash = %s: %w", hashName(header.IntegrityHash), ErrIntegrityMismatch)
+	}
+	return nil
+}
+
+// encryptdir.headerIntegrity: the digest of the decrypted plaintext of a file with `header` and `key` to check, nil if it didnt record one
+func headerIntegrity(header *FileHeader, key []byte) hash.Hash {
+	if header == nil {
+		return nil
+	}
+	return newIntegrity(header.IntegrityHash, key)
+}
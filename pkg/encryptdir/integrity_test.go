@@ -0,0 +1,143 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+// encryptIntegrity: `spec` written to a new dir and encrypted with `opts`
+// returns: dir
+func encryptIntegrity(t *testing.T, spec map[string][]byte, keyMap map[string][]byte, opts Options) string {
+	t.Helper()
+
+	dir, _ := testutil.BuildTree(t, spec)
+	_, err := EncryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	return dir
+}
+
+func TestIntegrityRoundTrip(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world")}
+
+	for name, opts := range map[string]Options{
+		"in memory": {HashAlgo: crypto.SHA256, IntegrityHash: crypto.SHA512},
+		"streamed":  {HashAlgo: crypto.SHA256, IntegrityHash: crypto.SHA512, StreamThreshold: -1},
+		"signed":    {HashAlgo: crypto.SHA256, IntegrityHash: crypto.SHA512, StreamThreshold: -1, SignHeader: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := encryptIntegrity(t, spec, keyMap, opts)
+
+			// both hashes are recorded, each on its own
+			header, err := ReadHeader(filepath.Join(dir, "a.txt"))
+			if err != nil {
+				t.Fatalf("ReadHeader: %v", err)
+			}
+			if header.Hash != "sha256" || header.IntegrityHash != "sha512" {
+				t.Errorf("ReadHeader: hash = %q, integrity hash = %q, want sha256 and sha512", header.Hash, header.IntegrityHash)
+			}
+
+			// decrypting checks the digest whatever its own options say
+			_, err = DecryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), keyMap, []string{dir}, Options{})
+			if err != nil {
+				t.Fatalf("DecryptWithOptions: %v", err)
+			}
+			assertTree(t, dir, spec)
+		})
+	}
+}
+
+func TestIntegrityMismatch(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+
+	dir := encryptIntegrity(t, spec, keyMap, Options{IntegrityHash: crypto.SHA256})
+	path := filepath.Join(dir, "a.txt")
+	flipByte(t, path, IntegrityOffset, 1)
+	encrypted := readTree(t, dir)
+
+	_, err := decryptBytes(t, keyMap, path, Options{})
+	if !errors.Is(err, ErrIntegrityMismatch) || !errors.Is(err, ErrDecryptFailed) {
+		t.Errorf("decryptTo: err = %v, want ErrIntegrityMismatch and ErrDecryptFailed", err)
+	}
+	_, err = DecryptFileToBytes(testutil.NewPrivateKey(t), keyMap["txt"], path)
+	if !errors.Is(err, ErrIntegrityMismatch) {
+		t.Errorf("DecryptFileToBytes: err = %v, want ErrIntegrityMismatch", err)
+	}
+
+	// the file that doesnt match is left encrypted
+	report, err := DecryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), keyMap, []string{dir}, Options{})
+	if !errors.Is(err, ErrIntegrityMismatch) || report.Failed != 1 {
+		t.Errorf("DecryptWithOptions: failed = %d, err = %v, want 1 and ErrIntegrityMismatch", report.Failed, err)
+	}
+	assertTree(t, dir, encrypted)
+}
+
+func TestIntegrityOptions(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello")})
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{IntegrityHash: crypto.SHA1})
+	if !errors.Is(err, ErrUnknownHash) {
+		t.Errorf("EncryptWithOptions sha1: err = %v, want ErrUnknownHash", err)
+	}
+	_, err = EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{IntegrityHash: crypto.MD5, StrictCrypto: true})
+	if !errors.Is(err, ErrWeakCrypto) {
+		t.Errorf("EncryptWithOptions md5 with StrictCrypto: err = %v, want ErrWeakCrypto", err)
+	}
+
+	// without it nothing is recorded
+	other := encryptIntegrity(t, map[string][]byte{"a.txt": []byte("hello")}, keyMap, Options{})
+	header, err := ReadHeader(filepath.Join(other, "a.txt"))
+	if err != nil || header.IntegrityHash != "" {
+		t.Errorf("ReadHeader: integrity hash = %q, %v, want none", header.IntegrityHash, err)
+	}
+	if !bytes.Equal(readTree(t, dir)["a.txt"], []byte("hello")) {
+		t.Errorf("a.txt: encrypted with bad options")
+	}
+}
+
+func TestIntegrityCompressed(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": bytes.Repeat([]byte("compresses well "), 256)}
+
+	dir := encryptIntegrity(t, spec, keyMap, Options{Compress: true, IntegrityHash: crypto.SHA256})
+	path := filepath.Join(dir, "a.txt")
+	header, err := ReadHeader(path)
+	if err != nil {
+		t.Fatalf("ReadHeader: %v", err)
+	}
+	if header.Compression != "gzip" || header.IntegrityHash != "sha256" {
+		t.Fatalf("ReadHeader: compression = %q, integrity hash = %q, want gzip and sha256", header.Compression, header.IntegrityHash)
+	}
+
+	// the digest is of the original plaintext, so it checks out once it is gunzipped, in memory and streamed
+	plain, err := DecryptFileToBytes(testutil.NewPrivateKey(t), keyMap["txt"], path)
+	if err != nil || !bytes.Equal(plain, spec["a.txt"]) {
+		t.Errorf("DecryptFileToBytes: err = %v, plaintext matches = %t", err, bytes.Equal(plain, spec["a.txt"]))
+	}
+	plain, err = decryptBytes(t, keyMap, path, Options{})
+	if err != nil || !bytes.Equal(plain, spec["a.txt"]) {
+		t.Errorf("decryptTo: err = %v, plaintext matches = %t", err, bytes.Equal(plain, spec["a.txt"]))
+	}
+
+	// a digest that doesnt match the original is caught after gunzipping too
+	flipByte(t, path, IntegrityOffset, 1)
+	_, err = DecryptFileToBytes(testutil.NewPrivateKey(t), keyMap["txt"], path)
+	if !errors.Is(err, ErrIntegrityMismatch) {
+		t.Errorf("DecryptFileToBytes: err = %v, want ErrIntegrityMismatch", err)
+	}
+	_, err = decryptBytes(t, keyMap, path, Options{})
+	if !errors.Is(err, ErrIntegrityMismatch) {
+		t.Errorf("decryptTo: err = %v, want ErrIntegrityMismatch", err)
+	}
+}
@@ -0,0 +1,189 @@
+package encryptdir
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// name of the journal in the first root, when `Options.Resume` is set without `Options.JournalPath`
+const DefaultJournalName = ".encryptdir-journal"
+
+// journalEntry: a single line of a journal, a file `Op` finished
+type journalEntry struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+}
+
+// journal: the files a run finished, appended to as each one does so an interrupted run can be resumed
+// a nil `*journal` records nothing and has nothing completed
+type journal struct {
+	mu   sync.Mutex
+	f    *os.File
+	path string
+	op   string
+	// finished by the run being resumed
+	completed map[string]bool
+}
+
+// encryptdir.Options.journalPath: where the journal of a run over `directories` is, "" without one
+// dry runs never have one, they dont finish anything
+func (o Options) journalPath(directories []string) string {
+	if o.DryRun || (!o.Resume && len(o.JournalPath) == 0) {
+		return ""
+	}
+	if len(o.JournalPath) > 0 {
+		return o.JournalPath
+	}
+	if len(directories) == 0 {
+		return ""
+	}
+	return filepath.Join(directories[0], DefaultJournalName)
+}
+
+// encryptdir.openJournal: opens the journal of `opts` for `op`, "encrypt" or "decrypt"
+// with `Options.Resume` the files the journal has for `op` are completed and it is appended to, otherwise it starts empty
+// returns: journal, nil without one, or error
+func openJournal(opts Options, directories []string, op string) (*journal, error) {
+	path := opts.journalPath(directories)
+	if len(path) == 0 {
+		return nil, nil
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.openJournal: filepath.Abs: %w", err)
+	}
+
+	j := &journal{path: path, op: op, completed: make(map[string]bool)}
+
+	flag := os.O_RDWR | os.O_CREATE | os.O_APPEND
+	if !opts.Resume {
+		flag |= os.O_TRUNC
+	}
+	j.f, err = os.OpenFile(path, flag, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.openJournal: os.OpenFile: %w", err)
+	}
+
+	err = j.load()
+	if err != nil {
+		j.f.Close()
+		return nil, fmt.Errorf("encryptdir.openJournal: %w", err)
+	}
+
+	// the journal itself has to survive a crash, not just what is written to it
+	err = syncDir(filepath.Dir(path))
+	if err != nil {
+		j.f.Close()
+		return nil, fmt.Errorf("encryptdir.openJournal: %w", err)
+	}
+	return j, nil
+}
+
+// encryptdir.journal.load: reads the entries already in the journal
+// a run killed mid write leaves the last line cut off, it is ignored and the next entry starts on a line of its own
+// returns: error
+func (j *journal) load() error {
+	size, err := j.f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("encryptdir.journal.load: f.Seek: %w", err)
+	}
+	if size == 0 {
+		return nil
+	}
+
+	last := make([]byte, 1)
+	_, err = j.f.ReadAt(last, size-1)
+	if err != nil {
+		return fmt.Errorf("encryptdir.journal.load: f.ReadAt: %w", err)
+	}
+	if last[0] != '\n' {
+		_, err = j.f.Write([]byte("\n"))
+		if err != nil {
+			return fmt.Errorf("encryptdir.journal.load: f.Write: %w", err)
+		}
+	}
+
+	scanner := bufio.NewScanner(io.NewSectionReader(j.f, 0, size))
+	for scanner.Scan() {
+		var entry journalEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		if entry.Op == j.op {
+			j.completed[entry.Path] = true
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return fmt.Errorf("encryptdir.journal.load: bufio.Scanner.Scan: %w", err)
+	}
+	return nil
+}
+
+// encryptdir.journal.done: if the run being resumed finished the file at `path`
+func (j *journal) done(path string) bool {
+	if j == nil {
+		return false
+	}
+	return j.completed[path]
+}
+
+// encryptdir.journal.is: if `path` is the journal, which is never encrypted or decrypted
+func (j *journal) is(path string) bool {
+	if j == nil {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	return err == nil && abs == j.path
+}
+
+// encryptdir.journal.record: appends the finished file at `path` and syncs it to disk before returning
+// returns: error
+func (j *journal) record(path string) error {
+	if j == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(journalEntry{Op: j.op, Path: path})
+	if err != nil {
+		return fmt.Errorf("encryptdir.journal.record: json.Marshal: %w", err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	_, err = j.f.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("encryptdir.journal.record: f.Write: %w", err)
+	}
+	err = j.f.Sync()
+	if err != nil {
+		return fmt.Errorf("encryptdir.journal.record: f.Sync: %w", err)
+	}
+	return nil
+}
+
+// encryptdir.journal.close: closes the journal, and removes it if the run `finished` so the next one starts over
+// returns: error
+func (j *journal) close(finished bool) error {
+	if j == nil {
+		return nil
+	}
+
+	err := j.f.Close()
+	if err != nil {
+		return fmt.Errorf("encryptdir.journal.close: f.Close: %w", err)
+	}
+	if finished {
+		err = os.Remove(j.path)
+		if err != nil {
+			return fmt.Errorf("encryptdir.journal.close: os.Remove: %w", err)
+		}
+	}
+	return nil
+}
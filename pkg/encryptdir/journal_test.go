@@ -0,0 +1,70 @@
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestResume(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world")}
+
+	for name, path := range map[string]string{"default": "", "JournalPath": filepath.Join(t.TempDir(), "journal")} {
+		t.Run(name, func(t *testing.T) {
+			dir, _ := testutil.BuildTree(t, spec)
+			opts := Options{Resume: true, JournalPath: path}
+			journalPath := path
+			if len(journalPath) == 0 {
+				journalPath = filepath.Join(dir, DefaultJournalName)
+			}
+
+			// b.txt blows up part way, like the run being killed before it got to it
+			interrupted := opts
+			interrupted.Namer = panickingNamer{SuffixNamer: SuffixNamer{EncSuffix: DefaultEncSuffix, DecSuffix: DefaultDecSuffix}, panicPath: filepath.Join(dir, "sub", "b.txt")}
+			report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, interrupted)
+			if !errors.Is(err, ErrFilePanic) || report.Processed != 1 {
+				t.Fatalf("EncryptWithOptions = processed %d, %v, want 1 and ErrFilePanic", report.Processed, err)
+			}
+			journal, err := os.ReadFile(journalPath)
+			if err != nil {
+				t.Fatalf("os.ReadFile(journal): %v", err)
+			}
+			if !strings.Contains(string(journal), "a.txt") || strings.Contains(string(journal), "b.txt") {
+				t.Errorf("journal = %q, want only a.txt in it", journal)
+			}
+
+			// resumed, only b.txt is encrypted
+			var mu sync.Mutex
+			var names []string
+			opts.Namer = prefixNamer{mu: &mu, names: &names}
+			report, err = EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+			if err != nil {
+				t.Fatalf("EncryptWithOptions resumed: %v", err)
+			}
+			if want := []string{filepath.Join(dir, "sub", ".tmp-enc-b.txt")}; !reflect.DeepEqual(names, want) || report.Processed != 1 {
+				t.Errorf("EncryptWithOptions resumed: processed %d, temp files %q, want 1 and %q", report.Processed, names, want)
+			}
+
+			// a finished run removes its journal
+			_, err = os.Stat(journalPath)
+			if !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("os.Stat(journal): err = %v, want os.ErrNotExist", err)
+			}
+
+			_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+			if err != nil {
+				t.Fatalf("DecryptWithOptions: %v", err)
+			}
+			assertTree(t, dir, spec)
+		})
+	}
+}
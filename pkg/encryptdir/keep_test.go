@@ -0,0 +1,140 @@
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestInPlace(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+
+	// the originals are replaced, nothing is written next to them
+	tree := readTree(t, dir)
+	for rel := range spec {
+		if string(tree[rel]) == string(spec[rel]) {
+			t.Errorf("path = %q: not encrypted", rel)
+		}
+	}
+	if len(tree) != len(spec) {
+		t.Errorf("tree = %d files, want %d", len(tree), len(spec))
+	}
+
+	_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	assertTree(t, dir, spec)
+}
+
+func TestKeepOriginal(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world")}
+	dir, _ := testutil.BuildTree(t, spec)
+	opts := Options{KeepOriginal: true}
+
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if report.Processed != len(spec) {
+		t.Errorf("EncryptWithOptions: processed = %d, want %d", report.Processed, len(spec))
+	}
+
+	// the originals stay, the outputs are `<name>.enc` and decrypt back to them
+	tree := readTree(t, dir)
+	for rel, plain := range spec {
+		if string(tree[rel]) != string(plain) {
+			t.Errorf("path = %q: original changed", rel)
+		}
+		got, err := DecryptFileToBytes(privKey, keyMap["txt"], filepath.Join(dir, filepath.FromSlash(rel+DefaultKeepSuffix)))
+		if err != nil || string(got) != string(plain) {
+			t.Errorf("path = %q: DecryptFileToBytes = %q, %v, want %q", rel+DefaultKeepSuffix, got, err, plain)
+		}
+	}
+	if len(tree) != 2*len(spec) {
+		t.Errorf("tree = %d files, want %d", len(tree), 2*len(spec))
+	}
+
+	// the outputs arent temp files, even once they are older than `CleanupGrace`
+	old := time.Now().Add(-2 * CleanupGrace)
+	for rel := range spec {
+		err = os.Chtimes(filepath.Join(dir, filepath.FromSlash(rel+DefaultKeepSuffix)), old, old)
+		if err != nil {
+			t.Fatalf("os.Chtimes: %v", err)
+		}
+	}
+	removed, err := Cleanup([]string{dir})
+	if err != nil || len(removed) != 0 {
+		t.Errorf("Cleanup = %q, %v, want nothing removed", removed, err)
+	}
+	assertTree(t, dir, tree)
+
+	// the originals are where the outputs would go
+	report, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil || report.Processed != 0 {
+		t.Errorf("DecryptWithOptions next to the originals: processed = %d, err = %v, want 0 and no error", report.Processed, err)
+	}
+	assertTree(t, dir, tree)
+
+	opts.DecSibling = SiblingError
+	report, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err == nil || report.Failed != len(spec) {
+		t.Errorf("DecryptWithOptions with SiblingError: failed = %d, err = %v, want %d", report.Failed, err, len(spec))
+	}
+	for _, f := range report.Files {
+		if f.Status == FileFailed && !errors.Is(f.Err, ErrDecSiblingExists) {
+			t.Errorf("path = %q: err = %v, want ErrDecSiblingExists", f.Path, f.Err)
+		}
+	}
+
+	for rel := range spec {
+		err = os.Remove(filepath.Join(dir, filepath.FromSlash(rel)))
+		if err != nil {
+			t.Fatalf("os.Remove: %v", err)
+		}
+	}
+	opts.DecSibling = ""
+	report, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	if report.Processed != len(spec) {
+		t.Errorf("DecryptWithOptions: processed = %d, want %d", report.Processed, len(spec))
+	}
+	assertTree(t, dir, tree)
+}
+
+func TestKeepOriginalDecSuffix(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	encrypted := readTree(t, dir)["a.txt"]
+
+	// a file without the keep suffix is decrypted to `<name><KeepDecSuffix>`
+	_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{KeepOriginal: true, KeepDecSuffix: ".plain"})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	assertTree(t, dir, map[string][]byte{"a.txt": encrypted, "a.txt.plain": spec["a.txt"]})
+}
@@ -0,0 +1,72 @@
+package encryptdir
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestKeyringTwoKeys(t *testing.T) {
+	for name, opts := range map[string]Options{
+		"default":     {},
+		"fingerprint": {KeyFingerprint: true},
+		"streamed":    {StreamThreshold: -1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			privKey := testutil.NewPrivateKey(t)
+			firstKeys := map[string][]byte{"txt": testutil.NewTestKey("first txt")}
+			secondKeys := map[string][]byte{"txt": testutil.NewTestKey("second txt")}
+
+			firstSpec := map[string][]byte{"a.txt": []byte("hello")}
+			secondSpec := map[string][]byte{"b.txt": []byte("world")}
+			firstDir, _ := testutil.BuildTree(t, firstSpec)
+			secondDir, _ := testutil.BuildTree(t, secondSpec)
+
+			_, err := EncryptWithOptions(context.Background(), nil, privKey, firstKeys, []string{firstDir}, opts)
+			if err != nil {
+				t.Fatalf("EncryptWithOptions with the first key: %v", err)
+			}
+			_, err = EncryptWithOptions(context.Background(), nil, privKey, secondKeys, []string{secondDir}, opts)
+			if err != nil {
+				t.Fatalf("EncryptWithOptions with the second key: %v", err)
+			}
+
+			// the key map holds neither, every file needs the keyring
+			keyMap := map[string][]byte{"txt": testutil.NewTestKey("current txt")}
+			opts.Keyring = [][]byte{firstKeys["txt"], secondKeys["txt"]}
+			report, err := DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{firstDir, secondDir}, opts)
+			if err != nil {
+				t.Fatalf("DecryptWithOptions: %v", err)
+			}
+			if report.Processed != 2 || report.Failed != 0 {
+				t.Errorf("DecryptWithOptions: processed = %d, failed = %d, want 2 and 0", report.Processed, report.Failed)
+			}
+			assertTree(t, firstDir, firstSpec)
+			assertTree(t, secondDir, secondSpec)
+		})
+	}
+}
+
+func TestKeyringMissingKey(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	oldKeys := map[string][]byte{"txt": testutil.NewTestKey("old txt")}
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello")})
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, oldKeys, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	encrypted := readTree(t, dir)
+
+	// without the key in the keyring the file doesnt verify and is left alone
+	keyMap := map[string][]byte{"txt": testutil.NewTestKey("current txt")}
+	report, err := DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{Keyring: [][]byte{testutil.NewTestKey("unrelated")}})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	if report.Processed != 0 {
+		t.Errorf("DecryptWithOptions: processed = %d, want 0", report.Processed)
+	}
+	assertTree(t, dir, encrypted)
+}
@@ -0,0 +1,19 @@
+//go:build !unix
+
+package encryptdir
+
+import "os"
+
+// encryptdir.lockTemp: temp files cant be locked on this platform, `Cleanup` only goes by their age
+func lockTemp(f *os.File) {}
+
+// encryptdir.tempLocked: temp files cant be locked on this platform, so none are
+func tempLocked(path string) (bool, error) {
+	return false, nil
+}
+
+// encryptdir.processAlive: if a process with `pid` is running, a reused pid looks alive too
+func processAlive(pid int) bool {
+	_, err := os.FindProcess(pid)
+	return err == nil
+}
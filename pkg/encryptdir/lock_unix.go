@@ -0,0 +1,41 @@
+//go:build unix
+
+package encryptdir
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// encryptdir.lockTemp: takes an exclusive advisory lock on the temp file `f` while it is written, released when `f` is closed
+// tells `Cleanup` the temp file still has an owner, filesystems without locks just go without
+func lockTemp(f *os.File) {
+	_ = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+// encryptdir.tempLocked: if a run holds the `lockTemp` lock on the temp file at `path`
+// returns: if it is locked or error
+func tempLocked(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("encryptdir.tempLocked: os.Open: %w", err)
+	}
+	defer f.Close()
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("encryptdir.tempLocked: syscall.Flock: %w", err)
+	}
+	return false, nil
+}
+
+// encryptdir.processAlive: if a process with `pid` is running, a reused pid looks alive too
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
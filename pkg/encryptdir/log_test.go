@@ -0,0 +1,59 @@
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestWalkLogs(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	log := zap.New(core).Sugar()
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello"), "sub/c.md": []byte("no key"), "newer.txt": newerFile})
+
+	_, err := EncryptWithOptions(context.Background(), log, testutil.NewPrivateKey(t), testutil.NewKeyMap("txt"), []string{dir}, Options{})
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("EncryptWithOptions: err = %v, want ErrUnsupportedVersion", err)
+	}
+
+	for _, want := range []struct {
+		level  zapcore.Level
+		msg    string
+		path   string
+		fields map[string]interface{}
+	}{
+		{zap.InfoLevel, "encrypted file", "a.txt", map[string]interface{}{"bytes": int64(5)}},
+		{zap.DebugLevel, "skipping file, no key for its extension", "sub/c.md", nil},
+		{zap.WarnLevel, "file failed", "newer.txt", nil},
+	} {
+		// logged from the workers, so found by the path rather than in order
+		path := filepath.Join(dir, filepath.FromSlash(want.path))
+		entries := logs.FilterMessage(want.msg).FilterField(zap.String("path", path)).All()
+		if len(entries) != 1 {
+			t.Errorf("msg = %q, path = %q: logged %d times, want once", want.msg, want.path, len(entries))
+			continue
+		}
+
+		entry := entries[0]
+		if entry.Level != want.level {
+			t.Errorf("msg = %q: level = %v, want %v", want.msg, entry.Level, want.level)
+		}
+		fields := entry.ContextMap()
+		for key, value := range want.fields {
+			if fields[key] != value {
+				t.Errorf("msg = %q: %s = %v, want %v", want.msg, key, fields[key], value)
+			}
+		}
+		if want.level == zap.WarnLevel && !strings.Contains(fmt.Sprint(fields["error"]), ErrUnsupportedVersion.Error()) {
+			t.Errorf("msg = %q: error = %v, want the error of the file", want.msg, fields["error"])
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/rsa"
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestDecryptLeavesBinaryFiles(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("bin")
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	// files with a key that were never encrypted, some of them long enough to hold a signature
+	spec := map[string][]byte{
+		"random.bin":   random,
+		"short.bin":    random[:7],
+		"zeros.bin":    make([]byte, 1024),
+		"almost.bin":   append([]byte("EDIx"), random[:600]...),
+		"magicmid.bin": append(append([]byte(nil), random[:100]...), FileMagic...),
+		"empty.bin":    {},
+	}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	report, err := DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	if report.Processed != 0 || report.Skipped != len(spec) {
+		t.Errorf("DecryptWithOptions: processed = %d, skipped = %d, want 0 and %d", report.Processed, report.Skipped, len(spec))
+	}
+	assertTree(t, dir, spec)
+
+	// the same files are plaintext to encrypt, and come back whole
+	_, err = EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	for rel, contents := range readTree(t, dir) {
+		if !bytes.HasPrefix(contents, []byte(FileMagic)) {
+			t.Errorf("path = %q: encrypted without the file magic", rel)
+		}
+	}
+	_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	assertTree(t, dir, spec)
+}
+
+func TestSignaturePrefixEncrypted(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	// starts like a version 1 file, with a signature of the key that verifies, but without the magic
+	sig, err := rsa.CreateSignature(privKey, keyMap["txt"], DefaultHashAlgo)
+	if err != nil {
+		t.Fatalf("rsa.CreateSignature: %v", err)
+	}
+	plain := append(sig, "still a secret"...)
+
+	for _, legacy := range []bool{false, true} {
+		dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": plain})
+		opts := Options{LegacyFormat: legacy}
+
+		report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+		if err != nil || report.Processed != 1 {
+			t.Fatalf("LegacyFormat = %t: EncryptWithOptions = processed %d, %v, want 1", legacy, report.Processed, err)
+		}
+		got := readTree(t, dir)["a.txt"]
+		if !bytes.HasPrefix(got, []byte(FileMagic)) || bytes.Contains(got, []byte("still a secret")) {
+			t.Errorf("LegacyFormat = %t: a.txt left as it was, want it encrypted", legacy)
+		}
+
+		_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+		if err != nil {
+			t.Fatalf("LegacyFormat = %t: DecryptWithOptions: %v", legacy, err)
+		}
+		assertTree(t, dir, map[string][]byte{"a.txt": plain})
+	}
+}
@@ -0,0 +1,123 @@
+package encryptdir
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// sentinel error used for when a decrypted file doesnt have the hash or size its `Options.Manifest` entry recorded
+var ErrManifestMismatch = errors.New("decrypted file doesn't match the manifest")
+
+// ManifestEntry: a single line of a manifest, the plaintext hash and size of a file
+type ManifestEntry struct {
+	Path   string     `json:"path"`
+	SHA256 string     `json:"sha256"`
+	Size   int64      `json:"size"`
+	Status FileStatus `json:"status"`
+}
+
+// Manifest: entries by path, like `ReadManifest` returns them
+type Manifest map[string]ManifestEntry
+
+// encryptdir.Report.WriteManifest: writes every file of `r` as a JSON line of `ManifestEntry`, in the order they were visited
+// files only have a hash with `Options.HashContents`, skipped and failed files are written too so the manifest accounts for every file
+// returns: error
+func (r *Report) WriteManifest(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, f := range r.Files {
+		err := enc.Encode(ManifestEntry{Path: f.Path, SHA256: f.SHA256, Size: f.Size, Status: f.Status})
+		if err != nil {
+			return fmt.Errorf("encryptdir.Report.WriteManifest: json.Encoder.Encode: %w", err)
+		}
+	}
+
+	err := bw.Flush()
+	if err != nil {
+		return fmt.Errorf("encryptdir.Report.WriteManifest: bufio.Writer.Flush: %w", err)
+	}
+	return nil
+}
+
+// encryptdir.ReadManifest: reads the JSON lines `Report.WriteManifest` wrote, a later line for the same path wins
+// returns: manifest or error
+func ReadManifest(r io.Reader) (Manifest, error) {
+	m := make(Manifest)
+	dec := json.NewDecoder(r)
+	for {
+		var entry ManifestEntry
+		err := dec.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			return m, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("encryptdir.ReadManifest: json.Decoder.Decode: %w", err)
+		}
+		m[entry.Path] = entry
+	}
+}
+
+// encryptdir.Manifest.check: checks the plaintext of the file at `path` has the hash and size its entry recorded
+// files without an entry, or whose entry has no hash, arent checked
+// returns: error wrapping `ErrManifestMismatch`
+func (m Manifest) check(path string, sum string, size int64) error {
+	entry, ok := m[path]
+	if !ok || len(entry.SHA256) == 0 {
+		return nil
+	}
+	if entry.SHA256 != sum || entry.Size != size {
+		return fmt.Errorf("encryptdir.Manifest.check: path = %q, sha256 = %s, want %s: %w", path, sum, entry.SHA256, ErrManifestMismatch)
+	}
+	return nil
+}
+
+// encryptdir.checkManifest: checks the plaintext hashed into `h` against the entry for `path` in `m`, a nil `m` checks nothing
+// returns: error wrapping `ErrManifestMismatch`
+func checkManifest(m Manifest, path string, h *contentHash) error {
+	if m == nil || h == nil {
+		return nil
+	}
+	sum, size := h.sum()
+	err := m.check(path, sum, size)
+	if err != nil {
+		return fmt.Errorf("encryptdir.checkManifest: %w", err)
+	}
+	return nil
+}
+
+// contentHash: SHA-256 of plaintext written to it, with its size
+type contentHash struct {
+	h    hash.Hash
+	size int64
+}
+
+// encryptdir.newContentHash: empty hash, nil unless `on`, a nil `*contentHash` ignores what is written to it
+func newContentHash(on bool) *contentHash {
+	if !on {
+		return nil
+	}
+	return &contentHash{h: sha256.New()}
+}
+
+// encryptdir.contentHash.Write: hashes `p`
+func (c *contentHash) Write(p []byte) (int, error) {
+	if c == nil {
+		return len(p), nil
+	}
+	c.size += int64(len(p))
+	return c.h.Write(p)
+}
+
+// encryptdir.contentHash.sum: hex SHA-256 and size of everything written
+func (c *contentHash) sum() (string, int64) {
+	if c == nil {
+		return "", 0
+	}
+	return hex.EncodeToString(c.h.Sum(nil)), c.size
+}
@@ -0,0 +1,98 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	gorsa "crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+// encryptManifest: encrypts `dir` hashing the contents
+// returns: the manifest of the run, written and read back
+func encryptManifest(t *testing.T, privKey *gorsa.PrivateKey, keyMap map[string][]byte, dir string) Manifest {
+	t.Helper()
+
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{HashContents: true})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+
+	var buf bytes.Buffer
+	err = report.WriteManifest(&buf)
+	if err != nil {
+		t.Fatalf("WriteManifest: %v", err)
+	}
+	m, err := ReadManifest(&buf)
+	if err != nil {
+		t.Fatalf("ReadManifest: %v", err)
+	}
+	return m
+}
+
+func TestWriteManifest(t *testing.T) {
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world"), "c.md": []byte("no key")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	m := encryptManifest(t, testutil.NewPrivateKey(t), testutil.NewKeyMap("txt"), dir)
+	// every file, skipped ones without a hash
+	if len(m) != len(spec) {
+		t.Errorf("manifest has %d entries, want %d", len(m), len(spec))
+	}
+	for rel, plain := range spec {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		entry, ok := m[path]
+		if !ok {
+			t.Errorf("path = %q: no manifest entry", rel)
+			continue
+		}
+
+		want := ManifestEntry{Path: path, Status: FileSkipped}
+		if filepath.Ext(rel) == ".txt" {
+			sum := sha256.Sum256(plain)
+			want = ManifestEntry{Path: path, SHA256: hex.EncodeToString(sum[:]), Size: int64(len(plain)), Status: FileProcessed}
+		}
+		if entry != want {
+			t.Errorf("path = %q: entry = %+v, want %+v", rel, entry, want)
+		}
+	}
+}
+
+func TestManifestVerify(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	m := encryptManifest(t, privKey, keyMap, dir)
+	report, err := DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{Manifest: m})
+	if err != nil || report.Processed != 2 {
+		t.Fatalf("DecryptWithOptions: processed = %d, err = %v, want 2 and no error", report.Processed, err)
+	}
+	assertTree(t, dir, spec)
+
+	// an entry that no longer matches what the file decrypts to
+	m = encryptManifest(t, privKey, keyMap, dir)
+	path := filepath.Join(dir, "a.txt")
+	entry := m[path]
+	entry.SHA256 = hex.EncodeToString(make([]byte, sha256.Size))
+	m[path] = entry
+	encrypted := readTree(t, dir)
+
+	report, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{Manifest: m})
+	if !errors.Is(err, ErrManifestMismatch) {
+		t.Fatalf("DecryptWithOptions: err = %v, want ErrManifestMismatch", err)
+	}
+	if report.Processed != 1 || report.Failed != 1 {
+		t.Errorf("DecryptWithOptions: processed = %d, failed = %d, want 1 and 1", report.Processed, report.Failed)
+	}
+	// the mismatching file stays encrypted
+	if got := readTree(t, dir)["a.txt"]; !bytes.Equal(got, encrypted["a.txt"]) {
+		t.Errorf("a.txt: replaced by a plaintext that doesnt match the manifest")
+	}
+}
@@ -0,0 +1,53 @@
+package encryptdir
+
+import (
+	"crypto"
+	gorsa "crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/prairir/encryptdir/pkg/aes"
+	"github.com/prairir/encryptdir/pkg/rsa"
+)
+
+// sentinel error used for when `Options.EmptyDirMarker` has no key for its extension, it wraps `ErrKeyNotFound`
+var ErrNoMarkerKey = fmt.Errorf("empty directory marker: %w", ErrKeyNotFound)
+
+// encryptdir.ensureMarker: writes an encrypted, empty `name` file into `outDir` if `dir` has no entries
+// `outDir` is `dir` itself unless the tree is mirrored into `Options.OutputDir`
+// the marker is encrypted with the key for its own extension, so it shows up next to the other encrypted files in an audit
+// returns: error wrapping `ErrNoMarkerKey` if `keyMap` has no key for `name`
+func ensureMarker(privKey *gorsa.PrivateKey, keyMap map[string][]byte, dir string, outDir string, name string, hash crypto.Hash) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("encryptdir.ensureMarker: os.ReadDir: %w", err)
+	}
+
+	if len(entries) > 0 {
+		return nil
+	}
+
+	key, ok := lookupKey(keyMap, name)
+	if !ok {
+		return fmt.Errorf("encryptdir.ensureMarker: name = %q: %w", name, ErrNoMarkerKey)
+	}
+
+	sig, err := rsa.CreateSignature(privKey, key, hash)
+	if err != nil {
+		return fmt.Errorf("encryptdir.ensureMarker: rsa.CreateSignature: %w", err)
+	}
+
+	cipher, err := aes.EncryptGCM(key, nil)
+	if err != nil {
+		return fmt.Errorf("encryptdir.ensureMarker: %w", err)
+	}
+
+	err = writeNewFile(filepath.Join(outDir, name), append(append(newFileHeader(name, 0644, hash).marshal(), sig...), cipher...), 0644)
+	// another run got there first
+	if err != nil && !errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("encryptdir.ensureMarker: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestEmptyDirMarker(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt", "keep")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"sub/a.txt": []byte("hello")})
+	for _, rel := range []string{"empty", "sub/empty"} {
+		err := os.MkdirAll(filepath.Join(dir, filepath.FromSlash(rel)), 0755)
+		if err != nil {
+			t.Fatalf("os.MkdirAll: %v", err)
+		}
+	}
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{EmptyDirMarker: ".keep"})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+
+	// only the empty dirs get one
+	tree := readTree(t, dir)
+	for _, rel := range []string{"empty/.keep", "sub/empty/.keep"} {
+		if _, ok := tree[rel]; !ok {
+			t.Errorf("path = %q: no marker", rel)
+			continue
+		}
+		got, err := DecryptFileToBytes(privKey, keyMap["keep"], filepath.Join(dir, filepath.FromSlash(rel)))
+		if err != nil || len(got) != 0 {
+			t.Errorf("path = %q: DecryptFileToBytes = %q, %v, want an encrypted empty file", rel, got, err)
+		}
+	}
+	if _, ok := tree["sub/.keep"]; ok {
+		t.Errorf("path = %q: marker in a dir that isnt empty", "sub/.keep")
+	}
+	if len(tree) != 3 {
+		t.Errorf("%d files after encrypting, want a.txt and the 2 markers", len(tree))
+	}
+}
+
+func TestEmptyDirMarkerOff(t *testing.T) {
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello")})
+	err := os.Mkdir(filepath.Join(dir, "empty"), 0755)
+	if err != nil {
+		t.Fatalf("os.Mkdir: %v", err)
+	}
+
+	_, err = EncryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), testutil.NewKeyMap("txt", "keep"), []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if _, ok := readTree(t, dir)["empty/.keep"]; ok {
+		t.Errorf("marker written without EmptyDirMarker")
+	}
+}
+
+func TestEmptyDirMarkerNoKey(t *testing.T) {
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello")})
+	err := os.Mkdir(filepath.Join(dir, "empty"), 0755)
+	if err != nil {
+		t.Fatalf("os.Mkdir: %v", err)
+	}
+
+	_, err = EncryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), testutil.NewKeyMap("txt"), []string{dir}, Options{EmptyDirMarker: ".keep"})
+	if !errors.Is(err, ErrNoMarkerKey) || !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("EncryptWithOptions: err = %v, want ErrNoMarkerKey", err)
+	}
+}
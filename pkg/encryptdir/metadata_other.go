@@ -0,0 +1,19 @@
+//go:build !unix
+
+package encryptdir
+
+import (
+	"fmt"
+	"os"
+)
+
+// encryptdir.copyMetadata: gives the file at `dst` the modification time of the original described by `info`
+// there are no unix owners to restore on this platform, and no portable access time, so it is set to the modification time
+// returns: error
+func copyMetadata(info os.FileInfo, dst string) error {
+	err := chtimes(dst, info.ModTime(), info.ModTime())
+	if err != nil {
+		return fmt.Errorf("encryptdir.copyMetadata: os.Chtimes: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package encryptdir
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+// modTime: the modification time of the file at `path`
+func modTime(t *testing.T, path string) time.Time {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("os.Stat: %v", err)
+	}
+	return info.ModTime()
+}
+
+func TestPreserveMetadata(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world")}
+	// well in the past, a fresh file can never have it
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for _, preserve := range []bool{false, true} {
+		dir, _ := testutil.BuildTree(t, spec)
+		for rel := range spec {
+			err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(rel)), old, old)
+			if err != nil {
+				t.Fatalf("os.Chtimes: %v", err)
+			}
+		}
+		opts := Options{PreserveMetadata: preserve}
+
+		_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+		if err != nil {
+			t.Fatalf("EncryptWithOptions: %v", err)
+		}
+		for rel := range spec {
+			got := modTime(t, filepath.Join(dir, filepath.FromSlash(rel)))
+			if got.Equal(old) != preserve {
+				t.Errorf("PreserveMetadata = %t: path = %q: encrypted mtime = %v, original %v", preserve, rel, got, old)
+			}
+		}
+
+		if !preserve {
+			continue
+		}
+		_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+		if err != nil {
+			t.Fatalf("DecryptWithOptions: %v", err)
+		}
+		assertTree(t, dir, spec)
+		for rel := range spec {
+			if got := modTime(t, filepath.Join(dir, filepath.FromSlash(rel))); !got.Equal(old) {
+				t.Errorf("path = %q: mtime after a round trip = %v, want %v", rel, got, old)
+			}
+		}
+	}
+}
@@ -0,0 +1,32 @@
+//go:build unix
+
+package encryptdir
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// encryptdir.copyMetadata: gives the file at `dst` the access and modification times of the original described by `info`, and its owner
+// the owner is only restored when the process is allowed to, changing it needs root for another user's file
+// returns: error
+func copyMetadata(info os.FileInfo, dst string) error {
+	err := chtimes(dst, accessTime(info), info.ModTime())
+	if err != nil {
+		return fmt.Errorf("encryptdir.copyMetadata: os.Chtimes: %w", err)
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+
+	err = os.Lchown(dst, int(stat.Uid), int(stat.Gid))
+	if err != nil && !errors.Is(err, fs.ErrPermission) {
+		return fmt.Errorf("encryptdir.copyMetadata: os.Lchown: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package encryptdir
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sentinel error used for when `Options.OutputDir` is set with other than one root, or is the root or holds it
+var ErrBadOutputDir = errors.New("output dir needs exactly one root it doesnt hold")
+
+// encryptdir.Options.checkOutputDir: checks `o.OutputDir` can mirror `directories`
+// relative paths of several roots would collide in it, and the root would mirror into itself
+// an output dir under the root is fine, the walk never descends into it, so the output isnt walked again
+// returns: error wrapping `ErrBadOutputDir`
+func (o Options) checkOutputDir(directories []string) error {
+	if len(o.OutputDir) == 0 {
+		if o.ContentAddressed {
+			return fmt.Errorf("encryptdir.Options.checkOutputDir: content addressed: %w", ErrBadOutputDir)
+		}
+		return nil
+	}
+	if len(directories) != 1 {
+		return fmt.Errorf("encryptdir.Options.checkOutputDir: roots = %d: %w", len(directories), ErrBadOutputDir)
+	}
+
+	root, err := filepath.Abs(directories[0])
+	if err != nil {
+		return fmt.Errorf("encryptdir.Options.checkOutputDir: filepath.Abs: %w", err)
+	}
+	out, err := filepath.Abs(o.OutputDir)
+	if err != nil {
+		return fmt.Errorf("encryptdir.Options.checkOutputDir: filepath.Abs: %w", err)
+	}
+
+	if within(out, root) {
+		return fmt.Errorf("encryptdir.Options.checkOutputDir: root = %q, output dir = %q: %w", root, out, ErrBadOutputDir)
+	}
+	return nil
+}
+
+// encryptdir.within: if the absolute `path` is `dir` or under it
+func within(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// encryptdir.Options.outputPath: where the output for the file at `rel` under the root is written
+// `fullPath` itself without `OutputDir`, so the output replaces the original
+func (o Options) outputPath(fullPath string, rel string) string {
+	if len(o.OutputDir) == 0 {
+		return fullPath
+	}
+	return filepath.Join(o.OutputDir, rel)
+}
+
+// encryptdir.Options.keptPath: where `KeepOriginal` writes the output for the file at `fullPath`, encrypted or `decrypt`ed, next to it
+func (o Options) keptPath(fullPath string, decrypt bool) string {
+	if !decrypt {
+		return fullPath + o.keepSuffix()
+	}
+	if base := filepath.Base(fullPath); len(base) > len(o.keepSuffix()) && strings.HasSuffix(base, o.keepSuffix()) {
+		return strings.TrimSuffix(fullPath, o.keepSuffix())
+	}
+	return fullPath + o.keepDecSuffix()
+}
+
+// encryptdir.mirrorDirs: creates `outDir` and the dirs of `rel` under it, with the permission bits of the same dirs under `root`
+// dirs that already exist are left as they are, cwalk's workers can reach a file before its dir so they race to create the same ones
+// returns: error
+func mirrorDirs(root string, outDir string, rel string) error {
+	dirs := []string{"."}
+	if rel = filepath.Clean(rel); rel != "." {
+		parts := strings.Split(rel, string(filepath.Separator))
+		for i := range parts {
+			dirs = append(dirs, filepath.Join(parts[:i+1]...))
+		}
+	}
+
+	for _, dir := range dirs {
+		info, err := os.Stat(filepath.Join(root, dir))
+		if err != nil {
+			return fmt.Errorf("encryptdir.mirrorDirs: os.Stat: %w", err)
+		}
+
+		out := filepath.Join(outDir, dir)
+		err = os.Mkdir(out, info.Mode().Perm())
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("encryptdir.mirrorDirs: os.Mkdir: %w", err)
+		}
+
+		// the umask can take bits off of `os.Mkdir`
+		err = os.Chmod(out, info.Mode().Perm())
+		if err != nil {
+			return fmt.Errorf("encryptdir.mirrorDirs: os.Chmod: %w", err)
+		}
+	}
+	return nil
+}
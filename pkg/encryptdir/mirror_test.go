@@ -0,0 +1,104 @@
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestOutputDirUnderRoot(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world")}
+	dir, _ := testutil.BuildTree(t, spec)
+	out := filepath.Join(dir, "out")
+
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{OutputDir: out})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	// the output written under the root isnt walked and encrypted again
+	if report.Processed != len(spec) {
+		t.Errorf("EncryptWithOptions: processed = %d, want %d", report.Processed, len(spec))
+	}
+	_, err = os.Stat(filepath.Join(out, "out"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("os.Stat(out/out): err = %v, want the output not mirrored into itself", err)
+	}
+
+	for name, want := range spec {
+		plain, err := decryptBytes(t, keyMap, filepath.Join(out, name), Options{})
+		if err != nil || string(plain) != string(want) {
+			t.Errorf("decryptTo(%s) = %q, %v, want %q", name, plain, err, want)
+		}
+	}
+	// the root itself is left alone
+	got := readTree(t, dir)
+	for name, want := range spec {
+		if string(got[name]) != string(want) {
+			t.Errorf("%s = %q, want %q", name, got[name], want)
+		}
+	}
+}
+
+func TestOutputDirHoldsRoot(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello")})
+
+	for _, out := range []string{dir, filepath.Dir(dir)} {
+		_, err := EncryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), keyMap, []string{dir}, Options{OutputDir: out})
+		if !errors.Is(err, ErrBadOutputDir) {
+			t.Errorf("EncryptWithOptions(%s): err = %v, want ErrBadOutputDir", out, err)
+		}
+	}
+}
+
+func TestOutputDirMirror(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "a/b.txt": []byte("world"), "a/c/d.txt": []byte("deep"), "a/e.md": []byte("no key")}
+	dir, _ := testutil.BuildTree(t, spec)
+	err := os.Chmod(filepath.Join(dir, "a", "c"), 0700)
+	if err != nil {
+		t.Fatalf("os.Chmod: %v", err)
+	}
+	out := filepath.Join(t.TempDir(), "out")
+
+	_, err = EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{OutputDir: out})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	// the source is untouched
+	assertTree(t, dir, spec)
+
+	// the files with a key at the same relative path, the dirs with the modes of the originals
+	mirror := readTree(t, out)
+	if len(mirror) != 3 {
+		t.Errorf("mirror has %d files, want the 3 with a key", len(mirror))
+	}
+	for _, rel := range []string{"a.txt", "a/b.txt", "a/c/d.txt"} {
+		if _, ok := mirror[rel]; !ok {
+			t.Errorf("path = %q: missing from the mirror", rel)
+		}
+	}
+	info, err := os.Stat(filepath.Join(out, "a", "c"))
+	if err != nil {
+		t.Fatalf("os.Stat: %v", err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("mirror of a/c: mode = %v, want 0700", info.Mode().Perm())
+	}
+
+	// and back the other way, into a mirror of the mirror
+	back := filepath.Join(t.TempDir(), "back")
+	_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{out}, Options{OutputDir: back})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	assertTree(t, out, mirror)
+	assertTree(t, back, map[string][]byte{"a.txt": []byte("hello"), "a/b.txt": []byte("world"), "a/c/d.txt": []byte("deep")})
+}
@@ -0,0 +1,71 @@
+//go:build unix
+
+package encryptdir
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestOutputFileMode(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world")}
+
+	for _, test := range []struct {
+		name  string
+		umask int
+		mode  os.FileMode
+		opts  Options
+	}{
+		// the umask would take the group and other bits off
+		{"looser", 0077, 0644, Options{}},
+		// and wouldnt take any off the 0666 source
+		{"stricter", 0, 0600, Options{}},
+		{"direct write", 0077, 0640, Options{DirectWrite: true}},
+		{"streamed", 0077, 0640, Options{StreamThreshold: -1}},
+	} {
+		t.Run(fmt.Sprintf("%s, umask %04o", test.name, test.umask), func(t *testing.T) {
+			umask := syscall.Umask(test.umask)
+			t.Cleanup(func() { syscall.Umask(umask) })
+
+			dir, _ := testutil.BuildTree(t, spec)
+			for rel := range spec {
+				err := os.Chmod(filepath.Join(dir, filepath.FromSlash(rel)), 0666)
+				if err != nil {
+					t.Fatalf("os.Chmod: %v", err)
+				}
+			}
+			opts := test.opts
+			opts.OutputFileMode = test.mode
+
+			for _, decrypt := range []bool{false, true} {
+				name, run := "EncryptWithOptions", EncryptWithOptions
+				if decrypt {
+					name, run = "DecryptWithOptions", DecryptWithOptions
+				}
+				_, err := run(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+
+				for rel := range spec {
+					info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(rel)))
+					if err != nil {
+						t.Fatalf("os.Stat: %v", err)
+					}
+					if info.Mode().Perm() != test.mode {
+						t.Errorf("%s: path = %q: mode = %04o, want %04o", name, rel, info.Mode().Perm(), test.mode)
+					}
+				}
+			}
+			assertTree(t, dir, spec)
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package encryptdir
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Namer: names the temp files written next to a file before it replaces the original
+type Namer interface {
+	// path of the temp file for `path`, `decrypt` is set when decrypting
+	// the temp file has to be on the same filesystem as `path` so it can be renamed over it
+	TempName(path string, decrypt bool) string
+	// if `path` is a temp file, temp files are never encrypted or decrypted themselves
+	IsTemp(path string) bool
+}
+
+// SuffixNamer: the default `Namer`, appends a suffix to the path
+type SuffixNamer struct {
+	EncSuffix string
+	DecSuffix string
+	// appended after the suffix as `-<pid>` if not 0, so runs of different processes never share a temp file
+	// temp files of every pid are temp files to `IsTemp`
+	PID int
+}
+
+// encryptdir.SuffixNamer.TempName: `path` with the encrypt or decrypt suffix, and the pid if there is one
+func (n SuffixNamer) TempName(path string, decrypt bool) string {
+	suffix := n.EncSuffix
+	if decrypt {
+		suffix = n.DecSuffix
+	}
+	if n.PID != 0 {
+		suffix += "-" + strconv.Itoa(n.PID)
+	}
+	return path + suffix
+}
+
+// encryptdir.SuffixNamer.IsTemp: if `path` ends in either suffix, followed by any pid if `n` has one
+func (n SuffixNamer) IsTemp(path string) bool {
+	_, _, ok := n.split(path)
+	return ok
+}
+
+// encryptdir.SuffixNamer.split: splits the temp file name `path` into the path it was written for and the pid that wrote it
+// returns: path, pid, 0 if the name has none, and if `path` is a temp file
+func (n SuffixNamer) split(path string) (string, int, bool) {
+	for _, suffix := range []string{n.EncSuffix, n.DecSuffix} {
+		if strings.HasSuffix(path, suffix) {
+			return strings.TrimSuffix(path, suffix), 0, true
+		}
+		if n.PID == 0 {
+			continue
+		}
+
+		i := strings.LastIndex(path, suffix+"-")
+		if i < 0 {
+			continue
+		}
+		pid, err := strconv.Atoi(path[i+len(suffix)+1:])
+		if err == nil && pid > 0 {
+			return path[:i], pid, true
+		}
+	}
+	return "", 0, false
+}
@@ -0,0 +1,125 @@
+package encryptdir
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+// prefixNamer: names temp files `.tmp-enc-<name>` and `.tmp-dec-<name>` in the dir of the file, recording every name it gave out
+type prefixNamer struct {
+	mu    *sync.Mutex
+	names *[]string
+}
+
+func (n prefixNamer) TempName(path string, decrypt bool) string {
+	prefix := ".tmp-enc-"
+	if decrypt {
+		prefix = ".tmp-dec-"
+	}
+	name := filepath.Join(filepath.Dir(path), prefix+filepath.Base(path))
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	*n.names = append(*n.names, name)
+	return name
+}
+
+func (n prefixNamer) IsTemp(path string) bool {
+	base := filepath.Base(path)
+	return strings.HasPrefix(base, ".tmp-enc-") || strings.HasPrefix(base, ".tmp-dec-")
+}
+
+func TestSuffixNamer(t *testing.T) {
+	n := SuffixNamer{EncSuffix: ".e", DecSuffix: ".d", PID: 42}
+	if got := n.TempName("dir/a.txt", false); got != "dir/a.txt.e-42" {
+		t.Errorf("TempName(encrypt) = %q, want %q", got, "dir/a.txt.e-42")
+	}
+	if got := n.TempName("dir/a.txt", true); got != "dir/a.txt.d-42" {
+		t.Errorf("TempName(decrypt) = %q, want %q", got, "dir/a.txt.d-42")
+	}
+
+	for path, want := range map[string]bool{
+		"a.txt.e-42":  true,
+		"a.txt.d-7":   true,
+		"a.txt.e":     true,
+		"a.txt":       false,
+		"a.txt.e-":    false,
+		"a.txt.e-abc": false,
+		"a.e.txt":     false,
+	} {
+		if got := n.IsTemp(path); got != want {
+			t.Errorf("IsTemp(%q) = %t, want %t", path, got, want)
+		}
+	}
+
+	// without a pid only the bare suffixes are temp files
+	n.PID = 0
+	if got := n.TempName("a.txt", false); got != "a.txt.e" {
+		t.Errorf("TempName without a pid = %q, want %q", got, "a.txt.e")
+	}
+	if n.IsTemp("a.txt.e-42") {
+		t.Errorf("IsTemp(%q) without a pid = true, want false", "a.txt.e-42")
+	}
+}
+
+func TestCustomNamer(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	var mu sync.Mutex
+	var names []string
+	opts := Options{Namer: prefixNamer{mu: &mu, names: &names}}
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+
+	want := []string{
+		filepath.Join(dir, ".tmp-enc-a.txt"),
+		filepath.Join(dir, "sub", ".tmp-enc-b.txt"),
+		filepath.Join(dir, ".tmp-dec-a.txt"),
+		filepath.Join(dir, "sub", ".tmp-dec-b.txt"),
+	}
+	sort.Strings(want)
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("temp files named %v, want %v", names, want)
+	}
+
+	// the temp files were renamed over the originals, none are left
+	assertTree(t, dir, spec)
+}
+
+func TestCustomNamerIsTemp(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), ".tmp-enc-b.txt": []byte("left by a killed run")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	var mu sync.Mutex
+	var names []string
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{Namer: prefixNamer{mu: &mu, names: &names}})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if report.Processed != 1 {
+		t.Errorf("EncryptWithOptions: processed = %d, want 1", report.Processed)
+	}
+	if got := readTree(t, dir)[".tmp-enc-b.txt"]; string(got) != string(spec[".tmp-enc-b.txt"]) {
+		t.Errorf("temp file of the custom namer was encrypted, want it left alone")
+	}
+}
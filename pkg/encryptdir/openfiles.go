@@ -0,0 +1,83 @@
+package encryptdir
+
+import (
+	"errors"
+	"fmt"
+)
+
+// sentinel error used for when `Options.MaxOpenFiles` is too low to process a single file
+var ErrMaxOpenFilesTooLow = errors.New("max open files too low to process a file")
+
+// descriptors a file holds at most at once while it is processed, the original and its temp file, then the temp file and one more
+// the original is closed once it is read, reading the temp file back, copying it across filesystems, and hashing it all go through its own descriptor,
+// the one more is a dir synced after the rename, a copy, or the detached signature of the original
+const descriptorsPerFile = 2
+
+// fileSemaphore: caps how many files are processed at once across every root and worker, for `Options.MaxOpenFiles` and `Options.MaxWorkers`
+// a nil `*fileSemaphore` has no cap
+type fileSemaphore chan struct{}
+
+// encryptdir.newFileSemaphore: semaphore for `maxOpen` descriptors, `shared` of them held for the whole run like the journal, and for at most `maxFiles` files
+// either is no cap if 0 or less, nil if neither caps
+// returns: semaphore with a slot for every `descriptorsPerFile`, at least one, and no more than `maxFiles`
+func newFileSemaphore(maxOpen int, shared int, maxFiles int) fileSemaphore {
+	files := maxFiles
+	if maxOpen > 0 {
+		byDescriptors := (maxOpen - shared) / descriptorsPerFile
+		if byDescriptors < 1 {
+			byDescriptors = 1
+		}
+		if files <= 0 || byDescriptors < files {
+			files = byDescriptors
+		}
+	}
+	if files <= 0 {
+		return nil
+	}
+	return make(fileSemaphore, files)
+}
+
+// encryptdir.fileSemaphore.acquire: waits for a free slot
+func (s fileSemaphore) acquire() {
+	if s == nil {
+		return
+	}
+	s <- struct{}{}
+}
+
+// encryptdir.fileSemaphore.release: frees the slot taken by `acquire`
+func (s fileSemaphore) release() {
+	if s == nil {
+		return
+	}
+	<-s
+}
+
+// encryptdir.Options.maxOpenFiles: `MaxOpenFiles` if set, otherwise half of the soft open file limit so the rest of the process has room
+// returns: limit, 0 if there is none
+func (o Options) maxOpenFiles() int {
+	if o.MaxOpenFiles > 0 {
+		return o.MaxOpenFiles
+	}
+	return softOpenFileLimit() / 2
+}
+
+// encryptdir.Options.sharedOpenFiles: descriptors held for the whole run of a walk with `o`, the journal
+func (o Options) sharedOpenFiles() int {
+	if o.DryRun || (!o.Resume && len(o.JournalPath) == 0) {
+		return 0
+	}
+	return 1
+}
+
+// encryptdir.Options.checkMaxOpenFiles: `MaxOpenFiles` has room for the descriptors of the run and of one file
+// returns: error wrapping `ErrMaxOpenFilesTooLow`
+func (o Options) checkMaxOpenFiles() error {
+	if o.MaxOpenFiles <= 0 {
+		return nil
+	}
+	if need := o.sharedOpenFiles() + descriptorsPerFile; o.MaxOpenFiles < need {
+		return fmt.Errorf("encryptdir.Options.checkMaxOpenFiles: max open files = %d, need %d: %w", o.MaxOpenFiles, need, ErrMaxOpenFilesTooLow)
+	}
+	return nil
+}
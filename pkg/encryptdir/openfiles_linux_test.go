@@ -0,0 +1,130 @@
+//go:build linux
+
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iafan/cwalk"
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+// openFiles: descriptors of the process open on files under any of `dirs`
+// dirs are left out, the walk reads them and closes them before processing the files in them
+func openFiles(dirs ...string) int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0
+	}
+
+	n := 0
+	for _, entry := range entries {
+		target, err := os.Readlink(filepath.Join("/proc/self/fd", entry.Name()))
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(target)
+		if err == nil && info.IsDir() {
+			continue
+		}
+		for _, dir := range dirs {
+			if strings.HasPrefix(target, dir+string(filepath.Separator)) {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+// peakOpenFiles: runs `run` while polling `openFiles` of `dirs`
+// returns: the most descriptors seen open at once
+func peakOpenFiles(t *testing.T, run func(), dirs ...string) int {
+	t.Helper()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	peak := 0
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			if n := openFiles(dirs...); n > peak {
+				peak = n
+			}
+			select {
+			case <-done:
+				return
+			default:
+			}
+		}
+	}()
+
+	run()
+	close(done)
+	wg.Wait()
+	return peak
+}
+
+func TestMaxOpenFilesDescriptors(t *testing.T) {
+	numWorkers := cwalk.NumWorkers
+	cwalk.NumWorkers = 8
+	t.Cleanup(func() { cwalk.NumWorkers = numWorkers })
+
+	// held at the rename so the workers pile up behind the limit
+	swap = func(tmpPath string, path string) error {
+		time.Sleep(20 * time.Millisecond)
+		return swapInto(tmpPath, path)
+	}
+	t.Cleanup(func() { swap = swapInto })
+
+	spec := make(map[string][]byte)
+	for i := 0; i < 24; i++ {
+		// cwalk walks each dir on one worker, files of different dirs overlap, big enough that reading them back takes a while
+		spec[fmt.Sprintf("d%d/f.txt", i)] = bytes.Repeat([]byte{byte(i)}, 1<<20)
+	}
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+
+	for _, maxOpen := range []int{3, 4, 1000} {
+		t.Run(fmt.Sprint(maxOpen), func(t *testing.T) {
+			dir, _ := testutil.BuildTree(t, spec)
+			journalDir := t.TempDir()
+			opts := Options{MaxOpenFiles: maxOpen, VerifyAfterWrite: true, JournalPath: filepath.Join(journalDir, "journal")}
+
+			var err error
+			encPeak := peakOpenFiles(t, func() {
+				_, err = EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+			}, dir, journalDir)
+			if err != nil {
+				t.Fatalf("EncryptWithOptions: %v", err)
+			}
+			decPeak := peakOpenFiles(t, func() {
+				_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+			}, dir, journalDir)
+			if err != nil {
+				t.Fatalf("DecryptWithOptions: %v", err)
+			}
+			assertTree(t, dir, spec)
+
+			if maxOpen == 1000 {
+				// without a low limit the workers do overlap, so the limit is what held them back
+				if encPeak <= 4 || decPeak <= 4 {
+					t.Errorf("MaxOpenFiles = %d: %d and %d descriptors open at once, want more than 4", maxOpen, encPeak, decPeak)
+				}
+				return
+			}
+			if encPeak > maxOpen || decPeak > maxOpen {
+				t.Errorf("MaxOpenFiles = %d: %d descriptors open at once encrypting, %d decrypting, want at most %d", maxOpen, encPeak, decPeak, maxOpen)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package encryptdir
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iafan/cwalk"
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestNewFileSemaphore(t *testing.T) {
+	tests := []struct {
+		maxOpen, shared, maxFiles, want int
+	}{
+		{-1, 0, 0, 0},
+		{0, 1, 0, 0},
+		{1, 0, 0, 1},
+		{2, 0, 0, 1},
+		{3, 0, 0, 1},
+		{10, 0, 0, 5},
+		// the journal is held for the whole run
+		{10, 1, 0, 4},
+		{3, 1, 0, 1},
+		// the lower of the two caps
+		{0, 0, 3, 3},
+		{10, 0, 3, 3},
+		{10, 0, 8, 5},
+	}
+	for _, tt := range tests {
+		s := newFileSemaphore(tt.maxOpen, tt.shared, tt.maxFiles)
+		if cap(s) != tt.want || (tt.want == 0) != (s == nil) {
+			t.Errorf("newFileSemaphore(%d, %d, %d): %d slots, nil = %t, want %d", tt.maxOpen, tt.shared, tt.maxFiles, cap(s), s == nil, tt.want)
+		}
+	}
+
+	// no cap, never blocks
+	var s fileSemaphore
+	s.acquire()
+	s.release()
+}
+
+func TestCheckMaxOpenFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want error
+	}{
+		{"unset", Options{}, nil},
+		{"one file", Options{MaxOpenFiles: 2}, nil},
+		{"too low", Options{MaxOpenFiles: 1}, ErrMaxOpenFilesTooLow},
+		{"journal", Options{MaxOpenFiles: 2, Resume: true}, ErrMaxOpenFilesTooLow},
+		{"journal fits", Options{MaxOpenFiles: 3, JournalPath: "journal"}, nil},
+		// dry runs never open the journal
+		{"dry run", Options{MaxOpenFiles: 2, Resume: true, DryRun: true}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.checkMaxOpenFiles()
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Options.checkMaxOpenFiles: err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxWorkers(t *testing.T) {
+	numWorkers := cwalk.NumWorkers
+	cwalk.NumWorkers = 8
+	t.Cleanup(func() { cwalk.NumWorkers = numWorkers })
+
+	// held at the rename so the workers pile up behind the cap
+	var mu sync.Mutex
+	var renaming, peak int
+	swap = func(tmpPath string, path string) error {
+		mu.Lock()
+		renaming++
+		if renaming > peak {
+			peak = renaming
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		renaming--
+		mu.Unlock()
+		return swapInto(tmpPath, path)
+	}
+	t.Cleanup(func() { swap = swapInto })
+
+	spec := make(map[string][]byte)
+	for i := 0; i < 24; i++ {
+		// cwalk walks each dir on one worker, files of different dirs overlap
+		spec[fmt.Sprintf("d%d/f.txt", i)] = []byte(fmt.Sprintf("file %d", i))
+	}
+
+	for maxWorkers, want := range map[int]int{2: 2, 0: 3} {
+		dir, _ := testutil.BuildTree(t, spec)
+		peak = 0
+		_, err := EncryptDirs(testutil.NewPrivateKey(t), testutil.NewKeyMap("txt"), []string{dir}, WithMaxWorkers(maxWorkers))
+		if err != nil {
+			t.Fatalf("EncryptDirs: %v", err)
+		}
+		if maxWorkers > 0 && peak > want {
+			t.Errorf("WithMaxWorkers(%d): %d files at once, want at most %d", maxWorkers, peak, want)
+		}
+		// without a cap the workers do overlap, so the cap is what held them back
+		if maxWorkers == 0 && peak < want {
+			t.Errorf("WithMaxWorkers(0): %d files at once, want at least %d", peak, want)
+		}
+	}
+}
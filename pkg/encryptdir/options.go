@@ -0,0 +1,588 @@
+package encryptdir
+
+import (
+	"crypto"
+	gorsa "crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prairir/encryptdir/pkg/aes"
+	"github.com/prairir/encryptdir/pkg/config"
+	"github.com/prairir/encryptdir/pkg/rsa"
+)
+
+// default number of bytes scanned for `Options.ContentMatch`
+const DefaultContentMatchLimit = 1 << 20
+
+// default number of bytes streamed files are encrypted and decrypted in at a time
+const DefaultStreamChunkSize = 1 << 20
+
+// default size from which files are streamed instead of read into memory, one chunk, so with `Options.StreamChunkSize` set it is that instead
+// `BenchmarkStreamThreshold` has no size from 4KiB to 64MiB where reading a file into memory is faster, the two are within noise up to one chunk
+// and streaming is 10 to 25 percent faster from 4MiB on, while holding 2MiB instead of three to four times the file
+// so files smaller than a chunk are kept in memory, where `Options.Compress` and `Options.DirectWrite` apply at no measured cost, and the rest are streamed
+const DefaultStreamThreshold = DefaultStreamChunkSize
+
+// default suffixes of the temp files written next to the original before it is replaced, followed by `-<pid>`
+// long enough that real files, like blobs ending in `.enc`, dont end in them
+const (
+	DefaultEncSuffix = ".encryptdir-enc"
+	DefaultDecSuffix = ".encryptdir-dec"
+)
+
+// default suffixes of the outputs `Options.KeepOriginal` writes next to the original, they are real files rather than temp files
+const (
+	DefaultKeepSuffix    = ".enc"
+	DefaultKeepDecSuffix = ".dec"
+)
+
+// sentinel error used for when a `.dec` file already exists and `SiblingError` is set
+var ErrDecSiblingExists = errors.New("decrypted sibling file already exists")
+
+// sentinel error used for when `Options.PreserveXattrs` is set on a platform without xattrs
+var ErrXattrsUnsupported = errors.New("extended attributes are not supported on this platform")
+
+// sentinel error used for when `Options.OwnerUID` is set on a platform without unix file owners
+var ErrOwnerUnsupported = errors.New("file owners are not supported on this platform")
+
+// sentinel error used for when `Options.CheckFreeSpace` is set on a platform without statfs
+var ErrFreeSpaceUnsupported = errors.New("checking free disk space is not supported on this platform")
+
+// sentinel error used for when the config has an unknown sibling policy
+var ErrUnknownSiblingPolicy = errors.New("unknown sibling policy")
+
+// sentinel error used for when the encrypt and decrypt temp suffixes are the same
+var ErrSameTempSuffix = errors.New("encrypt and decrypt temp suffixes must differ")
+
+// SiblingPolicy: what decryption does when `<name>.dec` already exists
+type SiblingPolicy string
+
+const (
+	// skip the file, another goroutine or run may be working on it
+	SiblingSkip SiblingPolicy = "skip"
+	// remove the existing `.dec` file and decrypt anyway
+	SiblingOverwrite SiblingPolicy = "overwrite"
+	// report the file as an error
+	SiblingError SiblingPolicy = "error"
+)
+
+// Options: settings for the encrypt and decrypt walkers
+// the zero value is the default behaviour
+type Options struct {
+	// what to do when a `.dec` file, or the output of `KeepOriginal`, already exists while decrypting, empty means `SiblingSkip`
+	// `SiblingOverwrite` can clobber the work of a concurrent run on the same tree
+	DecSibling SiblingPolicy
+
+	// plaintext line written above the signature of every encrypted file, and stripped again on decrypt
+	// a trailing newline is added if missing
+	Banner string
+
+	// if set, every encrypted file needs a detached signature from this key in `<name>.sig` before it is decrypted
+	TrustKey *gorsa.PublicKey
+
+	// if set, the AES key of every file encrypted is also wrapped for this key in its file header, for `DecryptWithEscrow` to recover it without the AES key file
+	// the slot is reserved metadata, so with `Options.SignHeader` it is signed like the rest of the header
+	EscrowKey *gorsa.PublicKey
+
+	// if set, only files with content matching it are encrypted
+	ContentMatch *regexp.Regexp
+	// how many bytes of each file are scanned for `ContentMatch`, 0 means `DefaultContentMatchLimit`
+	ContentMatchLimit int64
+
+	// extra AES keys tried in order when decrypting a file whose signature doesn't match its `keyMap` key
+	// encrypting leaves files signed by one of them alone instead of encrypting them a second time
+	Keyring [][]byte
+
+	// write over the original file instead of writing a temp file and renaming it
+	// not atomic, a crash mid write loses the file, only meant for scratch space like a ramdisk
+	DirectWrite bool
+
+	// copy extended attributes, like SELinux labels, from the original file to the temp file before it replaces the original
+	// only supported on linux and darwin
+	PreserveXattrs bool
+
+	// give the output the access and modification times of the original, and on unix its owner when the process is allowed to
+	// without it every output is a new file to backup tools and rsync
+	PreserveMetadata bool
+	// fail a file whose times, owner or original mode couldnt be restored, instead of logging a warning and keeping the output
+	// filesystems like some network mounts refuse `os.Chtimes` or chmod while the contents are fine
+	StrictMetadata bool
+
+	// permission bits of every encrypted and decrypted output, 0 keeps the mode of the original
+	// set on the temp file after it is written, so the umask cant take bits off and the output never has a looser mode on disk even for a moment
+	// the file header still records the mode of the original, decrypting with 0 restores it
+	OutputFileMode os.FileMode
+
+	// report files with a key that aren't encrypted as errors when decrypting, instead of skipping them
+	// files with a file header but no key for it fail too, with `ErrKeyNotFound`
+	StrictDecrypt bool
+
+	// callbacks around each directory root, only settable from code
+	Hooks Hooks
+
+	// count the files with a key before starting, so `Hooks.OnProgress` gets a total
+	CountTotal bool
+
+	// suffixes of the encrypt and decrypt temp files, empty means `DefaultEncSuffix` and `DefaultDecSuffix` followed by `-<pid>`
+	// setting either drops the pid, files ending in either are never encrypted or decrypted themselves
+	EncSuffix string
+	DecSuffix string
+	// names the temp files instead of the suffixes, only settable from code
+	Namer Namer
+
+	// files taking longer than this are reported through `Hooks.OnSlowFile` and `Stats.Slow`, 0 turns it off
+	SlowFileThreshold time.Duration
+	// report slow files as errors too
+	FailOnSlow bool
+
+	// collect the files of each root first and process them in random order, spreads the IO of directories with many large files
+	// there is no deterministic order to take precedence over, without it files are processed in whatever order cwalk's workers reach them
+	// dirs are still handled as they are collected, pruned, marked with `EmptyDirMarker`, and mirrored into `OutputDir`, before any file is processed
+	Shuffle bool
+
+	// stop encrypting once the bytes written by the run would go over this, 0 means no limit
+	// files that dont fit are left as is and reported through `ErrOutputBudget`
+	MaxTotalOutputBytes int64
+
+	// if set, only files owned by this uid are encrypted, only supported on unix
+	OwnerUID *int
+
+	// most descriptors open at once across the whole run, counting the journal and the two each file holds at most while it is processed
+	// 0 means half of the soft open file limit, on platforms without one there is no limit, less than one file and the journal need fails with `ErrMaxOpenFilesTooLow`
+	// dirs the walk reads are closed before the files in them are processed, and arent counted
+	MaxOpenFiles int
+	// most files processed at once across the whole run, whatever `MaxOpenFiles` leaves room for, 0 means no cap of its own
+	// cwalk still walks the dirs with its own workers, they wait on each other for files
+	MaxWorkers int
+
+	// write the output into a mirror of the root under this dir, like `<OutputDir>/a/b.txt` for `<root>/a/b.txt`, and leave the root alone
+	// dirs are created with the permission bits of the ones they mirror, it needs exactly one root and cant be it or hold it, one under the root is never walked
+	// files of the root that are already encrypted, or not encrypted when decrypting, are skipped and so missing from the mirror
+	// takes precedence over `KeepOriginal` and `DirectWrite`
+	OutputDir string
+	// when encrypting into `OutputDir`, write each file as `<OutputDir>/<SHA-256 of it in hex>` instead of mirroring the tree, and map the path of each original to it in `ContentIndexName`
+	// the payload is sealed convergently under a nonce derived from the key and plaintext, so the same file under the same key and options is always the same object and is only stored once
+	// that gives away which files are the same, keys derived from `Passphrases` get a new salt every run so only dedup within one, and `EmptyDirMarker` isnt written
+	// decrypt an object with `DecryptContent`
+	ContentAddressed bool
+
+	// leave the original file alone and write the output next to it, `<name><KeepSuffix>` when encrypting
+	// decrypting writes `<name>` for the file `<name><KeepSuffix>` and `<name><KeepDecSuffix>` for any other, an output that is already there goes by `DecSibling`
+	// takes precedence over `DirectWrite`
+	KeepOriginal bool
+	// empty means `DefaultKeepSuffix` and `DefaultKeepDecSuffix`
+	KeepSuffix    string
+	KeepDecSuffix string
+
+	// name of an encrypted marker file written into every empty directory when encrypting, like `.keep`
+	// keeps the directory structure, its extension needs a key in `keyMap`
+	EmptyDirMarker string
+
+	// refuse to run with weak crypto, see `CheckStrictCrypto`
+	StrictCrypto bool
+	// refuse to run with a key that isnt 32 bytes, every key is at least checked to be 16, 24, or 32 bytes before anything is touched
+	EnforceAES256 bool
+
+	// files of at least this many bytes are streamed through a `StreamChunkSize` buffer instead of read into memory
+	// 0 means `DefaultStreamThreshold`, or `StreamChunkSize` if it is set, less than 0 streams every file
+	// the on-disk format is the same either way, streamed files are never written with `DirectWrite`
+	StreamThreshold int64
+	// 0 means `DefaultStreamChunkSize`, streamed files are sealed in AES-GCM chunks of this size, at most `aes.MaxGCMChunkSize`
+	StreamChunkSize int
+
+	// gzip the plaintext before encrypting it, encrypted files dont compress so backups of them cant either
+	// a file is only stored compressed if that makes it smaller, its file header records it and decrypting gunzips it again
+	// streamed files aren't compressed
+	Compress bool
+
+	// check every directory has room for the temp files before encrypting anything, only supported on linux and darwin
+	CheckFreeSpace bool
+
+	// hash of the AES key signature written when encrypting, 0 means `DefaultHashAlgo`
+	// the file header records it and decrypting only accepts a signature with that hash, version 1 files are accepted with any of md5, sha256, or sha512
+	HashAlgo crypto.Hash
+	// sign the whole file header along with the AES key when encrypting, so changing any of its fields, like the mode or compression, fails the file on decrypt
+	// the file header records it, files with and without it decrypt either way, every file then gets a signature of its own instead of one per key
+	SignHeader bool
+	// when decrypting, fail files whose signature is of the AES key alone with `ErrUnsignedHeader` instead of trusting their file header unsigned
+	// for trees only ever encrypted with `SignHeader`, where a file signed for its key alone had its header swapped, files without a file header are taken as not encrypted
+	RequireSignedHeader bool
+	// hash of the integrity digest of the original plaintext recorded in the file header when encrypting, independent of `HashAlgo`, 0 records none
+	// the digest is an HMAC keyed with the AES key over the bytes as they were read, before `Options.Compress` gzips them or a BOM is stripped
+	// decrypting checks it once those are reversed, after the file is gunzipped and its BOM put back, whatever the options decrypting it
+	IntegrityHash crypto.Hash
+	// record `KeyFingerprint` of the AES key in the file header when encrypting, so decrypting with another key fails with `ErrKeyFingerprint`
+	// instead of the file being skipped as if it wasnt encrypted, and only a key with the fingerprint is tried
+	KeyFingerprint bool
+	// user metadata, like owner or classification, recorded in the clear in the file header of every file encrypted, at most `MaxMetadataSize` bytes as JSON
+	// read back with `ReadMetadata` without any keys, it is only protected from changes with `SignHeader`
+	Metadata map[string]string
+	// extensions without the dot of text files a leading UTF-8 BOM is stripped from before they are encrypted, the file header records it was there
+	// and decrypting puts it back, so the file round trips byte for byte, streamed files are encrypted as they are with the BOM part of the plaintext
+	TextExtensions []string
+	// when encrypting, recorded in the file header as the time the file is no longer valid after, zero records none
+	// decrypting a file past it fails with `ErrExpired`, it is only protected from being changed or removed with `SignHeader`
+	ExpiresAt time.Time
+	// decrypt files past the expiry they were encrypted with anyway
+	AllowExpired bool
+
+	// doublestar globs matched against paths relative to each root, like `**/*.sql`, `**` matches any number of dirs
+	// with `Include` only matching files are processed, `Exclude` wins over it and matching dirs aren't descended into
+	Include []string
+	Exclude []string
+
+	// how many levels below each root files are processed, 1 is only the files of the root itself, 0 means no limit
+	// dirs at the limit aren't descended into, like dirs matching `Exclude`
+	MaxDepth int
+
+	// only files of at least `MinSize` and at most `MaxSize` bytes are processed, the others are reported as skipped
+	// decrypting compares the encrypted files, which are larger than the originals by their header and signature, 0 `MaxSize` means no limit
+	MinSize int64
+	MaxSize int64
+
+	// append every file finished to a journal, `JournalPath` or `DefaultJournalName` in the first root, synced to disk after each one
+	// with `Resume` the files the journal has are skipped without being read, so an interrupted run picks up where it stopped
+	// the journal is removed once a run gets through every file, and never written by a dry run
+	Resume      bool
+	JournalPath string
+
+	// files that are never encrypted, like the private key or the config, skipped with a warning before anything is opened
+	// a key locked away in the tree it unlocks cant be got back, from a config the key and config files are always protected
+	ProtectedPaths []string
+
+	// the walkers only treat files starting with `FileMagic` as encrypted, so files of other programs are never mangled
+	// set it to also decrypt version 1 files, which have no magic and are only told apart by their signature verifying
+	// encrypting never skips a file for its signature alone, so version 1 files are encrypted again rather than risk leaving a plaintext as it is
+	LegacyFormat bool
+
+	// encrypt and decrypt the targets of symlinks, even outside the tree, the links themselves stay as they are
+	// without it links are skipped, cwalk never descends into linked dirs either way
+	FollowSymlinks bool
+
+	// run every check and report the files that would be encrypted or decrypted, without writing anything
+	// `Options.MaxTotalOutputBytes` isn't applied and no empty dir markers are written
+	DryRun bool
+
+	// tag every `FileResult` and the lines logged for its file with the id of the walk worker that processed it, for debugging concurrency issues
+	DebugWorkers bool
+
+	// fail with `ErrNoMatchingFiles` when a run processes no file at all, instead of returning nil as if it did its job
+	// files skipped for any reason dont count, so encrypting a tree that is already encrypted fails too, dry runs count the files they would process
+	RequireMatches bool
+
+	// encrypt and decrypt a file with several hard links once, at the first of its paths walked, and link the others to the output
+	// without it every path is replaced by a file of its own, files are only relinked when replaced in place, unix only
+	PreserveHardlinks bool
+
+	// sync the directory outputs are renamed into once every this many files instead of after each one, 0 or 1 syncs after every file
+	// each output is still synced before it is renamed, a crash can only undo the renames of the last files of each directory, leaving their originals and temp files for `Cleanup`
+	// ignored with `Resume` or `JournalPath`, the journal only records a file once its rename is on disk
+	SyncBatch int
+
+	// stop at the first file or root that fails, canceling the walks of every root, instead of collecting the errors of every file
+	// files already in flight still finish, their errors are returned with the first
+	FailFast bool
+
+	// cancels the walks of single roots while the others keep going, see `RootCancels`
+	RootCancels *RootCancels
+
+	// keep going past entries that fail on their own, files already do unless `FailFast` is set
+	// a dir that fails, like its mirror under `OutputDir` not being created, is reported without skipping the files under it, so they are each reported too,
+	// and with `Shuffle` entries that cant be read are reported and skipped instead of failing the whole root before any file
+	// every failure is still logged, in the `Report`, and in the returned error
+	ContinueOnError bool
+
+	// passphrases per extension, like `keyMap` but the AES keys are derived from them with `aes.DeriveKey`
+	// a passphrase wins over a `keyMap` key for the same extension
+	// every file records the salt and iterations of its key, so decrypting derives it again with just the passphrase
+	Passphrases map[string]string
+	// what keys are derived from `Passphrases` with when encrypting, a random salt per run if it has none
+	KDF aes.KDFParams
+
+	// read every encrypted file back and decrypt it before it replaces the original, a file that doesnt decrypt to the same
+	// SHA-256 fails with `ErrVerifyFailed` and the original is left as it was, files are never written with `DirectWrite` then
+	// the output is read a second time, so it is slower, meant for data that cant be lost
+	VerifyAfterWrite bool
+
+	// hash the plaintext of every file encrypted or decrypted into its `FileResult`, for `Report.WriteManifest`, only settable from code
+	// files are hashed as they are read, before encrypting and after decrypting, so streamed files arent read twice
+	HashContents bool
+	// if set, decrypted files with an entry are checked against its hash and size before they replace the original, only settable from code
+	// a file that doesnt match fails with `ErrManifestMismatch`, in its `FileResult.Err`, and is left encrypted, files without an entry arent checked
+	Manifest Manifest
+
+	// per file results of the run, set by `EncryptWithOptions` and `DecryptWithOptions`
+	results *resultCollector
+
+	// settings per key map extension, set by `EncryptWithRules` and `DecryptWithRules`
+	rules map[string]FileRule
+
+	// absolute `ProtectedPaths`, set by `encryptDirectories`
+	protected map[string]bool
+
+	// the only files under the root processed, relative to it, set by `EncryptPathsWithOptions`, nil walks the whole root
+	paths []string
+}
+
+// encryptdir.Options.streams: if a file of `size` bytes is streamed
+func (o Options) streams(size int64) bool {
+	switch {
+	case o.StreamThreshold < 0:
+		return true
+	case o.StreamThreshold == 0:
+		// the crossover of `DefaultStreamThreshold` is at one chunk whatever its size
+		return size >= int64(o.streamChunkSize())
+	default:
+		return size >= o.StreamThreshold
+	}
+}
+
+// encryptdir.Options.sizeAllowed: if a file of `size` bytes is within `MinSize` and `MaxSize`
+func (o Options) sizeAllowed(size int64) bool {
+	if size < o.MinSize {
+		return false
+	}
+	return o.MaxSize <= 0 || size <= o.MaxSize
+}
+
+// encryptdir.Options.outputMode: the mode of the output of a file whose original had `mode`
+func (o Options) outputMode(mode os.FileMode) os.FileMode {
+	if o.OutputFileMode != 0 {
+		return o.OutputFileMode.Perm()
+	}
+	return mode
+}
+
+// encryptdir.Options.streamChunkSize: size of the buffer streamed files go through
+func (o Options) streamChunkSize() int {
+	if o.StreamChunkSize <= 0 {
+		return DefaultStreamChunkSize
+	}
+	return o.StreamChunkSize
+}
+
+// encryptdir.Options.encSuffix: suffix of the temp file written while encrypting
+func (o Options) encSuffix() string {
+	if len(o.EncSuffix) == 0 {
+		return DefaultEncSuffix
+	}
+	return o.EncSuffix
+}
+
+// encryptdir.Options.decSuffix: suffix of the temp file written while decrypting
+func (o Options) decSuffix() string {
+	if len(o.DecSuffix) == 0 {
+		return DefaultDecSuffix
+	}
+	return o.DecSuffix
+}
+
+// encryptdir.Options.keepSuffix: suffix of the encrypted file `KeepOriginal` writes
+func (o Options) keepSuffix() string {
+	if len(o.KeepSuffix) == 0 {
+		return DefaultKeepSuffix
+	}
+	return o.KeepSuffix
+}
+
+// encryptdir.Options.keepDecSuffix: suffix of the decrypted file `KeepOriginal` writes for a file not ending in `keepSuffix`
+func (o Options) keepDecSuffix() string {
+	if len(o.KeepDecSuffix) == 0 {
+		return DefaultKeepDecSuffix
+	}
+	return o.KeepDecSuffix
+}
+
+// encryptdir.Options.namer: `Namer` if set, otherwise a `SuffixNamer` of the configured suffixes, with the pid if neither is set
+func (o Options) namer() Namer {
+	if o.Namer != nil {
+		return o.Namer
+	}
+
+	n := SuffixNamer{EncSuffix: o.encSuffix(), DecSuffix: o.decSuffix()}
+	if len(o.EncSuffix) == 0 && len(o.DecSuffix) == 0 {
+		n.PID = os.Getpid()
+	}
+	return n
+}
+
+// encryptdir.Options.bannerLine: the banner as it is written to disk, nil if there is no banner
+func (o Options) bannerLine() []byte {
+	if len(o.Banner) == 0 {
+		return nil
+	}
+
+	if strings.HasSuffix(o.Banner, "\n") {
+		return []byte(o.Banner)
+	}
+	return []byte(o.Banner + "\n")
+}
+
+// encryptdir.optionsFromConfig: builds `Options` from the config file fields of `c`
+func optionsFromConfig(c *config.Config) (Options, error) {
+	opts := Options{
+		DecSibling: SiblingPolicy(c.DecSibling),
+		Banner:     c.Banner,
+
+		ContentMatchLimit: c.ContentMatchLimit,
+		DirectWrite:       c.DirectWrite,
+		PreserveXattrs:    c.PreserveXattrs,
+		PreserveMetadata:  c.PreserveMetadata,
+		StrictMetadata:    c.StrictMetadata,
+		StrictDecrypt:     c.StrictDecrypt,
+		EncSuffix:         c.EncSuffix,
+		DecSuffix:         c.DecSuffix,
+		SlowFileThreshold: c.SlowFileThreshold,
+		FailOnSlow:        c.FailOnSlow,
+		Shuffle:           c.Shuffle,
+
+		MaxTotalOutputBytes: c.MaxTotalOutputBytes,
+		OwnerUID:            c.OwnerUID,
+		MaxOpenFiles:        c.MaxOpenFiles,
+		MaxWorkers:          c.MaxWorkers,
+		KeepOriginal:        c.KeepOriginal,
+		KeepSuffix:          c.KeepSuffix,
+		KeepDecSuffix:       c.KeepDecSuffix,
+		OutputDir:           c.OutputDir,
+		ContentAddressed:    c.ContentAddressed,
+		EmptyDirMarker:      c.EmptyDirMarker,
+		StrictCrypto:        c.StrictCrypto,
+		StreamThreshold:     c.StreamThreshold,
+		StreamChunkSize:     c.StreamChunkSize,
+		CheckFreeSpace:      c.CheckFreeSpace,
+		Include:             c.Include,
+		Exclude:             c.Exclude,
+		LegacyFormat:        c.LegacyFormat,
+		FollowSymlinks:      c.FollowSymlinks,
+		DryRun:              c.DryRun,
+		DebugWorkers:        c.DebugWorkers,
+		RequireMatches:      c.RequireMatches,
+		PreserveHardlinks:   c.PreserveHardlinks,
+		FailFast:            c.FailFast,
+		Compress:            c.Compress,
+		EnforceAES256:       c.EnforceAES256,
+		ContinueOnError:     c.ContinueOnError,
+		MaxDepth:            c.MaxDepth,
+		MinSize:             c.MinSize,
+		MaxSize:             c.MaxSize,
+		Resume:              c.Resume,
+		JournalPath:         c.JournalPath,
+		ProtectedPaths:      append([]string{c.ConfigPath, c.PrivateKeyFile, c.PublicKeyFile, c.AESKeyFile, c.EscrowKeyFile}, c.ProtectedPaths...),
+		VerifyAfterWrite:    c.VerifyAfterWrite,
+		SignHeader:          c.SignHeader,
+		RequireSignedHeader: c.RequireSignedHeader,
+		KeyFingerprint:      c.KeyFingerprint,
+		Metadata:            c.Metadata,
+		TextExtensions:      c.TextExtensions,
+		ExpiresAt:           c.ExpiresAt,
+		AllowExpired:        c.AllowExpired,
+		SyncBatch:           c.SyncBatch,
+		Passphrases:         c.Passphrases,
+		KDF:                 aes.KDFParams{Iterations: c.KDFIterations},
+	}
+
+	hash, err := ParseHashAlgo(c.HashAlgo)
+	if err != nil {
+		return Options{}, fmt.Errorf("encryptdir.optionsFromConfig: hash_algo: %w", err)
+	}
+	opts.HashAlgo = hash
+
+	opts.IntegrityHash, err = ParseHashAlgo(c.IntegrityHash)
+	if err != nil {
+		return Options{}, fmt.Errorf("encryptdir.optionsFromConfig: integrity_hash: %w", err)
+	}
+
+	if len(c.OutputFileMode) > 0 {
+		mode, err := strconv.ParseUint(c.OutputFileMode, 8, 32)
+		if err != nil || mode > 0777 {
+			return Options{}, fmt.Errorf("encryptdir.optionsFromConfig: output_file_mode = %q: not an octal permission", c.OutputFileMode)
+		}
+		opts.OutputFileMode = os.FileMode(mode)
+	}
+
+	if len(c.EscrowKeyFile) > 0 {
+		opts.EscrowKey, err = rsa.ReadPublicKey(c.EscrowKeyFile)
+		if err != nil {
+			return Options{}, fmt.Errorf("encryptdir.optionsFromConfig: escrow_key = %q: %w", c.EscrowKeyFile, err)
+		}
+	}
+
+	err = opts.validate(c.RSAKey)
+	if err != nil {
+		return Options{}, fmt.Errorf("encryptdir.optionsFromConfig: %w", err)
+	}
+
+	for _, path := range c.KeyringFiles {
+		keyMap, err := aes.ReadKeys(c.RSAKey, path)
+		if err != nil {
+			return Options{}, fmt.Errorf("encryptdir.optionsFromConfig: keyring = %q: aes.ReadKeys: %w", path, err)
+		}
+
+		// sorted so the order keys are tried in doesnt change between runs
+		exts := make([]string, 0, len(keyMap))
+		for ext := range keyMap {
+			exts = append(exts, ext)
+		}
+		sort.Strings(exts)
+
+		for _, ext := range exts {
+			opts.Keyring = append(opts.Keyring, keyMap[ext])
+		}
+	}
+
+	if len(c.ContentMatch) > 0 {
+		re, err := regexp.Compile(c.ContentMatch)
+		if err != nil {
+			return Options{}, fmt.Errorf("encryptdir.optionsFromConfig: content_match: regexp.Compile: %w", err)
+		}
+		opts.ContentMatch = re
+	}
+
+	return opts, nil
+}
+
+// encryptdir.Options.validate: checks the settings of `o` that dont depend on the keys or dirs, before anything is touched
+// every walk runs it whether `o` came from the config or not, `privKey` is the key it signs or verifies with
+// returns: error wrapping `ErrWeakCrypto` with `Options.StrictCrypto`, `ErrUnknownSiblingPolicy`, `ErrReservedMetadata`, `ErrMetadataTooLarge`, or `ErrSameTempSuffix`
+func (o Options) validate(privKey *gorsa.PrivateKey) error {
+	if o.StrictCrypto {
+		err := CheckStrictCrypto(privKey, o.signatureHash())
+		if err != nil {
+			return fmt.Errorf("encryptdir.Options.validate: %w", err)
+		}
+		if o.IntegrityHash == crypto.MD5 {
+			return fmt.Errorf("encryptdir.Options.validate: integrity hash = md5: %w", ErrWeakCrypto)
+		}
+	}
+
+	if _, ok := hashIDs[o.IntegrityHash]; o.IntegrityHash != 0 && !ok {
+		return fmt.Errorf("encryptdir.Options.validate: integrity hash = %v: %w", o.IntegrityHash, ErrUnknownHash)
+	}
+
+	err := o.checkMetadata()
+	if err != nil {
+		return fmt.Errorf("encryptdir.Options.validate: %w", err)
+	}
+
+	err = o.checkMaxOpenFiles()
+	if err != nil {
+		return fmt.Errorf("encryptdir.Options.validate: %w", err)
+	}
+
+	switch o.DecSibling {
+	case "", SiblingSkip, SiblingOverwrite, SiblingError:
+	default:
+		return fmt.Errorf("encryptdir.Options.validate: dec_sibling = %q: %w", o.DecSibling, ErrUnknownSiblingPolicy)
+	}
+
+	if o.encSuffix() == o.decSuffix() {
+		return fmt.Errorf("encryptdir.Options.validate: enc_suffix = %q, dec_suffix = %q: %w", o.encSuffix(), o.decSuffix(), ErrSameTempSuffix)
+	}
+	return nil
+}
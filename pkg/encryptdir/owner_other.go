@@ -0,0 +1,14 @@
+//go:build !unix
+
+package encryptdir
+
+import (
+	"fmt"
+	"os"
+)
+
+// encryptdir.ownedBy: file owners arent supported on this platform
+// returns: `ErrOwnerUnsupported`
+func ownedBy(info os.FileInfo, uid int) (bool, error) {
+	return false, fmt.Errorf("encryptdir.ownedBy: %w", ErrOwnerUnsupported)
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package encryptdir
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// encryptdir.ownedBy: checks if the file described by `info` is owned by `uid`
+// returns: if it is owned by `uid` or error
+func ownedBy(info os.FileInfo, uid int) (bool, error) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false, fmt.Errorf("encryptdir.ownedBy: %w", ErrOwnerUnsupported)
+	}
+	return int(stat.Uid) == uid, nil
+}
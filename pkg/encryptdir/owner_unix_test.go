@@ -0,0 +1,80 @@
+//go:build unix
+
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestOwnerUID(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("giving files to another owner needs root")
+	}
+	const otherUID = 4242
+
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"mine.txt": []byte("mine"), "sub/mine.txt": []byte("mine too"), "theirs.txt": []byte("theirs")}
+	dir, _ := testutil.BuildTree(t, spec)
+	err := os.Chown(filepath.Join(dir, "theirs.txt"), otherUID, otherUID)
+	if err != nil {
+		t.Fatalf("os.Chown: %v", err)
+	}
+
+	uid := os.Geteuid()
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{OwnerUID: &uid})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if report.Processed != 2 {
+		t.Errorf("EncryptWithOptions: processed = %d, want 2", report.Processed)
+	}
+
+	got := readTree(t, dir)
+	for _, rel := range []string{"mine.txt", "sub/mine.txt"} {
+		if bytes.Equal(got[rel], spec[rel]) {
+			t.Errorf("path = %q: left as plaintext, want it encrypted", rel)
+		}
+	}
+	if !bytes.Equal(got["theirs.txt"], spec["theirs.txt"]) {
+		t.Errorf("theirs.txt: changed, want the file of uid %d left alone", otherUID)
+	}
+
+	// and only theirs with their uid
+	other := otherUID
+	_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	report, err = EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{OwnerUID: &other})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if report.Processed != 1 {
+		t.Errorf("EncryptWithOptions with uid %d: processed = %d, want 1", otherUID, report.Processed)
+	}
+}
+
+func TestOwnerUIDNoneOwned(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	// every file of the tree is owned by the uid running the test
+	other := os.Geteuid() + 1
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{OwnerUID: &other})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if report.Processed != 0 {
+		t.Errorf("EncryptWithOptions: processed = %d, want 0", report.Processed)
+	}
+	assertTree(t, dir, spec)
+}
@@ -0,0 +1,26 @@
+package encryptdir
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"go.uber.org/zap"
+)
+
+// sentinel error used for when processing a file panicked
+var ErrFilePanic = errors.New("panic while processing file")
+
+// encryptdir.recoverFile: turns a panic while processing a file into the error `err` points to, has to be deferred
+// the stack goes to the debug log, `log` may be nil
+func recoverFile(log *zap.SugaredLogger, path string, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	if log != nil {
+		log.Debugf("panic: path = %q: %v\n%s", path, r, debug.Stack())
+	}
+	*err = fmt.Errorf("%w: %v", ErrFilePanic, r)
+}
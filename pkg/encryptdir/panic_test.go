@@ -0,0 +1,67 @@
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+// panickingNamer: names temp files like the default, panicking for the one for `panicPath` like a bug hit part way through a file
+type panickingNamer struct {
+	SuffixNamer
+	panicPath string
+}
+
+func (n panickingNamer) TempName(path string, decrypt bool) string {
+	if path == n.panicPath {
+		panic("stub blew up")
+	}
+	return n.SuffixNamer.TempName(path, decrypt)
+}
+
+func TestFilePanicRecovered(t *testing.T) {
+	for _, decrypt := range []bool{false, true} {
+		name := "encrypt"
+		if decrypt {
+			name = "decrypt"
+		}
+		t.Run(name, func(t *testing.T) {
+			privKey := testutil.NewPrivateKey(t)
+			keyMap := testutil.NewKeyMap("txt")
+			spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world"), "bad.txt": []byte("panics")}
+			dir, _ := testutil.BuildTree(t, spec)
+			namer := SuffixNamer{EncSuffix: DefaultEncSuffix, DecSuffix: DefaultDecSuffix}
+			opts := Options{Namer: panickingNamer{SuffixNamer: namer, panicPath: filepath.Join(dir, "bad.txt")}}
+
+			run := EncryptWithOptions
+			if decrypt {
+				_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{Namer: namer})
+				if err != nil {
+					t.Fatalf("EncryptWithOptions: %v", err)
+				}
+				run = DecryptWithOptions
+			}
+			before := readTree(t, dir)
+
+			report, err := run(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+			if !errors.Is(err, ErrFilePanic) {
+				t.Fatalf("err = %v, want ErrFilePanic", err)
+			}
+			if !strings.Contains(err.Error(), "stub blew up") || !strings.Contains(err.Error(), "bad.txt") {
+				t.Errorf("err = %v, want the panic value and the file", err)
+			}
+
+			// the run goes on past it
+			if report.Processed != 2 || report.Failed != 1 {
+				t.Errorf("processed = %d, failed = %d, want 2 and 1", report.Processed, report.Failed)
+			}
+			if got := readTree(t, dir)["bad.txt"]; string(got) != string(before["bad.txt"]) {
+				t.Errorf("bad.txt: changed by the run that panicked on it")
+			}
+		})
+	}
+}
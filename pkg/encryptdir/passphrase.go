@@ -0,0 +1,126 @@
+package encryptdir
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/prairir/encryptdir/pkg/aes"
+)
+
+// derivedKeys: the keys of `Options.Passphrases`, shared by every walker of a run
+type derivedKeys struct {
+	passphrases map[string]string
+	// what encrypting derives with and records in the file header
+	params aes.KDFParams
+
+	mu sync.Mutex
+	// by extension, iterations, and salt, so each is only derived once per run
+	keys map[string]*derivedKey
+}
+
+// derivedKey: a key of `derivedKeys`, derived once by the first file that needs it while the others with the same salt wait on `once`
+type derivedKey struct {
+	once sync.Once
+	key  []byte
+	err  error
+}
+
+// encryptdir.Options.deriveKeys: derives the keys of `o.Passphrases` into a copy of `keyMap`, a passphrase wins over a key for the same extension
+// without `o.KDF.Salt` the run gets a random salt, every file records the salt of its key so decrypting doesnt need it
+// returns: key map, derived keys, nil without passphrases, or error
+func (o Options) deriveKeys(keyMap map[string][]byte) (map[string][]byte, *derivedKeys, error) {
+	if len(o.Passphrases) == 0 {
+		return keyMap, nil, nil
+	}
+
+	params := o.KDF
+	if len(params.Salt) == 0 {
+		salt, err := aes.GenSalt()
+		if err != nil {
+			return nil, nil, fmt.Errorf("encryptdir.Options.deriveKeys: %w", err)
+		}
+		params.Salt = salt
+	}
+	if params.Iterations == 0 {
+		params.Iterations = aes.DefaultKDFIterations
+	}
+
+	derived, err := aes.DeriveKeyMapWithParams(o.Passphrases, params)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encryptdir.Options.deriveKeys: %w", err)
+	}
+
+	d := &derivedKeys{passphrases: o.Passphrases, params: params, keys: make(map[string]*derivedKey, len(derived))}
+	merged := make(map[string][]byte, len(keyMap)+len(derived))
+	for ext, key := range keyMap {
+		merged[ext] = key
+	}
+	for ext, key := range derived {
+		merged[ext] = key
+
+		k := &derivedKey{key: key}
+		k.once.Do(func() {})
+		d.keys[derivedKeyID(ext, params)] = k
+	}
+	return merged, d, nil
+}
+
+// encryptdir.derivedKeys.kdf: what the key for `ext` was derived with, nil if it isnt from a passphrase
+func (d *derivedKeys) kdf(ext string) *aes.KDFParams {
+	if d == nil {
+		return nil
+	}
+	if _, ok := d.passphrases[ext]; !ok {
+		return nil
+	}
+	params := d.params
+	return &params
+}
+
+// encryptdir.derivedKeys.key: derives the key for `ext` with the `params` a file header recorded, or the `FallbackExt` key without a passphrase for `ext`
+// returns: key and true, false if there is no passphrase for `ext`, or error wrapping `aes.ErrBadKDFParams`
+func (d *derivedKeys) key(ext string, params aes.KDFParams) ([]byte, bool, error) {
+	if d == nil {
+		return nil, false, nil
+	}
+	passphrase, ok := d.passphrases[ext]
+	if !ok {
+		// a file header records the extension of the file, not the key it fell back to
+		ext = FallbackExt
+		passphrase, ok = d.passphrases[ext]
+	}
+	if !ok {
+		return nil, false, nil
+	}
+
+	id := derivedKeyID(ext, params)
+	d.mu.Lock()
+	k, ok := d.keys[id]
+	if !ok {
+		k = &derivedKey{}
+		d.keys[id] = k
+	}
+	d.mu.Unlock()
+
+	// derived without holding `mu`, files with the same salt wait for the first instead of all deriving it, others dont wait at all
+	k.once.Do(func() {
+		k.key, k.err = aes.DeriveKey(passphrase, params)
+	})
+	if k.err != nil {
+		return nil, false, fmt.Errorf("encryptdir.derivedKeys.key: extension = %q: %w", ext, k.err)
+	}
+	return k.key, true, nil
+}
+
+// encryptdir.derivedKeyID: what `derivedKeys` caches the key for `ext` derived with `params` by
+func derivedKeyID(ext string, params aes.KDFParams) string {
+	var id bytes.Buffer
+	id.WriteString(ext)
+	id.WriteByte(0)
+	id.WriteString(strconv.Itoa(params.Iterations))
+	id.WriteByte(0)
+	id.Write(params.Salt)
+	return id.String()
+}
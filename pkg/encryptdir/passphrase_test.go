@@ -0,0 +1,126 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/aes"
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+// passphraseOpts: options deriving the `txt` key from `passphrase`, with few iterations so the tests stay fast
+func passphraseOpts(passphrase string) Options {
+	return Options{Passphrases: map[string]string{"txt": passphrase}, KDF: aes.KDFParams{Iterations: 1000}}
+}
+
+func TestPassphraseRoundTrip(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, nil, []string{dir}, passphraseOpts("correct horse"))
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+
+	// every file records the salt and iterations it was derived with
+	header, err := ReadHeader(filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatalf("ReadHeader: %v", err)
+	}
+	if header.KDF == nil || header.KDF.Iterations != 1000 || len(header.KDF.Salt) != aes.DefaultSaltSize {
+		t.Fatalf("ReadHeader: kdf = %+v, want 1000 iterations and a %d byte salt", header.KDF, aes.DefaultSaltSize)
+	}
+	encrypted := readTree(t, dir)
+
+	// another passphrase derives another key, which no file verifies with
+	report, err := DecryptWithOptions(context.Background(), nil, privKey, nil, []string{dir}, passphraseOpts("battery staple"))
+	if err != nil || report.Processed != 0 {
+		t.Errorf("DecryptWithOptions with another passphrase: processed = %d, err = %v, want 0 and no error", report.Processed, err)
+	}
+	assertTree(t, dir, encrypted)
+
+	// the run gets a salt of its own, the one in the file header is what derives the key again
+	_, err = DecryptWithOptions(context.Background(), nil, privKey, nil, []string{dir}, passphraseOpts("correct horse"))
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	assertTree(t, dir, spec)
+}
+
+func TestPassphraseIterationsCap(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, nil, []string{dir}, passphraseOpts("correct horse"))
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+
+	// a file header asking for more iterations than `aes.MaxKDFIterations` fails the file instead of being derived
+	path := filepath.Join(dir, "a.txt")
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("os.ReadFile: %v", err)
+	}
+	binary.LittleEndian.PutUint32(contents[IterationsOffset:], 1<<32-1)
+	err = os.WriteFile(path, contents, 0600)
+	if err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+
+	_, err = DecryptWithOptions(context.Background(), nil, privKey, nil, []string{dir}, passphraseOpts("correct horse"))
+	if !errors.Is(err, aes.ErrBadKDFParams) {
+		t.Errorf("DecryptWithOptions: err = %v, want aes.ErrBadKDFParams", err)
+	}
+	assertTree(t, dir, map[string][]byte{"a.txt": contents})
+}
+
+func TestDerivedKeysConcurrent(t *testing.T) {
+	_, derived, err := passphraseOpts("correct horse").deriveKeys(nil)
+	if err != nil {
+		t.Fatalf("Options.deriveKeys: %v", err)
+	}
+
+	want, err := aes.DeriveKey("correct horse", aes.KDFParams{Salt: []byte("0123456789abcdef"), Iterations: 1000})
+	if err != nil {
+		t.Fatalf("aes.DeriveKey: %v", err)
+	}
+
+	// every caller gets the key of its salt, whether another is deriving the same one or another one at the time
+	var wg sync.WaitGroup
+	keys := make([][]byte, 16)
+	errs := make([]error, len(keys))
+	for i := range keys {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			salt := []byte("0123456789abcdef")
+			if i%2 == 1 {
+				salt = derived.params.Salt
+			}
+			keys[i], _, errs[i] = derived.key("txt", aes.KDFParams{Salt: salt, Iterations: 1000})
+		}()
+	}
+	wg.Wait()
+
+	for i, key := range keys {
+		if errs[i] != nil {
+			t.Fatalf("derivedKeys.key: %v", errs[i])
+		}
+		if i%2 == 0 && !bytes.Equal(key, want) {
+			t.Errorf("derivedKeys.key = %x, want %x", key, want)
+		}
+		if i%2 == 1 && !bytes.Equal(key, keys[1]) {
+			t.Errorf("derivedKeys.key with the run's salt = %x, want %x", key, keys[1])
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package encryptdir
+
+import (
+	"bufio"
+	"context"
+	gorsa "crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// sentinel error used for when a relative path given to `EncryptPaths` is outside of the root
+var ErrPathOutsideRoot = errors.New("path is outside of root")
+
+// encryptdir.EncryptPaths: `EncryptPathsWithOptions` without cancellation, logging or options
+// returns: error, joined over every file that failed
+func EncryptPaths(privKey *gorsa.PrivateKey, keyMap map[string][]byte, root string, relPaths []string) error {
+	_, err := EncryptPathsWithOptions(context.Background(), nil, privKey, keyMap, root, relPaths, Options{})
+	if err != nil {
+		return fmt.Errorf("encryptdir.EncryptPaths: %w", err)
+	}
+	return nil
+}
+
+// encryptdir.EncryptPathsWithOptions: encrypts only the files at `relPaths` under `root`, like `EncryptWithOptions` with a walk limited to a manifest
+// every path is validated before anything is encrypted, a path through a link to a dir outside of `root` is outside of it too,
+// links and other non regular files are skipped, the files are encrypted in the order given
+// returns: report, also on error, and error like `EncryptContext`, wrapping `ErrPathOutsideRoot` if a path isnt under `root`
+func EncryptPathsWithOptions(ctx context.Context, log *zap.SugaredLogger, privKey *gorsa.PrivateKey, keyMap map[string][]byte, root string, relPaths []string, opts Options) (*Report, error) {
+	results := newResultCollector()
+
+	paths, err := resolvePaths(root, relPaths)
+	if err != nil {
+		return results.snapshot(), fmt.Errorf("encryptdir.EncryptPathsWithOptions: %w", err)
+	}
+
+	opts.results = results
+	opts.paths = paths
+	err = encryptDirectories(ctx, log, privKey, keyMap, []string{root}, opts)
+	if err != nil {
+		return results.snapshot(), fmt.Errorf("encryptdir.EncryptPathsWithOptions: %w", err)
+	}
+	return results.snapshot(), nil
+}
+
+// encryptdir.resolvePaths: checks every one of `relPaths` is under `root` once the links on the way to it are resolved, and exists
+// returns: the paths cleaned, without the ones given twice, never nil, or error wrapping `ErrPathOutsideRoot`
+func resolvePaths(root string, relPaths []string) ([]string, error) {
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.resolvePaths: filepath.EvalSymlinks: %w", err)
+	}
+
+	paths := make([]string, 0, len(relPaths))
+	seen := make(map[string]bool, len(relPaths))
+	for _, relPath := range relPaths {
+		if !filepath.IsLocal(relPath) {
+			return nil, fmt.Errorf("encryptdir.resolvePaths: path = %q: %w", relPath, ErrPathOutsideRoot)
+		}
+		relPath = filepath.Clean(relPath)
+		fullPath := filepath.Join(root, relPath)
+
+		// the file itself may be a link, it is skipped, but not a dir on the way to it
+		dir, err := filepath.EvalSymlinks(filepath.Dir(fullPath))
+		if err != nil {
+			return nil, fmt.Errorf("encryptdir.resolvePaths: filepath.EvalSymlinks: %w", err)
+		}
+		if !within(resolvedRoot, dir) {
+			return nil, fmt.Errorf("encryptdir.resolvePaths: path = %q, resolved = %q: %w", relPath, filepath.Join(dir, filepath.Base(fullPath)), ErrPathOutsideRoot)
+		}
+
+		_, err = os.Lstat(fullPath)
+		if err != nil {
+			return nil, fmt.Errorf("encryptdir.resolvePaths: os.Lstat: %w", err)
+		}
+
+		if !seen[relPath] {
+			seen[relPath] = true
+			paths = append(paths, relPath)
+		}
+	}
+	return paths, nil
+}
+
+// encryptdir.listedWalk: like `cwalk.Walk` but only hands `walkFn` the regular files at `paths` under `root`, in order, instead of walking it
+// returns: error, joined over every file that failed
+func listedWalk(root string, paths []string, walkFn filepath.WalkFunc) error {
+	var errList []error
+	for _, path := range paths {
+		info, err := os.Lstat(filepath.Join(root, path))
+		if err != nil {
+			errList = append(errList, fmt.Errorf("os.Lstat: %w", err))
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		err = walkFn(path, info, nil)
+		if err != nil {
+			errList = append(errList, err)
+		}
+	}
+	return errors.Join(errList...)
+}
+
+// encryptdir.EncryptChangelist: encrypts the files listed in the changelist at `changelistPath`, without walking any directory
+// the changelist has one path per line, relative to the directory the changelist is in, blank lines are ignored
+// returns: error, see `EncryptPaths`
+func EncryptChangelist(privKey *gorsa.PrivateKey, keyMap map[string][]byte, changelistPath string) error {
+	in, err := os.OpenFile(changelistPath, os.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("encryptdir.EncryptChangelist: os.OpenFile: %w", err)
+	}
+	defer in.Close()
+
+	var relPaths []string
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		relPaths = append(relPaths, line)
+	}
+	err = scanner.Err()
+	if err != nil {
+		return fmt.Errorf("encryptdir.EncryptChangelist: scanner.Scan: %w", err)
+	}
+
+	err = EncryptPaths(privKey, keyMap, filepath.Dir(changelistPath), relPaths)
+	if err != nil {
+		return fmt.Errorf("encryptdir.EncryptChangelist: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestEncryptPaths(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{
+		"a.txt":       []byte("listed"),
+		"sub/b.txt":   []byte("listed too"),
+		"c.txt":       []byte("not listed"),
+		"sub/d.txt":   []byte("not listed either"),
+		"sub/e.md":    []byte("listed, no key"),
+		"other/f.txt": []byte("not listed"),
+	}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	listed := []string{"a.txt", "sub/b.txt", "sub/e.md", "./sub/../a.txt"}
+	err := EncryptPaths(privKey, keyMap, dir, listed)
+	if err != nil {
+		t.Fatalf("EncryptPaths: %v", err)
+	}
+
+	got := readTree(t, dir)
+	for _, rel := range []string{"a.txt", "sub/b.txt"} {
+		plain, err := DecryptFileToBytes(privKey, keyMap["txt"], filepath.Join(dir, filepath.FromSlash(rel)))
+		if err != nil || !bytes.Equal(plain, spec[rel]) {
+			t.Errorf("path = %q: DecryptFileToBytes = %q, %v, want %q", rel, plain, err, spec[rel])
+		}
+	}
+	for _, rel := range []string{"c.txt", "sub/d.txt", "sub/e.md", "other/f.txt"} {
+		if !bytes.Equal(got[rel], spec[rel]) {
+			t.Errorf("path = %q: changed, want it left alone", rel)
+		}
+	}
+}
+
+func TestEncryptPathsOutsideRoot(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	for _, rel := range []string{"../a.txt", "sub/../../a.txt", filepath.Join(dir, "a.txt")} {
+		// the path before it escapes the root is fine, nothing is encrypted either way
+		err := EncryptPaths(privKey, keyMap, dir, []string{"a.txt", rel})
+		if !errors.Is(err, ErrPathOutsideRoot) {
+			t.Errorf("EncryptPaths(%q): err = %v, want ErrPathOutsideRoot", rel, err)
+		}
+	}
+	assertTree(t, dir, spec)
+}
+
+func TestEncryptPathsFailed(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "newer.txt": newerFile}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	err := EncryptPaths(privKey, keyMap, dir, []string{"newer.txt", "a.txt"})
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("EncryptPaths: err = %v, want ErrUnsupportedVersion", err)
+	}
+
+	// the file after the one that failed is still encrypted
+	plain, err := DecryptFileToBytes(privKey, keyMap["txt"], filepath.Join(dir, "a.txt"))
+	if err != nil || !bytes.Equal(plain, spec["a.txt"]) {
+		t.Errorf("DecryptFileToBytes = %q, %v, want %q", plain, err, spec["a.txt"])
+	}
+}
+
+func TestEncryptChangelist(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("listed"), "sub/b.txt": []byte("listed too"), "c.txt": []byte("not listed")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	changelist := filepath.Join(dir, "changes")
+	err := os.WriteFile(changelist, []byte("a.txt\n\n  sub/b.txt  \n"), 0644)
+	if err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+
+	err = EncryptChangelist(privKey, keyMap, changelist)
+	if err != nil {
+		t.Fatalf("EncryptChangelist: %v", err)
+	}
+
+	for _, rel := range []string{"a.txt", "sub/b.txt"} {
+		plain, err := DecryptFileToBytes(privKey, keyMap["txt"], filepath.Join(dir, filepath.FromSlash(rel)))
+		if err != nil || !bytes.Equal(plain, spec[rel]) {
+			t.Errorf("path = %q: DecryptFileToBytes = %q, %v, want %q", rel, plain, err, spec[rel])
+		}
+	}
+	if got := readTree(t, dir)["c.txt"]; !bytes.Equal(got, spec["c.txt"]) {
+		t.Errorf("c.txt: changed, want it left alone")
+	}
+}
+
+func TestEncryptChangelistInvalid(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+	dir, _ := testutil.BuildTree(t, spec)
+	outside, _ := testutil.BuildTree(t, map[string][]byte{"b.txt": []byte("outside")})
+
+	for name, c := range map[string]struct {
+		lines string
+		err   error
+	}{
+		"parent":   {"a.txt\n../b.txt\n", ErrPathOutsideRoot},
+		"absolute": {"a.txt\n" + filepath.Join(outside, "b.txt") + "\n", ErrPathOutsideRoot},
+		"missing":  {"a.txt\nmissing.txt\n", os.ErrNotExist},
+	} {
+		t.Run(name, func(t *testing.T) {
+			changelist := filepath.Join(dir, "changes")
+			err := os.WriteFile(changelist, []byte(c.lines), 0644)
+			if err != nil {
+				t.Fatalf("os.WriteFile: %v", err)
+			}
+			t.Cleanup(func() { os.Remove(changelist) })
+
+			// every path is checked before the first is encrypted
+			err = EncryptChangelist(privKey, keyMap, changelist)
+			if !errors.Is(err, c.err) {
+				t.Errorf("EncryptChangelist: err = %v, want %v", err, c.err)
+			}
+			if got := readTree(t, dir)["a.txt"]; !bytes.Equal(got, spec["a.txt"]) {
+				t.Errorf("a.txt: encrypted from an invalid changelist")
+			}
+		})
+	}
+
+	err := EncryptChangelist(privKey, keyMap, filepath.Join(dir, "no-such-changelist"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("EncryptChangelist of a missing changelist: err = %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestEncryptPathsThroughLink(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("inside")}
+	dir, _ := testutil.BuildTree(t, spec)
+	outsideSpec := map[string][]byte{"secret.txt": []byte("outside")}
+	outside, _ := testutil.BuildTree(t, outsideSpec)
+
+	err := os.Symlink(outside, filepath.Join(dir, "link"))
+	if err != nil {
+		t.Fatalf("os.Symlink: %v", err)
+	}
+	err = os.Symlink(filepath.Join(dir, "sub"), filepath.Join(dir, "inner"))
+	if err != nil {
+		t.Fatalf("os.Symlink: %v", err)
+	}
+
+	for _, rel := range []string{"link/secret.txt", "inner/../link/secret.txt"} {
+		err = EncryptPaths(privKey, keyMap, dir, []string{"a.txt", rel})
+		if !errors.Is(err, ErrPathOutsideRoot) {
+			t.Errorf("EncryptPaths(%q): err = %v, want ErrPathOutsideRoot", rel, err)
+		}
+	}
+	assertTree(t, outside, outsideSpec)
+	if got := readTree(t, dir)["a.txt"]; !bytes.Equal(got, spec["a.txt"]) {
+		t.Errorf("a.txt: encrypted with a path outside of the root")
+	}
+
+	// a link to a dir under the root stays under it
+	err = EncryptPaths(privKey, keyMap, dir, []string{"inner/b.txt"})
+	if err != nil {
+		t.Fatalf("EncryptPaths(inner/b.txt): %v", err)
+	}
+	plain, err := DecryptFileToBytes(privKey, keyMap["txt"], filepath.Join(dir, "sub", "b.txt"))
+	if err != nil || !bytes.Equal(plain, spec["sub/b.txt"]) {
+		t.Errorf("DecryptFileToBytes = %q, %v, want %q", plain, err, spec["sub/b.txt"])
+	}
+}
+
+func TestEncryptPathsWithOptions(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world"), "c.txt": []byte("not listed")}
+	dir, _ := testutil.BuildTree(t, spec)
+	listed := []string{"a.txt", "sub/b.txt"}
+
+	// options and key maps are checked like a walk of the whole root
+	_, err := EncryptPathsWithOptions(context.Background(), nil, privKey, keyMap, dir, listed, Options{DecSibling: "bogus"})
+	if !errors.Is(err, ErrUnknownSiblingPolicy) {
+		t.Errorf("EncryptPathsWithOptions with a bad option: err = %v, want ErrUnknownSiblingPolicy", err)
+	}
+	_, err = EncryptPathsWithOptions(context.Background(), nil, privKey, nil, dir, listed, Options{})
+	if !errors.Is(err, ErrEmptyKeyMap) {
+		t.Errorf("EncryptPathsWithOptions without keys: err = %v, want ErrEmptyKeyMap", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = EncryptPathsWithOptions(ctx, nil, privKey, keyMap, dir, listed, Options{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("EncryptPathsWithOptions canceled: err = %v, want context.Canceled", err)
+	}
+	assertTree(t, dir, spec)
+
+	report, err := EncryptPathsWithOptions(context.Background(), nil, privKey, keyMap, dir, listed, Options{})
+	if err != nil {
+		t.Fatalf("EncryptPathsWithOptions: %v", err)
+	}
+	if report.Processed != 2 || len(report.Files) != 2 {
+		t.Errorf("EncryptPathsWithOptions: processed = %d of %d files, want 2 of 2", report.Processed, len(report.Files))
+	}
+	if got := readTree(t, dir)["c.txt"]; !bytes.Equal(got, spec["c.txt"]) {
+		t.Errorf("c.txt: changed, want it left alone")
+	}
+}
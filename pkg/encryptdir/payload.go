@@ -0,0 +1,62 @@
+package encryptdir
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/prairir/encryptdir/pkg/aes"
+)
+
+// sentinel error used for when the payload of an encrypted file doesnt decrypt, wrapped together with the cause like `aes.ErrAuthFailed`
+var ErrDecryptFailed = errors.New("payload failed to decrypt")
+
+// encryptdir.isGCM: if a file with `header` has an `aes.EncryptGCM` payload after its signature
+// version 1 files, without a file header, have an `aes.Encrypt` one, AES-CTR without authentication
+func isGCM(header *FileHeader) bool {
+	return header != nil
+}
+
+// encryptdir.openPayload: decrypts `payload`, everything after the signature of a file with `header`, and gunzips it if it was compressed and puts back its BOM if it was stripped
+// returns: plaintext, or error wrapping `ErrDecryptFailed`, and `aes.ErrAuthFailed` too if a version 2 payload was modified,
+// or `ErrIntegrityMismatch` if it doesnt match the integrity digest its file header recorded
+func openPayload(header *FileHeader, key []byte, payload []byte) ([]byte, error) {
+	if !isGCM(header) {
+		plain, err := aes.Decrypt(key, payload)
+		if err != nil {
+			return nil, fmt.Errorf("encryptdir.openPayload: %w: aes.Decrypt: %w", ErrDecryptFailed, err)
+		}
+		return plain, nil
+	}
+
+	plain, err := aes.DecryptGCM(key, payload)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.openPayload: %w: %w", ErrDecryptFailed, err)
+	}
+
+	plain, err = decompress(header, plain)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.openPayload: %w: %w", ErrDecryptFailed, err)
+	}
+	if restoresBOM(header) {
+		plain = append(append([]byte(nil), utf8BOM...), plain...)
+	}
+
+	mac := headerIntegrity(header, key)
+	if mac != nil {
+		mac.Write(plain)
+	}
+	err = checkIntegrity(mac, header)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.openPayload: %w: %w", ErrDecryptFailed, err)
+	}
+	return plain, nil
+}
+
+// encryptdir.Options.payloadSize: size of what `encryptWalk` writes after the signature for `size` bytes of plaintext
+// streamed files are sealed in `StreamChunkSize` chunks, the rest in `aes.DefaultGCMChunkSize` ones
+func (o Options) payloadSize(size int64) int64 {
+	if o.streams(size) {
+		return aes.GCMSize(size, o.streamChunkSize())
+	}
+	return aes.GCMSize(size, aes.DefaultGCMChunkSize)
+}
@@ -0,0 +1,193 @@
+package encryptdir
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/prairir/encryptdir/pkg/config"
+	"go.uber.org/zap"
+)
+
+// sentinel error used for when a planned file changed after the plan was made
+var ErrStalePlan = errors.New("file changed since the plan was made")
+
+// PlanAction: what applying a plan does to a file
+type PlanAction string
+
+const (
+	PlanEncrypt PlanAction = "encrypt"
+	PlanDecrypt PlanAction = "decrypt"
+)
+
+// PlanEntry: a single file in a plan
+// Size and ModTime are used to detect if the file changed since planning
+type PlanEntry struct {
+	Path    string     `json:"path"`
+	Action  PlanAction `json:"action"`
+	Size    int64      `json:"size"`
+	ModTime time.Time  `json:"mod_time"`
+}
+
+// Plan: list of intended actions, written by `MakePlan` and executed by `ApplyPlan`
+type Plan struct {
+	Decrypt bool        `json:"decrypt"`
+	Entries []PlanEntry `json:"entries"`
+}
+
+// encryptdir.RunPlan: like `Run` but writes a plan to `planPath` instead of touching any files
+func RunPlan(log *zap.SugaredLogger, configPath string, password string, decrypt bool, planPath string) error {
+	c, err := Startup(log, configPath, password)
+	if err != nil {
+		return fmt.Errorf("encryptdir.RunPlan: encryptdir.Startup: %w", err)
+	}
+
+	err = MakePlan(log, decrypt, c, planPath)
+	if err != nil {
+		return fmt.Errorf("encryptdir.RunPlan: %w", err)
+	}
+	return nil
+}
+
+// encryptdir.RunApplyPlan: like `Run` but only touches the files listed in the plan at `planPath`
+func RunApplyPlan(log *zap.SugaredLogger, configPath string, password string, planPath string) error {
+	c, err := Startup(log, configPath, password)
+	if err != nil {
+		return fmt.Errorf("encryptdir.RunApplyPlan: encryptdir.Startup: %w", err)
+	}
+
+	err = ApplyPlan(log, c, planPath)
+	if err != nil {
+		return fmt.Errorf("encryptdir.RunApplyPlan: %w", err)
+	}
+	return nil
+}
+
+// encryptdir.MakePlan: walks `c.Directories` read only and writes the files that would be encrypted, or decrypted if `decrypt`, to `planPath` as JSON
+// errors if `planPath` already exists
+// returns: error
+func MakePlan(log *zap.SugaredLogger, decrypt bool, c *config.Config, planPath string) error {
+	opts, err := optionsFromConfig(c)
+	if err != nil {
+		return fmt.Errorf("encryptdir.MakePlan: %w", err)
+	}
+
+	plan := Plan{Decrypt: decrypt}
+
+	action := PlanEncrypt
+	if decrypt {
+		action = PlanDecrypt
+	}
+
+	err = walkCandidates(c.AESKeyMap, c.Directories, func(path string, info os.FileInfo) error {
+		key, _ := lookupKey(c.AESKeyMap, path)
+
+		encrypted, err := isEncrypted(&c.RSAKey.PublicKey, key, opts.bannerLine(), path)
+		if err != nil {
+			return err
+		}
+
+		// encrypted files only need work when decrypting and vice versa
+		if encrypted != decrypt {
+			return nil
+		}
+
+		plan.Entries = append(plan.Entries, PlanEntry{
+			Path:    path,
+			Action:  action,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("encryptdir.MakePlan: %w", err)
+	}
+
+	payload, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encryptdir.MakePlan: json.MarshalIndent: %w", err)
+	}
+
+	err = writeNewFile(planPath, payload, 0644)
+	if err != nil {
+		return fmt.Errorf("encryptdir.MakePlan: %w", err)
+	}
+
+	log.Infof("planned %d files to %s: %s", len(plan.Entries), action, planPath)
+	return nil
+}
+
+// encryptdir.ApplyPlan: executes the plan at `planPath`
+// every file is checked against its size and modification time first, if any changed nothing is touched and `ErrStalePlan` is returned
+// returns: error, joining the errors of every file that failed
+func ApplyPlan(log *zap.SugaredLogger, c *config.Config, planPath string) error {
+	in, err := os.OpenFile(planPath, os.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("encryptdir.ApplyPlan: os.OpenFile: %w", err)
+	}
+	defer in.Close()
+
+	payload, err := io.ReadAll(in)
+	if err != nil {
+		return fmt.Errorf("encryptdir.ApplyPlan: io.ReadAll: %w", err)
+	}
+
+	var plan Plan
+	err = json.Unmarshal(payload, &plan)
+	if err != nil {
+		return fmt.Errorf("encryptdir.ApplyPlan: json.Unmarshal: %w", err)
+	}
+
+	opts, err := optionsFromConfig(c)
+	if err != nil {
+		return fmt.Errorf("encryptdir.ApplyPlan: %w", err)
+	}
+
+	// validate the whole plan before touching anything
+	infos := make([]os.FileInfo, len(plan.Entries))
+	for n, entry := range plan.Entries {
+		info, err := os.Lstat(entry.Path)
+		if err != nil {
+			return fmt.Errorf("encryptdir.ApplyPlan: path = %q: %w: %s", entry.Path, ErrStalePlan, err)
+		}
+
+		if info.Size() != entry.Size || !info.ModTime().Equal(entry.ModTime) {
+			return fmt.Errorf("encryptdir.ApplyPlan: path = %q: %w", entry.Path, ErrStalePlan)
+		}
+		infos[n] = info
+	}
+
+	// the walk funcs keep the errors of files in `errs` instead of returning them
+	w := Walker{
+		log:     log,
+		privKey: c.RSAKey,
+		keyMap:  c.AESKeyMap,
+		opts:    opts,
+		errs:    &errorList{},
+	}
+
+	log.Infof("applying plan: %s", planPath)
+	for n, entry := range plan.Entries {
+		switch entry.Action {
+		case PlanEncrypt:
+			err = w.encryptWalk(entry.Path, infos[n], nil)
+		case PlanDecrypt:
+			err = w.decryptWalk(entry.Path, infos[n], nil)
+		default:
+			err = fmt.Errorf("unknown action %q", entry.Action)
+		}
+		if err != nil {
+			return fmt.Errorf("encryptdir.ApplyPlan: %w", err)
+		}
+	}
+
+	errList := w.errs.list()
+	if len(errList) > 0 {
+		return fmt.Errorf("encryptdir.ApplyPlan: %w", errors.Join(errList...))
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package encryptdir
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prairir/encryptdir/pkg/config"
+	"github.com/prairir/encryptdir/pkg/testutil"
+	"go.uber.org/zap"
+)
+
+// planConfig: config for planning the run over `dir` with the keys of `keyMap`
+func planConfig(t *testing.T, dir string, keyMap map[string][]byte) *config.Config {
+	t.Helper()
+	return &config.Config{RSAKey: testutil.NewPrivateKey(t), AESKeyMap: keyMap, Directories: []string{dir}}
+}
+
+func TestPlanApply(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world"), "c.bin": []byte("no key")}
+	dir, _ := testutil.BuildTree(t, spec)
+	c := planConfig(t, dir, keyMap)
+	log := zap.NewNop().Sugar()
+
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+	err := MakePlan(log, false, c, planPath)
+	if err != nil {
+		t.Fatalf("MakePlan: %v", err)
+	}
+
+	// planning is read only
+	assertTree(t, dir, spec)
+
+	err = ApplyPlan(log, c, planPath)
+	if err != nil {
+		t.Fatalf("ApplyPlan: %v", err)
+	}
+	tree := readTree(t, dir)
+	for _, rel := range []string{"a.txt", "sub/b.txt"} {
+		if string(tree[rel]) == string(spec[rel]) {
+			t.Errorf("path = %q: not encrypted", rel)
+		}
+	}
+	if string(tree["c.bin"]) != string(spec["c.bin"]) {
+		t.Errorf("c.bin: changed without a key")
+	}
+
+	// a plan can only be made once at its path
+	err = MakePlan(log, false, c, planPath)
+	if !errors.Is(err, os.ErrExist) {
+		t.Errorf("MakePlan: existing plan: err = %v, want %v", err, os.ErrExist)
+	}
+
+	// the decrypt plan puts it back
+	decPlan := filepath.Join(t.TempDir(), "plan.json")
+	err = MakePlan(log, true, c, decPlan)
+	if err != nil {
+		t.Fatalf("MakePlan: decrypt: %v", err)
+	}
+	err = ApplyPlan(log, c, decPlan)
+	if err != nil {
+		t.Fatalf("ApplyPlan: decrypt: %v", err)
+	}
+	assertTree(t, dir, spec)
+}
+
+func TestPlanStale(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "b.txt": []byte("world")}
+	log := zap.NewNop().Sugar()
+
+	for name, change := range map[string]func(t *testing.T, path string){
+		"rewritten": func(t *testing.T, path string) {
+			err := os.WriteFile(path, []byte("changed"), 0600)
+			if err != nil {
+				t.Fatalf("os.WriteFile: %v", err)
+			}
+		},
+		"touched": func(t *testing.T, path string) {
+			later := time.Now().Add(time.Hour)
+			err := os.Chtimes(path, later, later)
+			if err != nil {
+				t.Fatalf("os.Chtimes: %v", err)
+			}
+		},
+		"removed": func(t *testing.T, path string) {
+			err := os.Remove(path)
+			if err != nil {
+				t.Fatalf("os.Remove: %v", err)
+			}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir, _ := testutil.BuildTree(t, spec)
+			c := planConfig(t, dir, keyMap)
+			planPath := filepath.Join(t.TempDir(), "plan.json")
+			err := MakePlan(log, false, c, planPath)
+			if err != nil {
+				t.Fatalf("MakePlan: %v", err)
+			}
+
+			change(t, filepath.Join(dir, "b.txt"))
+			before := readTree(t, dir)
+
+			// nothing is touched, not even the files that didnt change
+			err = ApplyPlan(log, c, planPath)
+			if !errors.Is(err, ErrStalePlan) {
+				t.Errorf("ApplyPlan: err = %v, want %v", err, ErrStalePlan)
+			}
+			assertTree(t, dir, before)
+		})
+	}
+}
+
+func TestPlanApplyFailure(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello")})
+	c := planConfig(t, dir, keyMap)
+	log := zap.NewNop().Sugar()
+
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+	err := MakePlan(log, false, c, planPath)
+	if err != nil {
+		t.Fatalf("MakePlan: %v", err)
+	}
+
+	// a file that fails is reported rather than dropped
+	c.StrictMetadata = true
+	c.PreserveMetadata = true
+	failChtimes(t)
+	err = ApplyPlan(log, c, planPath)
+	if !errors.Is(err, errChtimes) {
+		t.Errorf("ApplyPlan: err = %v, want %v", err, errChtimes)
+	}
+}
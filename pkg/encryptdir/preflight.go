@@ -0,0 +1,50 @@
+package encryptdir
+
+import (
+	gorsa "crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// sentinel error used for when none of the sampled files were encrypted with the supplied keys
+var ErrKeyMismatch = errors.New("private key does not match any sampled file")
+
+// used to stop `walkCandidates` early
+var errStopWalk = errors.New("stop walk")
+
+// encryptdir.PreflightCheck: checks the signatures of up to `samples` candidate files in `dirs`
+// meant to run before decrypting so a wrong private key fails fast instead of skipping every file
+// returns: `ErrKeyMismatch` if files were sampled and none verified, nil if one did or there was nothing to sample
+func PreflightCheck(privKey *gorsa.PrivateKey, keyMap map[string][]byte, dirs []string, samples int, opts Options) error {
+	sampled := 0
+	verified := false
+
+	err := walkCandidates(keyMap, dirs, func(path string, info os.FileInfo) error {
+		key, _ := lookupKey(keyMap, path)
+
+		encrypted, err := isEncrypted(&privKey.PublicKey, key, opts.bannerLine(), path)
+		if err != nil {
+			return err
+		}
+
+		sampled++
+		if encrypted {
+			verified = true
+			return errStopWalk
+		}
+
+		if sampled >= samples {
+			return errStopWalk
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopWalk) {
+		return fmt.Errorf("encryptdir.PreflightCheck: %w", err)
+	}
+
+	if sampled > 0 && !verified {
+		return fmt.Errorf("encryptdir.PreflightCheck: sampled %d files: %w", sampled, ErrKeyMismatch)
+	}
+	return nil
+}
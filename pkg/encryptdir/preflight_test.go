@@ -0,0 +1,54 @@
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestPreflightCheck(t *testing.T) {
+	for _, bits := range keySizes {
+		t.Run(fmt.Sprint(bits), func(t *testing.T) {
+			privKey := testutil.NewPrivateKeyBits(t, bits)
+			keyMap := testutil.NewKeyMap("txt")
+			for name, opts := range map[string]Options{"default": {}, "banner": {Banner: "encrypted"}} {
+				dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("a"), "b.txt": []byte("b")})
+				_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+				if err != nil {
+					t.Fatalf("%s: EncryptWithOptions: %v", name, err)
+				}
+
+				err = PreflightCheck(privKey, keyMap, []string{dir}, 2, opts)
+				if err != nil {
+					t.Errorf("%s: PreflightCheck with the right key: %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestPreflightCheckWrongKey(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("a"), "b.txt": []byte("b"), "c.txt": []byte("c")})
+	err := Encrypt(nil, testutil.NewPrivateKey(t), keyMap, []string{dir})
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	for _, bits := range []int{1024, 4096} {
+		err = PreflightCheck(testutil.NewPrivateKeyBits(t, bits), keyMap, []string{dir}, 2, Options{})
+		if !errors.Is(err, ErrKeyMismatch) {
+			t.Errorf("bits = %d: PreflightCheck with another key: error = %v, want `ErrKeyMismatch`", bits, err)
+		}
+	}
+
+	// a tree with nothing encrypted yet has nothing to check against
+	plain, _ := testutil.BuildTree(t, map[string][]byte{"d.md": []byte("d")})
+	err = PreflightCheck(testutil.NewPrivateKey(t), keyMap, []string{plain}, 2, Options{})
+	if err != nil {
+		t.Errorf("PreflightCheck without candidates: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package encryptdir
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sync"
+)
+
+// progress: counts finished files across every root for `Hooks.OnProgress`
+// a nil `*progress` reports nothing
+type progress struct {
+	mu    sync.Mutex
+	fn    func(path string, done, total int)
+	done  int
+	total int
+}
+
+// encryptdir.newProgress: progress reporting to `fn`, the total is -1 unless `count` is set and the files with a key in `dirs` that `filter` allows are counted first
+// returns: progress, nil if `fn` is nil, or error if counting fails
+func newProgress(fn func(path string, done, total int), keyMap map[string][]byte, dirs []string, count bool, filter pathFilter) (*progress, error) {
+	if fn == nil {
+		return nil, nil
+	}
+
+	p := &progress{fn: fn, total: -1}
+	if !count {
+		return p, nil
+	}
+
+	total, err := countCandidates(keyMap, dirs, filter)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.newProgress: %w", err)
+	}
+	p.total = total
+	return p, nil
+}
+
+// encryptdir.progress.file: records that the file at `path` finished, whatever happened to it, and calls the callback
+// calls are serialized, so the callback never runs concurrently with itself
+func (p *progress) file(path string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done++
+	p.fn(path, p.done, p.total)
+}
+
+// encryptdir.countCandidates: counts the regular files in `dirs` with a key in `keyMap` that `filter` allows, excluded dirs and the output dir are skipped
+// returns: count or error
+func countCandidates(keyMap map[string][]byte, dirs []string, filter pathFilter) (int, error) {
+	count := 0
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return fmt.Errorf("filepath.Rel: path = %q: %w", path, err)
+			}
+
+			if d.IsDir() {
+				if filter.excludesDir(rel) || filter.holdsOutput(path) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if !d.Type().IsRegular() || !filter.allows(rel) {
+				return nil
+			}
+			if _, ok := lookupKey(keyMap, path); ok {
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, fmt.Errorf("encryptdir.countCandidates: dir = %q: %w", dir, err)
+		}
+	}
+	return count, nil
+}
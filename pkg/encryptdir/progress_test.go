@@ -0,0 +1,72 @@
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+// progressCall: the arguments of one `Hooks.OnProgress` call
+type progressCall struct {
+	path        string
+	done, total int
+}
+
+// encryptProgress: encrypts a tree of 2 roots with 5 files with a key, and files without one, recording every progress call
+// returns: the calls in order
+func encryptProgress(t *testing.T, countTotal bool) []progressCall {
+	t.Helper()
+
+	spec := map[string][]byte{"a.txt": []byte("a"), "sub/b.txt": []byte("b"), "c.md": []byte("no key"), "newer.txt": newerFile}
+	first, _ := testutil.BuildTree(t, spec)
+	second, _ := testutil.BuildTree(t, map[string][]byte{"x.txt": []byte("x"), "deep/y/z.txt": []byte("z")})
+
+	// no lock of its own, the calls are serialized
+	var calls []progressCall
+	opts := Options{CountTotal: countTotal, Hooks: Hooks{OnProgress: func(path string, done, total int) {
+		calls = append(calls, progressCall{path, done, total})
+	}}}
+
+	_, err := EncryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), testutil.NewKeyMap("txt"), []string{first, second}, opts)
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("EncryptWithOptions: err = %v, want ErrUnsupportedVersion", err)
+	}
+	return calls
+}
+
+func TestProgress(t *testing.T) {
+	for _, countTotal := range []bool{false, true} {
+		calls := encryptProgress(t, countTotal)
+
+		// the failing file counts too, the one without a key doesnt
+		if len(calls) != 5 {
+			t.Fatalf("CountTotal = %t: %d progress calls, want 5", countTotal, len(calls))
+		}
+
+		wantTotal := -1
+		if countTotal {
+			wantTotal = 5
+		}
+		seen := make(map[string]bool)
+		for i, c := range calls {
+			if c.done != i+1 || c.total != wantTotal {
+				t.Errorf("CountTotal = %t: call %d = %d of %d, want %d of %d", countTotal, i, c.done, c.total, i+1, wantTotal)
+			}
+			if seen[c.path] {
+				t.Errorf("CountTotal = %t: path = %q: reported twice", countTotal, c.path)
+			}
+			seen[c.path] = true
+		}
+	}
+}
+
+func TestProgressNil(t *testing.T) {
+	// no callback, nothing to count
+	p, err := newProgress(nil, testutil.NewKeyMap("txt"), []string{"does-not-exist"}, true, pathFilter{})
+	if p != nil || err != nil {
+		t.Errorf("newProgress(nil) = %v, %v, want nil and nil", p, err)
+	}
+	p.file("a.txt")
+}
@@ -0,0 +1,40 @@
+package encryptdir
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// encryptdir.protectedSet: the absolute paths of `paths`, and where they lead if they are links, for `Options.ProtectedPaths`
+// paths that dont exist are kept as they are, they can still be created before the walk reaches them
+// returns: set of paths, nil for no paths, or error
+func protectedSet(paths []string) (map[string]bool, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	set := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		if len(path) == 0 {
+			continue
+		}
+
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("encryptdir.protectedSet: filepath.Abs: %w", err)
+		}
+		set[abs] = true
+
+		// a key kept under a linked dir is walked at the link
+		real, err := filepath.EvalSymlinks(abs)
+		if err == nil {
+			set[real] = true
+		}
+	}
+	return set, nil
+}
+
+// encryptdir.Options.protects: if the file at the absolute `path` is one of `Options.ProtectedPaths`
+func (o Options) protects(path string) bool {
+	return o.protected[filepath.Clean(path)]
+}
@@ -0,0 +1,132 @@
+package encryptdir
+
+import (
+	goaes "crypto/aes"
+	"crypto/cipher"
+	gorsa "crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/prairir/encryptdir/pkg/aes"
+)
+
+// randomReader: `io.ReaderAt` over the plaintext of an encrypted file
+type randomReader struct {
+	ra    io.ReaderAt
+	block cipher.Block
+	iv    []byte
+	size  int64
+	// where the ciphertext starts in `ra`
+	offset int64
+}
+
+// encryptdir.NewRandomReader: reads arbitrary plaintext ranges of the encrypted file in `ra` of `size` bytes
+// only what covers a read is decrypted, the AES-GCM chunks of version 2 files or the AES-CTR blocks of version 1 ones
+// each AES-GCM chunk is authenticated as it is read, a modified one fails the read with `aes.ErrAuthFailed`
+// files with a banner aren't supported
+// returns: reader, or error wrapping `ErrNotEncrypted` if the file isn't encrypted with `key`
+func NewRandomReader(privKey *gorsa.PrivateKey, key []byte, ra io.ReaderAt, size int64) (io.ReaderAt, error) {
+	// the offsets of `format.go` after the signature are for 2048 bit keys, shifted by the difference for others
+	sigSize := signatureSize(&privKey.PublicKey)
+	shift := int64(sigSize - SignatureSize)
+
+	prefix := make([]byte, CiphertextOffset+shift)
+	n, err := ra.ReadAt(prefix, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("encryptdir.NewRandomReader: ra.ReadAt: %w", err)
+	}
+	prefix, err = readMetadata(io.NewSectionReader(ra, int64(n), MaxMetadataSize), prefix[:n])
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.NewRandomReader: %w", err)
+	}
+	n = len(prefix)
+
+	// version 1 files have no file header, everything after it is shifted back
+	header, rest := splitFileHeader(prefix[:n])
+	base := int64(headerLen(header))
+	if int64(len(rest)) < CiphertextOffset-SignatureOffset+shift {
+		return nil, fmt.Errorf("encryptdir.NewRandomReader: %w", ErrNotEncrypted)
+	}
+
+	err = verifyKey(&privKey.PublicKey, rest[:sigSize], key, header, Options{})
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.NewRandomReader: %w", ErrNotEncrypted)
+	}
+
+	payloadOffset := base + PlaintextSizeOffset - SignatureOffset + shift
+	if isGCM(header) {
+		r, err := aes.NewGCMReader(key, io.NewSectionReader(ra, payloadOffset, size-payloadOffset), size-payloadOffset)
+		if err != nil {
+			return nil, fmt.Errorf("encryptdir.NewRandomReader: %w", err)
+		}
+		return r, nil
+	}
+
+	rest = rest[sigSize:]
+	plainSize := binary.LittleEndian.Uint64(rest[:PlaintextSizeSize])
+	cipherOffset := base + CiphertextOffset - SignatureOffset + shift
+	if plainSize > uint64(size-cipherOffset) {
+		return nil, fmt.Errorf("encryptdir.NewRandomReader: size = %d: %w", plainSize, aes.ErrCorrupt)
+	}
+
+	block, err := goaes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.NewRandomReader: aes.NewCipher: %w", err)
+	}
+
+	return &randomReader{
+		ra:     ra,
+		block:  block,
+		iv:     rest[PlaintextSizeSize : PlaintextSizeSize+LegacyIVSize],
+		size:   int64(plainSize),
+		offset: cipherOffset,
+	}, nil
+}
+
+// encryptdir.randomReader.ReadAt: decrypts `len(p)` plaintext bytes starting at `off`
+func (r *randomReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("encryptdir.randomReader.ReadAt: negative offset")
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+
+	// dont read into the padding
+	want := p
+	if int64(len(want)) > r.size-off {
+		want = want[:r.size-off]
+	}
+
+	n, err := r.ra.ReadAt(want, r.offset+off)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return n, fmt.Errorf("encryptdir.randomReader.ReadAt: ra.ReadAt: %w", err)
+	}
+
+	stream := cipher.NewCTR(r.block, counterAt(r.iv, uint64(off/goaes.BlockSize)))
+	// throw away the key stream before `off` in its block
+	skip := make([]byte, off%goaes.BlockSize)
+	stream.XORKeyStream(skip, skip)
+	stream.XORKeyStream(want[:n], want[:n])
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// encryptdir.counterAt: the CTR counter block for block `n`, `iv` plus `n` as a big endian 128 bit number
+func counterAt(iv []byte, n uint64) []byte {
+	ctr := make([]byte, len(iv))
+	copy(ctr, iv)
+
+	lo := binary.BigEndian.Uint64(ctr[8:])
+	sum := lo + n
+	binary.BigEndian.PutUint64(ctr[8:], sum)
+	if sum < lo { // carry into the high half
+		binary.BigEndian.PutUint64(ctr[:8], binary.BigEndian.Uint64(ctr[:8])+1)
+	}
+	return ctr
+}
@@ -0,0 +1,150 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/aes"
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+// openRandom: `NewRandomReader` over the file at `path`, closed when the test ends
+func openRandom(t *testing.T, key []byte, path string) io.ReaderAt {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("os.Open: %v", err)
+	}
+	t.Cleanup(func() { f.Close() })
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatalf("f.Stat: %v", err)
+	}
+
+	ra, err := NewRandomReader(testutil.NewPrivateKey(t), key, f, info.Size())
+	if err != nil {
+		t.Fatalf("NewRandomReader: %v", err)
+	}
+	return ra
+}
+
+func TestRandomReaderRanges(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	plain := make([]byte, 5000)
+	for i := range plain {
+		plain[i] = byte(i * 7)
+	}
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": plain})
+
+	// 1KiB chunks, so the reads below start and end inside and across chunks
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{StreamThreshold: -1, StreamChunkSize: 1024})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	ra := openRandom(t, keyMap["txt"], filepath.Join(dir, "a.txt"))
+
+	for _, r := range []struct{ off, n int }{
+		{0, 10},
+		{1000, 1100},
+		{1024, 1024},
+		{2500, 1},
+		{4900, 100},
+		{0, len(plain)},
+	} {
+		got := make([]byte, r.n)
+		n, err := ra.ReadAt(got, int64(r.off))
+		if err != nil && !(errors.Is(err, io.EOF) && r.off+r.n == len(plain)) {
+			t.Errorf("ReadAt(%d bytes at %d): %v", r.n, r.off, err)
+			continue
+		}
+		if n != r.n || !bytes.Equal(got, plain[r.off:r.off+r.n]) {
+			t.Errorf("ReadAt(%d bytes at %d): read %d bytes that arent the original range", r.n, r.off, n)
+		}
+	}
+
+	// past the end reads what is left
+	got := make([]byte, 100)
+	n, err := ra.ReadAt(got, 4990)
+	if n != 10 || !errors.Is(err, io.EOF) || !bytes.Equal(got[:n], plain[4990:]) {
+		t.Errorf("ReadAt past the end = %d, %v, want the last 10 bytes and io.EOF", n, err)
+	}
+	n, err = ra.ReadAt(got, int64(len(plain)))
+	if n != 0 || !errors.Is(err, io.EOF) {
+		t.Errorf("ReadAt at the end = %d, %v, want 0 and io.EOF", n, err)
+	}
+}
+
+func TestRandomReaderTampered(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	plain := bytes.Repeat([]byte("0123456789abcdef"), 256)
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": plain})
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{StreamThreshold: -1, StreamChunkSize: 1024})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	path := filepath.Join(dir, "a.txt")
+	// in the last chunk
+	tamper(t, path)
+	ra := openRandom(t, keyMap["txt"], path)
+
+	// chunks before it still read, only they are decrypted
+	got := make([]byte, 100)
+	_, err = ra.ReadAt(got, 10)
+	if err != nil || !bytes.Equal(got, plain[10:110]) {
+		t.Errorf("ReadAt in the first chunk: %v, want the original range", err)
+	}
+	_, err = ra.ReadAt(got, int64(len(plain)-100))
+	if !errors.Is(err, aes.ErrAuthFailed) {
+		t.Errorf("ReadAt in the tampered chunk: err = %v, want aes.ErrAuthFailed", err)
+	}
+}
+
+func TestRandomReaderWrongKey(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello"), "plain.txt": []byte("never encrypted")})
+
+	err := EncryptPaths(privKey, keyMap, dir, []string{"a.txt"})
+	if err != nil {
+		t.Fatalf("EncryptPaths: %v", err)
+	}
+
+	for name, key := range map[string][]byte{"a.txt": testutil.NewTestKey("other"), "plain.txt": keyMap["txt"]} {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("os.Open: %v", err)
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			t.Fatalf("f.Stat: %v", err)
+		}
+
+		_, err = NewRandomReader(privKey, key, f, info.Size())
+		if !errors.Is(err, ErrNotEncrypted) {
+			t.Errorf("NewRandomReader(%s): err = %v, want ErrNotEncrypted", name, err)
+		}
+	}
+}
+
+func TestCounterAt(t *testing.T) {
+	iv := []byte{0, 0, 0, 0, 0, 0, 0, 1, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe}
+	want := []byte{0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 1}
+
+	// carries into the high half
+	if got := counterAt(iv, 3); !bytes.Equal(got, want) {
+		t.Errorf("counterAt = %x, want %x", got, want)
+	}
+	if iv[15] != 0xfe {
+		t.Errorf("counterAt changed the iv it was given")
+	}
+}
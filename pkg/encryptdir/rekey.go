@@ -0,0 +1,97 @@
+package encryptdir
+
+import (
+	gorsa "crypto/rsa"
+	"fmt"
+	"os"
+
+	"github.com/prairir/encryptdir/pkg/aes"
+	"github.com/prairir/encryptdir/pkg/rsa"
+)
+
+// sentinel error used for when `Rotate` finds a file whose extension has no key in the new key map, it wraps `ErrKeyNotFound`
+var ErrNoRotateKey = fmt.Errorf("new key map: %w", ErrKeyNotFound)
+
+// encryptdir.ReKeyFile: re-encrypts the file at `path` from `oldKey` to `newKey`
+// the file has to be encrypted with `oldKey`, it is replaced through a temp file and rename so it is never half written
+// returns: error wrapping `ErrNotEncrypted` if the file isn't encrypted with `oldKey`
+func ReKeyFile(privKey *gorsa.PrivateKey, oldKey []byte, newKey []byte, path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fmt.Errorf("encryptdir.ReKeyFile: os.Lstat: %w", err)
+	}
+
+	plain, err := DecryptFileToBytes(privKey, oldKey, path)
+	if err != nil {
+		return fmt.Errorf("encryptdir.ReKeyFile: %w", err)
+	}
+
+	// keep what the old file header recorded, a renamed file keeps its original extension
+	old, err := ReadHeaderWithKey(&privKey.PublicKey, path)
+	if err != nil {
+		return fmt.Errorf("encryptdir.ReKeyFile: %w", err)
+	}
+	fileHeader := newFileHeader(path, info.Mode(), DefaultHashAlgo)
+	if old.Version > 1 {
+		fileHeader.Ext, fileHeader.Mode = old.Ext, old.Mode
+	}
+
+	sig, err := rsa.CreateSignature(privKey, newKey, DefaultHashAlgo)
+	if err != nil {
+		return fmt.Errorf("encryptdir.ReKeyFile: rsa.CreateSignature: %w", err)
+	}
+
+	cipher, err := aes.EncryptGCM(newKey, plain)
+	if err != nil {
+		return fmt.Errorf("encryptdir.ReKeyFile: %w", err)
+	}
+
+	tmpPath := Options{}.namer().TempName(path, false)
+	err = writeNewFile(tmpPath, append(append(fileHeader.marshal(), sig...), cipher...), info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("encryptdir.ReKeyFile: %w", err)
+	}
+
+	err = finalize(tmpPath, path)
+	if err != nil {
+		return fmt.Errorf("encryptdir.ReKeyFile: %w", err)
+	}
+
+	return nil
+}
+
+// encryptdir.Rotate: re-encrypts every file in `dirs` encrypted with its `oldKeyMap` key to its `newKeyMap` key, one file at a time
+// each file is decrypted in memory and swapped with `ReKeyFile`, the plaintext never touches the disk
+// files already encrypted with the new key, and files that aren't encrypted, are left alone, so an interrupted rotation can be run again
+// returns: error wrapping `ErrNoRotateKey` if an extension has an old key but no new one, stops at the first file that fails
+func Rotate(privKey *gorsa.PrivateKey, oldKeyMap map[string][]byte, newKeyMap map[string][]byte, dirs []string) error {
+	err := walkCandidates(oldKeyMap, dirs, func(path string, _ os.FileInfo) error {
+		oldKey, _ := lookupKey(oldKeyMap, path)
+		newKey, ok := lookupKey(newKeyMap, path)
+		if !ok {
+			return fmt.Errorf("path = %q: %w", path, ErrNoRotateKey)
+		}
+
+		rotated, err := isEncrypted(&privKey.PublicKey, newKey, nil, path)
+		if err != nil {
+			return err
+		}
+		if rotated {
+			return nil
+		}
+
+		encrypted, err := isEncrypted(&privKey.PublicKey, oldKey, nil, path)
+		if err != nil {
+			return err
+		}
+		if !encrypted {
+			return nil
+		}
+
+		return ReKeyFile(privKey, oldKey, newKey, path)
+	})
+	if err != nil {
+		return fmt.Errorf("encryptdir.Rotate: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestReKeyFile(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	oldKeys := testutil.NewKeyMap("txt")
+	newKey := testutil.NewTestKey("new txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "b.txt": []byte("left on the old key")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, oldKeys, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	path := filepath.Join(dir, "a.txt")
+
+	err = ReKeyFile(privKey, oldKeys["txt"], newKey, path)
+	if err != nil {
+		t.Fatalf("ReKeyFile: %v", err)
+	}
+
+	plain, err := DecryptFileToBytes(privKey, newKey, path)
+	if err != nil || !bytes.Equal(plain, spec["a.txt"]) {
+		t.Errorf("DecryptFileToBytes with the new key = %q, %v, want %q", plain, err, spec["a.txt"])
+	}
+	_, err = DecryptFileToBytes(privKey, oldKeys["txt"], path)
+	if !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("DecryptFileToBytes with the old key: err = %v, want ErrNotEncrypted", err)
+	}
+
+	// only the one file is re-keyed
+	plain, err = DecryptFileToBytes(privKey, oldKeys["txt"], filepath.Join(dir, "b.txt"))
+	if err != nil || !bytes.Equal(plain, spec["b.txt"]) {
+		t.Errorf("b.txt: DecryptFileToBytes with the old key = %q, %v, want %q", plain, err, spec["b.txt"])
+	}
+	if n := len(readTree(t, dir)); n != len(spec) {
+		t.Errorf("tree has %d files, want %d without temp files", n, len(spec))
+	}
+}
+
+func TestReKeyFileWrongOldKey(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello"), "plain.txt": []byte("never encrypted")})
+
+	err := EncryptPaths(privKey, keyMap, dir, []string{"a.txt"})
+	if err != nil {
+		t.Fatalf("EncryptPaths: %v", err)
+	}
+	before := readTree(t, dir)
+
+	// the old signature has to verify before anything is written
+	err = ReKeyFile(privKey, testutil.NewTestKey("not the old key"), testutil.NewTestKey("new txt"), filepath.Join(dir, "a.txt"))
+	if !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("ReKeyFile with another old key: err = %v, want ErrNotEncrypted", err)
+	}
+	err = ReKeyFile(privKey, keyMap["txt"], testutil.NewTestKey("new txt"), filepath.Join(dir, "plain.txt"))
+	if !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("ReKeyFile of a plaintext file: err = %v, want ErrNotEncrypted", err)
+	}
+	assertTree(t, dir, before)
+}
+
+func TestRotate(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	oldKeyMap := testutil.NewKeyMap("txt", "sql")
+	newKeyMap := map[string][]byte{"txt": testutil.NewTestKey("new txt"), "sql": testutil.NewTestKey("new sql")}
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.sql": []byte("select 1"), "c.txt": []byte("never encrypted")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	err := EncryptPaths(privKey, oldKeyMap, dir, []string{"a.txt", "sub/b.sql"})
+	if err != nil {
+		t.Fatalf("EncryptPaths: %v", err)
+	}
+
+	err = Rotate(privKey, oldKeyMap, newKeyMap, []string{dir})
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	// the plaintext is left as it is
+	if got := readTree(t, dir)["c.txt"]; string(got) != "never encrypted" {
+		t.Errorf("c.txt: encrypted by Rotate")
+	}
+	rotated := readTree(t, dir)
+
+	// already on the new key, running it again changes nothing
+	err = Rotate(privKey, oldKeyMap, newKeyMap, []string{dir})
+	if err != nil {
+		t.Fatalf("Rotate again: %v", err)
+	}
+	assertTree(t, dir, rotated)
+
+	for rel, ext := range map[string]string{"a.txt": "txt", "sub/b.sql": "sql"} {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		_, err := DecryptFileToBytes(privKey, oldKeyMap[ext], path)
+		if err == nil {
+			t.Errorf("path = %q: still decrypts with the old key", rel)
+		}
+		got, err := DecryptFileToBytes(privKey, newKeyMap[ext], path)
+		if err != nil || !bytes.Equal(got, spec[rel]) {
+			t.Errorf("path = %q: DecryptFileToBytes with the new key = %q, %v, want %q", rel, got, err, spec[rel])
+		}
+	}
+}
+
+func TestRotateNoNewKey(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	oldKeyMap := testutil.NewKeyMap("txt", "sql")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"b.sql": []byte("select 1")})
+	err := EncryptPaths(privKey, oldKeyMap, dir, []string{"b.sql"})
+	if err != nil {
+		t.Fatalf("EncryptPaths: %v", err)
+	}
+	encrypted := readTree(t, dir)
+
+	err = Rotate(privKey, oldKeyMap, map[string][]byte{"txt": testutil.NewTestKey("new txt")}, []string{dir})
+	if !errors.Is(err, ErrNoRotateKey) {
+		t.Errorf("Rotate: err = %v, want ErrNoRotateKey", err)
+	}
+	assertTree(t, dir, encrypted)
+}
@@ -0,0 +1,123 @@
+package encryptdir
+
+import "sync"
+
+// FileStatus: what happened to a single file
+type FileStatus string
+
+const (
+	// the file was encrypted, decrypted, or verified
+	FileProcessed FileStatus = "processed"
+	// the file was left alone
+	FileSkipped FileStatus = "skipped"
+	// the file errored
+	FileFailed FileStatus = "failed"
+)
+
+// FileResult: the outcome for a single file, `Err` is only set when `Status` is `FileFailed`
+type FileResult struct {
+	Path   string
+	Status FileStatus
+	Err    error
+
+	// hex SHA-256 and size of the plaintext, only set for processed files with `Options.HashContents` or `Options.VerifyAfterWrite`
+	SHA256 string
+	Size   int64
+
+	// the worker that processed the file with `Options.DebugWorkers`, from 1 to `cwalk.NumWorkers` times the number of dirs, 0 without
+	// each dir has its own workers, so files of different dirs never share one
+	Worker int
+}
+
+// Report: per file outcomes of a run
+type Report struct {
+	Processed int
+	Skipped   int
+	Failed    int
+
+	Files []FileResult
+}
+
+// encryptdir.Report.add: records the outcome for `path`
+func (r *Report) add(path string, status FileStatus, err error) {
+	r.addFile(FileResult{Path: path, Status: status, Err: err})
+}
+
+// encryptdir.Report.addFile: records the outcome `f`
+func (r *Report) addFile(f FileResult) {
+	switch f.Status {
+	case FileProcessed:
+		r.Processed++
+	case FileSkipped:
+		r.Skipped++
+	case FileFailed:
+		r.Failed++
+	}
+	r.Files = append(r.Files, f)
+}
+
+// resultCollector: builds a `Report` from the cwalk workers of every root concurrently
+// a nil `*resultCollector` records nothing
+type resultCollector struct {
+	mu        sync.Mutex
+	report    Report
+	processed map[string]bool
+	// plaintext hashes of processed files, by path like `processed`
+	hashes map[string]FileResult
+}
+
+// encryptdir.newResultCollector: empty collector
+func newResultCollector() *resultCollector {
+	return &resultCollector{processed: make(map[string]bool), hashes: make(map[string]FileResult)}
+}
+
+// encryptdir.resultCollector.done: marks the file at `path` as processed, recorded once it is visited
+func (c *resultCollector) done(path string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.processed[path] = true
+}
+
+// encryptdir.resultCollector.hash: records the plaintext hash and size of the file at `path`, recorded once it is visited
+func (c *resultCollector) hash(path string, sum string, size int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hashes[path] = FileResult{SHA256: sum, Size: size}
+}
+
+// encryptdir.resultCollector.visit: records the outcome for the file at `path` processed by `worker`, `err` is its walk error
+func (c *resultCollector) visit(path string, worker int, err error) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f := FileResult{Path: path, Status: FileSkipped, Worker: worker}
+	switch {
+	case err != nil:
+		f.Status, f.Err = FileFailed, err
+	case c.processed[path]:
+		f.SHA256, f.Size = c.hashes[path].SHA256, c.hashes[path].Size
+		f.Status = FileProcessed
+	}
+	c.report.addFile(f)
+	delete(c.processed, path)
+	delete(c.hashes, path)
+}
+
+// encryptdir.resultCollector.snapshot: copy of the report so far
+func (c *resultCollector) snapshot() *Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := c.report
+	report.Files = append([]FileResult(nil), c.report.Files...)
+	return &report
+}
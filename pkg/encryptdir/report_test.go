@@ -0,0 +1,52 @@
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestEncryptWithResults(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{
+		"a.txt":     []byte("hello"),
+		"sub/b.txt": []byte("world"),
+		"c.md":      []byte("no key"),
+		"newer.txt": newerFile,
+	})
+	// already encrypted, skipped like a file without a key
+	err := EncryptPaths(privKey, keyMap, dir, []string{"sub/b.txt"})
+	if err != nil {
+		t.Fatalf("EncryptPaths: %v", err)
+	}
+
+	report, err := EncryptWithResults(context.Background(), nil, privKey, keyMap, []string{dir})
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("EncryptWithResults: err = %v, want ErrUnsupportedVersion", err)
+	}
+	if report.Processed != 1 || report.Skipped != 2 || report.Failed != 1 {
+		t.Errorf("EncryptWithResults: processed = %d, skipped = %d, failed = %d, want 1, 2, and 1", report.Processed, report.Skipped, report.Failed)
+	}
+
+	want := map[string]FileStatus{"a.txt": FileProcessed, "sub/b.txt": FileSkipped, "c.md": FileSkipped, "newer.txt": FileFailed}
+	if len(report.Files) != len(want) {
+		t.Errorf("EncryptWithResults: %d files, want %d", len(report.Files), len(want))
+	}
+	for _, f := range report.Files {
+		rel, err := filepath.Rel(dir, f.Path)
+		if err != nil {
+			t.Fatalf("filepath.Rel: %v", err)
+		}
+		rel = filepath.ToSlash(rel)
+		if f.Status != want[rel] {
+			t.Errorf("path = %q: status = %q, want %q", rel, f.Status, want[rel])
+		}
+		if (f.Status == FileFailed) != (f.Err != nil) {
+			t.Errorf("path = %q: status = %q with err = %v", rel, f.Status, f.Err)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package encryptdir
+
+import "os"
+
+// os.Chtimes, swapped out by tests for one that fails like a filesystem that refuses it
+var chtimes = os.Chtimes
+
+// encryptdir.Walker.restored: `err` from restoring the times, owner or original mode of the output at `path`
+// the contents are already written and fine, so unless `Options.StrictMetadata` is set it is only logged and the file is kept
+// returns: `err` with `Options.StrictMetadata`, nil otherwise
+func (w Walker) restored(err error, path string) error {
+	if err == nil || w.opts.StrictMetadata {
+		return err
+	}
+	w.log.Warnw("couldnt restore file metadata, keeping the file without it", "path", path, "error", err)
+	return nil
+}
@@ -0,0 +1,68 @@
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+var errChtimes = errors.New("chtimes refused")
+
+// failChtimes: makes `chtimes` fail like a filesystem that refuses it until the test ends
+func failChtimes(t *testing.T) {
+	t.Helper()
+	chtimes = func(string, time.Time, time.Time) error {
+		return errChtimes
+	}
+	t.Cleanup(func() { chtimes = os.Chtimes })
+}
+
+func TestRestoreMetadataBestEffort(t *testing.T) {
+	failChtimes(t)
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world")}
+	dir, _ := testutil.BuildTree(t, spec)
+	opts := Options{PreserveMetadata: true}
+
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if report.Processed != len(spec) || report.Failed != 0 {
+		t.Errorf("EncryptWithOptions: processed = %d, failed = %d, want %d and 0", report.Processed, report.Failed, len(spec))
+	}
+
+	report, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	if report.Processed != len(spec) || report.Failed != 0 {
+		t.Errorf("DecryptWithOptions: processed = %d, failed = %d, want %d and 0", report.Processed, report.Failed, len(spec))
+	}
+	assertTree(t, dir, spec)
+}
+
+func TestRestoreMetadataStrict(t *testing.T) {
+	failChtimes(t)
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+	dir, _ := testutil.BuildTree(t, spec)
+	opts := Options{PreserveMetadata: true, StrictMetadata: true}
+
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if !errors.Is(err, errChtimes) {
+		t.Fatalf("EncryptWithOptions: err = %v, want %v", err, errChtimes)
+	}
+	if report.Failed != len(spec) {
+		t.Errorf("EncryptWithOptions: failed = %d, want %d", report.Failed, len(spec))
+	}
+
+	// it fails on the temp file, before the original is replaced
+	assertTree(t, dir, spec)
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package encryptdir
+
+// encryptdir.softOpenFileLimit: there is no open file rlimit on this platform
+// returns: 0
+func softOpenFileLimit() int {
+	return 0
+}
@@ -0,0 +1,20 @@
+//go:build unix
+
+package encryptdir
+
+import (
+	"math"
+	"syscall"
+)
+
+// encryptdir.softOpenFileLimit: the soft `RLIMIT_NOFILE` of the process
+// returns: limit, 0 if it can't be read or is unlimited
+func softOpenFileLimit() int {
+	var limit syscall.Rlimit
+	err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit)
+	// unlimited is the max value of the platform's type, anything this big may as well be
+	if err != nil || uint64(limit.Cur) > math.MaxInt32 {
+		return 0
+	}
+	return int(limit.Cur)
+}
@@ -0,0 +1,71 @@
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+)
+
+// sentinel error used for when the walk of a root was canceled with `RootCancels.Cancel` while the rest of the run kept going
+var ErrRootCanceled = errors.New("root canceled")
+
+// RootCancels: cancels the walk of one root of a run without the others, set as `Options.RootCancels`
+// a canceled root starts no new files, files in flight have their temp files removed like when the whole run is canceled
+// safe to use from any goroutine, like from `Hooks` or a signal handler, once per run
+type RootCancels struct {
+	mu sync.Mutex
+	// cancel funcs of the roots being walked
+	cancels map[string]context.CancelFunc
+	// roots canceled so far, a root canceled before it started is canceled as soon as it does
+	canceled map[string]bool
+}
+
+// encryptdir.NewRootCancels: `RootCancels` with no root canceled
+func NewRootCancels() *RootCancels {
+	return &RootCancels{cancels: map[string]context.CancelFunc{}, canceled: map[string]bool{}}
+}
+
+// encryptdir.RootCancels.Cancel: cancels the walk of the root `dir`, one of the directories the run was given
+// canceling a root that already finished or isnt part of the run does nothing
+// returns: error
+func (r *RootCancels) Cancel(dir string) error {
+	// roots are walked as absolute paths
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("encryptdir.RootCancels.Cancel: filepath.Abs: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.canceled[dir] = true
+	if cancel, ok := r.cancels[dir]; ok {
+		cancel()
+	}
+	return nil
+}
+
+// encryptdir.RootCancels.start: context for the walk of `dir` from `ctx`, canceled by `RootCancels.Cancel`
+// a nil `*RootCancels` cancels nothing but `ctx`
+// returns: context, and the func releasing it once the root is walked
+func (r *RootCancels) start(ctx context.Context, dir string) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	if r == nil {
+		return ctx, cancel
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.canceled[dir] {
+		cancel()
+	}
+	r.cancels[dir] = cancel
+
+	return ctx, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.cancels, dir)
+		cancel()
+	}
+}
@@ -0,0 +1,76 @@
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestRootCancelsOneOfThree(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world")}
+
+	var roots []string
+	for i := 0; i < 3; i++ {
+		dir, _ := testutil.BuildTree(t, spec)
+		roots = append(roots, dir)
+	}
+
+	// the second root is canceled as it starts, before any of its files
+	cancels := NewRootCancels()
+	opts := Options{RootCancels: cancels, Hooks: Hooks{OnRootStart: func(dir string) {
+		if dir == roots[1] {
+			if err := cancels.Cancel(dir); err != nil {
+				t.Errorf("RootCancels.Cancel: %v", err)
+			}
+		}
+	}}}
+
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, roots, opts)
+	if !errors.Is(err, ErrRootCanceled) || errors.Is(err, context.Canceled) {
+		t.Fatalf("EncryptWithOptions: err = %v, want ErrRootCanceled and not context.Canceled", err)
+	}
+	if report.Processed != 2*len(spec) || report.Failed != 0 {
+		t.Errorf("EncryptWithOptions: processed = %d, failed = %d, want %d and 0", report.Processed, report.Failed, 2*len(spec))
+	}
+	assertTree(t, roots[1], spec)
+
+	// the other two completed and decrypt back
+	_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{roots[0], roots[2]}, Options{})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	for _, dir := range roots {
+		assertTree(t, dir, spec)
+	}
+}
+
+func TestRootCancelsBeforeRun(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+	first, _ := testutil.BuildTree(t, spec)
+	second, _ := testutil.BuildTree(t, spec)
+
+	// a root canceled before the run is never walked, canceling one that isnt in the run does nothing
+	cancels := NewRootCancels()
+	for _, dir := range []string{second, t.TempDir()} {
+		if err := cancels.Cancel(dir); err != nil {
+			t.Fatalf("RootCancels.Cancel: %v", err)
+		}
+	}
+
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{first, second}, Options{RootCancels: cancels, FailFast: true})
+	if !errors.Is(err, ErrRootCanceled) {
+		t.Fatalf("EncryptWithOptions: err = %v, want ErrRootCanceled", err)
+	}
+	// with `FailFast` a canceled root doesnt cancel the others
+	if report.Processed != 1 {
+		t.Errorf("EncryptWithOptions: processed = %d, want 1", report.Processed)
+	}
+	assertTree(t, second, spec)
+
+}
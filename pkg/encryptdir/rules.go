@@ -0,0 +1,96 @@
+package encryptdir
+
+import (
+	"context"
+	gorsa "crypto/rsa"
+	"errors"
+	"fmt"
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+// sentinel error used for when a `FileRule` ends up without a key, neither its own nor one derived from `Options.Passphrases`
+var ErrBadRule = errors.New("file rule has no key")
+
+// FileRule: how files of one extension are encrypted, the generalization of a key map entry
+// every file is sealed with AES-GCM, the length of the key picks AES-128, AES-192, or AES-256
+type FileRule struct {
+	// nil for an extension whose key is derived from `Options.Passphrases`
+	Key []byte
+	// gzip files of the extension before encrypting them, nil follows `Options.Compress`
+	// decrypting doesnt need it, the file header records if a file was compressed
+	Compress *bool
+}
+
+// encryptdir.RulesFromKeyMap: a rule with the key for every extension of `keyMap`, and nothing else set
+func RulesFromKeyMap(keyMap map[string][]byte) map[string]FileRule {
+	rules := make(map[string]FileRule, len(keyMap))
+	for ext, key := range keyMap {
+		rules[ext] = FileRule{Key: key}
+	}
+	return rules
+}
+
+// encryptdir.keyMapFromRules: the keys of `rules`, rules without one are left out for `Options.Passphrases` to fill in
+func keyMapFromRules(rules map[string]FileRule) map[string][]byte {
+	keyMap := make(map[string][]byte, len(rules))
+	for ext, rule := range rules {
+		if len(rule.Key) > 0 {
+			keyMap[ext] = rule.Key
+		}
+	}
+	return keyMap
+}
+
+// encryptdir.validateRules: checks every rule ends up with a key in `keyMap` once any passphrases are derived, instead of its files being skipped
+// the length of the key is checked with the rest of the key map by `validateKeyMap`
+// returns: error joined over every extension in order, wrapping `ErrBadRule`
+func validateRules(rules map[string]FileRule, keyMap map[string][]byte) error {
+	exts := make([]string, 0, len(rules))
+	for ext := range rules {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+
+	var errList []error
+	for _, ext := range exts {
+		if len(keyMap[ext]) == 0 {
+			errList = append(errList, fmt.Errorf("extension = %q: %w", ext, ErrBadRule))
+		}
+	}
+	if len(errList) > 0 {
+		return fmt.Errorf("encryptdir.validateRules: %w", errors.Join(errList...))
+	}
+	return nil
+}
+
+// encryptdir.Options.compresses: if files under the key map extension `ext` are compressed, the rule for it wins over `Options.Compress`
+func (o Options) compresses(ext string) bool {
+	if rule, ok := o.rules[ext]; ok && rule.Compress != nil {
+		return *rule.Compress
+	}
+	return o.Compress
+}
+
+// encryptdir.EncryptWithRules: like `EncryptWithOptions` with the keys and settings per extension of `rules`
+// returns: report, also on error, and error like `EncryptContext`, wrapping `ErrBadRule` for a rule without a key
+func EncryptWithRules(ctx context.Context, log *zap.SugaredLogger, privKey *gorsa.PrivateKey, rules map[string]FileRule, dirs []string, opts Options) (*Report, error) {
+	opts.rules = rules
+	report, err := EncryptWithOptions(ctx, log, privKey, keyMapFromRules(rules), dirs, opts)
+	if err != nil {
+		return report, fmt.Errorf("encryptdir.EncryptWithRules: %w", err)
+	}
+	return report, nil
+}
+
+// encryptdir.DecryptWithRules: like `DecryptWithOptions` with the keys of `rules`, checked like `EncryptWithRules` does
+// returns: report, also on error, and error like `DecryptContext`
+func DecryptWithRules(ctx context.Context, log *zap.SugaredLogger, privKey *gorsa.PrivateKey, rules map[string]FileRule, dirs []string, opts Options) (*Report, error) {
+	opts.rules = rules
+	report, err := DecryptWithOptions(ctx, log, privKey, keyMapFromRules(rules), dirs, opts)
+	if err != nil {
+		return report, fmt.Errorf("encryptdir.DecryptWithRules: %w", err)
+	}
+	return report, nil
+}
@@ -0,0 +1,95 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestEncryptWithRules(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	yes, no := true, false
+	rules := map[string]FileRule{
+		"db":  {Key: bytes.Repeat([]byte{1}, 32), Compress: &yes},
+		"log": {Key: bytes.Repeat([]byte{2}, 16), Compress: &no},
+	}
+	// both compress well, only the rule decides
+	spec := map[string][]byte{
+		"a.db":      bytes.Repeat([]byte("row "), 1000),
+		"sub/b.log": bytes.Repeat([]byte("line "), 1000),
+	}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	report, err := EncryptWithRules(context.Background(), nil, privKey, rules, []string{dir}, Options{})
+	if err != nil || report.Processed != 2 {
+		t.Fatalf("EncryptWithRules = processed %d, %v, want 2", report.Processed, err)
+	}
+
+	for rel, want := range map[string]struct{ ext, other, compression string }{
+		"a.db":      {"db", "log", "gzip"},
+		"sub/b.log": {"log", "db", ""},
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		header, err := ReadHeaderWithKey(&privKey.PublicKey, path)
+		if err != nil {
+			t.Fatalf("ReadHeaderWithKey(%s): %v", rel, err)
+		}
+		if header.Compression != want.compression {
+			t.Errorf("%s: compression = %q, want %q", rel, header.Compression, want.compression)
+		}
+
+		// each file is under the key of its own rule
+		got, err := DecryptFileToBytes(privKey, rules[want.ext].Key, path)
+		if err != nil || !bytes.Equal(got, spec[rel]) {
+			t.Errorf("%s: DecryptFileToBytes with its own key = %v, want the original", rel, err)
+		}
+		_, err = DecryptFileToBytes(privKey, rules[want.other].Key, path)
+		if err == nil {
+			t.Errorf("%s: DecryptFileToBytes with the %s key: err = nil, want an error", rel, want.other)
+		}
+	}
+
+	_, err = DecryptWithRules(context.Background(), nil, privKey, rules, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("DecryptWithRules: %v", err)
+	}
+	assertTree(t, dir, spec)
+}
+
+func TestBadRule(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	spec := map[string][]byte{"a.db": []byte("row")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	// db has no key of its own and no passphrase to derive one from, its files would be skipped
+	rules := map[string]FileRule{"db": {}, "txt": {Key: bytes.Repeat([]byte{1}, 32)}}
+	for _, decrypt := range []bool{false, true} {
+		run := EncryptWithRules
+		if decrypt {
+			run = DecryptWithRules
+		}
+		_, err := run(context.Background(), nil, privKey, rules, []string{dir}, Options{})
+		if !errors.Is(err, ErrBadRule) || !strings.Contains(err.Error(), `extension = "db"`) {
+			t.Errorf("decrypt = %t: err = %v, want ErrBadRule naming the extension", decrypt, err)
+		}
+	}
+	assertTree(t, dir, spec)
+}
+
+func TestRulesFromKeyMap(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt", "md")
+
+	// the old key map, rule for rule, and back
+	rules := RulesFromKeyMap(keyMap)
+	if len(rules) != 2 || !bytes.Equal(rules["txt"].Key, keyMap["txt"]) || rules["txt"].Compress != nil {
+		t.Errorf("RulesFromKeyMap = %v, want a rule with only the key for each extension", rules)
+	}
+	if got := keyMapFromRules(rules); len(got) != 2 || !bytes.Equal(got["md"], keyMap["md"]) {
+		t.Errorf("keyMapFromRules = %v, want the key map back", got)
+	}
+}
@@ -0,0 +1,81 @@
+package encryptdir
+
+import (
+	gorsa "crypto/rsa"
+	"fmt"
+	"io"
+
+	"github.com/prairir/encryptdir/pkg/aes"
+	"github.com/prairir/encryptdir/pkg/rsa"
+)
+
+// layout of a backup and a blob, a whole tree in one stream
+//
+//	[magic][version][signature][aes.NewEncryptWriter stream]
+//
+// the magic tells backups and blobs apart from each other and from encrypted files, the version is a byte
+// the signature is of the magic, the version and the key, signed with `DefaultHashAlgo`, and checked before anything is decrypted
+// the stream is authenticated chunk by chunk, so a tampered or cut short one fails as it is read instead of decrypting to garbage
+
+// encryptdir.sealedMessage: what the signature of a stream with `magic` and `version` under `key` signs
+func sealedMessage(magic string, version byte, key []byte) []byte {
+	msg := append([]byte(magic), version)
+	return append(msg, key...)
+}
+
+// encryptdir.newSealedWriter: writes the magic, version and signature of a stream under `key` to `dst`
+// returns: writer encrypting everything written to it into `dst`, which has to be closed or the stream doesnt decrypt, or error
+func newSealedWriter(dst io.Writer, magic string, version byte, privKey *gorsa.PrivateKey, key []byte) (io.WriteCloser, error) {
+	sig, err := rsa.CreateSignature(privKey, sealedMessage(magic, version, key), DefaultHashAlgo)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.newSealedWriter: rsa.CreateSignature: %w", err)
+	}
+
+	_, err = dst.Write(append([]byte(magic), version))
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.newSealedWriter: dst.Write(magic): %w", err)
+	}
+	_, err = dst.Write(sig)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.newSealedWriter: dst.Write(sig): %w", err)
+	}
+
+	w, err := aes.NewEncryptWriter(dst, key)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.newSealedWriter: %w", err)
+	}
+	return w, nil
+}
+
+// encryptdir.newSealedReader: reads and checks the magic, version and signature of a stream under `key` from `src`
+// returns: reader of the plaintext, or error wrapping `malformed` if `src` isnt a stream with `magic`,
+// `ErrUnsupportedVersion` if it is newer than `version`, or the error of the signature if it isnt of `key`
+func newSealedReader(src io.Reader, magic string, version byte, privKey *gorsa.PrivateKey, key []byte, malformed error) (io.Reader, error) {
+	prefix := make([]byte, len(magic)+1)
+	_, err := io.ReadFull(src, prefix)
+	if err != nil || string(prefix[:len(magic)]) != magic {
+		return nil, fmt.Errorf("encryptdir.newSealedReader: no %s magic: %w", magic, malformed)
+	}
+	if got := prefix[len(magic)]; got > version {
+		return nil, fmt.Errorf("encryptdir.newSealedReader: version = %d, newest known = %d: %w", got, version, ErrUnsupportedVersion)
+	} else if got != version {
+		return nil, fmt.Errorf("encryptdir.newSealedReader: version = %d: %w", got, malformed)
+	}
+
+	sig := make([]byte, signatureSize(&privKey.PublicKey))
+	_, err = io.ReadFull(src, sig)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.newSealedReader: io.ReadFull(sig): %w", malformed)
+	}
+
+	err = rsa.VerifySignature(&privKey.PublicKey, sig, sealedMessage(magic, version, key), DefaultHashAlgo)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.newSealedReader: %w", err)
+	}
+
+	r, err := aes.NewDecryptReader(src, key)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.newSealedReader: %w", err)
+	}
+	return r, nil
+}
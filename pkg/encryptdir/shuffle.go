@@ -0,0 +1,107 @@
+package encryptdir
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/iafan/cwalk"
+)
+
+// shuffledFile: a file collected by `shuffledWalk`, `path` is relative to the root
+type shuffledFile struct {
+	path string
+	info os.FileInfo
+}
+
+// encryptdir.shuffledWalk: like `cwalk.Walk` but collects every file under `root` first and hands them to the workers in random order
+// spreads the IO of directories with many large files across the run, at the cost of holding every path in memory
+// dirs are passed to `walkFn` as they are reached, before anything under them, on the goroutine collecting the files, like cwalk the root with an empty path,
+// one `walkFn` errors on isnt descended into, so exclusions and the output dir are pruned, and the error is reported with the others unless it is `errPruned`,
+// and marking empty dirs or mirroring them into the output dir happens before any file under them is handed out
+// with `continueOnError` entries that cant be read, like a dir without permission, are reported and skipped instead of stopping the walk before any file
+// returns: error, joined over every file that failed
+func shuffledWalk(root string, walkFn filepath.WalkFunc, continueOnError bool) error {
+	var files []shuffledFile
+	var collectErrs []error
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		// the root itself failing is still the whole walk failing
+		if err != nil && continueOnError && path != root {
+			collectErrs = append(collectErrs, fmt.Errorf("path = %q: %w", path, err))
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil && continueOnError {
+			collectErrs = append(collectErrs, fmt.Errorf("d.Info: path = %q: %w", path, err))
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("d.Info: path = %q: %w", path, err)
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return fmt.Errorf("filepath.Rel: path = %q: %w", path, err)
+		}
+
+		if d.IsDir() {
+			if path == root {
+				// the root failing is the whole walk failing, like with cwalk
+				return walkFn("", info, nil)
+			}
+			err = walkFn(rel, info, nil)
+			if err != nil {
+				if !errors.Is(err, errPruned) {
+					collectErrs = append(collectErrs, err)
+				}
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		files = append(files, shuffledFile{path: rel, info: info})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("encryptdir.shuffledWalk: filepath.WalkDir: %w", err)
+	}
+
+	rand.Shuffle(len(files), func(i, j int) {
+		files[i], files[j] = files[j], files[i]
+	})
+
+	jobs := make(chan shuffledFile)
+	var mu sync.Mutex
+	errList := collectErrs
+	var wg sync.WaitGroup
+	for i := 0; i < cwalk.NumWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range jobs {
+				err := walkFn(file.path, file.info, nil)
+				if err != nil {
+					mu.Lock()
+					errList = append(errList, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, file := range files {
+		jobs <- file
+	}
+	close(jobs)
+	wg.Wait()
+
+	return errors.Join(errList...)
+}
@@ -0,0 +1,114 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestShuffleProcessesOnce(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := make(map[string][]byte)
+	for i := 0; i < 60; i++ {
+		spec[fmt.Sprintf("d%d/f%d.txt", i%3, i)] = []byte(fmt.Sprintf("file %d", i))
+	}
+	spec["skip.md"] = []byte("no key")
+	dir, _ := testutil.BuildTree(t, spec)
+
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	opts := Options{Shuffle: true, Hooks: Hooks{OnProgress: func(path string, _, _ int) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[path]++
+	}}}
+
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if report.Processed != len(spec)-1 || report.Failed != 0 {
+		t.Errorf("EncryptWithOptions: processed = %d, failed = %d, want %d and 0", report.Processed, report.Failed, len(spec)-1)
+	}
+	for rel := range spec {
+		if rel == "skip.md" {
+			continue
+		}
+		if n := seen[filepath.Join(dir, filepath.FromSlash(rel))]; n != 1 {
+			t.Errorf("path = %q: processed %d times, want once", rel, n)
+		}
+	}
+	if len(seen) != len(spec)-1 {
+		t.Errorf("%d files processed, want %d", len(seen), len(spec)-1)
+	}
+
+	report, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{Shuffle: true})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	if report.Processed != len(spec)-1 {
+		t.Errorf("DecryptWithOptions: processed = %d, want %d", report.Processed, len(spec)-1)
+	}
+	assertTree(t, dir, spec)
+}
+
+// dirs are passed to the walk func too, so everything done for dirs still happens with `Options.Shuffle`
+func TestShuffleDirs(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt", "keep")
+	spec := map[string][]byte{
+		"a.txt":           []byte("hello"),
+		"skip/b.txt":      []byte("excluded"),
+		"out/c.txt":       []byte("already in the output dir"),
+		"sub/d.txt":       []byte("world"),
+		"sub/skip/e.txt":  []byte("excluded too"),
+		"sub/deep/f.txt":  []byte("deep"),
+		"sub/deep/g.txt":  []byte("deeper"),
+		"sub/other/h.txt": []byte("other"),
+	}
+	dir, _ := testutil.BuildTree(t, spec)
+	err := os.Mkdir(filepath.Join(dir, "empty"), 0755)
+	if err != nil {
+		t.Fatalf("os.Mkdir: %v", err)
+	}
+	out := filepath.Join(dir, "out")
+
+	opts := Options{Shuffle: true, EmptyDirMarker: ".keep", Exclude: []string{"**/skip"}, OutputDir: out}
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if report.Processed != 5 {
+		t.Errorf("EncryptWithOptions: processed = %d, want 5", report.Processed)
+	}
+
+	tree := readTree(t, out)
+	for _, name := range []string{"a.txt", "sub/d.txt", "sub/deep/f.txt", "sub/deep/g.txt", "sub/other/h.txt"} {
+		plain, err := decryptBytes(t, keyMap, filepath.Join(out, filepath.FromSlash(name)), Options{})
+		if err != nil || !bytes.Equal(plain, spec[name]) {
+			t.Errorf("decryptTo(%s) = %q, %v, want %q", name, plain, err, spec[name])
+		}
+	}
+	// the empty dir is mirrored with its marker, the excluded dirs and the output dir are pruned
+	if _, ok := tree["empty/.keep"]; !ok {
+		t.Errorf("out/empty/.keep: no marker")
+	}
+	for _, name := range []string{"skip/b.txt", "sub/skip/e.txt", "out/c.txt"} {
+		if _, ok := tree[name]; ok {
+			t.Errorf("out/%s: written, want it pruned", name)
+		}
+	}
+	if string(tree["c.txt"]) != string(spec["out/c.txt"]) {
+		t.Errorf("out/c.txt = %q, want it left alone", tree["c.txt"])
+	}
+	if len(tree) != 7 {
+		t.Errorf("%d files in the output dir, want the 5 encrypted, the marker and c.txt", len(tree))
+	}
+}
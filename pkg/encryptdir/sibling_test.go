@@ -0,0 +1,63 @@
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestDecSibling(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+	stale := []byte("left by a crashed run")
+
+	for _, policy := range []SiblingPolicy{"", SiblingSkip, SiblingOverwrite, SiblingError} {
+		name := string(policy)
+		if len(name) == 0 {
+			name = "default"
+		}
+		t.Run(name, func(t *testing.T) {
+			dir, _ := testutil.BuildTree(t, spec)
+			opts := Options{DecSuffix: ".dec", DecSibling: policy}
+			_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+			if err != nil {
+				t.Fatalf("EncryptWithOptions: %v", err)
+			}
+			encrypted := readTree(t, dir)["a.txt"]
+
+			decPath := filepath.Join(dir, "a.txt.dec")
+			err = os.WriteFile(decPath, stale, 0600)
+			if err != nil {
+				t.Fatalf("os.WriteFile: %v", err)
+			}
+
+			report, err := DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+			switch policy {
+			case SiblingOverwrite:
+				// decrypted through the sibling, which is gone once it replaced the file
+				if err != nil || report.Processed != 1 {
+					t.Fatalf("DecryptWithOptions: processed = %d, err = %v, want 1 and nil", report.Processed, err)
+				}
+				assertTree(t, dir, spec)
+				return
+			case SiblingError:
+				if !errors.Is(err, ErrDecSiblingExists) || report.Failed != 1 {
+					t.Errorf("DecryptWithOptions: failed = %d, err = %v, want 1 and ErrDecSiblingExists", report.Failed, err)
+				}
+			default:
+				// the sibling is a temp file, so it is skipped along with the file
+				if err != nil || report.Processed != 0 || report.Failed != 0 {
+					t.Errorf("DecryptWithOptions: processed = %d, failed = %d, err = %v, want none", report.Processed, report.Failed, err)
+				}
+			}
+
+			// the file is left encrypted and the sibling as it was
+			assertTree(t, dir, map[string][]byte{"a.txt": encrypted, "a.txt.dec": stale})
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package encryptdir
+
+import (
+	gorsa "crypto/rsa"
+	"fmt"
+	"os"
+)
+
+// SidecarSuffix: suffix of the encrypted copy kept next to a plaintext file
+const SidecarSuffix = ".edir"
+
+// encryptdir.ConvertInPlaceToSidecar: turns every file in `dirs` that is encrypted in place into its plaintext plus an encrypted `<name>.edir` sidecar
+// the sidecar is the encrypted file as is, so it decrypts with the same key
+// the sidecar is written before the plaintext replaces the original, files that aren't encrypted are left alone
+// returns: error, stops at the first file that fails
+func ConvertInPlaceToSidecar(privKey *gorsa.PrivateKey, keyMap map[string][]byte, dirs []string) error {
+	err := walkCandidates(keyMap, dirs, func(path string, info os.FileInfo) error {
+		key, _ := lookupKey(keyMap, path)
+
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("os.ReadFile: %w", err)
+		}
+
+		header, sig, payload := splitSignature(&privKey.PublicKey, contents, nil)
+		if sig == nil {
+			return nil
+		}
+
+		err = verifyKey(&privKey.PublicKey, sig, key, header, Options{})
+		if err != nil { // not encrypted
+			return nil
+		}
+
+		plain, err := openPayload(header, key, payload)
+		if err != nil {
+			return fmt.Errorf("path = %q: %w", path, err)
+		}
+
+		err = writeNewFile(path+SidecarSuffix, contents, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+
+		tmpPath := Options{}.namer().TempName(path, true)
+		err = writeNewFile(tmpPath, plain, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+
+		return finalize(tmpPath, path)
+	})
+	if err != nil {
+		return fmt.Errorf("encryptdir.ConvertInPlaceToSidecar: %w", err)
+	}
+	return nil
+}
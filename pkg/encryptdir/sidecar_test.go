@@ -0,0 +1,56 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestConvertInPlaceToSidecar(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	encrypted := readTree(t, dir)
+
+	// a plaintext file among them is left alone, without a sidecar
+	err = os.WriteFile(filepath.Join(dir, "plain.txt"), []byte("never encrypted"), 0644)
+	if err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+
+	err = ConvertInPlaceToSidecar(privKey, keyMap, []string{dir})
+	if err != nil {
+		t.Fatalf("ConvertInPlaceToSidecar: %v", err)
+	}
+
+	want := map[string][]byte{"plain.txt": []byte("never encrypted")}
+	for rel, plain := range spec {
+		want[rel] = plain
+		want[rel+SidecarSuffix] = encrypted[rel]
+	}
+	assertTree(t, dir, want)
+
+	for rel, plain := range spec {
+		got, err := DecryptFileToBytes(privKey, keyMap["txt"], filepath.Join(dir, filepath.FromSlash(rel+SidecarSuffix)))
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("path = %q: DecryptFileToBytes = %q, %v, want %q", rel+SidecarSuffix, got, err, plain)
+		}
+	}
+
+	// converting again finds nothing encrypted in place
+	err = ConvertInPlaceToSidecar(privKey, keyMap, []string{dir})
+	if err != nil {
+		t.Fatalf("ConvertInPlaceToSidecar again: %v", err)
+	}
+	assertTree(t, dir, want)
+}
@@ -0,0 +1,82 @@
+package encryptdir
+
+import (
+	"crypto"
+	gorsa "crypto/rsa"
+	"fmt"
+	"sync"
+
+	"github.com/prairir/encryptdir/pkg/rsa"
+	"golang.org/x/sync/singleflight"
+)
+
+// rsa.CreateSignature, swapped out by tests counting and holding up signs
+var createSignature = rsa.CreateSignature
+
+// signatureCache: the signature of every AES key a run encrypted with, shared by every root and worker of the one private key
+// a file's signature only depends on its key and the hash, and PKCS #1 v1.5 signatures are deterministic,
+// so each key is signed once instead of once per file, with `Options.SignHeader` each file header is part of what is signed so nothing is cached
+// a nil `*signatureCache` signs every time
+type signatureCache struct {
+	mu   sync.Mutex
+	sigs map[signedKey][]byte
+	// signs of keys not cached yet, so two files of a new key dont both sign it
+	signing singleflight.Group
+}
+
+// signedKey: what a signature in a `signatureCache` was made from
+type signedKey struct {
+	key  string
+	hash crypto.Hash
+}
+
+// encryptdir.newSignatureCache: empty cache
+func newSignatureCache() *signatureCache {
+	return &signatureCache{sigs: make(map[signedKey][]byte)}
+}
+
+// encryptdir.signatureCache.sign: the signature of `key`, and of `header` if it signs the header, hashed with `hash` by `privKey`
+// a key alone is signed on the first call for it, without holding up the signs of other keys, a header signed with it every time
+// returns: signature, shared by every caller so it must not be modified, or error
+func (c *signatureCache) sign(privKey *gorsa.PrivateKey, key []byte, header *FileHeader, hash crypto.Hash) ([]byte, error) {
+	if c == nil || (header != nil && header.SignsHeader) {
+		sig, err := createSignature(privKey, signedMessage(key, header), hash)
+		if err != nil {
+			return nil, fmt.Errorf("encryptdir.signatureCache.sign: %w", err)
+		}
+		return sig, nil
+	}
+
+	k := signedKey{key: string(key), hash: hash}
+	if sig, ok := c.cached(k); ok {
+		return sig, nil
+	}
+
+	sig, err, _ := c.signing.Do(fmt.Sprintf("%d:%s", hash, key), func() (interface{}, error) {
+		// signed between the lookup and here
+		if sig, ok := c.cached(k); ok {
+			return sig, nil
+		}
+		sig, err := createSignature(privKey, key, hash)
+		if err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		c.sigs[k] = sig
+		c.mu.Unlock()
+		return sig, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.signatureCache.sign: %w", err)
+	}
+	return sig.([]byte), nil
+}
+
+// encryptdir.signatureCache.cached: the signature of `k` if it was signed already
+func (c *signatureCache) cached(k signedKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sig, ok := c.sigs[k]
+	return sig, ok
+}
@@ -0,0 +1,138 @@
+package encryptdir
+
+import (
+	"bytes"
+	"crypto"
+	gorsa "crypto/rsa"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prairir/encryptdir/pkg/rsa"
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestSignatureCache(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	key := testutil.NewTestKey("txt")
+	c := newSignatureCache()
+
+	first, err := c.sign(privKey, key, nil, crypto.SHA256)
+	if err != nil {
+		t.Fatalf("signatureCache.sign: %v", err)
+	}
+	again, err := c.sign(privKey, key, nil, crypto.SHA256)
+	if err != nil {
+		t.Fatalf("signatureCache.sign again: %v", err)
+	}
+	// the one signature, not signed again
+	if &again[0] != &first[0] || len(c.sigs) != 1 {
+		t.Errorf("signatureCache.sign again: signed again, %d cached, want the first signature and 1", len(c.sigs))
+	}
+
+	// each key and hash is signed on its own
+	_, err = c.sign(privKey, key, nil, crypto.SHA512)
+	if err != nil {
+		t.Fatalf("signatureCache.sign(SHA512): %v", err)
+	}
+	_, err = c.sign(privKey, testutil.NewTestKey("md"), nil, crypto.SHA256)
+	if err != nil {
+		t.Fatalf("signatureCache.sign(md): %v", err)
+	}
+	if len(c.sigs) != 3 {
+		t.Errorf("%d cached, want 3", len(c.sigs))
+	}
+
+	// the same bytes as signing without a cache
+	var none *signatureCache
+	uncached, err := none.sign(privKey, key, nil, crypto.SHA256)
+	if err != nil || !bytes.Equal(uncached, first) {
+		t.Errorf("nil signatureCache.sign = %x, %v, want %x", uncached, err, first)
+	}
+	err = rsa.VerifySignature(&privKey.PublicKey, first, key, crypto.SHA256)
+	if err != nil {
+		t.Errorf("rsa.VerifySignature: %v", err)
+	}
+}
+
+func TestSignatureCacheSignedHeader(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	key := testutil.NewTestKey("txt")
+	c := newSignatureCache()
+
+	// every file header is its own, so none of them is kept
+	for i := 0; i < 3; i++ {
+		header := &FileHeader{Version: FormatVersion, SignsHeader: true, Mode: 0600 + os.FileMode(i)}
+		sig, err := c.sign(privKey, key, header, crypto.SHA256)
+		if err != nil {
+			t.Fatalf("signatureCache.sign: %v", err)
+		}
+		err = rsa.VerifySignature(&privKey.PublicKey, sig, signedMessage(key, header), crypto.SHA256)
+		if err != nil {
+			t.Errorf("rsa.VerifySignature of the key and header: %v", err)
+		}
+	}
+	if len(c.sigs) != 0 {
+		t.Errorf("%d cached, want none", len(c.sigs))
+	}
+}
+
+func TestSignatureCacheConcurrent(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keys := [][]byte{testutil.NewTestKey("txt"), testutil.NewTestKey("md")}
+
+	// each sign waits for every key to be signing, which never happens if signing is serialized
+	var mu sync.Mutex
+	signs := make(map[string]int)
+	var signing sync.WaitGroup
+	signing.Add(len(keys))
+	allSigning := make(chan struct{})
+	go func() {
+		signing.Wait()
+		close(allSigning)
+	}()
+	createSignature = func(privKey *gorsa.PrivateKey, msg []byte, hash crypto.Hash) ([]byte, error) {
+		mu.Lock()
+		signs[string(msg)]++
+		if signs[string(msg)] == 1 {
+			signing.Done()
+		}
+		mu.Unlock()
+
+		select {
+		case <-allSigning:
+		case <-time.After(5 * time.Second):
+			t.Errorf("signing %x: the other keys werent signed at the same time", msg[:4])
+		}
+		return rsa.CreateSignature(privKey, msg, hash)
+	}
+	t.Cleanup(func() { createSignature = rsa.CreateSignature })
+
+	c := newSignatureCache()
+	sigs := make([][]byte, 16)
+	var wg sync.WaitGroup
+	for i := range sigs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sig, err := c.sign(privKey, keys[i%len(keys)], nil, crypto.SHA256)
+			if err != nil {
+				t.Errorf("signatureCache.sign: %v", err)
+			}
+			sigs[i] = sig
+		}(i)
+	}
+	wg.Wait()
+
+	for _, key := range keys {
+		if signs[string(key)] != 1 {
+			t.Errorf("key %x: signed %d times, want once", key[:4], signs[string(key)])
+		}
+	}
+	for i, sig := range sigs {
+		if !bytes.Equal(sig, sigs[i%len(keys)]) {
+			t.Errorf("sign %d: not the signature of the other signs of its key", i)
+		}
+	}
+}
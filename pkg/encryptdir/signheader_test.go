@@ -0,0 +1,171 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+// encryptSigned: `spec` written to a new dir and encrypted, with the whole file header signed if `signHeader`
+// returns: dir
+func encryptSigned(t *testing.T, spec map[string][]byte, keyMap map[string][]byte, signHeader bool) string {
+	t.Helper()
+
+	dir, _ := testutil.BuildTree(t, spec)
+	_, err := EncryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), keyMap, []string{dir}, Options{SignHeader: signHeader})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	return dir
+}
+
+// flipByte: xors the byte at `off` of the file at `path` with `mask`
+func flipByte(t *testing.T, path string, off int, mask byte) {
+	t.Helper()
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("os.ReadFile: %v", err)
+	}
+	contents[off] ^= mask
+	err = os.WriteFile(path, contents, 0600)
+	if err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+}
+
+func TestSignHeaderRoundTrip(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world")}
+	dir := encryptSigned(t, spec, keyMap, true)
+
+	header, err := ReadHeader(filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatalf("ReadHeader: %v", err)
+	}
+	if header.Version != FormatVersion || !header.SignsHeader {
+		t.Errorf("ReadHeader: version = %d, signs header = %v, want %d and true", header.Version, header.SignsHeader, FormatVersion)
+	}
+
+	_, err = DecryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	assertTree(t, dir, spec)
+}
+
+func TestSignHeaderTampered(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+
+	tests := []struct {
+		name string
+		off  int
+		mask byte
+	}{
+		{"mode", ModeOffset, 0o077},
+		{"ext", ExtOffset, 0x20},
+		{"compression", CompressionOffset, CompressionGzip},
+		// a file claiming the signature is of the key alone is caught the same way
+		{"signed", SignedOffset, SignedHeader},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := encryptSigned(t, spec, keyMap, true)
+			path := filepath.Join(dir, "a.txt")
+			flipByte(t, path, tt.off, tt.mask)
+
+			_, err := decryptBytes(t, keyMap, path, Options{})
+			if !errors.Is(err, ErrNotEncrypted) {
+				t.Errorf("decryptTo: err = %v, want ErrNotEncrypted", err)
+			}
+		})
+	}
+}
+
+func TestSignHeaderOff(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+	dir := encryptSigned(t, spec, keyMap, false)
+	path := filepath.Join(dir, "a.txt")
+
+	header, err := ReadHeader(path)
+	if err != nil || header.SignsHeader {
+		t.Fatalf("ReadHeader: signs header = %v, %v, want false", header.SignsHeader, err)
+	}
+
+	// only the key is signed, a changed mode goes unnoticed
+	flipByte(t, path, ModeOffset, 0o077)
+	plain, err := decryptBytes(t, keyMap, path, Options{})
+	if err != nil || !bytes.Equal(plain, spec["a.txt"]) {
+		t.Errorf("decryptTo = %q, %v, want %q", plain, err, spec["a.txt"])
+	}
+}
+
+func TestRequireSignedHeader(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+
+	signed := filepath.Join(encryptSigned(t, spec, keyMap, true), "a.txt")
+	plain, err := decryptBytes(t, keyMap, signed, Options{RequireSignedHeader: true})
+	if err != nil || !bytes.Equal(plain, spec["a.txt"]) {
+		t.Errorf("decryptTo(signed) = %q, %v, want %q", plain, err, spec["a.txt"])
+	}
+
+	unsigned := filepath.Join(encryptSigned(t, spec, keyMap, false), "a.txt")
+	_, err = decryptBytes(t, keyMap, unsigned, Options{RequireSignedHeader: true})
+	if !errors.Is(err, ErrUnsignedHeader) {
+		t.Errorf("decryptTo(unsigned): err = %v, want ErrUnsignedHeader", err)
+	}
+
+	// a file without a file header could be plaintext, it is left alone even with `LegacyFormat`
+	dir, _ := testutil.BuildTree(t, spec)
+	_, err = decryptBytes(t, keyMap, filepath.Join(dir, "a.txt"), Options{RequireSignedHeader: true, LegacyFormat: true})
+	if !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("decryptTo(plaintext): err = %v, want ErrNotEncrypted", err)
+	}
+}
+
+// a key alone signs the same for every file, so a file signed for its header can have it swapped for another file's signature of the key
+func TestRequireSignedHeaderDowngrade(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	pubKey := &testutil.NewPrivateKey(t).PublicKey
+
+	signedPath := filepath.Join(encryptSigned(t, map[string][]byte{"a.txt": []byte("hello")}, keyMap, true), "a.txt")
+	unsignedPath := filepath.Join(encryptSigned(t, map[string][]byte{"b.txt": []byte("other")}, keyMap, false), "b.txt")
+
+	signed, err := os.ReadFile(signedPath)
+	if err != nil {
+		t.Fatalf("os.ReadFile: %v", err)
+	}
+	unsigned, err := os.ReadFile(unsignedPath)
+	if err != nil {
+		t.Fatalf("os.ReadFile: %v", err)
+	}
+	_, _, body := splitSignature(pubKey, signed, nil)
+	_, keySig, _ := splitSignature(pubKey, unsigned, nil)
+
+	header := append([]byte(nil), signed[:len(signed)-len(keySig)-len(body)]...)
+	header[SignedOffset] = SignedKey
+	header[ModeOffset] ^= 0o077
+	forged := append(append(header, keySig...), body...)
+	err = os.WriteFile(signedPath, forged, 0600)
+	if err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+
+	plain, err := decryptBytes(t, keyMap, signedPath, Options{})
+	if err != nil || !bytes.Equal(plain, []byte("hello")) {
+		t.Fatalf("decryptTo = %q, %v, want the downgraded file to decrypt without the option", plain, err)
+	}
+	_, err = decryptBytes(t, keyMap, signedPath, Options{RequireSignedHeader: true})
+	if !errors.Is(err, ErrUnsignedHeader) {
+		t.Errorf("decryptTo: err = %v, want ErrUnsignedHeader", err)
+	}
+}
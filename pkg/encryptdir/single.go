@@ -0,0 +1,122 @@
+package encryptdir
+
+import (
+	"bytes"
+	gorsa "crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/prairir/encryptdir/pkg/aes"
+	"github.com/prairir/encryptdir/pkg/rsa"
+)
+
+// sentinel error used for when `EncryptFile` is given a file that is already encrypted with its key
+var ErrAlreadyEncrypted = errors.New("file is already encrypted")
+
+// encryptdir.EncryptFile: encrypts the file at `src` with `key` into `dst`, with the same file header and signature `Encrypt` writes
+// the output goes to a temp file next to `dst` that is renamed over it, so with `dst == src` the file is replaced atomically and never half written
+// `dst` gets the permission bits of `src`, and its file header the extension of `src`, so it decrypts with the key for `src` whatever `dst` is called
+// the file is held in memory, like `ReKeyFile` does
+// returns: error wrapping `ErrAlreadyEncrypted` if `src` is already encrypted with `key`
+func EncryptFile(privKey *gorsa.PrivateKey, key []byte, src string, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("encryptdir.EncryptFile: os.Stat: %w", err)
+	}
+
+	plain, err := os.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("encryptdir.EncryptFile: os.ReadFile: %w", err)
+	}
+
+	fileHeader, sig, _ := splitSignature(&privKey.PublicKey, plain, nil)
+	if fileHeader != nil && sig != nil && verifyKey(&privKey.PublicKey, sig, key, fileHeader, Options{}) == nil {
+		return fmt.Errorf("encryptdir.EncryptFile: path = %q: %w", src, ErrAlreadyEncrypted)
+	}
+
+	out, err := seal(privKey, key, src, info.Mode(), plain)
+	if err != nil {
+		return fmt.Errorf("encryptdir.EncryptFile: %w", err)
+	}
+
+	tmpPath := Options{}.namer().TempName(dst, false)
+	err = writeNewFile(tmpPath, out, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("encryptdir.EncryptFile: %w", err)
+	}
+
+	err = finalize(tmpPath, dst)
+	if err != nil {
+		return fmt.Errorf("encryptdir.EncryptFile: %w", err)
+	}
+
+	return nil
+}
+
+// encryptdir.seal: the file header, signature, and payload of `plain` encrypted with `key`, for a file at `path` with `mode`
+// returns: encrypted file or error
+func seal(privKey *gorsa.PrivateKey, key []byte, path string, mode os.FileMode, plain []byte) ([]byte, error) {
+	wSig, err := rsa.CreateSignature(privKey, key, DefaultHashAlgo)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.seal: rsa.CreateSignature: %w", err)
+	}
+
+	cipher, err := aes.EncryptGCM(key, plain)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.seal: %w", err)
+	}
+
+	var out bytes.Buffer
+	out.Grow(FileHeaderSize + len(wSig) + len(cipher))
+	out.Write(newFileHeader(path, mode, DefaultHashAlgo).marshal())
+	out.Write(wSig)
+	out.Write(cipher)
+	return out.Bytes(), nil
+}
+
+// encryptdir.DecryptFile: decrypts the file at `src` with `key` into `dst`
+// the output goes to a temp file next to `dst` that is renamed over it, so with `dst == src` the file is replaced atomically and never half written
+// `dst` gets the permission bits the file header of `src` recorded, or those of `src` for files without one
+// the file is held in memory, like `DecryptFileToBytes` does
+// returns: error wrapping `ErrNotEncrypted` if `src` isn't encrypted with `key`, or `aes.ErrAuthFailed` if it was modified
+func DecryptFile(privKey *gorsa.PrivateKey, key []byte, src string, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("encryptdir.DecryptFile: os.Stat: %w", err)
+	}
+
+	plain, err := DecryptFileToBytes(privKey, key, src)
+	if err != nil {
+		return fmt.Errorf("encryptdir.DecryptFile: %w", err)
+	}
+
+	header, err := ReadHeaderWithKey(&privKey.PublicKey, src)
+	if err != nil {
+		return fmt.Errorf("encryptdir.DecryptFile: %w", err)
+	}
+	mode := info.Mode().Perm()
+	if header.Version > 1 {
+		mode = header.Mode
+	}
+
+	tmpPath := Options{}.namer().TempName(dst, true)
+	err = writeNewFile(tmpPath, plain, mode)
+	if err != nil {
+		return fmt.Errorf("encryptdir.DecryptFile: %w", err)
+	}
+
+	// the umask can take bits off of `writeNewFile`
+	err = os.Chmod(tmpPath, mode)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("encryptdir.DecryptFile: os.Chmod: %w", err)
+	}
+
+	err = finalize(tmpPath, dst)
+	if err != nil {
+		return fmt.Errorf("encryptdir.DecryptFile: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package encryptdir
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestEncryptFileDistinct(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	key := testutil.NewTestKey("txt")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello")})
+	src := filepath.Join(dir, "a.txt")
+	err := os.Chmod(src, 0640)
+	if err != nil {
+		t.Fatalf("os.Chmod: %v", err)
+	}
+
+	// another name and extension, the file header keeps that of `src`
+	dst := filepath.Join(dir, "out", "a.bin")
+	err = os.Mkdir(filepath.Dir(dst), 0755)
+	if err != nil {
+		t.Fatalf("os.Mkdir: %v", err)
+	}
+	err = EncryptFile(privKey, key, src, dst)
+	if err != nil {
+		t.Fatalf("EncryptFile: %v", err)
+	}
+
+	// the source is left alone
+	got, err := os.ReadFile(src)
+	if err != nil || string(got) != "hello" {
+		t.Errorf("EncryptFile: src = %q, %v, want it untouched", got, err)
+	}
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatalf("os.Stat: %v", err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("EncryptFile: dst mode = %v, want the 0640 of src", info.Mode().Perm())
+	}
+	header, err := ReadHeader(dst)
+	if err != nil || header.Ext != "txt" {
+		t.Errorf("ReadHeader(dst) = %+v, %v, want the extension of src", header, err)
+	}
+
+	back := filepath.Join(dir, "back.txt")
+	err = DecryptFile(privKey, key, dst, back)
+	if err != nil {
+		t.Fatalf("DecryptFile: %v", err)
+	}
+	got, err = os.ReadFile(back)
+	if err != nil || string(got) != "hello" {
+		t.Errorf("DecryptFile: dst = %q, %v, want %q", got, err, "hello")
+	}
+	if _, err := os.Stat(dst); err != nil {
+		t.Errorf("DecryptFile: src: %v, want it left alone", err)
+	}
+}
+
+func TestEncryptFileInPlace(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	key := testutil.NewTestKey("txt")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello")})
+	path := filepath.Join(dir, "a.txt")
+
+	err := EncryptFile(privKey, key, path, path)
+	if err != nil {
+		t.Fatalf("EncryptFile: %v", err)
+	}
+	encrypted := readTree(t, dir)
+	// replaced, no temp file next to it
+	if len(encrypted) != 1 || bytes.Equal(encrypted["a.txt"], []byte("hello")) {
+		t.Fatalf("EncryptFile: tree = %d files, want only a.txt encrypted", len(encrypted))
+	}
+
+	err = EncryptFile(privKey, key, path, path)
+	if !errors.Is(err, ErrAlreadyEncrypted) {
+		t.Errorf("EncryptFile again: err = %v, want ErrAlreadyEncrypted", err)
+	}
+	assertTree(t, dir, encrypted)
+
+	err = DecryptFile(privKey, testutil.NewTestKey("other"), path, path)
+	if !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("DecryptFile with another key: err = %v, want ErrNotEncrypted", err)
+	}
+
+	err = DecryptFile(privKey, key, path, path)
+	if err != nil {
+		t.Fatalf("DecryptFile: %v", err)
+	}
+	assertTree(t, dir, map[string][]byte{"a.txt": []byte("hello")})
+}
@@ -0,0 +1,31 @@
+package encryptdir
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// sentinel error used for when a file takes longer than `Options.SlowFileThreshold` and `Options.FailOnSlow` is set
+var ErrSlowFile = errors.New("file took longer than the slow file threshold")
+
+// encryptdir.Walker.checkSlow: records `path` as slow if it took longer than the threshold
+// the file has already been processed when this is called, failing only reports it
+// returns: error wrapping `ErrSlowFile` if the file was slow and `FailOnSlow` is set
+func (w Walker) checkSlow(path string, took time.Duration) error {
+	if w.opts.SlowFileThreshold <= 0 || took <= w.opts.SlowFileThreshold {
+		return nil
+	}
+
+	w.stats.slow()
+	fullPath := filepath.Join(w.startPath, path)
+	if w.opts.Hooks.OnSlowFile != nil {
+		w.opts.Hooks.OnSlowFile(fullPath, took)
+	}
+
+	if w.opts.FailOnSlow {
+		return fmt.Errorf("took = %s: %w", took, ErrSlowFile)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+// slowNamer: names temp files like the default, taking `delay` to name the one for `slowPath`
+type slowNamer struct {
+	SuffixNamer
+	slowPath string
+	delay    time.Duration
+}
+
+func (n slowNamer) TempName(path string, decrypt bool) string {
+	if path == n.slowPath {
+		time.Sleep(n.delay)
+	}
+	return n.SuffixNamer.TempName(path, decrypt)
+}
+
+// slowOptions: options where encrypting `slow.txt` under `dir` takes well over the threshold
+// returns: options and the paths `Hooks.OnSlowFile` was called with
+func slowOptions(dir string, failOnSlow bool) (Options, func() []string) {
+	var mu sync.Mutex
+	var slow []string
+	opts := Options{
+		Namer:             slowNamer{SuffixNamer: SuffixNamer{EncSuffix: DefaultEncSuffix, DecSuffix: DefaultDecSuffix}, slowPath: filepath.Join(dir, "slow.txt"), delay: 200 * time.Millisecond},
+		SlowFileThreshold: 100 * time.Millisecond,
+		FailOnSlow:        failOnSlow,
+		Hooks: Hooks{OnSlowFile: func(path string, took time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			slow = append(slow, path)
+		}},
+	}
+	return opts, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slow
+	}
+}
+
+func TestSlowFileReported(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"slow.txt": []byte("slow"), "a.txt": []byte("fast"), "b.txt": []byte("fast")})
+	opts, slow := slowOptions(dir, false)
+
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if report.Processed != 3 || report.Failed != 0 {
+		t.Errorf("EncryptWithOptions: processed = %d, failed = %d, want 3 and 0", report.Processed, report.Failed)
+	}
+
+	got := slow()
+	if len(got) != 1 || got[0] != filepath.Join(dir, "slow.txt") {
+		t.Errorf("OnSlowFile called with %v, want just slow.txt", got)
+	}
+}
+
+func TestSlowFileFails(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"slow.txt": []byte("slow"), "a.txt": []byte("fast")})
+	opts, slow := slowOptions(dir, true)
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if !errors.Is(err, ErrSlowFile) {
+		t.Fatalf("EncryptWithOptions: err = %v, want ErrSlowFile", err)
+	}
+	if len(slow()) != 1 {
+		t.Errorf("OnSlowFile called %d times, want once", len(slow()))
+	}
+
+	// the slow file was still encrypted, failing only reports it
+	plain, err := DecryptFileToBytes(privKey, keyMap["txt"], filepath.Join(dir, "slow.txt"))
+	if err != nil || string(plain) != "slow" {
+		t.Errorf("DecryptFileToBytes = %q, %v, want %q", plain, err, "slow")
+	}
+}
@@ -0,0 +1,46 @@
+package encryptdir
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// sentinel error used for when a directory doesn't have room for the temp files of an encrypt run
+var ErrInsufficientSpace = errors.New("insufficient free disk space")
+
+// encryptdir.checkFreeSpace: checks every directory in `dirs` has room for what encrypting it writes next to the originals
+// in place that is the largest temp file, since each one replaces its original before the next one is needed
+// with `Options.KeepOriginal` every output is kept, so it is all of them
+// returns: error wrapping `ErrInsufficientSpace` for the first directory without room
+func checkFreeSpace(keyMap map[string][]byte, dirs []string, opts Options) error {
+	header := FileHeader{Version: FormatVersion, Metadata: opts.Metadata}
+	overhead := int64(len(opts.bannerLine()) + header.size() + SignatureSize)
+
+	for _, dir := range dirs {
+		var need int64
+		err := walkCandidates(keyMap, []string{dir}, func(path string, info os.FileInfo) error {
+			size := overhead + opts.payloadSize(info.Size())
+			if opts.KeepOriginal {
+				need += size
+			} else if size > need {
+				need = size
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("encryptdir.checkFreeSpace: %w", err)
+		}
+
+		free, err := freeSpace(dir)
+		if err != nil {
+			return fmt.Errorf("encryptdir.checkFreeSpace: %w", err)
+		}
+
+		if uint64(need) > free {
+			return fmt.Errorf("encryptdir.checkFreeSpace: dir = %q, need = %d, free = %d: %w", dir, need, free, ErrInsufficientSpace)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package encryptdir
+
+import "fmt"
+
+// encryptdir.freeSpace: free space isnt supported on this platform
+// returns: `ErrFreeSpaceUnsupported`
+func freeSpace(path string) (uint64, error) {
+	return 0, fmt.Errorf("encryptdir.freeSpace: %w", ErrFreeSpaceUnsupported)
+}
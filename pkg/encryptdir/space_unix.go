@@ -0,0 +1,20 @@
+//go:build linux || darwin
+
+package encryptdir
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// encryptdir.freeSpace: bytes available to unprivileged users on the filesystem holding `path`
+// returns: free bytes or error
+func freeSpace(path string) (uint64, error) {
+	var stat unix.Statfs_t
+	err := unix.Statfs(path, &stat)
+	if err != nil {
+		return 0, fmt.Errorf("encryptdir.freeSpace: unix.Statfs: path = %q: %w", path, err)
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
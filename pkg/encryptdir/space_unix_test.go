@@ -0,0 +1,62 @@
+//go:build linux || darwin
+
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestCheckFreeSpace(t *testing.T) {
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello"), "big.txt": nil})
+	free, err := freeSpace(dir)
+	if err != nil {
+		t.Fatalf("freeSpace: %v", err)
+	}
+
+	// sparse, so it takes no space itself but its temp file wouldnt fit
+	err = os.Truncate(filepath.Join(dir, "big.txt"), int64(free)+1<<30)
+	if err != nil {
+		t.Skipf("os.Truncate past the free space: %v", err)
+	}
+
+	report, err := EncryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), testutil.NewKeyMap("txt"), []string{dir}, Options{CheckFreeSpace: true})
+	if !errors.Is(err, ErrInsufficientSpace) {
+		t.Fatalf("EncryptWithOptions: err = %v, want ErrInsufficientSpace", err)
+	}
+
+	// it fails before starting, a.txt fits but isnt encrypted either
+	if report.Processed != 0 {
+		t.Errorf("EncryptWithOptions: processed = %d, want 0", report.Processed)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "a.txt"))
+	if err != nil || string(got) != "hello" {
+		t.Errorf("a.txt: encrypted by a run that failed its preflight")
+	}
+}
+
+func TestCheckFreeSpaceFits(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world")})
+
+	for _, keep := range []bool{false, true} {
+		err := checkFreeSpace(keyMap, []string{dir}, Options{KeepOriginal: keep})
+		if err != nil {
+			t.Errorf("checkFreeSpace(KeepOriginal = %t): %v", keep, err)
+		}
+	}
+
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{CheckFreeSpace: true})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if report.Processed != 2 {
+		t.Errorf("EncryptWithOptions: processed = %d, want 2", report.Processed)
+	}
+}
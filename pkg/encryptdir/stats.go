@@ -0,0 +1,113 @@
+package encryptdir
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Stats: what happened to the files under a single directory root
+type Stats struct {
+	// files encrypted or decrypted
+	Processed int
+	// files left alone, like extensions without a key or files already in the wanted state
+	Skipped int
+	// files that errored
+	Failed int
+	// files that took longer than `Options.SlowFileThreshold`, also counted in the other fields
+	Slow int
+	// symlinks followed with `Options.FollowSymlinks`, also counted in the other fields
+	Symlinks int
+}
+
+// Hooks: callbacks around the processing of each directory root
+// roots are processed concurrently so hooks for different roots can run at the same time
+type Hooks struct {
+	// called before a root is walked
+	OnRootStart func(dir string)
+	// called after a root is walked with its stats and walk error
+	OnRootFinish func(dir string, stats Stats, err error)
+	// called for every file that took longer than `Options.SlowFileThreshold`
+	OnSlowFile func(path string, took time.Duration)
+	// called after every file with a key finishes, whatever happened to it, calls are serialized across roots
+	// `total` is -1 unless `Options.CountTotal` is set
+	OnProgress func(path string, done, total int)
+}
+
+// walkStats: counters for a single root, safe to use from the cwalk workers concurrently
+// a nil `*walkStats` counts nothing
+type walkStats struct {
+	files     atomic.Int64
+	processed atomic.Int64
+	failed    atomic.Int64
+	slowFiles atomic.Int64
+	links     atomic.Int64
+
+	// shared by every root, nil unless per file results were asked for
+	results *resultCollector
+}
+
+// encryptdir.walkStats.done: records that the file at `path` was encrypted or decrypted
+func (s *walkStats) done(path string) {
+	if s == nil {
+		return
+	}
+	s.processed.Add(1)
+	s.results.done(path)
+}
+
+// encryptdir.walkStats.hashed: records the plaintext hash of the file at `path`, a nil `h` records nothing
+func (s *walkStats) hashed(path string, h *contentHash) {
+	if s == nil || h == nil {
+		return
+	}
+	sum, size := h.sum()
+	s.results.hash(path, sum, size)
+}
+
+// encryptdir.walkStats.slow: records that the file was slow
+func (s *walkStats) slow() {
+	if s == nil {
+		return
+	}
+	s.slowFiles.Add(1)
+}
+
+// encryptdir.walkStats.link: records that a symlink was followed
+func (s *walkStats) link() {
+	if s == nil {
+		return
+	}
+	s.links.Add(1)
+}
+
+// encryptdir.walkStats.visit: records the visited entry at `path`, processed by `worker`, and if it failed, dirs arent counted
+func (s *walkStats) visit(path string, info os.FileInfo, worker int, err error) {
+	if s == nil || info == nil || info.IsDir() {
+		return
+	}
+
+	s.files.Add(1)
+	if err != nil {
+		s.failed.Add(1)
+	}
+	s.results.visit(path, worker, err)
+}
+
+// encryptdir.walkStats.stats: snapshot of the counters
+func (s *walkStats) stats() Stats {
+	if s == nil {
+		return Stats{}
+	}
+
+	files := s.files.Load()
+	processed := s.processed.Load()
+	failed := s.failed.Load()
+	return Stats{
+		Processed: int(processed),
+		Skipped:   int(files - processed - failed),
+		Failed:    int(failed),
+		Slow:      int(s.slowFiles.Load()),
+		Symlinks:  int(s.links.Load()),
+	}
+}
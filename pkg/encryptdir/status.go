@@ -0,0 +1,106 @@
+package encryptdir
+
+import (
+	gorsa "crypto/rsa"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/prairir/encryptdir/pkg/aes"
+)
+
+// EncryptionState: if a file is encrypted, as seen by `Status`
+type EncryptionState string
+
+const (
+	// the file starts with the signature of its key and its sizes add up
+	StateEncrypted EncryptionState = "encrypted"
+	// the file doesnt start with the signature of its key
+	StatePlaintext EncryptionState = "plaintext"
+	// the file's extension isn't in the key map
+	StateSkipped EncryptionState = "skipped"
+	// the file is signed but its sizes dont add up, or it couldn't be read
+	StateCorrupt EncryptionState = "corrupt"
+)
+
+// PathStatus: the encryption state of a single file, `Err` is only set when `State` is `StateCorrupt`
+type PathStatus struct {
+	Path  string
+	State EncryptionState
+	Err   error
+}
+
+// encryptdir.Status: audits every regular file in `dirs`, nothing is written
+// detection is the same signature check encrypting uses to skip files, encrypted files only have their header read
+// files with a banner aren't supported, they show as plaintext
+// returns: state of every regular file, or error if a directory can't be walked
+func Status(privKey *gorsa.PrivateKey, keyMap map[string][]byte, dirs []string) ([]PathStatus, error) {
+	var statuses []PathStatus
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !d.Type().IsRegular() {
+				return nil
+			}
+
+			key, ok := lookupKey(keyMap, path)
+			if !ok {
+				statuses = append(statuses, PathStatus{Path: path, State: StateSkipped})
+				return nil
+			}
+
+			state, err := fileState(&privKey.PublicKey, key, path)
+			statuses = append(statuses, PathStatus{Path: path, State: state, Err: err})
+			return nil
+		})
+		if err != nil {
+			return statuses, fmt.Errorf("encryptdir.Status: dir = %q: %w", dir, err)
+		}
+	}
+
+	return statuses, nil
+}
+
+// encryptdir.fileState: the encryption state of the file at `path` encrypted by `key`
+// returns: state, and error when it is `StateCorrupt`
+func fileState(pubKey *gorsa.PublicKey, key []byte, path string) (EncryptionState, error) {
+	in, err := os.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return StateCorrupt, fmt.Errorf("encryptdir.fileState: os.OpenFile: %w", err)
+	}
+	defer in.Close()
+
+	encrypted, err := hasSignature(in, pubKey, key, nil)
+	if err != nil {
+		return StateCorrupt, fmt.Errorf("encryptdir.fileState: %w", err)
+	}
+	if !encrypted {
+		return StatePlaintext, nil
+	}
+
+	info, err := in.Stat()
+	if err != nil {
+		return StateCorrupt, fmt.Errorf("encryptdir.fileState: in.Stat: %w", err)
+	}
+
+	header, err := ReadHeaderWithKey(pubKey, path)
+	if err != nil {
+		return StateCorrupt, fmt.Errorf("encryptdir.fileState: %w", err)
+	}
+
+	// same check `aes.Decrypt` and `aes.DecryptGCM` do before decrypting, without reading the ciphertext
+	size := info.Size() - int64(fileHeaderSize(header.Version))
+	want := aes.CipherSize(int64(header.PlaintextSize))
+	if header.ChunkSize > 0 {
+		want = aes.GCMSize(int64(header.PlaintextSize), header.ChunkSize)
+	}
+	if header.PlaintextSize > uint64(size) || want != size-int64(signatureSize(pubKey)) {
+		return StateCorrupt, fmt.Errorf("encryptdir.fileState: path = %q, size = %d, plaintext size = %d: %w", path, size, header.PlaintextSize, aes.ErrCorrupt)
+	}
+
+	return StateEncrypted, nil
+}
@@ -0,0 +1,67 @@
+package encryptdir
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestStatus(t *testing.T) {
+	for _, bits := range keySizes {
+		t.Run(fmt.Sprint(bits), func(t *testing.T) {
+			privKey := testutil.NewPrivateKeyBits(t, bits)
+			keyMap := testutil.NewKeyMap("txt")
+			dir, _ := testutil.BuildTree(t, map[string][]byte{
+				"encrypted.txt": []byte("encrypted"),
+				"corrupt.txt":   []byte("cut short"),
+			})
+			err := Encrypt(nil, privKey, keyMap, []string{dir})
+			if err != nil {
+				t.Fatalf("Encrypt: %v", err)
+			}
+
+			corrupt := filepath.Join(dir, "corrupt.txt")
+			contents, err := os.ReadFile(corrupt)
+			if err != nil {
+				t.Fatalf("os.ReadFile: %v", err)
+			}
+			err = os.WriteFile(corrupt, contents[:len(contents)-1], 0600)
+			if err != nil {
+				t.Fatalf("os.WriteFile: %v", err)
+			}
+			for name, contents := range map[string]string{"plaintext.txt": "plain", "skipped.md": "no key"} {
+				err = os.WriteFile(filepath.Join(dir, name), []byte(contents), 0600)
+				if err != nil {
+					t.Fatalf("os.WriteFile: %v", err)
+				}
+			}
+
+			statuses, err := Status(privKey, keyMap, []string{dir})
+			if err != nil {
+				t.Fatalf("Status: %v", err)
+			}
+
+			want := map[string]EncryptionState{
+				"encrypted.txt": StateEncrypted,
+				"corrupt.txt":   StateCorrupt,
+				"plaintext.txt": StatePlaintext,
+				"skipped.md":    StateSkipped,
+			}
+			if len(statuses) != len(want) {
+				t.Fatalf("statuses = %v, want one per file", statuses)
+			}
+			for _, s := range statuses {
+				name := filepath.Base(s.Path)
+				if s.State != want[name] {
+					t.Errorf("path = %q: state = %q, want %q, error = %v", name, s.State, want[name], s.Err)
+				}
+				if (s.Err != nil) != (s.State == StateCorrupt) {
+					t.Errorf("path = %q: error = %v, only corrupt files have one", name, s.Err)
+				}
+			}
+		})
+	}
+}
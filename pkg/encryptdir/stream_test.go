@@ -0,0 +1,80 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestStreamLargeFile(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("bin")
+	size := int64(100 << 20)
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.bin": nil})
+	path := filepath.Join(dir, "a.bin")
+	// sparse, so the plaintext is never in memory either
+	err := os.Truncate(path, size)
+	if err != nil {
+		t.Fatalf("os.Truncate: %v", err)
+	}
+
+	// a ceiling well under the file, the gc has to keep up with a few chunks at a time
+	limit := debug.SetMemoryLimit(32 << 20)
+	t.Cleanup(func() { debug.SetMemoryLimit(limit) })
+
+	for _, decrypt := range []bool{false, true} {
+		name, run := "EncryptWithOptions", EncryptWithOptions
+		if decrypt {
+			name, run = "DecryptWithOptions", DecryptWithOptions
+		}
+
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		// the size is over `DefaultStreamThreshold`, it streams without being asked to
+		report, err := run(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+		runtime.ReadMemStats(&after)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if report.Processed != 1 {
+			t.Errorf("%s: processed = %d, want 1", name, report.Processed)
+		}
+		if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 16<<20 {
+			t.Errorf("%s allocated %d bytes for a %d byte file, want a few chunks", name, alloc, size)
+		}
+	}
+
+	// back to the zeros it started as
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("os.Open: %v", err)
+	}
+	defer f.Close()
+	zeros := make([]byte, DefaultStreamChunkSize)
+	buf := make([]byte, DefaultStreamChunkSize)
+	var n int64
+	for {
+		m, err := io.ReadFull(f, buf)
+		if !bytes.Equal(buf[:m], zeros[:m]) {
+			t.Fatalf("decrypted file differs from the original at about byte %d", n)
+		}
+		n += int64(m)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("io.ReadFull: %v", err)
+		}
+	}
+	if n != size {
+		t.Errorf("decrypted file is %d bytes, want %d", n, size)
+	}
+}
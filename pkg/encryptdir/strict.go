@@ -0,0 +1,27 @@
+package encryptdir
+
+import (
+	"crypto"
+	gorsa "crypto/rsa"
+	"errors"
+	"fmt"
+)
+
+// sentinel error used for when `Options.StrictCrypto` finds a weak setting
+var ErrWeakCrypto = errors.New("weak crypto configuration")
+
+// smallest RSA key `CheckStrictCrypto` allows, in bits
+const MinStrictRSABits = 2048
+
+// encryptdir.CheckStrictCrypto: rejects weak crypto settings before anything is touched, `hash` is the signature hash
+// returns: error wrapping `ErrWeakCrypto` naming the first weak setting
+func CheckStrictCrypto(privKey *gorsa.PrivateKey, hash crypto.Hash) error {
+	if bits := privKey.N.BitLen(); bits < MinStrictRSABits {
+		return fmt.Errorf("encryptdir.CheckStrictCrypto: rsa key = %d bits, want at least %d: %w", bits, MinStrictRSABits, ErrWeakCrypto)
+	}
+
+	if hash == crypto.MD5 {
+		return fmt.Errorf("encryptdir.CheckStrictCrypto: signature hash = md5: %w", ErrWeakCrypto)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package encryptdir
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/config"
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestValidateEveryEntryPoint(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+
+	tests := []struct {
+		name    string
+		bits    int
+		opts    Options
+		wantErr error
+	}{
+		{"md5", 2048, Options{StrictCrypto: true, HashAlgo: crypto.MD5}, ErrWeakCrypto},
+		{"small key", 1024, Options{StrictCrypto: true}, ErrWeakCrypto},
+		{"sibling policy", 2048, Options{DecSibling: "rename"}, ErrUnknownSiblingPolicy},
+		{"same suffix", 2048, Options{EncSuffix: ".tmp", DecSuffix: ".tmp"}, ErrSameTempSuffix},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			privKey := testutil.NewPrivateKeyBits(t, tt.bits)
+			dir, _ := testutil.BuildTree(t, spec)
+
+			_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, tt.opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("EncryptWithOptions: err = %v, want %v", err, tt.wantErr)
+			}
+			_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, tt.opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("DecryptWithOptions: err = %v, want %v", err, tt.wantErr)
+			}
+			_, err = EncryptDirs(privKey, keyMap, []string{dir}, WithOptions(tt.opts))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("EncryptDirs: err = %v, want %v", err, tt.wantErr)
+			}
+			_, err = DecryptDirs(privKey, keyMap, []string{dir}, WithOptions(tt.opts))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("DecryptDirs: err = %v, want %v", err, tt.wantErr)
+			}
+			_, err = VerifyDecryptable(privKey, keyMap, []string{dir}, WithOptions(tt.opts))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyDecryptable: err = %v, want %v", err, tt.wantErr)
+			}
+
+			// nothing was touched
+			assertTree(t, dir, spec)
+		})
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	_, err := optionsFromConfig(&config.Config{RSAKey: testutil.NewPrivateKey(t), StrictCrypto: true, HashAlgo: "md5"})
+	if !errors.Is(err, ErrWeakCrypto) {
+		t.Errorf("optionsFromConfig md5: err = %v, want ErrWeakCrypto", err)
+	}
+
+	_, err = optionsFromConfig(&config.Config{RSAKey: testutil.NewPrivateKey(t), StrictCrypto: true, HashAlgo: "sha512"})
+	if err != nil {
+		t.Errorf("optionsFromConfig sha512: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestStrictDecrypt(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{
+		"a.txt":     []byte("hello"),
+		"sub/b.txt": []byte("world"),
+		"plain.txt": []byte("should have been encrypted"),
+		"c.md":      []byte("no key, not expected to be encrypted"),
+	}
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": spec["a.txt"], "sub/b.txt": spec["sub/b.txt"], "c.md": spec["c.md"]})
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "plain.txt"), spec["plain.txt"], 0644)
+	if err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+
+	report, err := DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{StrictDecrypt: true})
+	if !errors.Is(err, ErrNotEncrypted) {
+		t.Fatalf("DecryptWithOptions: err = %v, want ErrNotEncrypted", err)
+	}
+	if !strings.Contains(err.Error(), "plain.txt") {
+		t.Errorf("DecryptWithOptions: err = %v, want it to name plain.txt", err)
+	}
+	if report.Processed != 2 || report.Failed != 1 {
+		t.Errorf("DecryptWithOptions: processed = %d, failed = %d, want 2 and 1", report.Processed, report.Failed)
+	}
+
+	// the encrypted files are still decrypted around it
+	assertTree(t, dir, spec)
+}
+
+func TestStrictDecryptOff(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"plain.txt": []byte("never encrypted")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	report, err := DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	if report.Processed != 0 || report.Failed != 0 {
+		t.Errorf("DecryptWithOptions: processed = %d, failed = %d, want 0 and 0", report.Processed, report.Failed)
+	}
+	assertTree(t, dir, spec)
+}
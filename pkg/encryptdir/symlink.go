@@ -0,0 +1,28 @@
+package encryptdir
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// encryptdir.isSymlink: if the entry described by `info` is a symlink, cwalk reports links without following them
+func isSymlink(info os.FileInfo) bool {
+	return info.Mode()&os.ModeSymlink != 0
+}
+
+// encryptdir.followLink: the path and info of the file the link at `path` points to, following every link on the way
+// the target can be outside the walked tree, it is read and replaced there while the link stays as is
+// returns: target path and info, or error if the link is broken
+func followLink(path string) (string, os.FileInfo, error) {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("encryptdir.followLink: filepath.EvalSymlinks: %w", err)
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return "", nil, fmt.Errorf("encryptdir.followLink: os.Stat: %w", err)
+	}
+	return target, info, nil
+}
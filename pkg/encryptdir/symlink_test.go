@@ -0,0 +1,94 @@
+package encryptdir
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+// linkedTree: a tree with a link to a file outside of it and a broken link, and the dir the link points into
+// returns: the tree and the outside dir
+func linkedTree(t *testing.T) (string, string) {
+	t.Helper()
+
+	outside, _ := testutil.BuildTree(t, map[string][]byte{"target.txt": []byte("outside the tree")})
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello")})
+	for name, target := range map[string]string{"link.txt": filepath.Join(outside, "target.txt"), "broken.txt": filepath.Join(outside, "missing.txt")} {
+		err := os.Symlink(target, filepath.Join(dir, name))
+		if err != nil {
+			t.Skipf("os.Symlink: %v", err)
+		}
+	}
+	return dir, outside
+}
+
+// assertLinks: fails `t` unless the links of `linkedTree` in `dir` are still links
+func assertLinks(t *testing.T, dir string) {
+	t.Helper()
+
+	for _, name := range []string{"link.txt", "broken.txt"} {
+		info, err := os.Lstat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("os.Lstat: %v", err)
+		}
+		if !isSymlink(info) {
+			t.Errorf("path = %q: replaced by a regular file", name)
+		}
+	}
+}
+
+func TestSymlinksSkipped(t *testing.T) {
+	dir, outside := linkedTree(t)
+
+	report, err := EncryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), testutil.NewKeyMap("txt"), []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if report.Processed != 1 {
+		t.Errorf("EncryptWithOptions: processed = %d, want only a.txt", report.Processed)
+	}
+
+	// not a temp file next to the target either
+	assertTree(t, outside, map[string][]byte{"target.txt": []byte("outside the tree")})
+	assertLinks(t, dir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("os.ReadDir: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("%d entries after encrypting, want a.txt and the 2 links", len(entries))
+	}
+}
+
+func TestFollowSymlinks(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	dir, outside := linkedTree(t)
+
+	var links int
+	opts := Options{FollowSymlinks: true, Hooks: Hooks{OnRootFinish: func(_ string, stats Stats, _ error) { links = stats.Symlinks }}}
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	// the broken link has nothing to encrypt
+	if report.Processed != 2 || links != 1 {
+		t.Errorf("EncryptWithOptions: processed = %d, links = %d, want 2 and 1", report.Processed, links)
+	}
+
+	// encrypted at the target, the link stays a link
+	if got := readTree(t, outside)["target.txt"]; string(got) == "outside the tree" {
+		t.Errorf("target.txt: not encrypted through the link")
+	}
+	assertLinks(t, dir)
+
+	_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	assertTree(t, outside, map[string][]byte{"target.txt": []byte("outside the tree")})
+	assertLinks(t, dir)
+}
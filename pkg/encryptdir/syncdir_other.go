@@ -0,0 +1,8 @@
+//go:build !unix
+
+package encryptdir
+
+// encryptdir.syncDir: directories cant be synced on this platform, the rename is left to the filesystem
+func syncDir(dir string) error {
+	return nil
+}
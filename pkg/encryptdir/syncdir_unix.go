@@ -0,0 +1,23 @@
+//go:build unix
+
+package encryptdir
+
+import (
+	"fmt"
+	"os"
+)
+
+// encryptdir.syncDir: flushes the entries of the directory at `dir` to disk, so a rename in it survives a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("encryptdir.syncDir: os.Open: %w", err)
+	}
+	defer d.Close()
+
+	err = d.Sync()
+	if err != nil {
+		return fmt.Errorf("encryptdir.syncDir: d.Sync: %w", err)
+	}
+	return nil
+}
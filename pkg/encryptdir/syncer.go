@@ -0,0 +1,90 @@
+package encryptdir
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// dirSyncer: syncs the directories outputs are renamed into once every `batch` renames instead of after each one, for `Options.SyncBatch`
+// shared by every root and worker of a run, `dirSyncer.flush` syncs what is left once the run is done
+// a nil `*dirSyncer` syncs the directory after every rename, like `finalize`
+type dirSyncer struct {
+	batch int
+
+	mu sync.Mutex
+	// renames into each directory since it was last synced
+	pending map[string]int
+}
+
+// encryptdir.newDirSyncer: syncer for `opts`, nil unless `Options.SyncBatch` is more than 1
+// a journal only records a file once it is done, so with one the directory is synced after every rename and nil is returned too
+func newDirSyncer(opts Options, journal *journal) *dirSyncer {
+	if opts.SyncBatch <= 1 || journal != nil {
+		return nil
+	}
+	return &dirSyncer{batch: opts.SyncBatch, pending: make(map[string]int)}
+}
+
+// encryptdir.dirSyncer.finalize: `finalizeOpen` with the directory of `path` synced once `s` has batched enough renames into it
+// the temp file is still synced before the rename, so a crash before the directory is synced leaves the original and its temp file, not a partly written file
+// returns: error
+func (s *dirSyncer) finalize(tmp *os.File, path string) error {
+	if s == nil {
+		return finalizeOpen(tmp, path)
+	}
+
+	err := replaceOpen(tmp, path)
+	if err != nil {
+		return fmt.Errorf("encryptdir.dirSyncer.finalize: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	s.mu.Lock()
+	s.pending[dir]++
+	full := s.pending[dir] >= s.batch
+	if full {
+		delete(s.pending, dir)
+	}
+	s.mu.Unlock()
+
+	if full {
+		err = syncDir(dir)
+		if err != nil {
+			return fmt.Errorf("encryptdir.dirSyncer.finalize: %w", err)
+		}
+	}
+	return nil
+}
+
+// encryptdir.dirSyncer.flush: syncs every directory with renames that havent been synced yet
+// returns: error, joined over every directory that failed to sync
+func (s *dirSyncer) flush() error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	dirs := make([]string, 0, len(s.pending))
+	for dir := range s.pending {
+		dirs = append(dirs, dir)
+	}
+	s.pending = make(map[string]int)
+	s.mu.Unlock()
+
+	sort.Strings(dirs)
+	var errList []error
+	for _, dir := range dirs {
+		err := syncDir(dir)
+		if err != nil {
+			errList = append(errList, err)
+		}
+	}
+	if len(errList) > 0 {
+		return fmt.Errorf("encryptdir.dirSyncer.flush: %w", errors.Join(errList...))
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package encryptdir
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestSyncBatchRoundTrip(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := benchTree(20)
+	dir, _ := testutil.BuildTree(t, spec)
+	opts := Options{SyncBatch: 3}
+
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if report.Processed != len(spec) {
+		t.Errorf("EncryptWithOptions: processed = %d, want %d", report.Processed, len(spec))
+	}
+
+	_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	assertTree(t, dir, spec)
+}
+
+func TestDirSyncerBatches(t *testing.T) {
+	dir := t.TempDir()
+	s := newDirSyncer(Options{SyncBatch: 3}, nil)
+
+	// every rename goes through, the directory is only synced once 3 have piled up
+	for i := 0; i < 4; i++ {
+		tmp, err := os.OpenFile(filepath.Join(dir, fmt.Sprintf("f%d.tmp", i)), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			t.Fatalf("os.OpenFile: %v", err)
+		}
+		_, err = tmp.WriteString("hello")
+		if err != nil {
+			t.Fatalf("tmp.WriteString: %v", err)
+		}
+
+		err = s.finalize(tmp, filepath.Join(dir, fmt.Sprintf("f%d", i)))
+		tmp.Close()
+		if err != nil {
+			t.Fatalf("dirSyncer.finalize: %v", err)
+		}
+	}
+	if s.pending[dir] != 1 {
+		t.Errorf("dirSyncer.finalize: pending = %d, want 1", s.pending[dir])
+	}
+
+	err := s.flush()
+	if err != nil || len(s.pending) != 0 {
+		t.Errorf("dirSyncer.flush: pending = %d, %v, want none", len(s.pending), err)
+	}
+	assertTree(t, dir, map[string][]byte{"f0": []byte("hello"), "f1": []byte("hello"), "f2": []byte("hello"), "f3": []byte("hello")})
+
+	// syncing every file doesnt batch, neither does a run with a journal
+	if newDirSyncer(Options{SyncBatch: 1}, nil) != nil || newDirSyncer(Options{SyncBatch: 3}, &journal{}) != nil {
+		t.Errorf("newDirSyncer: want nil for a batch of 1 and with a journal")
+	}
+}
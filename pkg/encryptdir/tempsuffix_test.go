@@ -0,0 +1,137 @@
+package encryptdir
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+// staleNamer: the namer of `opts` as another process would have named its temp files
+func staleNamer(opts Options) Namer {
+	namer := opts.namer()
+	if n, ok := namer.(SuffixNamer); ok && n.PID != 0 {
+		n.PID = os.Getpid() + 1
+		return n
+	}
+	return namer
+}
+
+// writeStale: writes `stale` into `dir`, and returns the tree `dir` should be with them
+func writeStale(t *testing.T, dir string, tree map[string][]byte, stale map[string][]byte) map[string][]byte {
+	t.Helper()
+	want := make(map[string][]byte, len(tree)+len(stale))
+	for rel, contents := range tree {
+		want[rel] = contents
+	}
+	for rel, contents := range stale {
+		err := os.WriteFile(filepath.Join(dir, filepath.FromSlash(rel)), contents, 0644)
+		if err != nil {
+			t.Fatalf("os.WriteFile: %v", err)
+		}
+		want[rel] = contents
+	}
+	return want
+}
+
+func TestStaleTempFilesIgnored(t *testing.T) {
+	for name, opts := range map[string]Options{
+		"default": {},
+		// ending in an extension with a key, so only the suffix tells them apart
+		"custom": {EncSuffix: ".partial-enc.txt", DecSuffix: ".partial-dec.txt"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			privKey := testutil.NewPrivateKey(t)
+			// every file has a key, so would the temp files if they werent recognized
+			keyMap := testutil.NewKeyMap("txt", FallbackExt)
+			spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world")}
+			dir, _ := testutil.BuildTree(t, spec)
+			namer := staleNamer(opts)
+
+			_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+			if err != nil {
+				t.Fatalf("EncryptWithOptions: %v", err)
+			}
+			encrypted := readTree(t, dir)
+
+			// left by a killed encrypt run, a full copy of the ciphertext and one cut short
+			want := writeStale(t, dir, encrypted, map[string][]byte{
+				namer.TempName("a.txt", false):     encrypted["a.txt"],
+				namer.TempName("sub/b.txt", false): encrypted["sub/b.txt"][:FileHeaderSize],
+			})
+
+			report, err := DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+			if err != nil {
+				t.Fatalf("DecryptWithOptions: %v", err)
+			}
+			if report.Processed != len(spec) || report.Failed != 0 {
+				t.Errorf("DecryptWithOptions: processed = %d, failed = %d, want %d and 0", report.Processed, report.Failed, len(spec))
+			}
+			for rel, contents := range spec {
+				want[rel] = contents
+			}
+			assertTree(t, dir, want)
+
+			// without a pid in their names a stale encrypt temp file holds its file back until `Cleanup` removes it
+			for _, rel := range []string{namer.TempName("a.txt", false), namer.TempName("sub/b.txt", false)} {
+				err = os.Remove(filepath.Join(dir, filepath.FromSlash(rel)))
+				if err != nil {
+					t.Fatalf("os.Remove: %v", err)
+				}
+			}
+
+			// left by a killed decrypt run, plaintext that must not be encrypted as a file of its own
+			want = writeStale(t, dir, spec, map[string][]byte{
+				namer.TempName("a.txt", true): spec["a.txt"],
+			})
+
+			report, err = EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+			if err != nil {
+				t.Fatalf("EncryptWithOptions: %v", err)
+			}
+			if report.Processed != len(spec) || report.Failed != 0 {
+				t.Errorf("EncryptWithOptions: processed = %d, failed = %d, want %d and 0", report.Processed, report.Failed, len(spec))
+			}
+			got := readTree(t, dir)
+			for rel, contents := range want {
+				if _, ok := spec[rel]; ok {
+					continue
+				}
+				if string(got[rel]) != string(contents) {
+					t.Errorf("path = %q: stale temp file changed by encrypting", rel)
+				}
+			}
+		})
+	}
+}
+
+func TestRealEncFilesProcessed(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt", "enc")
+	// `a.txt.enc` is where the temp file of a.txt used to go
+	spec := map[string][]byte{"a.txt": []byte("hello"), "a.txt.enc": []byte("a blob of another tool"), "b.dec": []byte("no key")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if report.Processed != 2 {
+		t.Errorf("EncryptWithOptions: processed = %d, want a.txt and a.txt.enc", report.Processed)
+	}
+	// encrypted in place, no temp file of either is left
+	if tree := readTree(t, dir); len(tree) != len(spec) {
+		t.Errorf("EncryptWithOptions: %d files, want %d", len(tree), len(spec))
+	}
+
+	report, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	if report.Processed != 2 {
+		t.Errorf("DecryptWithOptions: processed = %d, want 2", report.Processed)
+	}
+	assertTree(t, dir, spec)
+}
@@ -0,0 +1,204 @@
+package encryptdir
+
+import (
+	"bufio"
+	"bytes"
+	gorsa "crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// TreeManifestEntry: a single line of a tree manifest, the hash and size of a file as it is on disk, ciphertext for an encrypted file
+type TreeManifestEntry struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// VerifyReport: how a tree changed since its manifest was written, paths are like the manifest has them
+type VerifyReport struct {
+	// files that arent in the manifest
+	Added []string
+	// files in the manifest that are gone
+	Removed []string
+	// files whose hash or size differs from the manifest
+	Modified []string
+}
+
+// encryptdir.VerifyReport.Unchanged: if the tree is exactly what the manifest recorded
+func (v VerifyReport) Unchanged() bool {
+	return len(v.Added) == 0 && len(v.Removed) == 0 && len(v.Modified) == 0
+}
+
+// encryptdir.WriteTreeManifest: writes a JSON line of `TreeManifestEntry` for every file under `dirs` to `manifestPath`, signed with `signKey` like `SignDetached`
+// files are keyed by their path joined onto the dir they were found under, so `VerifyAgainstManifest` needs the same `dirs`
+// the manifest and its signature are left out when they are inside one of `dirs`
+// returns: error
+func WriteTreeManifest(signKey *gorsa.PrivateKey, dirs []string, manifestPath string) error {
+	files, err := treeFiles(dirs, manifestPath)
+	if err != nil {
+		return fmt.Errorf("encryptdir.WriteTreeManifest: %w", err)
+	}
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, path := range paths {
+		entry, err := hashTreeFile(path, files[path])
+		if err != nil {
+			return fmt.Errorf("encryptdir.WriteTreeManifest: %w", err)
+		}
+		err = enc.Encode(entry)
+		if err != nil {
+			return fmt.Errorf("encryptdir.WriteTreeManifest: json.Encoder.Encode: %w", err)
+		}
+	}
+
+	err = os.WriteFile(manifestPath, buf.Bytes(), 0644)
+	if err != nil {
+		return fmt.Errorf("encryptdir.WriteTreeManifest: os.WriteFile: %w", err)
+	}
+
+	// a signature left from an earlier manifest at the same path is replaced
+	err = os.Remove(manifestPath + DetachedSigSuffix)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("encryptdir.WriteTreeManifest: os.Remove: %w", err)
+	}
+	err = SignDetached(signKey, manifestPath)
+	if err != nil {
+		return fmt.Errorf("encryptdir.WriteTreeManifest: %w", err)
+	}
+	return nil
+}
+
+// encryptdir.VerifyAgainstManifest: checks the files under `dirs` against the manifest `WriteTreeManifest` wrote at `manifestPath` for the same `dirs`
+// the manifest has to verify against its detached signature with `pubKey` before anything is compared
+// returns: report of the added, removed and modified files, or error wrapping `ErrDetachedSig`
+func VerifyAgainstManifest(pubKey *gorsa.PublicKey, manifestPath string, dirs []string) (VerifyReport, error) {
+	err := verifyDetached(pubKey, manifestPath)
+	if err != nil {
+		return VerifyReport{}, fmt.Errorf("encryptdir.VerifyAgainstManifest: %w", err)
+	}
+
+	entries, err := readTreeManifest(manifestPath)
+	if err != nil {
+		return VerifyReport{}, fmt.Errorf("encryptdir.VerifyAgainstManifest: %w", err)
+	}
+
+	files, err := treeFiles(dirs, manifestPath)
+	if err != nil {
+		return VerifyReport{}, fmt.Errorf("encryptdir.VerifyAgainstManifest: %w", err)
+	}
+
+	var report VerifyReport
+	for path, fullPath := range files {
+		want, ok := entries[path]
+		if !ok {
+			report.Added = append(report.Added, path)
+			continue
+		}
+
+		got, err := hashTreeFile(path, fullPath)
+		if err != nil {
+			return VerifyReport{}, fmt.Errorf("encryptdir.VerifyAgainstManifest: %w", err)
+		}
+		if got != want {
+			report.Modified = append(report.Modified, path)
+		}
+	}
+
+	for path := range entries {
+		_, ok := files[path]
+		if !ok {
+			report.Removed = append(report.Removed, path)
+		}
+	}
+
+	sort.Strings(report.Added)
+	sort.Strings(report.Removed)
+	sort.Strings(report.Modified)
+	return report, nil
+}
+
+// encryptdir.treeFiles: the regular files under `dirs` by their manifest path, slash separated and joined onto their dir, leaving out the manifest at `manifestPath` and its signature
+// returns: manifest paths to the paths on disk, or error
+func treeFiles(dirs []string, manifestPath string) (map[string]string, error) {
+	absManifest, err := filepath.Abs(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.treeFiles: filepath.Abs: %w", err)
+	}
+
+	files := make(map[string]string)
+	for _, dir := range dirs {
+		rels, err := listFiles(dir)
+		if err != nil {
+			return nil, fmt.Errorf("encryptdir.treeFiles: %w", err)
+		}
+
+		for rel := range rels {
+			fullPath := filepath.Join(dir, rel)
+			abs, err := filepath.Abs(fullPath)
+			if err != nil {
+				return nil, fmt.Errorf("encryptdir.treeFiles: filepath.Abs: %w", err)
+			}
+			if abs == absManifest || abs == absManifest+DetachedSigSuffix {
+				continue
+			}
+			files[filepath.ToSlash(fullPath)] = fullPath
+		}
+	}
+	return files, nil
+}
+
+// encryptdir.hashTreeFile: the entry for the file at `fullPath` as `path`, hashed a chunk at a time so big files arent held in memory
+// returns: entry or error
+func hashTreeFile(path string, fullPath string) (TreeManifestEntry, error) {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return TreeManifestEntry{}, fmt.Errorf("encryptdir.hashTreeFile: os.Open: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return TreeManifestEntry{}, fmt.Errorf("encryptdir.hashTreeFile: io.Copy: path = %q: %w", fullPath, err)
+	}
+	return TreeManifestEntry{Path: path, SHA256: hex.EncodeToString(h.Sum(nil)), Size: size}, nil
+}
+
+// encryptdir.readTreeManifest: reads the JSON lines `WriteTreeManifest` wrote at `path`, a later line for the same path wins
+// returns: entries by path or error
+func readTreeManifest(path string) (map[string]TreeManifestEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.readTreeManifest: os.Open: %w", err)
+	}
+	defer f.Close()
+
+	entries := make(map[string]TreeManifestEntry)
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var entry TreeManifestEntry
+		err := dec.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("encryptdir.readTreeManifest: json.Decoder.Decode: %w", err)
+		}
+		entries[entry.Path] = entry
+	}
+}
@@ -0,0 +1,104 @@
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestVerifyAgainstManifest(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "b.txt": []byte("world"), "sub/c.txt": []byte("again")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+
+	manifestPath := filepath.Join(t.TempDir(), "manifest.jsonl")
+	err = WriteTreeManifest(privKey, []string{dir}, manifestPath)
+	if err != nil {
+		t.Fatalf("WriteTreeManifest: %v", err)
+	}
+
+	report, err := VerifyAgainstManifest(&privKey.PublicKey, manifestPath, []string{dir})
+	if err != nil {
+		t.Fatalf("VerifyAgainstManifest: %v", err)
+	}
+	if !report.Unchanged() {
+		t.Errorf("VerifyAgainstManifest: unchanged tree: report = %+v", report)
+	}
+
+	// one file of each kind of change, the modified one keeps its size
+	err = os.WriteFile(filepath.Join(dir, "new.txt"), []byte("added"), 0600)
+	if err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	err = os.Remove(filepath.Join(dir, "b.txt"))
+	if err != nil {
+		t.Fatalf("os.Remove: %v", err)
+	}
+	modified := filepath.Join(dir, "sub", "c.txt")
+	info, err := os.Stat(modified)
+	if err != nil {
+		t.Fatalf("os.Stat: %v", err)
+	}
+	flipByte(t, modified, int(info.Size()-1), 0x01)
+
+	report, err = VerifyAgainstManifest(&privKey.PublicKey, manifestPath, []string{dir})
+	if err != nil {
+		t.Fatalf("VerifyAgainstManifest: %v", err)
+	}
+	path := func(rel string) string { return filepath.ToSlash(filepath.Join(dir, rel)) }
+	want := VerifyReport{
+		Added:    []string{path("new.txt")},
+		Removed:  []string{path("b.txt")},
+		Modified: []string{path("sub/c.txt")},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("VerifyAgainstManifest: report = %+v, want %+v", report, want)
+	}
+}
+
+func TestVerifyAgainstManifestTampered(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello")})
+
+	// the manifest inside the tree leaves itself and its signature out
+	manifestPath := filepath.Join(dir, "manifest.jsonl")
+	err := WriteTreeManifest(privKey, []string{dir}, manifestPath)
+	if err != nil {
+		t.Fatalf("WriteTreeManifest: %v", err)
+	}
+	report, err := VerifyAgainstManifest(&privKey.PublicKey, manifestPath, []string{dir})
+	if err != nil {
+		t.Fatalf("VerifyAgainstManifest: %v", err)
+	}
+	if !report.Unchanged() {
+		t.Errorf("VerifyAgainstManifest: report = %+v, want unchanged", report)
+	}
+
+	// a manifest edited after it was signed no longer verifies
+	flipByte(t, manifestPath, 0, 0x01)
+	_, err = VerifyAgainstManifest(&privKey.PublicKey, manifestPath, []string{dir})
+	if !errors.Is(err, ErrDetachedSig) {
+		t.Errorf("VerifyAgainstManifest: err = %v, want %v", err, ErrDetachedSig)
+	}
+
+	// writing it again replaces the old signature
+	err = WriteTreeManifest(privKey, []string{dir}, manifestPath)
+	if err != nil {
+		t.Fatalf("WriteTreeManifest: again: %v", err)
+	}
+	_, err = VerifyAgainstManifest(&privKey.PublicKey, manifestPath, []string{dir})
+	if err != nil {
+		t.Errorf("VerifyAgainstManifest: rewritten: %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package encryptdir
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// the most bytes the metadata of a file header can take up, as a JSON object
+const MaxMetadataSize = 4096
+
+// metadata keys starting with it are what encryptdir itself records about a file, like whether it had a BOM, `Options.Metadata` cant have them
+const ReservedMetadataPrefix = "encryptdir."
+
+// sentinel error used for when `Options.Metadata` takes up more than `MaxMetadataSize` bytes
+var ErrMetadataTooLarge = errors.New("metadata too large")
+
+// sentinel error used for when a key of `Options.Metadata` starts with `ReservedMetadataPrefix`
+var ErrReservedMetadata = errors.New("metadata key is reserved")
+
+// encryptdir.Options.checkMetadata: checks `Options.Metadata` has no reserved keys, and fits in a file header with whatever encryptdir adds to it
+// returns: error wrapping `ErrReservedMetadata` or `ErrMetadataTooLarge`
+func (o Options) checkMetadata() error {
+	for k := range o.Metadata {
+		if strings.HasPrefix(k, ReservedMetadataPrefix) {
+			return fmt.Errorf("encryptdir.Options.checkMetadata: key = %q: %w", k, ErrReservedMetadata)
+		}
+	}
+
+	// the escrow slot is as long for every key, so a stand in of its length is enough
+	var escrow string
+	if o.EscrowKey != nil {
+		escrow = strings.Repeat("A", escrowSlotLen(o.EscrowKey))
+	}
+	metadata := o.fileMetadata(len(o.TextExtensions) > 0, escrow)
+	if len(metadata) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("encryptdir.Options.checkMetadata: json.Marshal: %w", err)
+	}
+	if len(b) > MaxMetadataSize {
+		return fmt.Errorf("encryptdir.Options.checkMetadata: size = %d, max = %d: %w", len(b), MaxMetadataSize, ErrMetadataTooLarge)
+	}
+	return nil
+}
+
+// encryptdir.Options.fileMetadata: the metadata of the file header of a file, `Options.Metadata` with the BOM flag if `bom`, the expiry of `Options.ExpiresAt`,
+// and the escrow slot `escrow` unless it is empty
+// returns: metadata, `Options.Metadata` itself without anything reserved to add, a copy with it
+func (o Options) fileMetadata(bom bool, escrow string) map[string]string {
+	if !bom && o.ExpiresAt.IsZero() && len(escrow) == 0 {
+		return o.Metadata
+	}
+	metadata := make(map[string]string, len(o.Metadata)+3)
+	for k, v := range o.Metadata {
+		metadata[k] = v
+	}
+	if bom {
+		metadata[metadataBOM] = "1"
+	}
+	if !o.ExpiresAt.IsZero() {
+		metadata[metadataExpires] = o.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	if len(escrow) > 0 {
+		metadata[metadataEscrow] = escrow
+	}
+	return metadata
+}
+
+// encryptdir.readMetadata: `prefix`, read from `in` up to where `in` is now, with as many more bytes read onto it as the metadata its file header says follows
+// so it reaches as far past the metadata as it would have reached into a file without any, a short file is left short for the header to fail parsing
+// `prefix` has to start at the file header and hold at least its fixed part for there to be any metadata
+// returns: prefix with the metadata, or error
+func readMetadata(in io.Reader, prefix []byte) ([]byte, error) {
+	metaLen := metadataLen(prefix)
+	if metaLen == 0 || metaLen > MaxMetadataSize {
+		return prefix, nil
+	}
+
+	metadata := make([]byte, metaLen)
+	n, err := io.ReadFull(in, metadata)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("encryptdir.readMetadata: io.ReadFull: %w", err)
+	}
+	return append(prefix, metadata[:n]...), nil
+}
+
+// encryptdir.ReadMetadata: the metadata the file at `path` was encrypted with by `Options.Metadata`, from its header alone without any keys
+// like `ReadHeader` nothing is verified, unless the file signed its header it can be changed without decrypting failing
+// the keys encryptdir recorded itself, starting with `ReservedMetadataPrefix`, are left out, `Header.Metadata` has them
+// returns: metadata, nil for a file without any, or error like `ReadHeader`
+func ReadMetadata(path string) (map[string]string, error) {
+	header, err := ReadHeader(path)
+	if err != nil {
+		return nil, fmt.Errorf("encryptdir.ReadMetadata: %w", err)
+	}
+
+	var metadata map[string]string
+	for k, v := range header.Metadata {
+		if strings.HasPrefix(k, ReservedMetadataPrefix) {
+			continue
+		}
+		if metadata == nil {
+			metadata = make(map[string]string, len(header.Metadata))
+		}
+		metadata[k] = v
+	}
+	return metadata, nil
+}
@@ -0,0 +1,124 @@
+package encryptdir
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestMetadataRoundTrip(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte(strings.Repeat("world", 1000))}
+	metadata := map[string]string{"owner": "alice", "classification": "secret"}
+
+	for _, opts := range []Options{{Metadata: metadata}, {Metadata: metadata, StreamThreshold: -1}, {Metadata: metadata, SignHeader: true}} {
+		dir, _ := testutil.BuildTree(t, spec)
+		_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+		if err != nil {
+			t.Fatalf("EncryptWithOptions: %v", err)
+		}
+
+		// read from the header alone, before anything is decrypted
+		for name := range spec {
+			got, err := ReadMetadata(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatalf("ReadMetadata: %v", err)
+			}
+			if !reflect.DeepEqual(got, metadata) {
+				t.Errorf("ReadMetadata(%s) = %v, want %v", name, got, metadata)
+			}
+		}
+
+		header, err := ReadHeader(filepath.Join(dir, "a.txt"))
+		if err != nil {
+			t.Fatalf("ReadHeader: %v", err)
+		}
+		if header.Version != FormatVersion || header.PlaintextSize == 0 {
+			t.Errorf("ReadHeader: version = %d, plaintext size = %d, want %d and the size past the metadata", header.Version, header.PlaintextSize, FormatVersion)
+		}
+
+		// the ciphertext is found past the metadata
+		f, err := os.Open(filepath.Join(dir, "sub/b.txt"))
+		if err != nil {
+			t.Fatalf("os.Open: %v", err)
+		}
+		info, err := f.Stat()
+		if err != nil {
+			t.Fatalf("f.Stat: %v", err)
+		}
+		ra, err := NewRandomReader(privKey, keyMap["txt"], f, info.Size())
+		if err != nil {
+			t.Fatalf("NewRandomReader: %v", err)
+		}
+		part := make([]byte, 10)
+		_, err = ra.ReadAt(part, 2000)
+		f.Close()
+		if err != nil || !bytes.Equal(part, spec["sub/b.txt"][2000:2010]) {
+			t.Errorf("ReadAt = %q, %v, want %q", part, err, spec["sub/b.txt"][2000:2010])
+		}
+
+		_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+		if err != nil {
+			t.Fatalf("DecryptWithOptions: %v", err)
+		}
+		assertTree(t, dir, spec)
+	}
+}
+
+func TestMetadataNone(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	dir := encryptSigned(t, map[string][]byte{"a.txt": []byte("hello")}, keyMap, false)
+
+	got, err := ReadMetadata(filepath.Join(dir, "a.txt"))
+	if err != nil || got != nil {
+		t.Errorf("ReadMetadata = %v, %v, want nil", got, err)
+	}
+}
+
+func TestMetadataTooLarge(t *testing.T) {
+	keyMap := testutil.NewKeyMap("txt")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello")})
+
+	opts := Options{Metadata: map[string]string{"notes": strings.Repeat("a", MaxMetadataSize)}}
+	_, err := EncryptWithOptions(context.Background(), nil, testutil.NewPrivateKey(t), keyMap, []string{dir}, opts)
+	if !errors.Is(err, ErrMetadataTooLarge) {
+		t.Fatalf("EncryptWithOptions: err = %v, want ErrMetadataTooLarge", err)
+	}
+	assertTree(t, dir, map[string][]byte{"a.txt": []byte("hello")})
+}
+
+func TestMetadataTampered(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello")})
+
+	opts := Options{Metadata: map[string]string{"owner": "alice"}, SignHeader: true}
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+
+	path := filepath.Join(dir, "a.txt")
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("os.ReadFile: %v", err)
+	}
+	err = os.WriteFile(path, []byte(strings.Replace(string(contents), "alice", "mallo", 1)), 0600)
+	if err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+
+	// the signed header covers the metadata too
+	_, err = decryptBytes(t, keyMap, path, Options{})
+	if !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("decryptTo: err = %v, want ErrNotEncrypted", err)
+	}
+}
@@ -0,0 +1,126 @@
+package encryptdir
+
+import (
+	gorsa "crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/prairir/encryptdir/pkg/aes"
+)
+
+// sentinel error used for when `VerifyDecryptable` finds files that fail to decrypt
+var ErrNotDecryptable = errors.New("files failed to decrypt")
+
+// sentinel error used for when `Options.VerifyAfterWrite` reads back an encrypted file that doesnt decrypt to the original
+var ErrVerifyFailed = errors.New("encrypted file doesn't decrypt to the original")
+
+// encryptdir.verifyWritten: reads back the file `encryptWalk` wrote, still open as `in`, from its start and checks it decrypts with `key` to plaintext with the hash and size `want` has
+// the payload is decrypted a chunk at a time into a hash, so big files arent held in memory
+// returns: error wrapping `ErrVerifyFailed`
+func verifyWritten(pubKey *gorsa.PublicKey, key []byte, in *os.File, banner []byte, opts Options, want *contentHash) error {
+	path := in.Name()
+	_, err := in.Seek(0, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("encryptdir.verifyWritten: in.Seek: %w", err)
+	}
+
+	info, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("encryptdir.verifyWritten: in.Stat: %w", err)
+	}
+
+	err = skipBanner(in, banner)
+	if err != nil {
+		return fmt.Errorf("encryptdir.verifyWritten: %w", err)
+	}
+
+	header, err := readFileHeader(in)
+	if err != nil {
+		return fmt.Errorf("encryptdir.verifyWritten: %w", err)
+	}
+	if header == nil {
+		return fmt.Errorf("encryptdir.verifyWritten: path = %q, no file header: %w", path, ErrVerifyFailed)
+	}
+
+	sig := make([]byte, signatureSize(pubKey))
+	_, err = io.ReadFull(in, sig)
+	if err != nil {
+		return fmt.Errorf("encryptdir.verifyWritten: path = %q, io.ReadFull: %v: %w", path, err, ErrVerifyFailed)
+	}
+	err = verifyKey(pubKey, sig, key, header, opts)
+	if err != nil {
+		return fmt.Errorf("encryptdir.verifyWritten: path = %q, signature: %v: %w", path, err, ErrVerifyFailed)
+	}
+
+	offset, err := in.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("encryptdir.verifyWritten: in.Seek: %w", err)
+	}
+
+	got := newContentHash(true)
+	dst, finish := decompressWriter(header, got)
+	err = finish(aes.DecryptGCMStream(key, in, info.Size()-offset, dst))
+	if err != nil {
+		return fmt.Errorf("encryptdir.verifyWritten: path = %q: %v: %w", path, err, ErrVerifyFailed)
+	}
+
+	gotSum, gotSize := got.sum()
+	wantSum, wantSize := want.sum()
+	if gotSum != wantSum || gotSize != wantSize {
+		return fmt.Errorf("encryptdir.verifyWritten: path = %q, sha256 = %s, want %s: %w", path, gotSum, wantSum, ErrVerifyFailed)
+	}
+	return nil
+}
+
+// encryptdir.VerifyDecryptable: decrypts every encrypted file in `dirs` and throws the plaintext away, nothing is written
+// version 2 files are authenticated, so this catches any modified byte, version 1 AES-CTR files only a signature from the wrong key and sizes that dont add up
+// files are read like decrypting them would, with the `Options.Banner`, `Options.Keyring`, `Options.Passphrases`, and `Options.TrustKey` of `opts` and the key for the extension their file header recorded,
+// and streamed a chunk at a time into `io.Discard` instead of read into memory
+// files that aren't encrypted are skipped, ones with the magic and a file header that doesnt parse fail
+// returns: report of every candidate file, and error wrapping `ErrNotDecryptable` if any failed
+func VerifyDecryptable(privKey *gorsa.PrivateKey, keyMap map[string][]byte, dirs []string, opts ...Option) (Report, error) {
+	wo := NewWalkOptions(opts...)
+	err := wo.Options.validate(privKey)
+	if err != nil {
+		return Report{}, fmt.Errorf("encryptdir.VerifyDecryptable: %w", err)
+	}
+
+	keyMap, derived, err := wo.Options.deriveKeys(keyMap)
+	if err != nil {
+		return Report{}, fmt.Errorf("encryptdir.VerifyDecryptable: %w", err)
+	}
+
+	var report Report
+	err = walkCandidates(keyMap, dirs, func(path string, _ os.FileInfo) error {
+		err := decryptTo(privKey, keyMap, path, io.Discard, wo.Options, derived)
+		switch {
+		case errors.Is(err, ErrNotEncrypted) || errors.Is(err, ErrKeyNotFound):
+			report.add(path, FileSkipped, nil)
+		case err != nil:
+			report.add(path, FileFailed, err)
+		default:
+			report.add(path, FileProcessed, nil)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("encryptdir.VerifyDecryptable: %w", err)
+	}
+
+	if report.Failed > 0 {
+		return report, fmt.Errorf("encryptdir.VerifyDecryptable: %d of %d: %w", report.Failed, len(report.Files), ErrNotDecryptable)
+	}
+	return report, nil
+}
+
+// encryptdir.VerifyIntegrity: `VerifyDecryptable` for periodic audits, every file that fails to decrypt or authenticate is flagged in the report
+// returns: report, also on error, with `FileFailed` and the error of every bad file, and error wrapping `ErrNotDecryptable` if any failed
+func VerifyIntegrity(privKey *gorsa.PrivateKey, keyMap map[string][]byte, dirs []string, opts ...Option) (*Report, error) {
+	report, err := VerifyDecryptable(privKey, keyMap, dirs, opts...)
+	if err != nil {
+		return &report, fmt.Errorf("encryptdir.VerifyIntegrity: %w", err)
+	}
+	return &report, nil
+}
@@ -0,0 +1,229 @@
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/aes"
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+// tamper: flips the last byte of the file at `path`, which is in its last AES-GCM chunk
+func tamper(t testing.TB, path string) {
+	t.Helper()
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("os.ReadFile: %v", err)
+	}
+	contents[len(contents)-1] ^= 1
+	err = os.WriteFile(path, contents, 0600)
+	if err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+}
+
+func TestVerifyIntegrity(t *testing.T) {
+	for _, bits := range keySizes {
+		for name, opts := range map[string]Options{
+			"default":  {},
+			"banner":   {Banner: "encrypted by encryptdir"},
+			"streamed": {StreamThreshold: -1},
+		} {
+			t.Run(fmt.Sprintf("%d/%s", bits, name), func(t *testing.T) {
+				privKey := testutil.NewPrivateKeyBits(t, bits)
+				keyMap := testutil.NewKeyMap("txt")
+				dir, _ := testutil.BuildTree(t, map[string][]byte{
+					"good.txt":     []byte("good"),
+					"tampered.txt": []byte("tampered"),
+				})
+				_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+				if err != nil {
+					t.Fatalf("EncryptWithOptions: %v", err)
+				}
+
+				report, err := VerifyIntegrity(privKey, keyMap, []string{dir}, WithOptions(opts))
+				if err != nil || report.Processed != 2 {
+					t.Fatalf("VerifyIntegrity of an intact tree: processed = %d, error = %v, want 2 and nil", report.Processed, err)
+				}
+
+				tamper(t, filepath.Join(dir, "tampered.txt"))
+				err = os.WriteFile(filepath.Join(dir, "plain.txt"), []byte("never encrypted"), 0600)
+				if err != nil {
+					t.Fatalf("os.WriteFile: %v", err)
+				}
+				before := readTree(t, dir)
+
+				report, err = VerifyIntegrity(privKey, keyMap, []string{dir}, WithOptions(opts))
+				if !errors.Is(err, ErrNotDecryptable) {
+					t.Fatalf("VerifyIntegrity: error = %v, want `ErrNotDecryptable`", err)
+				}
+				if report.Processed != 1 || report.Failed != 1 || report.Skipped != 1 {
+					t.Errorf("processed = %d, failed = %d, skipped = %d, want 1 of each", report.Processed, report.Failed, report.Skipped)
+				}
+				for _, f := range report.Files {
+					want := map[string]FileStatus{"good.txt": FileProcessed, "tampered.txt": FileFailed, "plain.txt": FileSkipped}[filepath.Base(f.Path)]
+					if f.Status != want {
+						t.Errorf("path = %q: status = %v, want %v", f.Path, f.Status, want)
+					}
+					if f.Status == FileFailed && !errors.Is(f.Err, aes.ErrAuthFailed) {
+						t.Errorf("path = %q: error = %v, want `aes.ErrAuthFailed`", f.Path, f.Err)
+					}
+				}
+				// nothing is written
+				assertTree(t, dir, before)
+			})
+		}
+	}
+}
+
+func TestVerifyIntegrityKeys(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	oldKeys := testutil.NewKeyMap("txt")
+	sqlKeys := testutil.NewKeyMap("sql")
+	passphrases := Options{Passphrases: map[string]string{"md": "correct horse battery staple"}, KDF: aes.KDFParams{Iterations: 1000}}
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"old.txt": []byte("old key"), "a.sql": []byte("select 1;"), "p.md": []byte("passphrase")})
+
+	err := Encrypt(nil, privKey, oldKeys, []string{dir})
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	err = Encrypt(nil, privKey, sqlKeys, []string{dir})
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	_, err = EncryptDirs(privKey, nil, []string{dir}, WithOptions(passphrases))
+	if err != nil {
+		t.Fatalf("EncryptDirs: %v", err)
+	}
+
+	// checked with the key of the extension its file header recorded, not of its name
+	err = os.Rename(filepath.Join(dir, "a.sql"), filepath.Join(dir, "renamed.txt"))
+	if err != nil {
+		t.Fatalf("os.Rename: %v", err)
+	}
+
+	newKeys := map[string][]byte{"txt": testutil.NewTestKey("new txt"), "sql": sqlKeys["sql"]}
+	report, err := VerifyIntegrity(privKey, newKeys, []string{dir})
+	if err != nil {
+		t.Fatalf("VerifyIntegrity: %v", err)
+	}
+	if report.Processed != 1 || report.Skipped != 1 {
+		t.Errorf("without the keyring: processed = %d, skipped = %d, want 1 and 1", report.Processed, report.Skipped)
+	}
+
+	report, err = VerifyIntegrity(privKey, newKeys, []string{dir}, WithOptions(Options{Keyring: [][]byte{oldKeys["txt"]}, Passphrases: passphrases.Passphrases}))
+	if err != nil {
+		t.Fatalf("VerifyIntegrity: %v", err)
+	}
+	if report.Processed != 3 || report.Skipped != 0 {
+		t.Errorf("with the keyring and passphrase: processed = %d, skipped = %d, want 3 and 0", report.Processed, report.Skipped)
+	}
+}
+
+func TestVerifyDecryptable(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	big := make([]byte, 3*1024)
+	for i := range big {
+		big[i] = byte(i)
+	}
+	dir, _ := testutil.BuildTree(t, map[string][]byte{
+		"good.txt":      []byte("good"),
+		"sub/good.txt":  big,
+		"corrupted.txt": big,
+		"truncated.txt": big,
+	})
+	// chunks of 1KiB, so the corrupted byte is in the first of them and the last chunk of the truncated file is cut
+	opts := Options{StreamThreshold: -1, StreamChunkSize: 1024}
+	_, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+
+	flipByte(t, filepath.Join(dir, "corrupted.txt"), CiphertextOffset+10, 0x80)
+	truncated := filepath.Join(dir, "truncated.txt")
+	info, err := os.Stat(truncated)
+	if err != nil {
+		t.Fatalf("os.Stat: %v", err)
+	}
+	err = os.Truncate(truncated, info.Size()-100)
+	if err != nil {
+		t.Fatalf("os.Truncate: %v", err)
+	}
+	before := readTree(t, dir)
+
+	report, err := VerifyDecryptable(privKey, keyMap, []string{dir}, WithOptions(opts))
+	if !errors.Is(err, ErrNotDecryptable) {
+		t.Fatalf("VerifyDecryptable: err = %v, want ErrNotDecryptable", err)
+	}
+	if report.Processed != 2 || report.Failed != 2 {
+		t.Errorf("VerifyDecryptable: processed = %d, failed = %d, want 2 and 2", report.Processed, report.Failed)
+	}
+	for _, f := range report.Files {
+		rel, _ := filepath.Rel(dir, f.Path)
+		want := FileProcessed
+		if rel == "corrupted.txt" || rel == "truncated.txt" {
+			want = FileFailed
+		}
+		if f.Status != want {
+			t.Errorf("path = %q: status = %v, err = %v, want %v", rel, f.Status, f.Err, want)
+		}
+	}
+
+	// thrown away, nothing is written
+	assertTree(t, dir, before)
+}
+
+func TestVerifyAfterWrite(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "sub/b.txt": []byte("world")}
+
+	for name, faulty := range map[string]func(key, plain []byte) ([]byte, error){
+		// authenticates, but isnt the original
+		"wrong plaintext": func(key, plain []byte) ([]byte, error) {
+			return aes.EncryptGCM(key, append([]byte("x"), plain...))
+		},
+		"corrupt cipher": func(key, plain []byte) ([]byte, error) {
+			cipher, err := aes.EncryptGCM(key, plain)
+			cipher[len(cipher)-1] ^= 0xff
+			return cipher, err
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			encrypt := encryptGCM
+			encryptGCM = faulty
+			t.Cleanup(func() { encryptGCM = encrypt })
+			dir, _ := testutil.BuildTree(t, spec)
+
+			report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{VerifyAfterWrite: true})
+			if !errors.Is(err, ErrVerifyFailed) {
+				t.Fatalf("err = %v, want ErrVerifyFailed", err)
+			}
+			if report.Failed != len(spec) || report.Processed != 0 {
+				t.Errorf("processed = %d, failed = %d, want 0 and %d", report.Processed, report.Failed, len(spec))
+			}
+
+			// the originals are left, and no temp files
+			assertTree(t, dir, spec)
+		})
+	}
+
+	// without the fault each file verifies and is replaced
+	dir, _ := testutil.BuildTree(t, spec)
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{VerifyAfterWrite: true})
+	if err != nil || report.Processed != len(spec) {
+		t.Fatalf("EncryptWithOptions = processed %d, %v, want %d and no error", report.Processed, err, len(spec))
+	}
+	for rel, plain := range spec {
+		got, err := DecryptFileToBytes(privKey, keyMap["txt"], filepath.Join(dir, filepath.FromSlash(rel)))
+		if err != nil || string(got) != string(plain) {
+			t.Errorf("path = %q: DecryptFileToBytes = %q, %v, want %q", rel, got, err, plain)
+		}
+	}
+}
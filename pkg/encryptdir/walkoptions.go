@@ -0,0 +1,97 @@
+package encryptdir
+
+import (
+	"context"
+	"crypto"
+	gorsa "crypto/rsa"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// WalkOptions: everything `EncryptDirs` and `DecryptDirs` walk with besides the keys and dirs, built from `Option`s by `NewWalkOptions`
+type WalkOptions struct {
+	// never nil, `context.Background` by default
+	Ctx context.Context
+	// never nil, nothing is logged by default
+	Log *zap.SugaredLogger
+
+	Options
+}
+
+// Option: sets one thing of `WalkOptions`, applied in order so a later one wins
+type Option func(*WalkOptions)
+
+// encryptdir.NewWalkOptions: `WalkOptions` with `opts` applied over the defaults, a nil context or logger is put back to its default
+func NewWalkOptions(opts ...Option) WalkOptions {
+	wo := WalkOptions{}
+	for _, opt := range opts {
+		opt(&wo)
+	}
+
+	if wo.Ctx == nil {
+		wo.Ctx = context.Background()
+	}
+	if wo.Log == nil {
+		wo.Log = zap.NewNop().Sugar()
+	}
+	return wo
+}
+
+// encryptdir.WithContext: once `ctx` is canceled no new files are started, like with `EncryptContext`
+func WithContext(ctx context.Context) Option {
+	return func(wo *WalkOptions) {
+		wo.Ctx = ctx
+	}
+}
+
+// encryptdir.WithLogger: logs every file step to `log`
+func WithLogger(log *zap.SugaredLogger) Option {
+	return func(wo *WalkOptions) {
+		wo.Log = log
+	}
+}
+
+// encryptdir.WithHash: signs the AES keys with `hash`, see `Options.HashAlgo`
+func WithHash(hash crypto.Hash) Option {
+	return func(wo *WalkOptions) {
+		wo.HashAlgo = hash
+	}
+}
+
+// encryptdir.WithMaxWorkers: processes at most `n` files at once across every root, see `Options.MaxWorkers`
+func WithMaxWorkers(n int) Option {
+	return func(wo *WalkOptions) {
+		wo.MaxWorkers = n
+	}
+}
+
+// encryptdir.WithOptions: sets `Options` to `opts`, for the fields without an `Option` of their own
+// all of it is replaced, a field `opts` leaves at its zero value is reset to it, so it goes before `WithHash` and the others setting fields over it
+func WithOptions(opts Options) Option {
+	return func(wo *WalkOptions) {
+		wo.Options = opts
+	}
+}
+
+// encryptdir.EncryptDirs: `EncryptWithOptions` with the `WalkOptions` of `opts`
+// returns: report, also on error, and error like `EncryptContext`
+func EncryptDirs(privKey *gorsa.PrivateKey, keyMap map[string][]byte, dirs []string, opts ...Option) (*Report, error) {
+	wo := NewWalkOptions(opts...)
+	report, err := EncryptWithOptions(wo.Ctx, wo.Log, privKey, keyMap, dirs, wo.Options)
+	if err != nil {
+		return report, fmt.Errorf("encryptdir.EncryptDirs: %w", err)
+	}
+	return report, nil
+}
+
+// encryptdir.DecryptDirs: `DecryptWithOptions` with the `WalkOptions` of `opts`
+// returns: report, also on error, and error like `DecryptContext`
+func DecryptDirs(privKey *gorsa.PrivateKey, keyMap map[string][]byte, dirs []string, opts ...Option) (*Report, error) {
+	wo := NewWalkOptions(opts...)
+	report, err := DecryptWithOptions(wo.Ctx, wo.Log, privKey, keyMap, dirs, wo.Options)
+	if err != nil {
+		return report, fmt.Errorf("encryptdir.DecryptDirs: %w", err)
+	}
+	return report, nil
+}
@@ -0,0 +1,103 @@
+package encryptdir
+
+import (
+	"context"
+	"crypto"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+	"go.uber.org/zap"
+)
+
+func TestNewWalkOptionsDefaults(t *testing.T) {
+	wo := NewWalkOptions()
+	if wo.Ctx == nil || wo.Log == nil {
+		t.Fatalf("NewWalkOptions: ctx = %v, log = %v, want both set", wo.Ctx, wo.Log)
+	}
+	if wo.HashAlgo != 0 || wo.MaxOpenFiles != 0 || wo.FailFast || wo.signatureHash() != DefaultHashAlgo {
+		t.Errorf("NewWalkOptions: hash = %v, max open files = %d, fail fast = %v, want the zero options", wo.HashAlgo, wo.MaxOpenFiles, wo.FailFast)
+	}
+
+	// a nil context or logger goes back to the default
+	wo = NewWalkOptions(WithContext(nil), WithLogger(nil))
+	if wo.Ctx == nil || wo.Log == nil {
+		t.Errorf("NewWalkOptions(nil, nil): ctx = %v, log = %v, want both set", wo.Ctx, wo.Log)
+	}
+}
+
+func TestNewWalkOptionsTogether(t *testing.T) {
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "run")
+	log := zap.NewExample().Sugar()
+
+	opts := []Option{
+		WithContext(ctx),
+		WithLogger(log),
+		WithHash(crypto.SHA512),
+		WithMaxWorkers(3),
+	}
+	// `WithOptions` goes first, the others after it in any order give the same options
+	for _, order := range [][]int{{0, 1, 2, 3}, {3, 2, 1, 0}, {2, 0, 3, 1}} {
+		ordered := []Option{WithOptions(Options{FailFast: true, Banner: "# encrypted"})}
+		for _, j := range order {
+			ordered = append(ordered, opts[j])
+		}
+
+		wo := NewWalkOptions(ordered...)
+		if wo.Ctx.Value(key{}) != "run" || wo.Log != log {
+			t.Errorf("order = %v: context or logger not set", order)
+		}
+		if wo.HashAlgo != crypto.SHA512 || wo.MaxWorkers != 3 || wo.MaxOpenFiles != 0 || !wo.FailFast || wo.Banner != "# encrypted" {
+			t.Errorf("order = %v: hash = %v, max workers = %d, max open files = %d, fail fast = %v, banner = %q, want sha512, 3, 0, true, and the banner",
+				order, wo.HashAlgo, wo.MaxWorkers, wo.MaxOpenFiles, wo.FailFast, wo.Banner)
+		}
+	}
+
+	// the same field set twice goes by the later one
+	wo := NewWalkOptions(WithOptions(Options{HashAlgo: crypto.SHA256}), WithHash(crypto.SHA512))
+	if wo.HashAlgo != crypto.SHA512 {
+		t.Errorf("WithHash after WithOptions: hash = %v, want sha512", wo.HashAlgo)
+	}
+	wo = NewWalkOptions(WithHash(crypto.SHA512), WithOptions(Options{HashAlgo: crypto.SHA256}))
+	if wo.HashAlgo != crypto.SHA256 {
+		t.Errorf("WithOptions after WithHash: hash = %v, want sha256", wo.HashAlgo)
+	}
+}
+
+func TestWithOptionsResets(t *testing.T) {
+	// a zero field of `WithOptions` resets what came before it, false and 0 included
+	wo := NewWalkOptions(WithOptions(Options{FailFast: true, Banner: "# encrypted"}), WithMaxWorkers(3), WithOptions(Options{Banner: "# other"}))
+	if wo.FailFast || wo.MaxWorkers != 0 || wo.Banner != "# other" {
+		t.Errorf("WithOptions: fail fast = %v, max workers = %d, banner = %q, want false, 0, and the later banner", wo.FailFast, wo.MaxWorkers, wo.Banner)
+	}
+}
+
+func TestEncryptDirsOptions(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello"), "b.md": []byte("left alone")}
+	dir, _ := testutil.BuildTree(t, spec)
+
+	opts := []Option{WithOptions(Options{Banner: "# encrypted"}), WithHash(crypto.SHA512), WithMaxWorkers(1)}
+	report, err := EncryptDirs(privKey, keyMap, []string{dir}, opts...)
+	if err != nil {
+		t.Fatalf("EncryptDirs: %v", err)
+	}
+	if report.Processed != 1 {
+		t.Errorf("EncryptDirs: processed = %d, want 1", report.Processed)
+	}
+
+	// the banner comes first, the header after it records the hash
+	contents := readTree(t, dir)["a.txt"]
+	banner := Options{Banner: "# encrypted"}.bannerLine()
+	header, ok := parseFileHeader(contents[len(banner):])
+	if string(contents[:len(banner)]) != string(banner) || !ok || header.Hash != crypto.SHA512 {
+		t.Errorf("a.txt: banner = %q, header = %+v, want the banner and a sha512 header", trim(contents[:len(banner)]), header)
+	}
+
+	_, err = DecryptDirs(privKey, keyMap, []string{dir}, opts...)
+	if err != nil {
+		t.Fatalf("DecryptDirs: %v", err)
+	}
+	assertTree(t, dir, spec)
+}
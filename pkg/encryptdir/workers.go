@@ -0,0 +1,47 @@
+package encryptdir
+
+import "github.com/iafan/cwalk"
+
+// workerIDs: the ids of the walk goroutines of a root for `Options.DebugWorkers`, each holds one while it processes a file
+// cwalk runs `cwalk.NumWorkers` of them per root and never calls a walk func from more at once, so taking an id never waits
+// a nil `workerIDs` hands out 0, which nothing is tagged with
+type workerIDs chan int
+
+// encryptdir.newWorkerIDs: ids for the walk goroutines of the root at index `root` of a run, unique across its roots, from 1 to `cwalk.NumWorkers` times how many there are
+// returns: ids, nil unless `on`
+func newWorkerIDs(on bool, root int) workerIDs {
+	if !on {
+		return nil
+	}
+	ids := make(workerIDs, cwalk.NumWorkers)
+	for i := 1; i <= cwalk.NumWorkers; i++ {
+		ids <- root*cwalk.NumWorkers + i
+	}
+	return ids
+}
+
+// encryptdir.workerIDs.take: a free id for the goroutine about to process a file, 0 for nil `ids`
+func (ids workerIDs) take() int {
+	if ids == nil {
+		return 0
+	}
+	return <-ids
+}
+
+// encryptdir.workerIDs.put: hands back the id `take` returned
+func (ids workerIDs) put(id int) {
+	if ids == nil {
+		return
+	}
+	ids <- id
+}
+
+// encryptdir.Walker.onWorker: `w` for processing a single file on the worker it takes an id for, every line it logs says which
+// returns: walker, the id has to go back with `workerIDs.put` once the file is done
+func (w Walker) onWorker() Walker {
+	w.worker = w.workers.take()
+	if w.worker != 0 {
+		w.log = w.log.With("worker", w.worker)
+	}
+	return w
+}
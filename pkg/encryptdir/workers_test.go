@@ -0,0 +1,62 @@
+package encryptdir
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/iafan/cwalk"
+	"github.com/prairir/encryptdir/pkg/testutil"
+)
+
+func TestDebugWorkers(t *testing.T) {
+	numWorkers := cwalk.NumWorkers
+	cwalk.NumWorkers = 3
+	t.Cleanup(func() { cwalk.NumWorkers = numWorkers })
+
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := make(map[string][]byte)
+	for i := 0; i < 20; i++ {
+		spec[fmt.Sprintf("d%d/f%d.txt", i%4, i)] = []byte("hello")
+	}
+	dirs := make([]string, 2)
+	for i := range dirs {
+		dirs[i], _ = testutil.BuildTree(t, spec)
+	}
+
+	report, err := EncryptWithOptions(context.Background(), nil, privKey, keyMap, dirs, Options{DebugWorkers: true})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if len(report.Files) != len(spec)*len(dirs) {
+		t.Fatalf("EncryptWithOptions: %d results, want %d", len(report.Files), len(spec)*len(dirs))
+	}
+
+	// each dir has the ids of its own workers
+	for _, f := range report.Files {
+		root := 0
+		if strings.HasPrefix(f.Path, dirs[1]) {
+			root = 1
+		}
+		low, high := root*cwalk.NumWorkers+1, (root+1)*cwalk.NumWorkers
+		if f.Worker < low || f.Worker > high {
+			t.Errorf("path = %q: worker = %d, want %d to %d", f.Path, f.Worker, low, high)
+		}
+	}
+
+	// without it nothing is tagged
+	report, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, dirs, Options{})
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	for _, f := range report.Files {
+		if f.Worker != 0 {
+			t.Errorf("path = %q: worker = %d, want 0", f.Path, f.Worker)
+		}
+	}
+	for _, dir := range dirs {
+		assertTree(t, dir, spec)
+	}
+}
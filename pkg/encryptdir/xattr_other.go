@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package encryptdir
+
+import "fmt"
+
+// encryptdir.copyXattrs: xattrs arent supported on this platform
+// returns: `ErrXattrsUnsupported`
+func copyXattrs(src string, dst string) error {
+	return fmt.Errorf("encryptdir.copyXattrs: %w", ErrXattrsUnsupported)
+}
@@ -0,0 +1,55 @@
+//go:build linux || darwin
+
+package encryptdir
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// encryptdir.copyXattrs: copies the extended attributes of the file at `src` to the file at `dst`
+// filesystems without xattr support are treated as having none
+// returns: error
+func copyXattrs(src string, dst string) error {
+	size, err := unix.Listxattr(src, nil)
+	if err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil
+		}
+		return fmt.Errorf("encryptdir.copyXattrs: unix.Listxattr: %w", err)
+	}
+
+	if size == 0 {
+		return nil
+	}
+
+	names := make([]byte, size)
+	size, err = unix.Listxattr(src, names)
+	if err != nil {
+		return fmt.Errorf("encryptdir.copyXattrs: unix.Listxattr: %w", err)
+	}
+
+	// names are `\0` terminated
+	for _, name := range strings.Split(strings.TrimRight(string(names[:size]), "\x00"), "\x00") {
+		valSize, err := unix.Getxattr(src, name, nil)
+		if err != nil {
+			return fmt.Errorf("encryptdir.copyXattrs: unix.Getxattr: name = %q: %w", name, err)
+		}
+
+		val := make([]byte, valSize)
+		valSize, err = unix.Getxattr(src, name, val)
+		if err != nil {
+			return fmt.Errorf("encryptdir.copyXattrs: unix.Getxattr: name = %q: %w", name, err)
+		}
+
+		err = unix.Setxattr(dst, name, val[:valSize], 0)
+		if err != nil {
+			return fmt.Errorf("encryptdir.copyXattrs: unix.Setxattr: name = %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+//go:build linux || darwin
+
+package encryptdir
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/testutil"
+	"golang.org/x/sys/unix"
+)
+
+const testXattr = "user.encryptdir.test"
+
+// getXattr: the value of the xattr `name` of the file at `path`
+func getXattr(t *testing.T, path string, name string) string {
+	t.Helper()
+	buf := make([]byte, 256)
+	n, err := unix.Getxattr(path, name, buf)
+	if err != nil {
+		t.Fatalf("unix.Getxattr: path = %q: %v", path, err)
+	}
+	return string(buf[:n])
+}
+
+func TestPreserveXattrs(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	spec := map[string][]byte{"a.txt": []byte("hello")}
+	dir, _ := testutil.BuildTree(t, spec)
+	path := filepath.Join(dir, "a.txt")
+
+	err := unix.Setxattr(path, testXattr, []byte("label"), 0)
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EPERM) {
+		t.Skipf("filesystem of %s has no user xattrs: %v", dir, err)
+	}
+	if err != nil {
+		t.Fatalf("unix.Setxattr: %v", err)
+	}
+	opts := Options{PreserveXattrs: true}
+
+	_, err = EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	if got := getXattr(t, path, testXattr); got != "label" {
+		t.Errorf("after encrypting: %s = %q, want %q", testXattr, got, "label")
+	}
+
+	_, err = DecryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("DecryptWithOptions: %v", err)
+	}
+	if got := getXattr(t, path, testXattr); got != "label" {
+		t.Errorf("after decrypting: %s = %q, want %q", testXattr, got, "label")
+	}
+	assertTree(t, dir, spec)
+}
+
+func TestPreserveXattrsOff(t *testing.T) {
+	privKey := testutil.NewPrivateKey(t)
+	keyMap := testutil.NewKeyMap("txt")
+	dir, _ := testutil.BuildTree(t, map[string][]byte{"a.txt": []byte("hello")})
+	path := filepath.Join(dir, "a.txt")
+
+	err := unix.Setxattr(path, testXattr, []byte("label"), 0)
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EPERM) {
+		t.Skipf("filesystem of %s has no user xattrs: %v", dir, err)
+	}
+	if err != nil {
+		t.Fatalf("unix.Setxattr: %v", err)
+	}
+
+	// the temp file the original is replaced by never had it
+	_, err = EncryptWithOptions(context.Background(), nil, privKey, keyMap, []string{dir}, Options{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions: %v", err)
+	}
+	_, err = unix.Getxattr(path, testXattr, make([]byte, 256))
+	if err == nil {
+		t.Errorf("without PreserveXattrs: %s kept, want it gone", testXattr)
+	}
+}
@@ -0,0 +1,200 @@
+package rsa
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// sentinel error used for when a PEM block is password protected, see `LoadPrivateKeyPEMWithPassphrase` for those
+var ErrEncryptedPEM = errors.New("PEM block is encrypted")
+
+// sentinel error used for when a PEM block holds something other than an RSA private key
+var ErrUnsupportedKey = errors.New("unsupported private key type")
+
+// sentinel error used for when a PEM block is encrypted but no passphrase was given
+var ErrPassphraseRequired = errors.New("passphrase required")
+
+// sentinel error used for when the passphrase doesn't decrypt a PEM block
+var ErrWrongPassphrase = errors.New("wrong passphrase")
+
+// rsa.LoadPrivateKeyPEM: reads an unencrypted RSA private key from the PEM file at `path`
+// returns: private key or error, see `LoadPrivateKeyPEMReader`
+func LoadPrivateKeyPEM(path string) (*rsa.PrivateKey, error) {
+	in, err := os.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("rsa.LoadPrivateKeyPEM: os.OpenFile: %w", err)
+	}
+	defer in.Close()
+
+	privateKey, err := LoadPrivateKeyPEMReader(in)
+	if err != nil {
+		return nil, fmt.Errorf("rsa.LoadPrivateKeyPEM: path = %q: %w", path, err)
+	}
+	return privateKey, nil
+}
+
+// rsa.LoadPrivateKeyPEMReader: reads an unencrypted RSA private key from the first PEM block in `r`
+// the block is either PKCS#1 `RSA PRIVATE KEY` or PKCS#8 `PRIVATE KEY`
+// returns: private key, or error wrapping `ErrPEMDecode`, `ErrEncryptedPEM`, or `ErrUnsupportedKey`
+func LoadPrivateKeyPEMReader(r io.Reader) (*rsa.PrivateKey, error) {
+	payload, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("rsa.LoadPrivateKeyPEMReader: io.ReadAll: %w", err)
+	}
+
+	block, _ := pem.Decode(payload)
+	if block == nil {
+		return nil, fmt.Errorf("rsa.LoadPrivateKeyPEMReader: %w", ErrPEMDecode)
+	}
+
+	if isEncryptedBlock(block) {
+		return nil, fmt.Errorf("rsa.LoadPrivateKeyPEMReader: type = %q: %w", block.Type, ErrEncryptedPEM)
+	}
+
+	privateKey, err := parsePrivateKeyBlock(block)
+	if err != nil {
+		return nil, fmt.Errorf("rsa.LoadPrivateKeyPEMReader: %w", err)
+	}
+	return privateKey, nil
+}
+
+// rsa.LoadPrivateKeyPEMWithPassphrase: reads an RSA private key from the PEM file at `path`, decrypted with `passphrase` if it is encrypted
+// legacy `DEK-Info` blocks and PBES2 encrypted PKCS#8 `ENCRYPTED PRIVATE KEY` blocks are supported, unencrypted keys ignore `passphrase`
+// returns: private key, or error wrapping `ErrPassphraseRequired`, `ErrWrongPassphrase`, or an error of `LoadPrivateKeyPEMReader`
+func LoadPrivateKeyPEMWithPassphrase(path string, passphrase []byte) (*rsa.PrivateKey, error) {
+	payload, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("rsa.LoadPrivateKeyPEMWithPassphrase: os.ReadFile: %w", err)
+	}
+
+	block, _ := pem.Decode(payload)
+	if block == nil {
+		return nil, fmt.Errorf("rsa.LoadPrivateKeyPEMWithPassphrase: path = %q: %w", path, ErrPEMDecode)
+	}
+
+	if !isEncryptedBlock(block) {
+		privateKey, err := parsePrivateKeyBlock(block)
+		if err != nil {
+			return nil, fmt.Errorf("rsa.LoadPrivateKeyPEMWithPassphrase: path = %q: %w", path, err)
+		}
+		return privateKey, nil
+	}
+
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("rsa.LoadPrivateKeyPEMWithPassphrase: path = %q: %w", path, ErrPassphraseRequired)
+	}
+
+	var decrypted *pem.Block
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		der, err := decryptPKCS8(block.Bytes, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("rsa.LoadPrivateKeyPEMWithPassphrase: path = %q: %w", path, err)
+		}
+		decrypted = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	} else {
+		der, err := x509.DecryptPEMBlock(block, passphrase)
+		if errors.Is(err, x509.IncorrectPasswordError) {
+			return nil, fmt.Errorf("rsa.LoadPrivateKeyPEMWithPassphrase: path = %q: %w", path, ErrWrongPassphrase)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("rsa.LoadPrivateKeyPEMWithPassphrase: x509.DecryptPEMBlock: %w", err)
+		}
+		decrypted = &pem.Block{Type: block.Type, Bytes: der}
+	}
+
+	privateKey, err := parsePrivateKeyBlock(decrypted)
+	if errors.Is(err, ErrUnsupportedKey) {
+		return nil, fmt.Errorf("rsa.LoadPrivateKeyPEMWithPassphrase: path = %q: %w", path, err)
+	}
+	if err != nil {
+		// the padding check passes by chance for some wrong passphrases, what comes out doesnt parse
+		return nil, fmt.Errorf("rsa.LoadPrivateKeyPEMWithPassphrase: path = %q: %w", path, ErrWrongPassphrase)
+	}
+	return privateKey, nil
+}
+
+// rsa.isEncryptedBlock: if `block` is password protected, either PKCS#8 or legacy `DEK-Info`
+func isEncryptedBlock(block *pem.Block) bool {
+	return block.Type == "ENCRYPTED PRIVATE KEY" || x509.IsEncryptedPEMBlock(block)
+}
+
+// rsa.parsePrivateKeyBlock: parses the unencrypted PKCS#1 `RSA PRIVATE KEY` or PKCS#8 `PRIVATE KEY` in `block`
+// returns: private key, or error wrapping `ErrUnsupportedKey`
+func parsePrivateKeyBlock(block *pem.Block) (*rsa.PrivateKey, error) {
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("rsa.parsePrivateKeyBlock: x509.ParsePKCS1PrivateKey: %w", err)
+		}
+		return privateKey, nil
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("rsa.parsePrivateKeyBlock: x509.ParsePKCS8PrivateKey: %w", err)
+		}
+
+		privateKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("rsa.parsePrivateKeyBlock: key = %T: %w", key, ErrUnsupportedKey)
+		}
+		return privateKey, nil
+	default:
+		return nil, fmt.Errorf("rsa.parsePrivateKeyBlock: type = %q: %w", block.Type, ErrUnsupportedKey)
+	}
+}
+
+// rsa.SavePrivateKeyPEM: writes `privateKey` unencrypted to a new PKCS#1 PEM file at `path`, readable only by the owner
+// returns: error
+func SavePrivateKeyPEM(privateKey *rsa.PrivateKey, path string) error {
+	// create file, error if already exist
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("rsa.SavePrivateKeyPEM: os.OpenFile: path = %q : %w", path, err)
+	}
+	defer out.Close()
+
+	block := &pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+	}
+
+	err = pem.Encode(out, block)
+	if err != nil {
+		return fmt.Errorf("rsa.SavePrivateKeyPEM: pem.Encode: path = %q : %w", path, err)
+	}
+	return nil
+}
+
+// rsa.SaveKeyPairPEM: writes `privateKey` unencrypted to a new PKCS#1 PEM file at `privPath` like `SavePrivateKeyPEM`,
+// and its public key to a new PKCS#1 PEM file at `pubPath` that `ReadPublicKey` reads
+// returns: error, fails without overwriting either file if it exists
+func SaveKeyPairPEM(privateKey *rsa.PrivateKey, privPath string, pubPath string) error {
+	// create file, error if already exist
+	out, err := os.OpenFile(pubPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("rsa.SaveKeyPairPEM: os.OpenFile: path = %q : %w", pubPath, err)
+	}
+	defer out.Close()
+
+	block := &pem.Block{
+		Type:  "RSA PUBLIC KEY",
+		Bytes: x509.MarshalPKCS1PublicKey(&privateKey.PublicKey),
+	}
+
+	err = pem.Encode(out, block)
+	if err != nil {
+		return fmt.Errorf("rsa.SaveKeyPairPEM: pem.Encode: path = %q : %w", pubPath, err)
+	}
+
+	err = SavePrivateKeyPEM(privateKey, privPath)
+	if err != nil {
+		return fmt.Errorf("rsa.SaveKeyPairPEM: %w", err)
+	}
+	return nil
+}
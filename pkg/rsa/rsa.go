@@ -110,12 +110,33 @@ func ReadPublicKey(path string) (*rsa.PublicKey, error) {
 	return publicKey, nil
 }
 
+// sentinel error used for when a key pair is asked for with fewer bits than `MinKeyBits`
+var ErrWeakKeySize = errors.New("rsa key size is too small")
+
+// smallest RSA key `GenerateKeyPair` generates, in bits
+const MinKeyBits = 2048
+
+// rsa.GenerateKeyPair: generates a new RSA private key, and its public key, of `bits` bits
+// encryptdir signatures are as long as the modulus, so files encrypted with a bigger key have a longer signature
+// returns: private key, or error wrapping `ErrWeakKeySize` if `bits` is less than `MinKeyBits`
+func GenerateKeyPair(bits int) (*rsa.PrivateKey, error) {
+	if bits < MinKeyBits {
+		return nil, fmt.Errorf("rsa.GenerateKeyPair: bits = %d, want at least %d: %w", bits, MinKeyBits, ErrWeakKeySize)
+	}
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, fmt.Errorf("rsa.GenerateKeyPair: rsa.GenerateKey: %w", err)
+	}
+	return privateKey, nil
+}
+
 // rsa.NewKeys: generates private/public key, encrypted with `password`, and writes them to `privPath` and `pubPath` respectively
 // returns: private key or error
 func NewKeys(privKeyPath string, pubKeyPath string, password string) (*rsa.PrivateKey, error) {
-	rsakey, err := rsa.GenerateKey(rand.Reader, 2048)
+	rsakey, err := GenerateKeyPair(MinKeyBits)
 	if err != nil {
-		return nil, fmt.Errorf("rsa.NewKeys: rsa.GenerateKey: %w", err)
+		return nil, fmt.Errorf("rsa.NewKeys: %w", err)
 	}
 
 	// write the keys to their respective files before we continue
@@ -175,17 +196,29 @@ func WriteKeysToFiles(privateKey *rsa.PrivateKey, privPath string, pubPath strin
 	return nil
 }
 
-// rsa.CreateSignature: hashes `payload` with `hashAlgo` then signs hashed `payload` with `key`
+// rsa.CreateSignature: `CreateSignatureWithRand` with `crypto/rand.Reader`
 // returns: signature or error
 func CreateSignature(key *rsa.PrivateKey, payload []byte, hashAlgo crypto.Hash) ([]byte, error) {
+	signature, err := CreateSignatureWithRand(rand.Reader, key, payload, hashAlgo)
+	if err != nil {
+		return nil, fmt.Errorf("rsa.CreateSignature: %w", err)
+	}
+	return signature, nil
+}
+
+// rsa.CreateSignatureWithRand: hashes `payload` with `hashAlgo` then signs hashed `payload` with `key`, reading randomness from `random`
+// PKCS #1 v1.5 signatures are deterministic, the same key and payload always give the same signature whatever `random` is,
+// the standard library only still takes it for compatibility and ignores it
+// returns: signature or error
+func CreateSignatureWithRand(random io.Reader, key *rsa.PrivateKey, payload []byte, hashAlgo crypto.Hash) ([]byte, error) {
 	h := hashAlgo.HashFunc().New()
 
 	// doesnt return error
 	h.Write(payload)
 
-	signature, err := rsa.SignPKCS1v15(nil, key, hashAlgo, h.Sum(nil)[:])
+	signature, err := rsa.SignPKCS1v15(random, key, hashAlgo, h.Sum(nil)[:])
 	if err != nil {
-		return nil, fmt.Errorf("rsa.CreateSignature: rsa.SignPKCS1v15: %w", err)
+		return nil, fmt.Errorf("rsa.CreateSignatureWithRand: rsa.SignPKCS1v15: %w", err)
 	}
 	return signature, nil
 }
@@ -0,0 +1,102 @@
+package rsa
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateSignatureWithRand(t *testing.T) {
+	privateKey, err := LoadPrivateKeyPEM(filepath.Join("testdata", pemFixtures[0]))
+	if err != nil {
+		t.Fatalf("LoadPrivateKeyPEM: %v", err)
+	}
+	payload := []byte("hello")
+
+	first, err := CreateSignatureWithRand(rand.New(rand.NewSource(1)), privateKey, payload, crypto.SHA256)
+	if err != nil {
+		t.Fatalf("CreateSignatureWithRand: %v", err)
+	}
+	err = VerifySignature(&privateKey.PublicKey, first, payload, crypto.SHA256)
+	if err != nil {
+		t.Errorf("VerifySignature: %v", err)
+	}
+
+	// the same seed signs the same, and so does the `crypto/rand` wrapper
+	again, err := CreateSignatureWithRand(rand.New(rand.NewSource(1)), privateKey, payload, crypto.SHA256)
+	if err != nil || !bytes.Equal(again, first) {
+		t.Errorf("CreateSignatureWithRand again = %x, %v, want %x", again, err, first)
+	}
+	wrapped, err := CreateSignature(privateKey, payload, crypto.SHA256)
+	if err != nil || !bytes.Equal(wrapped, first) {
+		t.Errorf("CreateSignature = %x, %v, want %x", wrapped, err, first)
+	}
+
+	other, err := CreateSignatureWithRand(rand.New(rand.NewSource(1)), privateKey, []byte("world"), crypto.SHA256)
+	if err != nil || bytes.Equal(other, first) {
+		t.Errorf("CreateSignatureWithRand of another payload = %x, %v, want another signature", other, err)
+	}
+}
+
+func TestGenerateKeyPair(t *testing.T) {
+	_, err := GenerateKeyPair(1024)
+	if !errors.Is(err, ErrWeakKeySize) {
+		t.Errorf("GenerateKeyPair(1024): err = %v, want ErrWeakKeySize", err)
+	}
+
+	privateKey, err := GenerateKeyPair(MinKeyBits)
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+	if bits := privateKey.N.BitLen(); bits != MinKeyBits {
+		t.Errorf("GenerateKeyPair: %d bit key, want %d", bits, MinKeyBits)
+	}
+
+	payload := []byte("hello")
+	signature, err := CreateSignature(privateKey, payload, crypto.SHA256)
+	if err != nil {
+		t.Fatalf("CreateSignature: %v", err)
+	}
+	err = VerifySignature(&privateKey.PublicKey, signature, payload, crypto.SHA256)
+	if err != nil {
+		t.Errorf("VerifySignature: %v", err)
+	}
+	err = VerifySignature(&privateKey.PublicKey, signature, []byte("world"), crypto.SHA256)
+	if err == nil {
+		t.Errorf("VerifySignature of another payload: err = nil, want an error")
+	}
+}
+
+func TestSaveKeyPairPEM(t *testing.T) {
+	privateKey, err := LoadPrivateKeyPEM(filepath.Join("testdata", pemFixtures[0]))
+	if err != nil {
+		t.Fatalf("LoadPrivateKeyPEM: %v", err)
+	}
+	dir := t.TempDir()
+	privPath := filepath.Join(dir, "key.pem")
+	pubPath := filepath.Join(dir, "key.pub.pem")
+
+	err = SaveKeyPairPEM(privateKey, privPath, pubPath)
+	if err != nil {
+		t.Fatalf("SaveKeyPairPEM: %v", err)
+	}
+
+	// both read back with the existing loaders
+	gotPriv, err := LoadPrivateKeyPEM(privPath)
+	if err != nil || !gotPriv.Equal(privateKey) {
+		t.Errorf("LoadPrivateKeyPEM = %v, want the saved key", err)
+	}
+	gotPub, err := ReadPublicKey(pubPath)
+	if err != nil || !gotPub.Equal(&privateKey.PublicKey) {
+		t.Errorf("ReadPublicKey = %v, want the saved public key", err)
+	}
+
+	err = SaveKeyPairPEM(privateKey, privPath, pubPath)
+	if !errors.Is(err, os.ErrExist) {
+		t.Errorf("SaveKeyPairPEM again: err = %v, want os.ErrExist", err)
+	}
+}
@@ -0,0 +1,111 @@
+// testutil: helpers for tests of code that embeds encryptdir, to build throwaway trees and keys without the boilerplate
+// nothing here is fit for real data, the keys are predictable on purpose
+package testutil
+
+import (
+	"crypto/rand"
+	gorsa "crypto/rsa"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/rsa"
+)
+
+// testutil.BuildTree: writes the files of `spec`, slash separated paths relative to the root to their contents, under a new temp dir
+// parent dirs are created as needed, files are written 0600 and dirs 0700, a path ending in "/" is an empty dir
+// the tree is also removed when `t` finishes, `cleanup` is for tests that want it gone sooner and can be called more than once
+// fails `t` on any error
+// returns: root of the tree, and a func removing it
+func BuildTree(t testing.TB, spec map[string][]byte) (dir string, cleanup func()) {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "encryptdir-testutil-")
+	if err != nil {
+		t.Fatalf("testutil.BuildTree: os.MkdirTemp: %v", err)
+	}
+
+	var once sync.Once
+	cleanup = func() {
+		once.Do(func() {
+			os.RemoveAll(dir)
+		})
+	}
+	t.Cleanup(cleanup)
+
+	for rel, contents := range spec {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		if len(rel) > 0 && rel[len(rel)-1] == '/' {
+			err = os.MkdirAll(path, 0700)
+			if err != nil {
+				t.Fatalf("testutil.BuildTree: os.MkdirAll: %v", err)
+			}
+			continue
+		}
+
+		err = os.MkdirAll(filepath.Dir(path), 0700)
+		if err != nil {
+			t.Fatalf("testutil.BuildTree: os.MkdirAll: %v", err)
+		}
+		err = os.WriteFile(path, contents, 0600)
+		if err != nil {
+			t.Fatalf("testutil.BuildTree: os.WriteFile: %v", err)
+		}
+	}
+	return dir, cleanup
+}
+
+// testutil.NewTestKey: a 32 byte AES key for the key map extension `ext`, the same on every call so failing tests are reproducible
+// returns: key
+func NewTestKey(ext string) []byte {
+	sum := sha256.Sum256([]byte("encryptdir testutil key: " + ext))
+	return sum[:]
+}
+
+// testutil.NewKeyMap: a key map with a `NewTestKey` for each of `exts`
+// returns: key map
+func NewKeyMap(exts ...string) map[string][]byte {
+	keyMap := make(map[string][]byte, len(exts))
+	for _, ext := range exts {
+		keyMap[ext] = NewTestKey(ext)
+	}
+	return keyMap
+}
+
+var (
+	privKeysMu sync.Mutex
+	privKeys   = make(map[int]*gorsa.PrivateKey)
+)
+
+// testutil.NewPrivateKey: an RSA key of `rsa.MinKeyBits` bits, generated once and shared by every test of the process since generating one is slow
+// fails `t` on any error
+// returns: private key
+func NewPrivateKey(t testing.TB) *gorsa.PrivateKey {
+	t.Helper()
+	return NewPrivateKeyBits(t, rsa.MinKeyBits)
+}
+
+// testutil.NewPrivateKeyBits: like `NewPrivateKey` for an RSA key of `bits` bits, one key per size is shared by every test of the process
+// unlike `rsa.GenerateKeyPair` keys smaller than `rsa.MinKeyBits` are allowed, to test files signed by keys that arent 2048 bits either way
+// fails `t` on any error
+// returns: private key
+func NewPrivateKeyBits(t testing.TB, bits int) *gorsa.PrivateKey {
+	t.Helper()
+
+	privKeysMu.Lock()
+	defer privKeysMu.Unlock()
+
+	privKey, ok := privKeys[bits]
+	if ok {
+		return privKey
+	}
+
+	privKey, err := gorsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatalf("testutil.NewPrivateKeyBits: bits = %d: rsa.GenerateKey: %v", bits, err)
+	}
+	privKeys[bits] = privKey
+	return privKey
+}
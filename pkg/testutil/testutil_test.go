@@ -0,0 +1,114 @@
+package testutil
+
+import (
+	"bytes"
+	"crypto"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prairir/encryptdir/pkg/rsa"
+)
+
+func TestBuildTree(t *testing.T) {
+	dir, cleanup := BuildTree(t, map[string][]byte{
+		"a.txt":       []byte("a"),
+		"sub/b.sql":   []byte("b"),
+		"empty/":      nil,
+		"sub/deep/c":  {},
+		"sub/deep/d/": nil,
+	})
+
+	for rel, want := range map[string][]byte{"a.txt": []byte("a"), "sub/b.sql": []byte("b"), "sub/deep/c": {}} {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("os.ReadFile: %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("path = %q: contents = %q, want %q", rel, got, want)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("os.Stat: %v", err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("path = %q: mode = %v, want 0600", rel, info.Mode().Perm())
+		}
+	}
+
+	for _, rel := range []string{"empty", "sub/deep/d"} {
+		info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(rel)))
+		if err != nil {
+			t.Fatalf("os.Stat: %v", err)
+		}
+		if !info.IsDir() || info.Mode().Perm() != 0700 {
+			t.Errorf("path = %q: mode = %v, want a 0700 dir", rel, info.Mode())
+		}
+	}
+
+	cleanup()
+	cleanup()
+	_, err := os.Stat(dir)
+	if !os.IsNotExist(err) {
+		t.Errorf("os.Stat after cleanup: error = %v, want not exist", err)
+	}
+}
+
+func TestNewTestKey(t *testing.T) {
+	a := NewTestKey("txt")
+	if len(a) != 32 {
+		t.Fatalf("len = %d, want 32", len(a))
+	}
+	if !bytes.Equal(a, NewTestKey("txt")) {
+		t.Error("keys for the same extension differ")
+	}
+	if bytes.Equal(a, NewTestKey("sql")) {
+		t.Error("keys for different extensions are the same")
+	}
+}
+
+func TestNewKeyMap(t *testing.T) {
+	keyMap := NewKeyMap("txt", "sql")
+	if len(keyMap) != 2 {
+		t.Fatalf("len = %d, want 2", len(keyMap))
+	}
+	for ext, key := range keyMap {
+		if !bytes.Equal(key, NewTestKey(ext)) {
+			t.Errorf("extension = %q: key isnt `NewTestKey`", ext)
+		}
+	}
+}
+
+func TestNewPrivateKey(t *testing.T) {
+	privKey := NewPrivateKey(t)
+	if privKey.N.BitLen() != rsa.MinKeyBits {
+		t.Errorf("bits = %d, want %d", privKey.N.BitLen(), rsa.MinKeyBits)
+	}
+	if NewPrivateKey(t) != privKey {
+		t.Error("key isnt shared between calls")
+	}
+
+	sig, err := rsa.CreateSignature(privKey, []byte("payload"), crypto.SHA256)
+	if err != nil {
+		t.Fatalf("rsa.CreateSignature: %v", err)
+	}
+	err = rsa.VerifySignature(&privKey.PublicKey, sig, []byte("payload"), crypto.SHA256)
+	if err != nil {
+		t.Errorf("rsa.VerifySignature: %v", err)
+	}
+}
+
+func TestNewPrivateKeyBits(t *testing.T) {
+	privKey := NewPrivateKeyBits(t, 1024)
+	if privKey.N.BitLen() != 1024 {
+		t.Errorf("bits = %d, want 1024", privKey.N.BitLen())
+	}
+	if NewPrivateKeyBits(t, 1024) != privKey {
+		t.Error("key isnt shared between calls")
+	}
+	if NewPrivateKeyBits(t, rsa.MinKeyBits) != NewPrivateKey(t) {
+		t.Error("`NewPrivateKey` isnt the `rsa.MinKeyBits` key")
+	}
+}